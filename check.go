@@ -0,0 +1,120 @@
+package foreman
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// CheckCustom is the category of the checks registered with AddCheck.
+const CheckCustom = "custom"
+
+// Check is a health check of a service. The built-in checks of the Procfile
+// implement it, and embedders register their own with AddCheck.
+type Check interface {
+    // Run performs the check, returning why it failed. ctx expires after the
+    // checks timeout, 2s by default.
+    Run(ctx context.Context) error
+    // Describe returns a short description of the check, used in its failures.
+    Describe() string
+}
+
+// builtinCheck is a check of the Procfile.
+type builtinCheck struct {
+    description string
+    run func(ctx context.Context) error
+}
+
+func (c builtinCheck) Run(ctx context.Context) error {
+    return c.run(ctx)
+}
+
+func (c builtinCheck) Describe() string {
+    return c.description
+}
+
+// serviceCheck is a check run in a round, with its category, the check name
+// of its failures, and whether its failure asks for the service to be interrupted.
+type serviceCheck struct {
+    category string
+    name string
+    check Check
+    interrupt bool
+}
+
+// Register a check run with the checks of a service, which interrupts the
+// service when it fails, like a failing cmd check. The checker of a running
+// service is restarted to pick it up.
+func (f *Foreman) AddCheck(serviceName string, check Check) error {
+    service, ok := f.updateService(serviceName, func(service *Service) {
+        service.customChecks = append(service.customChecks[:len(service.customChecks):len(service.customChecks)], check)
+    })
+    if !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+
+    if service.active && !service.noHealthCheck {
+        f.restartChecker(serviceName)
+    }
+    return nil
+}
+
+// Return the checks of a round: the built-in checks set in service.checks,
+// then the custom checks of the service. watch follows the resource usage of
+// the service from one round to the next.
+func (f *Foreman) serviceChecks(service Service, watch *limitWatch) []serviceCheck {
+    checks := service.checks
+    var round []serviceCheck
+    builtin := func(category, description string, interrupt bool, run func(ctx context.Context) error) {
+        round = append(round, serviceCheck{category: category, name: category, check: builtinCheck{description: description, run: run}, interrupt: interrupt})
+    }
+
+    if checks.cmd != "" {
+        builtin(CheckCmd, "cmd "+checks.cmd, true, func(ctx context.Context) error {
+            return f.checkCmd(ctx, service)
+        })
+    }
+    if len(checks.tcpPorts) > 0 {
+        builtin(CheckTCP, "tcp ports "+strings.Join(checks.tcpPorts, ", "), true, func(ctx context.Context) error {
+            return service.checkPorts("tcp")
+        })
+    }
+    if len(checks.udpPorts) > 0 {
+        builtin(CheckUDP, "udp ports "+strings.Join(checks.udpPorts, ", "), true, func(ctx context.Context) error {
+            return service.checkPorts("udp")
+        })
+    }
+    if checks.grpc.Address != "" {
+        builtin(CheckGRPC, "grpc "+checks.grpc.Address, true, service.checkGRPC)
+    }
+
+    // Files show the service is ready, restarting it won't create them sooner.
+    if len(checks.fileExists) > 0 || len(checks.socketExists) > 0 || checks.pidFile != "" {
+        builtin(CheckFile, "files", false, func(ctx context.Context) error {
+            return service.checkFiles()
+        })
+    }
+
+    // An unreachable external host won't be fixed by restarting the
+    // service, so it only keeps the service from being marked healthy.
+    if len(checks.networkReady) > 0 {
+        builtin(CheckNetwork, "network "+strings.Join(checks.networkReady, ", "), false, service.checkNetwork)
+    }
+    if len(checks.dns) > 0 {
+        builtin(CheckDNS, fmt.Sprintf("dns %d names", len(checks.dns)), false, service.checkDNS)
+    }
+    if len(checks.ping) > 0 {
+        builtin(CheckPing, "ping "+strings.Join(checks.ping, ", "), false, service.checkPing)
+    }
+
+    if checks.hasLimits() {
+        builtin(CheckLimits, "resource limits", true, func(ctx context.Context) error {
+            return watch.check(service.pid, checks, f.clock.Now())
+        })
+    }
+
+    for _, check := range service.customChecks {
+        round = append(round, serviceCheck{category: CheckCustom, name: check.Describe(), check: check, interrupt: true})
+    }
+    return round
+}
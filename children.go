@@ -0,0 +1,173 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// child is a process foreman started and waits for itself. While foreman
+// reaps as a subreaper, the SIGCHLD handler reaps it along with the orphans
+// and hands its wait status over, closing exited. Otherwise exited is closed
+// right away and the child is left to its own wait.
+type child struct {
+    exited chan struct{}
+    finished bool
+    reaped bool
+    status syscall.WaitStatus
+}
+
+// children are the processes foreman started, by pid, and reapers the number
+// of foremen reaping as subreapers. Holding the lock while forking keeps a new
+// child from being mistaken for an orphan before it is registered.
+var children = struct {
+    sync.Mutex
+    pids map[int]*child
+    reapers int
+}{pids: make(map[int]*child)}
+
+// Leave the child to its own wait, closing exited once. Called with the
+// children lock held.
+func (c *child) release() {
+    if c.finished {
+        return
+    }
+    c.finished = true
+    close(c.exited)
+}
+
+// Hand the wait status of the reaped child over to its wait. Called with the
+// children lock held.
+func (c *child) reap(status syscall.WaitStatus) {
+    if c.finished {
+        return
+    }
+    c.reaped = true
+    c.status = status
+    c.release()
+}
+
+// Return the error of a reaped child the way exec.Cmd.Wait does.
+func (c *child) err() error {
+    switch {
+    case c.status.Signaled():
+        return fmt.Errorf("signal: %v", c.status.Signal())
+    case c.status.ExitStatus() != 0:
+        return fmt.Errorf("exit status %d", c.status.ExitStatus())
+    }
+    return nil
+}
+
+// Return the exit code of a process from its wait status, 128 plus the number
+// of the signal that killed it like shells do, and that signal.
+func exitStatus(status syscall.WaitStatus) (int, syscall.Signal) {
+    if status.Signaled() {
+        return 128 + int(status.Signal()), status.Signal()
+    }
+    return status.ExitStatus(), 0
+}
+
+// Start cmd as a child foreman waits for itself with waitChild.
+func startChild(cmd *exec.Cmd) error {
+    children.Lock()
+    defer children.Unlock()
+    err := cmd.Start()
+    if err != nil {
+        return err
+    }
+    c := &child{exited: make(chan struct{})}
+    if children.reapers == 0 {
+        c.release()
+    }
+    children.pids[cmd.Process.Pid] = c
+    return nil
+}
+
+// Block until a child is reaped by the SIGCHLD handler or left to its own
+// wait, and return it, or nil when it isn't a child started by foreman.
+func awaitChild(pid int) *child {
+    children.Lock()
+    c := children.pids[pid]
+    children.Unlock()
+    if c != nil {
+        <-c.exited
+    }
+    return c
+}
+
+// Wait for a child started with startChild.
+func waitChild(cmd *exec.Cmd) error {
+    c := awaitChild(cmd.Process.Pid)
+    // Once the child was reaped, Wait fails to wait for it again but still
+    // copies its output and closes its pipes.
+    err := cmd.Wait()
+    forgetChild(cmd.Process.Pid)
+    if c != nil && c.reaped {
+        return c.err()
+    }
+    return err
+}
+
+// Run cmd as a child foreman waits for itself.
+func runChild(cmd *exec.Cmd) error {
+    err := startChild(cmd)
+    if err != nil {
+        return err
+    }
+    return waitChild(cmd)
+}
+
+// Stop tracking a child once it was waited for.
+func forgetChild(pid int) {
+    children.Lock()
+    delete(children.pids, pid)
+    children.Unlock()
+}
+
+// Have the SIGCHLD handler reap the children foreman started too.
+func startReaping() {
+    children.Lock()
+    children.reapers++
+    children.Unlock()
+}
+
+// Once no foreman reaps anymore, leave the children still running to their
+// own wait.
+func stopReaping() {
+    children.Lock()
+    defer children.Unlock()
+    children.reapers--
+    if children.reapers > 0 {
+        return
+    }
+    for _, c := range children.pids {
+        c.release()
+    }
+}
+
+// Reap the children that exit every time SIGCHLD is received, the orphans
+// adopted as a subreaper along with the children foreman started, until
+// foreman stops. Reaping starts before it returns, so no child started after
+// it is left to a wait the SIGCHLD handler races with.
+func (f *Foreman) reapChildren() {
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, sigCHLD)
+    startReaping()
+
+    go func() {
+        defer signal.Stop(sigs)
+        defer stopReaping()
+        for {
+            select {
+            case <-sigs:
+                f.sigChildHandler()
+            case <-f.done:
+                f.sigChildHandler()
+                return
+            }
+        }
+    }()
+}
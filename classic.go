@@ -0,0 +1,55 @@
+package foreman
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// classicLine matches a process of the classic Procfile format, like web: bundle exec puma.
+var classicLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):[ \t]*([^ \t{\[].*)$`)
+
+// Report whether data is a classic Procfile: every line that isn't blank or a
+// comment is an unindented name: command line.
+func isClassicProcfile(data []byte) bool {
+    processes := 0
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        line := strings.TrimRight(scanner.Text(), " \t\r")
+        if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if !classicLine.MatchString(line) {
+            return false
+        }
+        processes++
+    }
+    return processes > 0
+}
+
+// ClassicDecoder reads Heroku style Procfiles, with one name: command line per
+// process, into services with a cmd only.
+type ClassicDecoder struct{}
+
+func (ClassicDecoder) Decode(procfilePath string, data []byte) (map[string]any, error) {
+    procfileMap := make(map[string]any)
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for lineNumber := 1; scanner.Scan(); lineNumber++ {
+        line := strings.TrimRight(scanner.Text(), " \t\r")
+        if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        match := classicLine.FindStringSubmatch(line)
+        if match == nil {
+            return nil, fmt.Errorf("%s:%d: expected name: command, got %q", procfilePath, lineNumber, line)
+        }
+        if _, ok := procfileMap[match[1]]; ok {
+            return nil, fmt.Errorf("%s:%d: duplicate process %q", procfilePath, lineNumber, match[1])
+        }
+        procfileMap[match[1]] = map[string]any{"cmd": match[2]}
+    }
+    return procfileMap, scanner.Err()
+}
@@ -0,0 +1,19 @@
+package foreman
+
+import "time"
+
+// Clock abstracts time so delays can be tested deterministically.
+type Clock interface {
+    Now() time.Time
+    After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+    return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+    return time.After(d)
+}
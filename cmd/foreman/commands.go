@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codescalersinternships/IslamWalid-Foreman"
+)
+
+func runStart(args []string) error {
+    flags, socket := newFlagSet("start")
+    procfileOpts := addProcfileFlags(flags, "format", true)
+    color := flags.Bool("color", false, "force colored service output")
+    noColor := flags.Bool("no-color", false, "disable colored service output")
+    timestampFormat := flags.String("timestamp-format", "15:04:05", "Go time layout of the time printed before every output line, empty for none")
+    logFormat := flags.String("log-format", "text", "format of foreman's own messages, text or json")
+    var forward forwardFlag
+    flags.Var(&forward, "forward", "also send service output to fluentd://host:port[/tag] or an http(s) URL, can be repeated")
+    waitHealthy := flags.Bool("wait-healthy", false, "start services only once their dependencies passed their checks")
+    subreaper := flags.Bool("subreaper", false, "adopt and reap the processes orphaned by the services (Linux)")
+    initMode := flags.Bool("init", false, "run as the init process of a container: reap zombies and forward signals to every service")
+    once := flags.Bool("once", false, "run every service once in dependency order and exit with the exit code of the first failed one")
+    mainService := flags.String("main", "", "service whose exit stops foreman with its exit code, the only service given as argument with -init")
+    groups := flags.String("group", "", "comma separated groups whose services start, with the services given as arguments")
+    formation := flags.String("formation", "", "instances of the services to run, like web=3,worker=2")
+    daemon := flags.Bool("d", false, "run in the background, controlled through the socket")
+    pidFile := flags.String("pidfile", "./.foreman.pid", "where the background foreman writes its pid")
+    logFile := flags.String("log", "./foreman.log", "where the background foreman writes its output")
+    flags.Parse(args)
+
+    logger, err := newLogger(*logFormat)
+    if err != nil {
+        return err
+    }
+    opts, err := forward.options()
+    if err != nil {
+        return err
+    }
+    opts = append(opts, foreman.WithTimestampFormat(*timestampFormat), logger)
+    opts = append(opts, procfileOpts.options()...)
+    if *color {
+        opts = append(opts, foreman.WithColor(true))
+    }
+    if *noColor {
+        opts = append(opts, foreman.WithColor(false))
+    }
+    if *waitHealthy {
+        opts = append(opts, foreman.WithHealthyDependencies())
+    }
+    if *subreaper {
+        opts = append(opts, foreman.WithSubreaper())
+    }
+    if *initMode {
+        opts = append(opts, foreman.WithInit())
+        if *mainService == "" && flags.NArg() == 1 {
+            *mainService = flags.Arg(0)
+        }
+    }
+    if *mainService != "" {
+        opts = append(opts, foreman.WithMainService(*mainService))
+    }
+    if flags.NArg() > 0 {
+        opts = append(opts, foreman.WithTargets(flags.Args()...))
+    }
+    if *groups != "" {
+        opts = append(opts, foreman.WithGroups(strings.Split(*groups, ",")...))
+    }
+    if *formation != "" {
+        counts, err := foreman.ParseFormation(*formation)
+        if err != nil {
+            return err
+        }
+        opts = append(opts, foreman.WithFormation(counts))
+    }
+
+    f, err := foreman.New(procfileOpts.procfile(), opts...)
+    if err != nil {
+        return err
+    }
+
+    if *once {
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+        _, err = f.RunToCompletion(ctx)
+        return err
+    }
+    if *daemon && !isDaemon() {
+        return daemonize(args, *socket, *logFile)
+    }
+    if *daemon {
+        err = writePIDFile(*pidFile)
+        if err != nil {
+            return err
+        }
+        defer os.Remove(*pidFile)
+    }
+
+    listener, err := listenControl(*socket)
+    if err != nil {
+        return err
+    }
+    defer os.Remove(*socket)
+    defer listener.Close()
+    go f.ServeControl(listener)
+
+    // Without a terminal, kill $(cat .foreman.pid) is the other way to stop
+    // the background foreman: Start stops the services on SIGTERM.
+    return f.Start(context.Background())
+}
+
+func runRun(args []string) error {
+    flags := flag.NewFlagSet("foreman run", flag.ExitOnError)
+    withDeps := flags.Bool("deps", false, "start the dependency chain of the service first")
+    procfileOpts := addProcfileFlags(flags, "format", true)
+    logFormat := flags.String("log-format", "text", "format of foreman's own messages, text or json")
+    var forward forwardFlag
+    flags.Var(&forward, "forward", "also send service output to fluentd://host:port[/tag] or an http(s) URL, can be repeated")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errors.New("run needs a service name")
+    }
+
+    logger, err := newLogger(*logFormat)
+    if err != nil {
+        return err
+    }
+    opts, err := forward.options()
+    if err != nil {
+        return err
+    }
+    opts = append(opts, logger)
+    opts = append(opts, procfileOpts.options()...)
+    f, err := foreman.New(procfileOpts.procfile(), opts...)
+    if err != nil {
+        return err
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    exitCode, err := f.Run(ctx, flags.Arg(0), *withDeps)
+    if err != nil {
+        return err
+    }
+    if exitCode != 0 {
+        // Custom runners may not report an exit code for killed processes.
+        if exitCode < 0 {
+            exitCode = 1
+        }
+        os.Exit(exitCode)
+    }
+    return nil
+}
+
+// procfileFlags are the flags saying how the commands loading the Procfile read it.
+type procfileFlags struct {
+    procfiles procfilesFlag
+    profiles *string
+    envFiles *string
+    template *bool
+    format *string
+    port *int
+}
+
+// Add -f, -profile, -e, -template, the Procfile format flag named formatFlag
+// and, withPort, -p to flags.
+func addProcfileFlags(flags *flag.FlagSet, formatFlag string, withPort bool) *procfileFlags {
+    procfileFlags := &procfileFlags{
+    	profiles: flags.String("profile", "", "comma separated profiles to apply, only services without profiles run by default"),
+    	envFiles: flags.String("e", "", "comma separated .env files to read, the .env next to the Procfile by default"),
+    	template: flags.Bool("template", false, "render the Procfile as a Go template first"),
+    	format:   flags.String(formatFlag, foreman.FormatAuto, "format of the Procfile: "+strings.Join(foreman.ProcfileFormats(), ", ")),
+    }
+    flags.Var(&procfileFlags.procfiles, "f", "path of the Procfile, repeat to merge more Procfiles over it (default "+defaultProcfile+")")
+    if withPort {
+        procfileFlags.port = flags.Int("p", 0, "port services are numbered from, PORT or 5000 by default")
+    }
+    return procfileFlags
+}
+
+// Return the Procfile given first with -f, or the default one.
+func (p *procfileFlags) procfile() string {
+    if len(p.procfiles) == 0 {
+        return defaultProcfile
+    }
+    return p.procfiles[0]
+}
+
+// Turn the flags into options.
+func (p *procfileFlags) options() []foreman.Option {
+    opts := []foreman.Option{foreman.WithProcfileFormat(*p.format)}
+    if len(p.procfiles) > 1 {
+        opts = append(opts, foreman.WithOverrides(p.procfiles[1:]...))
+    }
+    if *p.profiles != "" {
+        opts = append(opts, foreman.WithProfiles(strings.Split(*p.profiles, ",")...))
+    }
+    if p.port != nil && *p.port != 0 {
+        opts = append(opts, foreman.WithBasePort(*p.port))
+    }
+    if *p.envFiles != "" {
+        opts = append(opts, foreman.WithEnvFiles(strings.Split(*p.envFiles, ",")...))
+    }
+    if *p.template {
+        opts = append(opts, foreman.WithProcfileTemplate())
+    }
+    return opts
+}
+
+// procfilesFlag collects the Procfiles of the repeatable -f flag.
+type procfilesFlag []string
+
+func (f *procfilesFlag) String() string {
+    return strings.Join(*f, ",")
+}
+
+func (f *procfilesFlag) Set(value string) error {
+    *f = append(*f, value)
+    return nil
+}
+
+// Choose the logger of foreman's own messages from the -log-format flag.
+func newLogger(format string) (foreman.Option, error) {
+    switch format {
+    case "text":
+        return foreman.WithLogger(log.New(os.Stdout, "", 0)), nil
+    case "json":
+        return foreman.WithStructuredLogger(foreman.NewJSONLogger(os.Stdout)), nil
+    }
+    return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// Listen on the control socket, refusing to take over the socket of a running foreman.
+func listenControl(socket string) (net.Listener, error) {
+    if controlRunning(socket) {
+        return nil, fmt.Errorf("foreman is already running on %s", socket)
+    }
+
+    // Remove the leftover of a foreman that did not exit cleanly.
+    os.Remove(socket)
+    return net.Listen("unix", socket)
+}
+
+func runStatus(args []string) error {
+    flags, socket := newFlagSet("status")
+    flags.Parse(args)
+
+    status, err := foreman.NewControlClient(*socket).Status()
+    if err != nil {
+        return err
+    }
+
+    names := make([]string, 0, len(status))
+    for serviceName := range status {
+        names = append(names, serviceName)
+    }
+    sort.Strings(names)
+
+    table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(table, "NAME\tSTATE\tPID\tUPTIME\tRESTARTS\tEXIT")
+    for _, serviceName := range names {
+        service := status[serviceName]
+        pid, uptime := "-", "-"
+        if service.PID != 0 {
+            pid = fmt.Sprint(service.PID)
+            uptime = service.Uptime.Round(time.Second).String()
+        }
+        exit := fmt.Sprint(service.ExitCode)
+        if service.ExitSignal != 0 {
+            exit += fmt.Sprintf(" (%v)", service.ExitSignal)
+        }
+        fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\n",
+            serviceName, service.State, pid, uptime, service.Restarts, exit)
+    }
+    return table.Flush()
+}
+
+type psRow struct {
+    Name string `json:"name"`
+    PID int `json:"pid"`
+    CPUPercent float64 `json:"cpu_percent"`
+    RSS uint64 `json:"rss"`
+    OpenFDs int32 `json:"open_fds"`
+    Uptime float64 `json:"uptime_seconds"`
+    Restarts int `json:"restarts"`
+}
+
+func runPS(args []string) error {
+    flags, socket := newFlagSet("ps")
+    asJSON := flags.Bool("json", false, "print the rows as JSON")
+    flags.Parse(args)
+
+    client := foreman.NewControlClient(*socket)
+    status, err := client.Status()
+    if err != nil {
+        return err
+    }
+    usage, err := client.Usage()
+    if err != nil {
+        return err
+    }
+
+    names := make([]string, 0, len(usage))
+    for serviceName := range usage {
+        names = append(names, serviceName)
+    }
+    sort.Strings(names)
+
+    rows := make([]psRow, 0, len(names))
+    for _, serviceName := range names {
+        rows = append(rows, psRow{
+        	Name:       serviceName,
+        	PID:        usage[serviceName].PID,
+        	CPUPercent: usage[serviceName].CPUPercent,
+        	RSS:        usage[serviceName].RSS,
+        	OpenFDs:    usage[serviceName].OpenFDs,
+        	Uptime:     status[serviceName].Uptime.Seconds(),
+        	Restarts:   status[serviceName].Restarts,
+        })
+    }
+
+    if *asJSON {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(rows)
+    }
+
+    table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(table, "NAME\tPID\tCPU%\tRSS\tFDS\tUPTIME\tRESTARTS")
+    for _, row := range rows {
+        uptime := time.Duration(row.Uptime * float64(time.Second)).Round(time.Second)
+        fmt.Fprintf(table, "%s\t%d\t%.1f\t%s\t%d\t%s\t%d\n",
+            row.Name, row.PID, row.CPUPercent, formatBytes(row.RSS), row.OpenFDs, uptime, row.Restarts)
+    }
+    return table.Flush()
+}
+
+// Format a size like 12.5MiB.
+func formatBytes(size uint64) string {
+    const unit = 1024
+    if size < unit {
+        return fmt.Sprintf("%dB", size)
+    }
+    value, exponent := float64(size)/unit, 0
+    for value >= unit && exponent < 3 {
+        value /= unit
+        exponent++
+    }
+    return fmt.Sprintf("%.1f%ciB", value, "KMGT"[exponent])
+}
+
+func runLaunch(args []string) error {
+    flags, socket := newFlagSet("launch")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errors.New("launch needs a service name")
+    }
+    return foreman.NewControlClient(*socket).Start(flags.Arg(0))
+}
+
+func runStop(args []string) error {
+    flags, socket := newFlagSet("stop")
+    flags.Parse(args)
+
+    return foreman.NewControlClient(*socket).Stop(flags.Arg(0))
+}
+
+func runRestart(args []string) error {
+    flags, socket := newFlagSet("restart")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errors.New("restart needs a service name")
+    }
+    return foreman.NewControlClient(*socket).Restart(flags.Arg(0))
+}
+
+func runPause(args []string) error {
+    flags, socket := newFlagSet("pause")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errors.New("pause needs a service name")
+    }
+    return foreman.NewControlClient(*socket).Pause(flags.Arg(0))
+}
+
+func runResume(args []string) error {
+    flags, socket := newFlagSet("resume")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        return errors.New("resume needs a service name")
+    }
+    return foreman.NewControlClient(*socket).Resume(flags.Arg(0))
+}
+
+func runSignal(args []string) error {
+    flags, socket := newFlagSet("signal")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        return errors.New("signal needs a service name and a signal, like HUP")
+    }
+    return foreman.NewControlClient(*socket).Signal(flags.Arg(0), flags.Arg(1))
+}
+
+func runReload(args []string) error {
+    flags, socket := newFlagSet("reload")
+    flags.Parse(args)
+
+    return foreman.NewControlClient(*socket).Reload()
+}
+
+func runLogs(args []string) error {
+    flags, socket := newFlagSet("logs")
+    follow := flags.Bool("f", false, "keep printing new output")
+    since := flags.String("since", "", "only print output since a time (RFC 3339) or a duration ago, like 10m")
+    tail := flags.Int("tail", -1, "only print the last `N` lines, or all of them when negative")
+    timestampFormat := flags.String("timestamp-format", "15:04:05", "Go time layout of the time printed before every line, empty for none")
+    flags.Parse(args)
+
+    query := foreman.LogQuery{Tail: *tail, Follow: *follow}
+    if *since != "" {
+        sinceTime, err := parseSince(*since, time.Now())
+        if err != nil {
+            return err
+        }
+        query.Since = sinceTime
+    }
+
+    return foreman.NewControlClient(*socket).Logs(flags.Arg(0), query, func(line foreman.LogLine) bool {
+        if *timestampFormat != "" {
+            fmt.Printf("%s ", line.Time.Local().Format(*timestampFormat))
+        }
+        fmt.Printf("%s | %s\n", line.Service, line.Line)
+        return true
+    })
+}
+
+// Parse the -since flag of logs, a time or a duration before now.
+func parseSince(since string, now time.Time) (time.Time, error) {
+    ago, err := time.ParseDuration(since)
+    if err == nil {
+        return now.Add(-ago), nil
+    }
+    sinceTime, err := time.Parse(time.RFC3339, since)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid -since %q, expected a duration like 10m or an RFC 3339 time", since)
+    }
+    return sinceTime, nil
+}
+
+func runCheck(args []string) error {
+    flags, socket := newFlagSet("check")
+    flags.Parse(args)
+
+    status, err := foreman.NewControlClient(*socket).Status()
+    if err != nil {
+        return err
+    }
+
+    var unhealthy []string
+    for serviceName, service := range status {
+        if !healthy(service) {
+            unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", serviceName, service.State))
+        }
+    }
+    if len(unhealthy) > 0 {
+        sort.Strings(unhealthy)
+        return fmt.Errorf("unhealthy services: %v", unhealthy)
+    }
+    return nil
+}
+
+// Report whether a service is fine: healthy, disabled, or a job that completed.
+func healthy(service foreman.ServiceStatus) bool {
+    switch service.State {
+    case foreman.StateHealthy, foreman.StateDisabled:
+        return true
+    case foreman.StateStopped:
+        return service.ExitCode == 0
+    }
+    return false
+}
+
+func runGraph(args []string) error {
+    flags, socket := newFlagSet("graph")
+    procfile := flags.String("f", defaultProcfile, "path of the Procfile")
+    format := flags.String("format", "dot", "dot or mermaid")
+    flags.Parse(args)
+
+    f, err := foreman.New(*procfile)
+    if err != nil {
+        return err
+    }
+
+    // Color the services by state when a foreman is running them.
+    status, err := foreman.NewControlClient(*socket).Status()
+    if err != nil {
+        status = nil
+    }
+
+    switch *format {
+    case "dot":
+        fmt.Print(f.DOT(status))
+    case "mermaid":
+        fmt.Print(f.Mermaid(status))
+    default:
+        return fmt.Errorf("unknown graph format %q, expected dot or mermaid", *format)
+    }
+    return nil
+}
+
+func runValidate(args []string) error {
+    flags := flag.NewFlagSet("foreman validate", flag.ExitOnError)
+    procfileOpts := addProcfileFlags(flags, "format", false)
+    flags.Parse(args)
+
+    diagnostics := foreman.Validate(procfileOpts.procfile(), procfileOpts.options()...)
+    for _, diagnostic := range diagnostics {
+        fmt.Println(diagnostic)
+    }
+    if len(diagnostics) > 0 {
+        return fmt.Errorf("%s has %d problems", procfileOpts.procfile(), len(diagnostics))
+    }
+    return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+    // daemonEnv marks the background process started by foreman start -d.
+    daemonEnv = "FOREMAN_DAEMON"
+    daemonStartTimeout = 10 * time.Second
+    daemonPollInterval = 50 * time.Millisecond
+)
+
+// Report whether this process is the background copy started by daemonize.
+func isDaemon() bool {
+    return os.Getenv(daemonEnv) != ""
+}
+
+// Start foreman again with the same arguments in a new session detached from
+// the terminal, its output going to logPath, and wait for its control socket.
+func daemonize(args []string, socket, logPath string) error {
+    if controlRunning(socket) {
+        return fmt.Errorf("foreman is already running on %s", socket)
+    }
+
+    executable, err := os.Executable()
+    if err != nil {
+        return err
+    }
+
+    logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    defer logFile.Close()
+
+    daemon := exec.Command(executable, append([]string{"start"}, args...)...)
+    daemon.Env = append(os.Environ(), daemonEnv+"=1")
+    daemon.Stdout = logFile
+    daemon.Stderr = logFile
+    daemon.SysProcAttr = detachedAttr()
+    err = daemon.Start()
+    if err != nil {
+        return err
+    }
+
+    exited := make(chan struct{})
+    go func() {
+        daemon.Wait()
+        close(exited)
+    }()
+
+    deadline := time.After(daemonStartTimeout)
+    for !controlRunning(socket) {
+        select {
+        case <-exited:
+            return fmt.Errorf("foreman exited while starting, see %s", logPath)
+        case <-deadline:
+            return fmt.Errorf("foreman didn't start in %v, see %s", daemonStartTimeout, logPath)
+        case <-time.After(daemonPollInterval):
+        }
+    }
+
+    fmt.Printf("foreman running in the background with pid %d, logging to %s\n", daemon.Process.Pid, logPath)
+    return nil
+}
+
+// Write the pid of this process to path, refusing to overwrite the pid file of a live process.
+func writePIDFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err == nil {
+        pid, err := strconv.Atoi(string(data))
+        if err == nil && pid != os.Getpid() && processAlive(pid) {
+            return fmt.Errorf("%s belongs to running process %d", path, pid)
+        }
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+
+    return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// Report whether a foreman answers on the control socket.
+func controlRunning(socket string) bool {
+    conn, err := net.Dial("unix", socket)
+    if err != nil {
+        return false
+    }
+    conn.Close()
+    return true
+}
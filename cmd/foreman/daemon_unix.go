@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// Return the attributes starting the daemon in a new session, detached from
+// the terminal.
+func detachedAttr() *syscall.SysProcAttr {
+    return &syscall.SysProcAttr{Setsid: true}
+}
+
+// Report whether a process is alive.
+func processAlive(pid int) bool {
+    return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code of a process that didn't exit yet.
+const stillActive = 259
+
+// Return the attributes starting the daemon without a console, out of reach
+// of the console control events of the terminal.
+func detachedAttr() *syscall.SysProcAttr {
+    return &syscall.SysProcAttr{CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// Report whether a process is alive.
+func processAlive(pid int) bool {
+    handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+    if err != nil {
+        return false
+    }
+    defer windows.CloseHandle(handle)
+
+    var exitCode uint32
+    err = windows.GetExitCodeProcess(handle, &exitCode)
+    return err == nil && exitCode == stillActive
+}
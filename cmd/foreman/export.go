@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codescalersinternships/IslamWalid-Foreman"
+)
+
+var exporters = map[string]foreman.Exporter{
+    "docker-compose": foreman.ComposeExporter{},
+    "launchd": foreman.LaunchdExporter{},
+    "supervisord": foreman.SupervisordExporter{},
+    "systemd": foreman.SystemdExporter{},
+}
+
+func runExport(args []string) error {
+    flags := flag.NewFlagSet("foreman export", flag.ExitOnError)
+    app := flags.String("app", "", "name of the exported app, the directory of the Procfile by default")
+    dir := flags.String("dir", ".", "directory the files are written to")
+    format := flags.String("format", "", "format to export to: "+strings.Join(exportFormats(), ", "))
+    // -format is the export format, the Procfile format is -procfile-format.
+    procfileOpts := addProcfileFlags(flags, "procfile-format", true)
+    flags.Parse(args)
+
+    // The format can also be given as an argument, like foreman export systemd.
+    if *format == "" && flags.NArg() == 1 {
+        *format = flags.Arg(0)
+    }
+    if *format == "" {
+        return fmt.Errorf("export needs a format: %s", strings.Join(exportFormats(), ", "))
+    }
+    exporter, ok := exporters[*format]
+    if !ok {
+        return fmt.Errorf("unknown export format %q, expected one of: %s", *format, strings.Join(exportFormats(), ", "))
+    }
+
+    f, err := foreman.New(procfileOpts.procfile(), procfileOpts.options()...)
+    if err != nil {
+        return err
+    }
+
+    if *app == "" {
+        absProcfile, err := filepath.Abs(procfileOpts.procfile())
+        if err != nil {
+            return err
+        }
+        *app = filepath.Base(filepath.Dir(absProcfile))
+    }
+    if *app == "" || strings.ContainsAny(*app, "/ ") {
+        return errors.New("invalid app name, set one with -app")
+    }
+
+    files, err := f.Export(exporter, *app)
+    if err != nil {
+        return err
+    }
+
+    err = os.MkdirAll(*dir, 0755)
+    if err != nil {
+        return err
+    }
+    for name, content := range files {
+        path := filepath.Join(*dir, name)
+        err := os.WriteFile(path, []byte(content), 0644)
+        if err != nil {
+            return err
+        }
+        fmt.Println("wrote", path)
+    }
+    return nil
+}
+
+func exportFormats() []string {
+    formats := make([]string, 0, len(exporters))
+    for format := range exporters {
+        formats = append(formats, format)
+    }
+    sort.Strings(formats)
+    return formats
+}
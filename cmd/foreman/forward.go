@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/codescalersinternships/IslamWalid-Foreman"
+)
+
+const defaultFluentdTag = "foreman"
+
+// forwardFlag collects the destinations of the repeatable -forward flag.
+type forwardFlag []string
+
+func (f *forwardFlag) String() string {
+    return strings.Join(*f, ",")
+}
+
+func (f *forwardFlag) Set(value string) error {
+    *f = append(*f, value)
+    return nil
+}
+
+// Turn the -forward destinations into options, fluentd://host:port[/tag] or an http(s) URL.
+func (f forwardFlag) options() ([]foreman.Option, error) {
+    var opts []foreman.Option
+    for _, destination := range f {
+        output, err := logOutput(destination)
+        if err != nil {
+            return nil, err
+        }
+        opts = append(opts, foreman.WithLogForwarding(output))
+    }
+    return opts, nil
+}
+
+func logOutput(destination string) (foreman.LogOutput, error) {
+    parsed, err := url.Parse(destination)
+    if err != nil {
+        return nil, err
+    }
+
+    switch parsed.Scheme {
+    case "fluentd":
+        tag := strings.Trim(parsed.Path, "/")
+        if tag == "" {
+            tag = defaultFluentdTag
+        }
+        return foreman.NewFluentdOutput(parsed.Host, tag), nil
+    case "http", "https":
+        return foreman.NewHTTPOutput(destination), nil
+    }
+    return nil, fmt.Errorf("unknown -forward destination %q, expected fluentd://host:port or an http(s) URL", destination)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codescalersinternships/IslamWalid-Foreman"
+)
+
+const (
+    defaultProcfile = "./Procfile"
+    defaultSocket = "./.foreman.sock"
+)
+
+type command struct {
+    name string
+    usage string
+    run func(args []string) error
+}
+
+var commands = []command{
+    {"start", "start every service of the Procfile", runStart},
+    {"run", "run a single service once and exit with its exit code", runRun},
+    {"status", "show the state of the running services", runStatus},
+    {"ps", "show the resource usage of the running services", runPS},
+    {"top", "monitor the running services interactively", runTop},
+    {"launch", "start a manual or stopped service", runLaunch},
+    {"stop", "stop a service and its dependents, or everything", runStop},
+    {"restart", "restart a service", runRestart},
+    {"pause", "suspend a service with SIGSTOP, along with its checks", runPause},
+    {"resume", "continue a paused service", runResume},
+    {"signal", "send a signal to a service, like HUP to reload its configuration", runSignal},
+    {"reload", "apply the changes of the Procfile to the running services", runReload},
+    {"logs", "follow the output of a service, or of all services", runLogs},
+    {"check", "exit with an error if a running service is unhealthy", runCheck},
+    {"graph", "print the dependency graph as Graphviz DOT or Mermaid", runGraph},
+    {"export", "convert the Procfile for another process manager", runExport},
+    {"validate", "report the problems of the Procfile without starting anything", runValidate},
+}
+
+func main() {
+    args := os.Args[1:]
+
+    // Without a subcommand foreman starts the services, like it always did.
+    name := "start"
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        name, args = args[0], args[1:]
+    }
+
+    if name == "help" {
+        usage()
+        return
+    }
+
+    for _, cmd := range commands {
+        if cmd.name == name {
+            err := cmd.run(args)
+            if err != nil {
+                fmt.Fprintln(os.Stderr, "foreman:", err)
+                os.Exit(exitCode(err))
+            }
+            return
+        }
+    }
+
+    fmt.Fprintf(os.Stderr, "foreman: unknown command %q\n", name)
+    usage()
+    os.Exit(2)
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: foreman <command> [flags] [service]")
+    fmt.Fprintln(os.Stderr)
+    fmt.Fprintln(os.Stderr, "commands:")
+    for _, cmd := range commands {
+        fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+    }
+}
+
+// Create the flag set of a subcommand with the flags shared by every command.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+    flags := flag.NewFlagSet("foreman "+name, flag.ExitOnError)
+    socket := flags.String("socket", defaultSocket, "control socket of the running foreman")
+    return flags, socket
+}
+
+// Return the exit code of foreman after err: the exit code of the main service
+// that stopped it, like an init process, or of the first service that failed
+// when running them once, or else 1.
+func exitCode(err error) int {
+    var mainErr *foreman.MainExitError
+    if errors.As(err, &mainErr) && mainErr.ExitCode > 0 {
+        return mainErr.ExitCode
+    }
+    var completionErr *foreman.CompletionError
+    if errors.As(err, &completionErr) && completionErr.ExitCode > 0 {
+        return completionErr.ExitCode
+    }
+    return 1
+}
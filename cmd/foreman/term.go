@@ -0,0 +1,42 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Put the terminal in raw mode, reading single key presses without echo.
+// The returned function restores the previous mode.
+func rawMode(tty *os.File) (func(), error) {
+    fd := int(tty.Fd())
+    old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := *old
+    raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+    raw.Iflag &^= unix.IXON | unix.ICRNL
+    raw.Cc[unix.VMIN] = 1
+    raw.Cc[unix.VTIME] = 0
+    err = unix.IoctlSetTermios(fd, ioctlSetTermios, &raw)
+    if err != nil {
+        return nil, err
+    }
+
+    return func() {
+        unix.IoctlSetTermios(fd, ioctlSetTermios, old)
+    }, nil
+}
+
+// Return the number of rows and columns of the terminal.
+func terminalSize(tty *os.File) (int, int) {
+    size, err := unix.IoctlGetWinsize(int(tty.Fd()), unix.TIOCGWINSZ)
+    if err != nil || size.Row == 0 {
+        return 24, 80
+    }
+    return int(size.Row), int(size.Col)
+}
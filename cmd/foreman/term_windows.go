@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Put the console in raw mode, reading single key presses without echo, and
+// have it interpret the escape sequences top draws with. The returned
+// function restores the previous modes.
+func rawMode(tty *os.File) (func(), error) {
+    input := windows.Handle(tty.Fd())
+    var oldInput uint32
+    err := windows.GetConsoleMode(input, &oldInput)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := oldInput &^ (windows.ENABLE_ECHO_INPUT | windows.ENABLE_LINE_INPUT | windows.ENABLE_PROCESSED_INPUT)
+    err = windows.SetConsoleMode(input, raw|windows.ENABLE_VIRTUAL_TERMINAL_INPUT)
+    if err != nil {
+        return nil, err
+    }
+
+    output := windows.Handle(os.Stdout.Fd())
+    var oldOutput uint32
+    err = windows.GetConsoleMode(output, &oldOutput)
+    if err == nil {
+        windows.SetConsoleMode(output, oldOutput|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+    }
+
+    return func() {
+        windows.SetConsoleMode(input, oldInput)
+        if err == nil {
+            windows.SetConsoleMode(output, oldOutput)
+        }
+    }, nil
+}
+
+// Return the number of rows and columns of the console window.
+func terminalSize(tty *os.File) (int, int) {
+    var info windows.ConsoleScreenBufferInfo
+    err := windows.GetConsoleScreenBufferInfo(windows.Handle(tty.Fd()), &info)
+    if err != nil {
+        return 24, 80
+    }
+    return int(info.Window.Bottom-info.Window.Top) + 1, int(info.Window.Right-info.Window.Left) + 1
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codescalersinternships/IslamWalid-Foreman"
+)
+
+const (
+    topRefreshInterval = time.Second
+    topLogLines = 200
+)
+
+// top is the state of the monitor: the latest status and usage of the
+// services and the last lines of their output.
+type top struct {
+    client *foreman.ControlClient
+
+    mu sync.Mutex
+    status map[string]foreman.ServiceStatus
+    usage map[string]foreman.ProcessUsage
+    logs map[string][]string
+    selected int
+    message string
+    err error
+}
+
+func runTop(args []string) error {
+    flags, socket := newFlagSet("top")
+    flags.Parse(args)
+
+    t := &top{client: foreman.NewControlClient(*socket), logs: make(map[string][]string)}
+    err := t.refresh()
+    if err != nil {
+        return err
+    }
+
+    restore, err := rawMode(os.Stdin)
+    if err != nil {
+        return fmt.Errorf("top needs a terminal: %w", err)
+    }
+    defer restore()
+
+    // Use the alternate screen and hide the cursor while running.
+    fmt.Print("\x1b[?1049h\x1b[?25l")
+    defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+    go t.client.Logs("", foreman.LogQuery{Tail: topLogLines, Follow: true}, func(line foreman.LogLine) bool {
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        lines := append(t.logs[line.Service], line.Line)
+        if len(lines) > topLogLines {
+            lines = lines[len(lines)-topLogLines:]
+        }
+        t.logs[line.Service] = lines
+        return true
+    })
+
+    keys := make(chan byte)
+    go readKeys(keys)
+
+    ticker := time.NewTicker(topRefreshInterval)
+    defer ticker.Stop()
+    refreshed := make(chan struct{}, 1)
+    go func() {
+        for range ticker.C {
+            t.refresh()
+            select {
+            case refreshed <- struct{}{}:
+            default:
+            }
+        }
+    }()
+
+    for {
+        t.draw()
+        select {
+        case key, ok := <-keys:
+            if !ok || !t.handleKey(key) {
+                return nil
+            }
+        case <-refreshed:
+        }
+    }
+}
+
+// Fetch the status and usage of the services from the running foreman.
+func (t *top) refresh() error {
+    status, err := t.client.Status()
+    if err != nil {
+        t.mu.Lock()
+        t.err = err
+        t.mu.Unlock()
+        return err
+    }
+    usage, _ := t.client.Usage()
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.status, t.usage, t.err = status, usage, nil
+    return nil
+}
+
+// Act on a key press, returning false to quit.
+func (t *top) handleKey(key byte) bool {
+    t.mu.Lock()
+    names := t.names()
+    selected := ""
+    if t.selected < len(names) {
+        selected = names[t.selected]
+    }
+    paused := t.status[selected].State == foreman.StatePaused
+    t.mu.Unlock()
+
+    var err error
+    switch key {
+    case 'q', 3:
+        return false
+    case 'k', 'A':
+        t.move(-1)
+    case 'j', 'B':
+        t.move(1)
+    case 'r':
+        t.setMessage("restarting " + selected)
+        err = t.client.Restart(selected)
+    case 's':
+        t.setMessage("stopping " + selected)
+        err = t.client.Stop(selected)
+    case 'p':
+        if paused {
+            t.setMessage("resuming " + selected)
+            err = t.client.Resume(selected)
+        } else {
+            t.setMessage("pausing " + selected)
+            err = t.client.Pause(selected)
+        }
+    default:
+        return true
+    }
+
+    if err != nil {
+        t.setMessage(err.Error())
+    }
+    go t.refresh()
+    return true
+}
+
+func (t *top) move(offset int) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.selected += offset
+    if t.selected >= len(t.status) {
+        t.selected = len(t.status) - 1
+    }
+    if t.selected < 0 {
+        t.selected = 0
+    }
+}
+
+func (t *top) setMessage(message string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.message = message
+}
+
+// Return the sorted service names. The caller holds t.mu.
+func (t *top) names() []string {
+    names := make([]string, 0, len(t.status))
+    for serviceName := range t.status {
+        names = append(names, serviceName)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Redraw the whole screen: the service table, then the output of the selected service.
+func (t *top) draw() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    rows, columns := terminalSize(os.Stdout)
+    var screen []string
+    screen = append(screen, "foreman top    ↑/k ↓/j select   r restart   s stop   p pause/resume   q quit")
+    screen = append(screen, fmt.Sprintf("  %-20s %-10s %7s %6s %9s %8s %8s", "NAME", "STATE", "PID", "CPU%", "RSS", "UPTIME", "RESTARTS"))
+
+    names := t.names()
+    for i, serviceName := range names {
+        status := t.status[serviceName]
+        usage, running := t.usage[serviceName]
+        cpu, rss := "-", "-"
+        if running {
+            cpu = fmt.Sprintf("%.1f", usage.CPUPercent)
+            rss = formatBytes(usage.RSS)
+        }
+        marker := " "
+        if i == t.selected {
+            marker = ">"
+        }
+        line := fmt.Sprintf("%s %-20s %s %7d %6s %9s %8s %8d", marker, serviceName, colorState(status.State),
+            status.PID, cpu, rss, status.Uptime.Round(time.Second), status.Restarts)
+        screen = append(screen, line)
+    }
+
+    if t.err != nil {
+        screen = append(screen, "", "\x1b[31m"+t.err.Error()+"\x1b[0m")
+    } else if t.message != "" {
+        screen = append(screen, "", t.message)
+    }
+
+    if t.selected < len(names) {
+        serviceName := names[t.selected]
+        screen = append(screen, "", fmt.Sprintf("── %s output ──", serviceName))
+        logs := t.logs[serviceName]
+        room := rows - len(screen) - 1
+        if room < 0 {
+            room = 0
+        }
+        if len(logs) > room {
+            logs = logs[len(logs)-room:]
+        }
+        screen = append(screen, logs...)
+    }
+
+    var out strings.Builder
+    out.WriteString("\x1b[H\x1b[2J")
+    for i, line := range screen {
+        if i >= rows {
+            break
+        }
+        if len(line) > columns && !strings.Contains(line, "\x1b[") {
+            line = line[:columns]
+        }
+        out.WriteString(line + "\r\n")
+    }
+    fmt.Print(out.String())
+}
+
+// Pad and color a state for the service table.
+func colorState(state foreman.State) string {
+    color := "0"
+    switch state {
+    case foreman.StateHealthy:
+        color = "32"
+    case foreman.StateStarting, foreman.StateRestarting:
+        color = "33"
+    case foreman.StateFailed, foreman.StateCrashed:
+        color = "31"
+    case foreman.StatePaused:
+        color = "36"
+    }
+    return fmt.Sprintf("\x1b[%sm%-10s\x1b[0m", color, state)
+}
+
+// Send the keys pressed on stdin, arrow keys reduced to the letter of their escape sequence.
+func readKeys(keys chan<- byte) {
+    buf := make([]byte, 8)
+    for {
+        n, err := os.Stdin.Read(buf)
+        if err != nil {
+            close(keys)
+            return
+        }
+        key := buf[0]
+        if n == 3 && buf[0] == '\x1b' && buf[1] == '[' {
+            key = buf[2]
+        }
+        keys <- key
+    }
+}
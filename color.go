@@ -0,0 +1,48 @@
+package foreman
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+)
+
+var colorPalette = []int{36, 33, 32, 35, 34, 31, 96, 93, 92, 95, 94, 91}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// Report whether service output should be colored, either as forced
+// by WithColor or because the output is a terminal.
+func (f *Foreman) useColor() bool {
+    if f.color != nil {
+        return *f.color
+    }
+    return isTerminal(f.output)
+}
+
+func isTerminal(w io.Writer) bool {
+    file, ok := w.(*os.File)
+    if !ok {
+        return false
+    }
+
+    info, err := file.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Wrap the service name in a color picked from the palette by its hash,
+// so a service keeps the same color across runs.
+func colorize(serviceName string) string {
+    hash := fnv.New32a()
+    hash.Write([]byte(serviceName))
+    color := colorPalette[hash.Sum32()%uint32(len(colorPalette))]
+    return fmt.Sprintf("\x1b[%dm%s\x1b[0m", color, serviceName)
+}
+
+func stripANSI(line string) string {
+    return ansiEscape.ReplaceAllString(line, "")
+}
@@ -0,0 +1,141 @@
+package foreman
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Start every enabled service in dependency order and wait for all of them to exit.
+// It is meant for workloads where no enabled service is restarted: a service is
+// launched once its dependencies have exited successfully and skipped if any of them
+// failed. It returns the exit code of every service that ran, and a CompletionError
+// if any of them failed or was skipped, with the exit code to exit with. It can only be called once, instead of Start.
+func (f *Foreman) RunToCompletion(ctx context.Context) (map[string]int, error) {
+    if !atomic.CompareAndSwapInt32(&f.lifecycle, lifecycleNew, lifecycleStarted) {
+        return nil, f.lifecycleError()
+    }
+
+    services := f.snapshot()
+    depGraph := graphOf(services)
+    cycle := depGraph.findCycle()
+    if cycle != nil {
+        atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+        return nil, &CyclicDependencyError{Cycle: cycle}
+    }
+
+    var startList []string
+    for _, serviceName := range depGraph.topSort() {
+        service := services[serviceName]
+        if !service.enabled {
+            continue
+        }
+        if service.restart != RestartNo {
+            atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+            return nil, fmt.Errorf("%s is restarted on exit, set run_once or restart: no", serviceName)
+        }
+        startList = append(startList, serviceName)
+    }
+    defer atomic.StoreInt32(&f.lifecycle, lifecycleStopped)
+
+    var mu sync.Mutex
+    exitCodes := make(map[string]int)
+    failed := make(map[string]string)
+    finished := make(map[string]chan struct{})
+    for _, serviceName := range startList {
+        finished[serviceName] = make(chan struct{})
+    }
+
+    run := func(serviceName string) {
+        defer close(finished[serviceName])
+
+        for _, depName := range services[serviceName].deps {
+            depFinished, ok := finished[depName]
+            if !ok {
+                continue
+            }
+            select {
+            case <-depFinished:
+            case <-ctx.Done():
+                return
+            }
+
+            mu.Lock()
+            _, depFailed := failed[depName]
+            if depFailed {
+                failed[serviceName] = fmt.Sprintf("%s (dependency %s failed)", serviceName, depName)
+            }
+            mu.Unlock()
+            if depFailed {
+                return
+            }
+        }
+
+        err := f.startService(serviceName)
+        if err != nil {
+            mu.Lock()
+            failed[serviceName] = fmt.Sprintf("%s (%v)", serviceName, err)
+            mu.Unlock()
+            return
+        }
+
+        service, _ := f.service(serviceName)
+        select {
+        case <-service.exited:
+        case <-ctx.Done():
+            return
+        }
+
+        service, _ = f.service(serviceName)
+        exitCode := service.exitCode
+        mu.Lock()
+        exitCodes[serviceName] = exitCode
+        if exitCode != 0 {
+            failed[serviceName] = fmt.Sprintf("%s (%s)", serviceName, describeExit(exitCode, service.exitSignal))
+        }
+        mu.Unlock()
+    }
+
+    for _, serviceName := range startList {
+        go run(serviceName)
+    }
+
+    for _, serviceName := range startList {
+        select {
+        case <-finished[serviceName]:
+        case <-ctx.Done():
+            f.stopAll()
+            mu.Lock()
+            defer mu.Unlock()
+            ranToCompletion := make(map[string]int, len(exitCodes))
+            for name, exitCode := range exitCodes {
+                ranToCompletion[name] = exitCode
+            }
+            return ranToCompletion, ctx.Err()
+        }
+    }
+
+    f.mu.Lock()
+    f.active = false
+    f.mu.Unlock()
+
+    if len(failed) == 0 {
+        return exitCodes, nil
+    }
+
+    var reasons []string
+    completionExitCode := 0
+    for _, serviceName := range startList {
+        if reason, ok := failed[serviceName]; ok {
+            reasons = append(reasons, reason)
+        }
+        if exitCodes[serviceName] > 0 && completionExitCode == 0 {
+            completionExitCode = exitCodes[serviceName]
+        }
+    }
+    if completionExitCode == 0 {
+        completionExitCode = 1
+    }
+    return exitCodes, &CompletionError{Failed: reasons, ExitCode: completionExitCode}
+}
@@ -0,0 +1,280 @@
+package foreman
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// Commands understood by the control server.
+const (
+    ControlStatus = "status"
+    ControlStart = "start"
+    ControlStop = "stop"
+    ControlRestart = "restart"
+    ControlLogs = "logs"
+    ControlUsage = "usage"
+    ControlReload = "reload"
+    ControlSignal = "signal"
+    ControlPause = "pause"
+    ControlResume = "resume"
+)
+
+// usageSampleInterval is how long CPU usage is sampled for usage requests.
+const usageSampleInterval = 500 * time.Millisecond
+
+type controlRequest struct {
+    Command string `json:"command"`
+    Service string `json:"service,omitempty"`
+    Signal string `json:"signal,omitempty"`
+    Logs *LogQuery `json:"logs,omitempty"`
+}
+
+type controlResponse struct {
+    Error string `json:"error,omitempty"`
+    Status map[string]ServiceStatus `json:"status,omitempty"`
+    Log *LogLine `json:"log,omitempty"`
+    Usage map[string]ProcessUsage `json:"usage,omitempty"`
+}
+
+// ControlClient sends commands to a foreman serving its control socket.
+type ControlClient struct {
+    socketPath string
+}
+
+// Serve control commands from other processes, like the foreman CLI, on listener.
+// It returns when the listener is closed.
+func (f *Foreman) ServeControl(listener net.Listener) error {
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return nil
+            }
+            return err
+        }
+        go f.handleControl(conn)
+    }
+}
+
+// Answer a single control request.
+func (f *Foreman) handleControl(conn net.Conn) {
+    defer conn.Close()
+
+    var request controlRequest
+    err := json.NewDecoder(conn).Decode(&request)
+    if err != nil {
+        return
+    }
+
+    encoder := json.NewEncoder(conn)
+    reply := func(err error) {
+        response := controlResponse{}
+        if err != nil {
+            response.Error = err.Error()
+        }
+        encoder.Encode(response)
+    }
+
+    switch request.Command {
+    case ControlStatus:
+        encoder.Encode(controlResponse{Status: f.Status()})
+    case ControlStart:
+        reply(f.StartService(request.Service))
+    case ControlStop:
+        if request.Service == "" {
+            reply(f.Stop(defaultStopTimeout))
+            return
+        }
+        reply(f.StopService(request.Service, true))
+    case ControlRestart:
+        reply(f.RestartService(request.Service))
+    case ControlLogs:
+        query := LogQuery{Tail: -1, Follow: true}
+        if request.Logs != nil {
+            query = *request.Logs
+        }
+        f.streamLogs(conn, encoder, request.Service, query)
+    case ControlUsage:
+        encoder.Encode(controlResponse{Usage: f.Usage(usageSampleInterval)})
+    case ControlReload:
+        reply(f.Reload())
+    case ControlSignal:
+        sig, err := parseSignal(request.Signal)
+        if err != nil {
+            reply(err)
+            return
+        }
+        reply(f.SignalService(request.Service, sig))
+    case ControlPause:
+        reply(f.PauseService(request.Service))
+    case ControlResume:
+        reply(f.ResumeService(request.Service))
+    default:
+        reply(errors.New("unknown command " + request.Command))
+    }
+}
+
+// Send the past output lines of a service matching query then, when following,
+// stream the next ones until the client disconnects.
+func (f *Foreman) streamLogs(conn net.Conn, encoder *json.Encoder, serviceName string, query LogQuery) {
+    if serviceName != "" {
+        if _, ok := f.service(serviceName); !ok {
+            encoder.Encode(controlResponse{Error: (&UnknownServiceError{Service: serviceName}).Error()})
+            return
+        }
+    }
+
+    history, lines, cancel := f.queryLogs(serviceName, query)
+    defer cancel()
+
+    for i := range history {
+        err := encoder.Encode(controlResponse{Log: &history[i]})
+        if err != nil {
+            return
+        }
+    }
+    if !query.Follow {
+        return
+    }
+
+    disconnected := make(chan struct{})
+    go func() {
+        conn.Read(make([]byte, 1))
+        close(disconnected)
+    }()
+
+    for {
+        select {
+        case line := <-lines:
+            err := encoder.Encode(controlResponse{Log: &line})
+            if err != nil {
+                return
+            }
+        case <-disconnected:
+            return
+        }
+    }
+}
+
+// Create a client for the control socket at socketPath.
+func NewControlClient(socketPath string) *ControlClient {
+    return &ControlClient{socketPath: socketPath}
+}
+
+// Report the status of every service of the running foreman.
+func (c *ControlClient) Status() (map[string]ServiceStatus, error) {
+    response, err := c.call(controlRequest{Command: ControlStatus})
+    if err != nil {
+        return nil, err
+    }
+    return response.Status, nil
+}
+
+// Report the resource usage of every running service of the running foreman.
+func (c *ControlClient) Usage() (map[string]ProcessUsage, error) {
+    response, err := c.call(controlRequest{Command: ControlUsage})
+    if err != nil {
+        return nil, err
+    }
+    return response.Usage, nil
+}
+
+// Start a service of the running foreman that isn't running, like a manual one.
+func (c *ControlClient) Start(serviceName string) error {
+    _, err := c.call(controlRequest{Command: ControlStart, Service: serviceName})
+    return err
+}
+
+// Stop a service and its dependents, or the whole foreman when serviceName is empty.
+func (c *ControlClient) Stop(serviceName string) error {
+    _, err := c.call(controlRequest{Command: ControlStop, Service: serviceName})
+    return err
+}
+
+// Restart a service of the running foreman.
+func (c *ControlClient) Restart(serviceName string) error {
+    _, err := c.call(controlRequest{Command: ControlRestart, Service: serviceName})
+    return err
+}
+
+// Send a signal, named like HUP or SIGHUP, to a service of the running foreman.
+func (c *ControlClient) Signal(serviceName, signal string) error {
+    _, err := c.call(controlRequest{Command: ControlSignal, Service: serviceName, Signal: signal})
+    return err
+}
+
+// Pause a service of the running foreman with SIGSTOP.
+func (c *ControlClient) Pause(serviceName string) error {
+    _, err := c.call(controlRequest{Command: ControlPause, Service: serviceName})
+    return err
+}
+
+// Resume a paused service of the running foreman with SIGCONT.
+func (c *ControlClient) Resume(serviceName string) error {
+    _, err := c.call(controlRequest{Command: ControlResume, Service: serviceName})
+    return err
+}
+
+// Reload the Procfile of the running foreman.
+func (c *ControlClient) Reload() error {
+    _, err := c.call(controlRequest{Command: ControlReload})
+    return err
+}
+
+// Call handle with the output lines of a service, or of all services when
+// serviceName is empty, matching query. It returns after the past lines unless
+// query.Follow is set, then when handle returns false or the foreman goes away.
+func (c *ControlClient) Logs(serviceName string, query LogQuery, handle func(LogLine) bool) error {
+    conn, err := net.Dial("unix", c.socketPath)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    err = json.NewEncoder(conn).Encode(controlRequest{Command: ControlLogs, Service: serviceName, Logs: &query})
+    if err != nil {
+        return err
+    }
+
+    decoder := json.NewDecoder(bufio.NewReader(conn))
+    for {
+        var response controlResponse
+        err := decoder.Decode(&response)
+        if err != nil {
+            return nil
+        }
+        if response.Error != "" {
+            return errors.New(response.Error)
+        }
+        if response.Log != nil && !handle(*response.Log) {
+            return nil
+        }
+    }
+}
+
+// Send a request and read the single response.
+func (c *ControlClient) call(request controlRequest) (controlResponse, error) {
+    conn, err := net.Dial("unix", c.socketPath)
+    if err != nil {
+        return controlResponse{}, err
+    }
+    defer conn.Close()
+
+    err = json.NewEncoder(conn).Encode(request)
+    if err != nil {
+        return controlResponse{}, err
+    }
+
+    var response controlResponse
+    err = json.NewDecoder(conn).Decode(&response)
+    if err != nil {
+        return controlResponse{}, err
+    }
+    if response.Error != "" {
+        return response, errors.New(response.Error)
+    }
+    return response, nil
+}
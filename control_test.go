@@ -0,0 +1,163 @@
+package foreman
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+    newControlledForeman := func(t *testing.T, opts ...Option) (*Foreman, *ControlClient) {
+        t.Helper()
+
+        foreman, err := New(testChainProcfile, append(opts, WithRunner(newFakeRunner()))...)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, serviceName := range []string{"database", "backend", "frontend"} {
+            foreman.startService(serviceName)
+        }
+
+        socket := filepath.Join(t.TempDir(), "foreman.sock")
+        listener, err := net.Listen("unix", socket)
+        if err != nil {
+            t.Fatal(err)
+        }
+        go foreman.ServeControl(listener)
+        t.Cleanup(func() {
+            listener.Close()
+            foreman.stopAll()
+        })
+
+        return foreman, NewControlClient(socket)
+    }
+
+    t.Run("status", func(t *testing.T) {
+        _, client := newControlledForeman(t)
+
+        status, err := client.Status()
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(len(status)), "3")
+        assertString(t, fmt.Sprint(status["backend"].PID), "2")
+        assertString(t, status["backend"].State.String(), StateStarting.String())
+    })
+
+    t.Run("restart", func(t *testing.T) {
+        foreman, client := newControlledForeman(t)
+
+        err := client.Restart("backend")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(foreman.snapshot()["backend"].pid), "4")
+    })
+
+    t.Run("start", func(t *testing.T) {
+        foreman, client := newControlledForeman(t)
+        foreman.StopService("frontend", false)
+
+        err := client.Start("frontend")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(foreman.snapshot()["frontend"].pid), "4")
+
+        err = client.Start("frontend")
+        assertError(t, err, "frontend is already running")
+    })
+
+    t.Run("signal", func(t *testing.T) {
+        foreman, client := newControlledForeman(t)
+
+        err := client.Signal("backend", "SIGHUP")
+        if err != nil {
+            t.Fatal(err)
+        }
+        for {
+            select {
+            case event := <-foreman.Events():
+                if event.Type != ServiceCrashed {
+                    continue
+                }
+                assertString(t, fmt.Sprint(event.Service, " ", event.Signal), "backend hangup")
+            case <-time.After(time.Second):
+                t.Fatal("backend didn't get the signal")
+            }
+            break
+        }
+
+        err = client.Signal("backend", "FOO")
+        assertError(t, err, `unknown signal "FOO"`)
+        foreman.StopService("frontend", false)
+        err = client.Signal("frontend", "HUP")
+        assertError(t, err, "frontend isn't running")
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        _, client := newControlledForeman(t)
+
+        err := client.Restart("unknown")
+        assertError(t, err, (&UnknownServiceError{Service: "unknown"}).Error())
+
+        err = client.Signal("unknown", "HUP")
+        assertError(t, err, (&UnknownServiceError{Service: "unknown"}).Error())
+
+        err = client.Logs("unknown", LogQuery{}, func(LogLine) bool { return true })
+        assertError(t, err, (&UnknownServiceError{Service: "unknown"}).Error())
+    })
+
+    t.Run("logs", func(t *testing.T) {
+        foreman, client := newControlledForeman(t)
+
+        lines := make(chan LogLine)
+        go client.Logs("backend", LogQuery{Follow: true}, func(line LogLine) bool {
+            lines <- line
+            return false
+        })
+
+        // Publish until the client subscribed, lines are dropped before that.
+        for {
+            foreman.publishLog("frontend", "ignored")
+            foreman.publishLog("backend", "listening")
+            select {
+            case line := <-lines:
+                assertString(t, line.Service, "backend")
+                assertString(t, line.Line, "listening")
+                return
+            case <-time.After(10 * time.Millisecond):
+            }
+        }
+    })
+
+    t.Run("logs history", func(t *testing.T) {
+        clock := newFakeClock()
+        foreman, client := newControlledForeman(t, WithClock(clock))
+
+        foreman.publishLog("backend", "first")
+        clock.advance(time.Minute)
+        foreman.publishLog("frontend", "second")
+        foreman.publishLog("backend", "third")
+
+        read := func(serviceName string, query LogQuery) []string {
+            t.Helper()
+            var lines []string
+            err := client.Logs(serviceName, query, func(line LogLine) bool {
+                lines = append(lines, line.Service+" "+line.Line)
+                return true
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            return lines
+        }
+
+        assertList(t, read("", LogQuery{Tail: -1}), []string{"backend first", "frontend second", "backend third"})
+        assertList(t, read("backend", LogQuery{Tail: -1}), []string{"backend first", "backend third"})
+        assertList(t, read("", LogQuery{Tail: 2}), []string{"frontend second", "backend third"})
+        assertList(t, read("", LogQuery{Tail: -1, Since: time.Unix(30, 0)}), []string{"frontend second", "backend third"})
+    })
+}
@@ -0,0 +1,44 @@
+package foreman
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parse a user or group, a name like "www-data" or a numeric id like 33.
+func parseAccount(field string, value any) (string, error) {
+    switch v := value.(type) {
+    case int:
+        if v >= 0 {
+            return strconv.Itoa(v), nil
+        }
+    case string:
+        if strings.TrimSpace(v) != "" {
+            return v, nil
+        }
+    }
+    return "", fieldError(field, "expected a name or a numeric id, got %v", value)
+}
+
+// Parse a list of groups, each a name or a numeric id.
+func parseAccountList(field string, value any) ([]string, error) {
+    list, ok := value.([]any)
+    if !ok {
+        return nil, fieldError(field, "expected a list of groups, got %v", value)
+    }
+
+    var accounts []string
+    for _, item := range list {
+        account, err := parseAccount(field, item)
+        if err != nil {
+            return nil, err
+        }
+        accounts = append(accounts, account)
+    }
+    return accounts, nil
+}
+
+// Report whether a service runs as another user or group than foreman.
+func (s Service) switchesUser() bool {
+    return s.user != "" || s.group != "" || len(s.supplementaryGroups) > 0
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+
+package foreman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Set the user and groups cmd runs as from the service's user, group and
+// supplementary groups. The group defaults to the primary group of the user,
+// and the supplementary groups to the groups the user is a member of.
+// Switching to another user or group takes foreman running as root.
+func setCredential(cmd *exec.Cmd, service Service) error {
+    if !service.switchesUser() {
+        return nil
+    }
+
+    credential := &syscall.Credential{Uid: uint32(os.Geteuid()), Gid: uint32(os.Getegid()), NoSetGroups: true}
+    hasGroup := false
+    if service.user != "" {
+        account, uid, err := lookupUser(service.user)
+        if err != nil {
+            return err
+        }
+        credential.Uid = uid
+        if account != nil {
+            gid, err := strconv.ParseUint(account.Gid, 10, 32)
+            if err != nil {
+                return fmt.Errorf("user %s: invalid group id %q", service.user, account.Gid)
+            }
+            credential.Gid, hasGroup = uint32(gid), true
+            credential.Groups, err = memberGroups(account)
+            if err != nil {
+                return err
+            }
+            credential.NoSetGroups = false
+        }
+    }
+    if service.group != "" {
+        gid, err := lookupGroup(service.group)
+        if err != nil {
+            return err
+        }
+        credential.Gid, hasGroup = gid, true
+    }
+    if service.user != "" && !hasGroup {
+        return fmt.Errorf("user %s has no account to take the primary group from, set the group", service.user)
+    }
+    if len(service.supplementaryGroups) > 0 {
+        credential.Groups = nil
+        for _, group := range service.supplementaryGroups {
+            gid, err := lookupGroup(group)
+            if err != nil {
+                return err
+            }
+            credential.Groups = append(credential.Groups, gid)
+        }
+        credential.NoSetGroups = false
+    }
+
+    if os.Geteuid() != 0 {
+        if credential.Uid == uint32(os.Geteuid()) && credential.Gid == uint32(os.Getegid()) && len(service.supplementaryGroups) == 0 {
+            // Foreman already runs as them.
+            return nil
+        }
+        return errors.New("foreman must run as root to run services as another user or group")
+    }
+    cmd.SysProcAttr.Credential = credential
+    return nil
+}
+
+// Look up a user by name or numeric id. A numeric id without an account is
+// returned alone, as in containers whose users aren't in /etc/passwd.
+func lookupUser(name string) (*user.User, uint32, error) {
+    account, err := user.Lookup(name)
+    if err != nil {
+        account, err = user.LookupId(name)
+    }
+    if err == nil {
+        uid, err := strconv.ParseUint(account.Uid, 10, 32)
+        if err != nil {
+            return nil, 0, fmt.Errorf("user %s: invalid user id %q", name, account.Uid)
+        }
+        return account, uint32(uid), nil
+    }
+
+    uid, parseErr := strconv.ParseUint(name, 10, 32)
+    if parseErr != nil {
+        return nil, 0, fmt.Errorf("unknown user %s", name)
+    }
+    return nil, uint32(uid), nil
+}
+
+// Look up a group by name or numeric id, which needs no entry in /etc/group.
+func lookupGroup(name string) (uint32, error) {
+    group, err := user.LookupGroup(name)
+    if err == nil {
+        name = group.Gid
+    }
+    gid, err := strconv.ParseUint(name, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("unknown group %s", name)
+    }
+    return uint32(gid), nil
+}
+
+// Return the ids of the groups a user is a member of.
+func memberGroups(account *user.User) ([]uint32, error) {
+    ids, err := account.GroupIds()
+    if err != nil {
+        return nil, fmt.Errorf("user %s: %v", account.Username, err)
+    }
+
+    var groups []uint32
+    for _, id := range ids {
+        gid, err := strconv.ParseUint(id, 10, 32)
+        if err == nil {
+            groups = append(groups, uint32(gid))
+        }
+    }
+    return groups, nil
+}
@@ -0,0 +1,13 @@
+package foreman
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, service Service) error {
+    if service.switchesUser() {
+        return errors.New("running services as another user or group isn't supported on Windows")
+    }
+    return nil
+}
@@ -0,0 +1,213 @@
+package foreman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Procfile formats accepted by WithProcfileFormat.
+const (
+    FormatAuto = "auto"
+    FormatYAML = "yaml"
+    FormatClassic = "classic"
+    FormatTOML = "toml"
+    FormatJSON = "json"
+)
+
+// Decoder reads the content of a Procfile in some format.
+type Decoder interface {
+    // Decode returns the top level keys of the Procfile: the services by name
+    // and the include, defaults and env blocks. Numbers must be ints or float64s.
+    // procfilePath names the file in errors.
+    Decode(procfilePath string, data []byte) (map[string]any, error)
+}
+
+var (
+    decoders = map[string]Decoder{
+        FormatYAML: YAMLDecoder{},
+        FormatClassic: ClassicDecoder{},
+        FormatTOML: TOMLDecoder{},
+        FormatJSON: JSONDecoder{},
+    }
+    extensionFormats = map[string]string{
+        ".yml": FormatYAML,
+        ".yaml": FormatYAML,
+        ".toml": FormatTOML,
+        ".json": FormatJSON,
+    }
+)
+
+// Return the names of the known Procfile formats, sorted.
+func ProcfileFormats() []string {
+    formats := []string{FormatAuto}
+    for format := range decoders {
+        formats = append(formats, format)
+    }
+    sort.Strings(formats)
+    return formats
+}
+
+// Choose the decoder of a Procfile: the WithDecoder one, else the one of the
+// format, else the one of the file extension, else the classic one when the
+// content looks like a classic Procfile and the YAML one otherwise.
+func (s procfileSettings) decoderFor(procfilePath string, data []byte) (Decoder, error) {
+    if s.decoder != nil {
+        return s.decoder, nil
+    }
+
+    format := s.format
+    if format == FormatAuto || format == "" {
+        format = extensionFormats[strings.ToLower(filepath.Ext(procfilePath))]
+    }
+    if format == "" {
+        format = FormatYAML
+        if isClassicProcfile(data) {
+            format = FormatClassic
+        }
+    }
+
+    decoder, ok := decoders[format]
+    if !ok {
+        return nil, fmt.Errorf("unknown Procfile format %q, expected one of: %s", format, strings.Join(ProcfileFormats(), ", "))
+    }
+    return decoder, nil
+}
+
+// positionDecoder is implemented by the decoders knowing where the keys of a
+// Procfile are, by dotted path like web.checks.cmd.
+type positionDecoder interface {
+    positions(procfilePath string, data []byte) (keyPositions, error)
+}
+
+// keyPositions are the positions of the keys of a Procfile by dotted path.
+type keyPositions map[string]Position
+
+// Return the position of the field of a service, or of the global field when
+// serviceName is empty. Fields the service doesn't set are looked up in the
+// defaults block, and unknown fields get the position of their parent field.
+func (p keyPositions) locate(serviceName, field string) Position {
+    for path := field; path != ""; {
+        key := path
+        if serviceName != "" {
+            key = serviceName + "." + path
+        }
+        if position, ok := p[key]; ok {
+            return position
+        }
+        if position, ok := p[defaultsKey+"."+path]; ok && serviceName != "" {
+            return position
+        }
+
+        dot := strings.LastIndex(path, ".")
+        if dot < 0 {
+            break
+        }
+        path = path[:dot]
+    }
+    return p[serviceName]
+}
+
+// Set the position of a ParseError from the positions of the keys, unless it has one.
+func (p keyPositions) locateError(serviceName string, err error) error {
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) && parseErr.Position.Line == 0 {
+        parseErr.Position = p.locate(serviceName, parseErr.Field)
+    }
+    return err
+}
+
+// YAMLDecoder reads YAML Procfiles.
+type YAMLDecoder struct{}
+
+func (YAMLDecoder) Decode(procfilePath string, data []byte) (map[string]any, error) {
+    procfileMap := map[string]any{}
+    err := yaml.Unmarshal(data, procfileMap)
+    if err != nil {
+        return nil, err
+    }
+    return procfileMap, nil
+}
+
+func (YAMLDecoder) positions(procfilePath string, data []byte) (keyPositions, error) {
+    var document yaml.Node
+    err := yaml.Unmarshal(data, &document)
+    if err != nil {
+        return nil, err
+    }
+
+    positions := make(keyPositions)
+    if len(document.Content) > 0 {
+        addPositions(positions, procfilePath, "", document.Content[0])
+    }
+    return positions, nil
+}
+
+// Record the positions of the keys of a mapping node and of the mappings under it.
+func addPositions(positions keyPositions, procfilePath, prefix string, node *yaml.Node) {
+    if node.Kind != yaml.MappingNode {
+        return
+    }
+    for i := 0; i+1 < len(node.Content); i += 2 {
+        key, value := node.Content[i], node.Content[i+1]
+        path := key.Value
+        if prefix != "" {
+            path = prefix + "." + key.Value
+        }
+        positions[path] = Position{File: procfilePath, Line: key.Line, Column: key.Column}
+        addPositions(positions, procfilePath, path, value)
+    }
+}
+
+// TOMLDecoder reads TOML Procfiles, where services are tables like [web].
+type TOMLDecoder struct{}
+
+func (TOMLDecoder) Decode(procfilePath string, data []byte) (map[string]any, error) {
+    procfileMap := map[string]any{}
+    _, err := toml.Decode(string(data), &procfileMap)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", procfilePath, err)
+    }
+    return normalizeNumbers(procfileMap).(map[string]any), nil
+}
+
+// JSONDecoder reads JSON Procfiles, an object of services.
+type JSONDecoder struct{}
+
+func (JSONDecoder) Decode(procfilePath string, data []byte) (map[string]any, error) {
+    procfileMap := map[string]any{}
+    err := json.Unmarshal(data, &procfileMap)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", procfilePath, err)
+    }
+    return normalizeNumbers(procfileMap).(map[string]any), nil
+}
+
+// Turn the int64s of TOML and the whole float64s of JSON into the ints the
+// parser expects, in nested maps and lists too.
+func normalizeNumbers(value any) any {
+    switch v := value.(type) {
+    case int64:
+        return int(v)
+    case float64:
+        if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+            return int(v)
+        }
+    case map[string]any:
+        for key, item := range v {
+            v[key] = normalizeNumbers(item)
+        }
+    case []any:
+        for i, item := range v {
+            v[i] = normalizeNumbers(item)
+        }
+    }
+    return value
+}
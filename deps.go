@@ -0,0 +1,233 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DepCondition is what a service waits for from a dependency before starting.
+type DepCondition string
+
+const (
+    DepStarted DepCondition = "service_started"
+    DepHealthy DepCondition = "service_healthy"
+    DepCompleted DepCondition = "service_completed_successfully"
+)
+
+// Parse deps given either as a list of names or as a map from names to
+// conditions like docker-compose's depends_on. Each condition may also be a map
+// with condition and timeout keys.
+func parseDeps(field string, value any) ([]string, map[string]DepCondition, map[string]time.Duration, error) {
+    switch deps := value.(type) {
+    case []any:
+        var names []string
+        for _, dep := range deps {
+            name, ok := dep.(string)
+            if !ok {
+                return nil, nil, nil, fieldError(field, "expected a service name, got %v", dep)
+            }
+            names = append(names, name)
+        }
+        return names, nil, nil, nil
+    case map[string]any:
+        var names []string
+        conditions := make(map[string]DepCondition)
+        timeouts := make(map[string]time.Duration)
+        for name, value := range deps {
+            if options, ok := value.(map[string]any); ok {
+                value = options["condition"]
+                if timeout, ok := options["timeout"]; ok {
+                    parsed, err := parseDuration(field, timeout)
+                    if err != nil {
+                        return nil, nil, nil, err
+                    }
+                    timeouts[name] = parsed
+                }
+            }
+
+            condition, err := parseDepCondition(field, value)
+            if err != nil {
+                return nil, nil, nil, err
+            }
+            names = append(names, name)
+            conditions[name] = condition
+        }
+        sort.Strings(names)
+        return names, conditions, timeouts, nil
+    }
+    return nil, nil, nil, fieldError(field, "expected a list or a map of services, got %v", value)
+}
+
+// Parse a dependency condition, service_started when left empty.
+func parseDepCondition(field string, value any) (DepCondition, error) {
+    if value == nil {
+        return DepStarted, nil
+    }
+
+    name, _ := value.(string)
+    switch condition := DepCondition(name); condition {
+    case DepStarted, DepHealthy, DepCompleted:
+        return condition, nil
+    }
+    return "", fieldError(field, "expected service_started, service_healthy or service_completed_successfully, got %v", value)
+}
+
+// Return the condition a service waits for from one of its dependencies.
+// Dependencies without one must be healthy when WithHealthyDependencies is used.
+func (f *Foreman) depCondition(service Service, depName string) DepCondition {
+    if condition, ok := service.depConditions[depName]; ok {
+        return condition
+    }
+    if f.healthyDeps {
+        return DepHealthy
+    }
+    return DepStarted
+}
+
+// Return how long a service waits for one of its dependencies, zero meaning no limit.
+func (s Service) depTimeoutFor(depName string) time.Duration {
+    if timeout, ok := s.depTimeouts[depName]; ok {
+        return timeout
+    }
+    return s.depTimeout
+}
+
+// Wait until a dependency meets the condition it must reach before the service
+// starts. It returns false if the wait timed out, or if the dependency exited
+// before becoming healthy and isn't being restarted.
+func (f *Foreman) waitDep(service Service, depName string) bool {
+    dep, _ := f.service(depName)
+
+    var ready, exited <-chan struct{}
+    switch f.depCondition(service, depName) {
+    case DepHealthy:
+        if !dep.active {
+            return true
+        }
+        f.mu.Lock()
+        ready = f.healthyChan(depName)
+        f.mu.Unlock()
+        exited = dep.exited
+    case DepCompleted:
+        if !dep.active {
+            return true
+        }
+        ready = dep.exited
+    default:
+        return true
+    }
+
+    var timeout <-chan time.Time
+    if limit := service.depTimeoutFor(depName); limit > 0 {
+        timeout = f.clock.After(limit)
+    }
+
+    // retry polls a dependency waiting for its restart, which has no exited
+    // channel until its next process starts.
+    var retry <-chan time.Time
+    for {
+        select {
+        case <-ready:
+            return true
+        case <-f.done:
+            return true
+        case <-exited:
+        case <-retry:
+        case <-timeout:
+            f.log(LogRecord{
+            	Level:   LevelWarning,
+            	Event:   "dependency timeout",
+            	Service: service.serviceName,
+            	Message: fmt.Sprintf("%s: timed out after %v waiting for %s", service.serviceName, service.depTimeoutFor(depName), depName),
+            })
+            return false
+        }
+
+        select {
+        case <-ready:
+            // It became healthy before exiting.
+            return true
+        default:
+        }
+        dep, _ = f.service(depName)
+        exited, retry = nil, nil
+        switch {
+        case dep.active:
+            exited = dep.exited
+        case dep.state == StateRestarting:
+            retry = f.clock.After(depRetryInterval)
+        default:
+            f.log(LogRecord{
+            	Level:   LevelWarning,
+            	Event:   "dependency exited",
+            	Service: service.serviceName,
+            	Message: fmt.Sprintf("%s: %s exited before becoming healthy", service.serviceName, depName),
+            })
+            return false
+        }
+    }
+}
+
+// Report whether the process of a service has run and exited successfully.
+func (s Service) exitedSuccessfully() bool {
+    return !s.active && s.pid != 0 && s.exitCode == 0
+}
+
+// Turn the before field of init tasks into dependencies: the services an init
+// task runs before, and the services depending on it without a condition, wait
+// for it to complete successfully. scaled maps the scaled services to their
+// instances, which all wait for the task.
+func expandBefore(services map[string]Service, scaled map[string][]string) error {
+    var taskNames []string
+    for serviceName, service := range services {
+        if len(service.before) > 0 {
+            taskNames = append(taskNames, serviceName)
+        }
+    }
+    sort.Strings(taskNames)
+
+    for _, taskName := range taskNames {
+        for _, target := range services[taskName].before {
+            targetNames, ok := scaled[target]
+            if !ok {
+                targetNames = []string{target}
+            }
+            for _, targetName := range targetNames {
+                service, ok := services[targetName]
+                if !ok {
+                    return &ParseError{Service: taskName, Field: "before", Cause: fmt.Errorf("unknown service %s", target)}
+                }
+                if _, ok := service.depConditions[taskName]; !ok {
+                    service.deps = append(service.deps, taskName)
+                }
+                service.depConditions = withCondition(service.depConditions, taskName, DepCompleted)
+                services[targetName] = service
+            }
+        }
+    }
+
+    isTask := make(map[string]bool, len(taskNames))
+    for _, taskName := range taskNames {
+        isTask[taskName] = true
+    }
+    for serviceName, service := range services {
+        for _, depName := range service.deps {
+            if _, ok := service.depConditions[depName]; isTask[depName] && !ok {
+                service.depConditions = withCondition(service.depConditions, depName, DepCompleted)
+                services[serviceName] = service
+            }
+        }
+    }
+    return nil
+}
+
+// Return a copy of conditions with the condition of a dependency set.
+func withCondition(conditions map[string]DepCondition, depName string, condition DepCondition) map[string]DepCondition {
+    copied := copyConditions(conditions)
+    if copied == nil {
+        copied = make(map[string]DepCondition)
+    }
+    copied[depName] = condition
+    return copied
+}
@@ -0,0 +1,298 @@
+package foreman
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+    defaultEnvFile = ".env"
+    envFileKey = "env_file"
+)
+
+var (
+    envVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+    envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+)
+
+// Read the variables of .env files, later files overriding earlier ones. Without
+// files, the .env file next to the Procfile is read if there is one.
+func loadDotEnv(procfileDir string, paths []string) (map[string]string, error) {
+    if len(paths) == 0 {
+        path := filepath.Join(procfileDir, defaultEnvFile)
+        if _, err := os.Stat(path); err != nil {
+            return nil, nil
+        }
+        paths = []string{path}
+    }
+
+    env := make(map[string]string)
+    for _, path := range paths {
+        err := readEnvFile(path, env)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return env, nil
+}
+
+// Read the KEY=VALUE lines of an env file into env. Lines may start with export,
+// and # starts a comment. Values may be quoted: ${VAR} and ${VAR:-default} are
+// expanded in double quoted and unquoted values from the variables read so far
+// and the environment, and single quoted values are kept as they are.
+func readEnvFile(path string, env map[string]string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    lookup := func(name string) (string, bool) {
+        if value, ok := env[name]; ok {
+            return value, true
+        }
+        return os.LookupEnv(name)
+    }
+
+    scanner := bufio.NewScanner(file)
+    for lineNumber := 1; scanner.Scan(); lineNumber++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+        name, value, ok := strings.Cut(line, "=")
+        name = strings.TrimSpace(name)
+        if !ok || !envName.MatchString(name) {
+            return fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", path, lineNumber, line)
+        }
+
+        parsed, err := parseEnvValue(strings.TrimSpace(value))
+        if err != nil {
+            return fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+        }
+        if parsed.expand {
+            parsed.text = expandEnv(parsed.text, lookup)
+        }
+        env[name] = parsed.text
+    }
+    return scanner.Err()
+}
+
+type envValue struct {
+    text string
+    expand bool
+}
+
+// Unquote the value of an env file line.
+func parseEnvValue(value string) (envValue, error) {
+    switch {
+    case strings.HasPrefix(value, "'"):
+        end := strings.Index(value[1:], "'")
+        if end < 0 {
+            return envValue{}, fmt.Errorf("unterminated single quoted value")
+        }
+        return envValue{text: value[1 : end+1]}, nil
+
+    case strings.HasPrefix(value, `"`):
+        var text strings.Builder
+        for i := 1; i < len(value); i++ {
+            switch value[i] {
+            case '"':
+                return envValue{text: text.String(), expand: true}, nil
+            case '\\':
+                if i+1 < len(value) {
+                    i++
+                    switch value[i] {
+                    case 'n':
+                        text.WriteByte('\n')
+                    case 't':
+                        text.WriteByte('\t')
+                    default:
+                        text.WriteByte(value[i])
+                    }
+                    continue
+                }
+            }
+            text.WriteByte(value[i])
+        }
+        return envValue{}, fmt.Errorf("unterminated double quoted value")
+    }
+
+    if comment := strings.Index(value, " #"); comment >= 0 {
+        value = strings.TrimSpace(value[:comment])
+    }
+    return envValue{text: value, expand: true}, nil
+}
+
+// Replace the ${VAR} references of s by their value, leaving unknown variables as they are.
+// ${VAR:-default} is replaced by default when VAR is unset or empty.
+func expandEnv(s string, lookup func(string) (string, bool)) string {
+    return envVariable.ReplaceAllStringFunc(s, func(reference string) string {
+        match := envVariable.FindStringSubmatch(reference)
+        value, ok := lookup(match[1])
+        if match[2] != "" && value == "" {
+            return match[3]
+        }
+        if ok {
+            return value
+        }
+        return reference
+    })
+}
+
+// Merge the .env variables and the env_file of a service definition into its env,
+// then expand ${VAR} in its cmd, check cmd and ports with that environment, extra
+// KEY=VALUE entries and foreman's own environment. Relative env_file paths are
+// resolved with resolve. The definition isn't modified, a copy is returned.
+func interpolateService(serviceMap map[string]any, dotEnv map[string]string, extra []string, resolve func(string) string) (map[string]any, error) {
+    env := make(map[string]string)
+    for key, value := range dotEnv {
+        env[key] = value
+    }
+
+    if value, ok := serviceMap[envFileKey]; ok {
+        paths, err := parsePaths(envFileKey, value)
+        if err != nil {
+            return nil, err
+        }
+        for _, path := range paths {
+            err = readEnvFile(resolve(path), env)
+            if err != nil {
+                return nil, fieldError(envFileKey, "%v", err)
+            }
+        }
+    }
+
+    if value, ok := serviceMap[envKey]; ok {
+        serviceEnv, err := parseEnv(envKey, value)
+        if err != nil {
+            return nil, err
+        }
+        for key, value := range serviceEnv {
+            env[key] = value
+        }
+    }
+
+    lookup := func(name string) (string, bool) {
+        if value, ok := env[name]; ok {
+            return value, true
+        }
+        for i := len(extra) - 1; i >= 0; i-- {
+            if key, value, ok := strings.Cut(extra[i], "="); ok && key == name {
+                return value, true
+            }
+        }
+        return os.LookupEnv(name)
+    }
+
+    interpolated := make(map[string]any, len(serviceMap))
+    for key, value := range serviceMap {
+        interpolated[key] = value
+    }
+    if len(env) > 0 {
+        envMap := make(map[string]any, len(env))
+        for key, value := range env {
+            envMap[key] = value
+        }
+        interpolated[envKey] = envMap
+    }
+
+    if cmd, ok := interpolated["cmd"]; ok {
+        interpolated["cmd"] = expandCmd(cmd, lookup)
+    }
+    for _, checksKey := range checkBlocks {
+        if checks, ok := interpolated[checksKey].(map[string]any); ok {
+            interpolated[checksKey] = expandChecks(checks, lookup)
+        }
+    }
+    return interpolated, nil
+}
+
+// Expand the variables of a command line or of every argument of a list.
+func expandCmd(cmd any, lookup func(string) (string, bool)) any {
+    switch cmd := cmd.(type) {
+    case string:
+        return expandEnv(cmd, lookup)
+    case []any:
+        expandedArgs := make([]any, len(cmd))
+        for i, arg := range cmd {
+            if arg, ok := arg.(string); ok {
+                expandedArgs[i] = expandEnv(arg, lookup)
+                continue
+            }
+            expandedArgs[i] = arg
+        }
+        return expandedArgs
+    }
+    return cmd
+}
+
+// Expand the variables of the commands, addresses, ports and ping hosts of a
+// checks block.
+func expandChecks(checks map[string]any, lookup func(string) (string, bool)) map[string]any {
+    expandedChecks := make(map[string]any, len(checks))
+    for key, value := range checks {
+        expandedChecks[key] = value
+    }
+    if cmd, ok := checks["cmd"]; ok {
+        expandedChecks["cmd"] = expandCmd(cmd, lookup)
+    }
+    switch grpc := checks["grpc"].(type) {
+    case string:
+        expandedChecks["grpc"] = expandEnv(grpc, lookup)
+    case map[string]any:
+        expandedGRPC := make(map[string]any, len(grpc))
+        for key, value := range grpc {
+            expandedGRPC[key] = value
+            if value, ok := value.(string); ok {
+                expandedGRPC[key] = expandEnv(value, lookup)
+            }
+        }
+        expandedChecks["grpc"] = expandedGRPC
+    }
+    for _, key := range []string{"tcp_ports", "udp_ports", "ping"} {
+        ports, ok := checks[key].([]any)
+        if !ok {
+            continue
+        }
+        expandedPorts := make([]any, len(ports))
+        for i, port := range ports {
+            if port, ok := port.(string); ok {
+                expandedPorts[i] = expandEnv(port, lookup)
+                continue
+            }
+            expandedPorts[i] = port
+        }
+        expandedChecks[key] = expandedPorts
+    }
+    return expandedChecks
+}
+
+// Merge environments, the variables of later ones taking precedence.
+func mergeEnv(envs ...map[string]string) map[string]string {
+    merged := make(map[string]string)
+    for _, env := range envs {
+        for key, value := range env {
+            merged[key] = value
+        }
+    }
+    return merged
+}
+
+// Parse a path or a list of paths, like env_file.
+func parsePaths(field string, value any) ([]string, error) {
+    if path, ok := value.(string); ok {
+        return []string{path}, nil
+    }
+    paths, err := parseStringList(field, value)
+    if err != nil {
+        return nil, fieldError(field, "expected a path or a list of paths, got %v", value)
+    }
+    return paths, nil
+}
@@ -0,0 +1,154 @@
+package foreman
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CyclicDependencyError is returned when the services depend on each other in a cycle.
+type CyclicDependencyError struct {
+    Cycle []string
+}
+
+// ParseError is returned when a Procfile field can't be parsed.
+type ParseError struct {
+    Service string
+    Field string
+    Position Position
+    Cause error
+}
+
+// Position locates a key in a Procfile. Line is 0 when it isn't known, as in
+// formats other than YAML.
+type Position struct {
+    File string
+    Line int
+    Column int
+}
+
+// BrokenDependencyError is returned when a service dependency isn't running.
+type BrokenDependencyError struct {
+    Service string
+    Dep string
+}
+
+// DependenciesNotReadyError lists every inactive dependency of a service.
+// errors.As also matches it against the first BrokenDependencyError.
+type DependenciesNotReadyError struct {
+    Service string
+    Broken []*BrokenDependencyError
+}
+
+// LaunchError is returned when a service process can't be started.
+type LaunchError struct {
+    Service string
+    Cause error
+}
+
+// CheckFailedError is reported when a health check of a running service fails.
+type CheckFailedError struct {
+    Service string
+    Check string
+    Cause error
+}
+
+// CompletionError is returned by RunToCompletion when some services failed or were skipped.
+type CompletionError struct {
+    Failed []string
+    // ExitCode is the exit code of the first service that failed in
+    // dependency order, or 1 when none exited with a positive code.
+    ExitCode int
+}
+
+// MainExitError is returned by Start when the main service exited on its own
+// with a non-zero exit code, 128 plus the signal number when it was killed.
+type MainExitError struct {
+    Service string
+    ExitCode int
+}
+
+// UnknownServiceError is returned when a service name isn't defined.
+type UnknownServiceError struct {
+    Service string
+}
+
+func (e *CyclicDependencyError) Error() string {
+    return "Cyclic dependency detected: " + strings.Join(e.Cycle, " -> ")
+}
+
+func (e *ParseError) Error() string {
+    var location []string
+    if e.Position.Line > 0 {
+        location = append(location, e.Position.String())
+    }
+    if e.Service != "" {
+        location = append(location, e.Service)
+    }
+    if e.Field != "" {
+        location = append(location, e.Field)
+    }
+    location = append(location, e.Cause.Error())
+    return strings.Join(location, ": ")
+}
+
+func (e *ParseError) Unwrap() error {
+    return e.Cause
+}
+
+func (p Position) String() string {
+    return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+func (e *BrokenDependencyError) Error() string {
+    return fmt.Sprintf("Broken dependency: %s requires %s", e.Service, e.Dep)
+}
+
+func (e *DependenciesNotReadyError) Error() string {
+    deps := make([]string, len(e.Broken))
+    for i, broken := range e.Broken {
+        deps[i] = broken.Dep
+    }
+    return fmt.Sprintf("%s: dependencies not ready: [%s]", e.Service, strings.Join(deps, ", "))
+}
+
+func (e *DependenciesNotReadyError) As(target any) bool {
+    broken, ok := target.(**BrokenDependencyError)
+    if !ok || len(e.Broken) == 0 {
+        return false
+    }
+    *broken = e.Broken[0]
+    return true
+}
+
+func (e *LaunchError) Error() string {
+    return fmt.Sprintf("failed to launch %s: %v", e.Service, e.Cause)
+}
+
+func (e *LaunchError) Unwrap() error {
+    return e.Cause
+}
+
+func (e *CheckFailedError) Error() string {
+    return fmt.Sprintf("%s: %s check failed: %v", e.Service, e.Check, e.Cause)
+}
+
+func (e *CheckFailedError) Unwrap() error {
+    return e.Cause
+}
+
+func (e *CompletionError) Error() string {
+    return "services failed: " + strings.Join(e.Failed, ", ")
+}
+
+func (e *MainExitError) Error() string {
+    return fmt.Sprintf("main service %s exited with code %d", e.Service, e.ExitCode)
+}
+
+func (e *UnknownServiceError) Error() string {
+    return fmt.Sprintf("unknown service %q", e.Service)
+}
+
+// Build a ParseError for a field with a formatted cause.
+func fieldError(field, format string, args ...any) error {
+    return &ParseError{Field: field, Cause: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,68 @@
+package foreman
+
+import (
+	"syscall"
+	"time"
+)
+
+const (
+    ServiceStarted EventType = iota
+    ServiceStopped
+    ServiceCrashed
+    CheckFailed
+    RestartScheduled
+    RestartLimitReached
+
+    eventsBufferSize = 256
+)
+
+// EventType identifies what happened to a service.
+type EventType int
+
+// Event describes a change in a service lifecycle.
+type Event struct {
+    Type EventType
+    Service string
+    PID int
+    Time time.Time
+    // ExitCode is set for ServiceStopped, ServiceCrashed and RestartLimitReached
+    // events, and Signal when a signal killed the process.
+    ExitCode int
+    Signal syscall.Signal
+    // Check and Err describe the failing check of a CheckFailed event.
+    Check string
+    Err error
+}
+
+func (t EventType) String() string {
+    switch t {
+    case ServiceStarted:
+        return "started"
+    case ServiceStopped:
+        return "stopped"
+    case ServiceCrashed:
+        return "crashed"
+    case CheckFailed:
+        return "check failed"
+    case RestartScheduled:
+        return "restart scheduled"
+    case RestartLimitReached:
+        return "restart limit reached"
+    }
+    return "unknown"
+}
+
+// Return the channel lifecycle events are published on.
+// Events are dropped rather than blocking the supervisor when it is full.
+func (f *Foreman) Events() <-chan Event {
+    return f.events
+}
+
+// Publish an event without blocking.
+func (f *Foreman) emit(event Event) {
+    event.Time = f.clock.Now()
+    select {
+    case f.events <- event:
+    default:
+    }
+}
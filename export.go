@@ -0,0 +1,122 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Exporter converts the services to the configuration of another process manager.
+type Exporter interface {
+    // Export returns the content of every file to write, by file name.
+    // app names the group of services, like the directory of the Procfile.
+    Export(app string, specs map[string]ServiceSpec) (map[string]string, error)
+}
+
+// Convert the enabled services with exporter. Services without a cwd get the
+// directory they would run in under foreman, as other managers have their own.
+func (f *Foreman) Export(exporter Exporter, app string) (map[string]string, error) {
+    workingDir := f.workingDir
+    if workingDir == "" {
+        var err error
+        workingDir, err = os.Getwd()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    cycle := graphOf(f.snapshot()).findCycle()
+    if cycle != nil {
+        return nil, &CyclicDependencyError{Cycle: cycle}
+    }
+
+    specs := f.Specs()
+    for serviceName, spec := range specs {
+        if !spec.Enabled {
+            delete(specs, serviceName)
+            continue
+        }
+        if spec.Cwd == "" {
+            spec.Cwd = workingDir
+            specs[serviceName] = spec
+        }
+    }
+    return exporter.Export(app, specs)
+}
+
+// Return the names of the services sorted, so exports are stable.
+func sortedSpecNames(specs map[string]ServiceSpec) []string {
+    names := make([]string, 0, len(specs))
+    for serviceName := range specs {
+        names = append(names, serviceName)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func sortedKeys(values map[string]string) []string {
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Build a shell command waiting until the checks of a service pass.
+// It returns an empty string when the service has no checks to wait for.
+func waitForChecks(spec ServiceSpec) string {
+    check := checkCommand(spec)
+    if check == "" {
+        return ""
+    }
+    return fmt.Sprintf("until %s; do sleep 1; done", check)
+}
+
+// Build a shell command succeeding when the checks of a service pass,
+// empty when the service has no checks to run.
+func checkCommand(spec ServiceSpec) string {
+    if spec.NoHealthCheck {
+        return ""
+    }
+
+    var conditions []string
+    if spec.Checks.Cmd != "" {
+        conditions = append(conditions, "("+spec.Checks.Cmd+")")
+    }
+    for _, port := range spec.Checks.TCPPorts {
+        conditions = append(conditions, fmt.Sprintf("(echo > /dev/tcp/127.0.0.1/%s) 2>/dev/null", port))
+    }
+    return strings.Join(conditions, " && ")
+}
+
+// Prefix the command of a service with waits for the checks of its dependencies,
+// for managers without dependencies of their own.
+func commandAfterDeps(spec ServiceSpec, specs map[string]ServiceSpec) string {
+    var waits []string
+    for _, depName := range spec.Deps {
+        if spec.DepConditions[depName] == DepCompleted {
+            continue
+        }
+        if wait := waitForChecks(specs[depName]); wait != "" {
+            waits = append(waits, wait)
+        }
+    }
+    return strings.Join(append(waits, spec.Cmd), "; ")
+}
+
+// Return how deep a service sits in the dependency graph, 0 without dependencies.
+// The graph must be acyclic.
+func dependencyDepth(serviceName string, specs map[string]ServiceSpec) int {
+    depth := 0
+    for _, depName := range specs[serviceName].Deps {
+        if _, ok := specs[depName]; !ok {
+            continue
+        }
+        if depDepth := dependencyDepth(depName, specs) + 1; depDepth > depth {
+            depth = depDepth
+        }
+    }
+    return depth
+}
@@ -0,0 +1,140 @@
+package foreman
+
+import (
+	"bytes"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeExporter writes a docker-compose.yml with a service per Procfile service,
+// all built from the app directory. Dependencies keep their conditions, check
+// ports are published and the checks become healthchecks.
+type ComposeExporter struct{}
+
+type composeFile struct {
+    Name string `yaml:"name"`
+    Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+    Build string `yaml:"build"`
+    Command []string `yaml:"command"`
+    Environment map[string]string `yaml:"environment,omitempty"`
+    Ports []string `yaml:"ports,omitempty"`
+    DependsOn map[string]composeDependency `yaml:"depends_on,omitempty"`
+    Healthcheck *composeHealthcheck `yaml:"healthcheck,omitempty"`
+    Restart string `yaml:"restart"`
+    StopSignal string `yaml:"stop_signal"`
+    StopGracePeriod string `yaml:"stop_grace_period,omitempty"`
+}
+
+type composeDependency struct {
+    Condition DepCondition `yaml:"condition"`
+}
+
+type composeHealthcheck struct {
+    Test []string `yaml:"test"`
+    Interval string `yaml:"interval,omitempty"`
+    Timeout string `yaml:"timeout,omitempty"`
+    Retries int `yaml:"retries,omitempty"`
+    StartPeriod string `yaml:"start_period,omitempty"`
+}
+
+func (ComposeExporter) Export(app string, specs map[string]ServiceSpec) (map[string]string, error) {
+    compose := composeFile{Name: app, Services: make(map[string]composeService)}
+
+    for serviceName, spec := range specs {
+        stopSignal := spec.StopSignal
+        if stopSignal == 0 {
+            stopSignal = defaultStopSignal
+        }
+
+        service := composeService{
+        	Build:       ".",
+        	Command:     []string{"bash", "-c", composeEscape(spec.Cmd)},
+        	Environment: composeEnv(spec.Env),
+        	Restart:     string(spec.Restart),
+        	StopSignal:  signalName(stopSignal),
+        	Healthcheck: composeCheck(spec),
+        }
+        if len(spec.Argv) > 0 {
+            service.Command = make([]string, len(spec.Argv))
+            for i, arg := range spec.Argv {
+                service.Command[i] = composeEscape(arg)
+            }
+        }
+        if spec.StopTimeout > 0 {
+            service.StopGracePeriod = spec.StopTimeout.String()
+        }
+
+        for _, port := range spec.Checks.TCPPorts {
+            service.Ports = append(service.Ports, port+":"+port)
+        }
+        for _, port := range spec.Checks.UDPPorts {
+            service.Ports = append(service.Ports, port+":"+port+"/udp")
+        }
+
+        for _, depName := range spec.Deps {
+            if service.DependsOn == nil {
+                service.DependsOn = make(map[string]composeDependency)
+            }
+            condition := spec.DepConditions[depName]
+            if condition == "" || (condition == DepHealthy && composeCheck(specs[depName]) == nil) {
+                // Without a healthcheck compose would never see the dependency healthy.
+                condition = DepStarted
+            }
+            service.DependsOn[depName] = composeDependency{Condition: condition}
+        }
+
+        compose.Services[serviceName] = service
+    }
+
+    var out bytes.Buffer
+    encoder := yaml.NewEncoder(&out)
+    encoder.SetIndent(2)
+    err := encoder.Encode(compose)
+    if err != nil {
+        return nil, err
+    }
+
+    return map[string]string{"docker-compose.yml": out.String()}, nil
+}
+
+// Convert the checks of a service to a healthcheck, nil when it has none to run.
+func composeCheck(spec ServiceSpec) *composeHealthcheck {
+    check := checkCommand(spec)
+    if check == "" {
+        return nil
+    }
+
+    healthcheck := &composeHealthcheck{Test: []string{"CMD", "bash", "-c", composeEscape(check)}}
+    if spec.Checks.Interval > 0 {
+        healthcheck.Interval = spec.Checks.Interval.String()
+    }
+    if spec.Checks.Timeout > 0 {
+        healthcheck.Timeout = spec.Checks.Timeout.String()
+    }
+    healthcheck.Retries = spec.Checks.Retries
+    if spec.Checks.InitialDelay > 0 {
+        healthcheck.StartPeriod = spec.Checks.InitialDelay.String()
+    }
+    return healthcheck
+}
+
+func composeEnv(env map[string]string) map[string]string {
+    if env == nil {
+        return nil
+    }
+
+    escaped := make(map[string]string, len(env))
+    for key, value := range env {
+        escaped[key] = composeEscape(value)
+    }
+    return escaped
+}
+
+// Escape the $ compose would otherwise substitute from the host environment.
+func composeEscape(value string) string {
+    return strings.ReplaceAll(value, "$", "$$")
+}
@@ -0,0 +1,107 @@
+package foreman
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`
+
+// LaunchdExporter writes a macOS launchd plist per service, labeled app.service.
+// launchd has no dependencies: services wait for the checks of their
+// dependencies before running their command.
+type LaunchdExporter struct{}
+
+func (LaunchdExporter) Export(app string, specs map[string]ServiceSpec) (map[string]string, error) {
+    files := make(map[string]string)
+
+    for serviceName, spec := range specs {
+        label := app + "." + serviceName
+        var plist strings.Builder
+        plist.WriteString(plistHeader)
+
+        plistString(&plist, "Label", label)
+        fmt.Fprintf(&plist, "    <key>ProgramArguments</key>\n    <array>\n")
+        for _, arg := range []string{"/bin/bash", "-c", commandAfterDeps(spec, specs)} {
+            fmt.Fprintf(&plist, "        <string>%s</string>\n", xmlEscape(arg))
+        }
+        fmt.Fprintf(&plist, "    </array>\n")
+        plistString(&plist, "WorkingDirectory", spec.Cwd)
+        if spec.User != "" {
+            plistString(&plist, "UserName", spec.User)
+        }
+        if spec.Group != "" {
+            plistString(&plist, "GroupName", spec.Group)
+        }
+
+        if len(spec.Env) > 0 {
+            fmt.Fprintf(&plist, "    <key>EnvironmentVariables</key>\n    <dict>\n")
+            for _, key := range sortedKeys(spec.Env) {
+                fmt.Fprintf(&plist, "        <key>%s</key>\n        <string>%s</string>\n", xmlEscape(key), xmlEscape(spec.Env[key]))
+            }
+            fmt.Fprintf(&plist, "    </dict>\n")
+        }
+
+        fmt.Fprintf(&plist, "    <key>RunAtLoad</key>\n    <true/>\n")
+        fmt.Fprintf(&plist, "    <key>KeepAlive</key>\n%s", launchdKeepAlive(spec.Restart))
+        if spec.RestartDelay > 0 {
+            plistInteger(&plist, "ThrottleInterval", int(spec.RestartDelay.Seconds()+0.5))
+        }
+        if spec.StopTimeout > 0 {
+            plistInteger(&plist, "ExitTimeOut", int(spec.StopTimeout.Seconds()+0.5))
+        }
+        if output := launchdOutput(spec.Stdout); output != "" {
+            plistString(&plist, "StandardOutPath", output)
+        }
+        if output := launchdOutput(spec.Stderr); output != "" {
+            plistString(&plist, "StandardErrorPath", output)
+        }
+
+        plist.WriteString("</dict>\n</plist>\n")
+        files[label+".plist"] = plist.String()
+    }
+
+    return files, nil
+}
+
+// Map a restart policy to KeepAlive, restarting only unsuccessful exits for on-failure.
+func launchdKeepAlive(policy RestartPolicy) string {
+    switch policy {
+    case RestartNo:
+        return "    <false/>\n"
+    case RestartOnFailure:
+        return "    <dict>\n        <key>SuccessfulExit</key>\n        <false/>\n    </dict>\n"
+    }
+    return "    <true/>\n"
+}
+
+// Map an output target to a file path, empty when launchd should leave it alone.
+func launchdOutput(target string) string {
+    switch target {
+    case "", outputInherit:
+        return ""
+    case outputDiscard:
+        return "/dev/null"
+    }
+    return target
+}
+
+func plistString(plist *strings.Builder, key, value string) {
+    fmt.Fprintf(plist, "    <key>%s</key>\n    <string>%s</string>\n", key, xmlEscape(value))
+}
+
+func plistInteger(plist *strings.Builder, key string, value int) {
+    fmt.Fprintf(plist, "    <key>%s</key>\n    <integer>%d</integer>\n", key, value)
+}
+
+func xmlEscape(value string) string {
+    var escaped bytes.Buffer
+    xml.EscapeText(&escaped, []byte(value))
+    return escaped.String()
+}
@@ -0,0 +1,95 @@
+package foreman
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SupervisordExporter writes the services as program blocks of a single group.
+// supervisord has no dependencies: programs are ordered by priority and wait
+// for the checks of their dependencies before running their command.
+type SupervisordExporter struct{}
+
+func (SupervisordExporter) Export(app string, specs map[string]ServiceSpec) (map[string]string, error) {
+    var programs []string
+    for _, serviceName := range sortedSpecNames(specs) {
+        programs = append(programs, app+"-"+serviceName)
+    }
+
+    var conf strings.Builder
+    fmt.Fprintf(&conf, "[group:%s]\nprograms=%s\n", app, strings.Join(programs, ","))
+
+    for _, serviceName := range sortedSpecNames(specs) {
+        spec := specs[serviceName]
+        stopSignal := spec.StopSignal
+        if stopSignal == 0 {
+            stopSignal = defaultStopSignal
+        }
+
+        fmt.Fprintf(&conf, "\n[program:%s-%s]\n", app, serviceName)
+        command := commandAfterDeps(spec, specs)
+        fmt.Fprintf(&conf, "command=/bin/bash -c \"%s\"\n", supervisordEscape(command))
+        fmt.Fprintf(&conf, "directory=%s\n", strings.ReplaceAll(spec.Cwd, "%", "%%"))
+        if spec.Umask != "" {
+            fmt.Fprintf(&conf, "umask=%s\n", spec.Umask)
+        }
+        if spec.User != "" {
+            // supervisord takes the primary and supplementary groups of the user.
+            fmt.Fprintf(&conf, "user=%s\n", spec.User)
+        }
+        if len(spec.Env) > 0 {
+            var env []string
+            for _, key := range sortedKeys(spec.Env) {
+                env = append(env, fmt.Sprintf("%s=\"%s\"", key, supervisordEscape(spec.Env[key])))
+            }
+            fmt.Fprintf(&conf, "environment=%s\n", strings.Join(env, ","))
+        }
+        fmt.Fprintf(&conf, "priority=%d\n", 100*(dependencyDepth(serviceName, specs)+1))
+        fmt.Fprintf(&conf, "autostart=true\nautorestart=%s\n", supervisordRestart(spec.Restart))
+        if spec.Restart == RestartNo {
+            // A job exiting right away is fine, not a failure to start.
+            fmt.Fprintf(&conf, "startsecs=0\n")
+        }
+        fmt.Fprintf(&conf, "stopsignal=%s\n", strings.TrimPrefix(signalName(stopSignal), "SIG"))
+        if spec.StopTimeout > 0 {
+            fmt.Fprintf(&conf, "stopwaitsecs=%d\n", int(spec.StopTimeout.Seconds()+0.5))
+        }
+        fmt.Fprintf(&conf, "stopasgroup=true\nkillasgroup=true\n")
+        if output := supervisordOutput(spec.Stdout); output != "" {
+            fmt.Fprintf(&conf, "stdout_logfile=%s\n", output)
+        }
+        if output := supervisordOutput(spec.Stderr); output != "" {
+            fmt.Fprintf(&conf, "stderr_logfile=%s\n", output)
+        }
+    }
+
+    return map[string]string{app + ".conf": conf.String()}, nil
+}
+
+// Map a restart policy to autorestart, unexpected meaning a non-zero exit code.
+func supervisordRestart(policy RestartPolicy) string {
+    switch policy {
+    case RestartNo:
+        return "false"
+    case RestartOnFailure:
+        return "unexpected"
+    }
+    return "true"
+}
+
+// Map an output target to a log file, empty for supervisord's own.
+func supervisordOutput(target string) string {
+    switch target {
+    case "", outputInherit:
+        return ""
+    case outputDiscard:
+        return "NONE"
+    }
+    return strings.ReplaceAll(target, "%", "%%")
+}
+
+// Escape a value for supervisord, which expands %(name)s and splits
+// commands like a shell.
+func supervisordEscape(value string) string {
+    return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "\n", " ").Replace(value)
+}
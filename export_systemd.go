@@ -0,0 +1,153 @@
+package foreman
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SystemdExporter writes a unit per service and a target grouping them, named after the app.
+// Dependencies become After= and Requires=, the checks of a dependency are waited for
+// in ExecStartPre, and restart policies map to Restart=.
+type SystemdExporter struct{}
+
+func (SystemdExporter) Export(app string, specs map[string]ServiceSpec) (map[string]string, error) {
+    files := make(map[string]string)
+
+    // Jobs others wait to complete must be oneshot units for After= to wait for their exit.
+    awaited := make(map[string]bool)
+    for _, spec := range specs {
+        for depName, condition := range spec.DepConditions {
+            if condition == DepCompleted {
+                awaited[depName] = true
+            }
+        }
+    }
+
+    var units []string
+    for _, serviceName := range sortedSpecNames(specs) {
+        unit := systemdUnitName(app, serviceName)
+        units = append(units, unit)
+        files[unit] = systemdUnit(app, serviceName, specs, awaited[serviceName])
+    }
+
+    var target strings.Builder
+    fmt.Fprintf(&target, "[Unit]\nDescription=%s\nWants=%s\n", app, strings.Join(units, " "))
+    fmt.Fprintf(&target, "\n[Install]\nWantedBy=multi-user.target\n")
+    files[app+".target"] = target.String()
+
+    return files, nil
+}
+
+func systemdUnit(app, serviceName string, specs map[string]ServiceSpec, oneshot bool) string {
+    spec := specs[serviceName]
+    var unit strings.Builder
+
+    fmt.Fprintf(&unit, "[Unit]\nDescription=%s %s\nPartOf=%s.target\n", app, serviceName, app)
+    for _, depName := range spec.Deps {
+        depUnit := systemdUnitName(app, depName)
+        fmt.Fprintf(&unit, "After=%s\nRequires=%s\n", depUnit, depUnit)
+    }
+
+    fmt.Fprintf(&unit, "\n[Service]\n")
+    if oneshot {
+        fmt.Fprintf(&unit, "Type=oneshot\nRemainAfterExit=yes\n")
+    }
+    if spec.Cwd != "" {
+        fmt.Fprintf(&unit, "WorkingDirectory=%s\n", spec.Cwd)
+    }
+    if spec.Umask != "" {
+        fmt.Fprintf(&unit, "UMask=0%s\n", spec.Umask)
+    }
+    if spec.User != "" {
+        fmt.Fprintf(&unit, "User=%s\n", spec.User)
+    }
+    if spec.Group != "" {
+        fmt.Fprintf(&unit, "Group=%s\n", spec.Group)
+    }
+    if len(spec.SupplementaryGroups) > 0 {
+        fmt.Fprintf(&unit, "SupplementaryGroups=%s\n", strings.Join(spec.SupplementaryGroups, " "))
+    }
+    if spec.NoNewPrivs {
+        fmt.Fprintf(&unit, "NoNewPrivileges=yes\n")
+    }
+    for _, key := range sortedKeys(spec.Env) {
+        fmt.Fprintf(&unit, "Environment=\"%s\"\n", systemdEscape(key+"="+spec.Env[key], false))
+    }
+    for _, depName := range spec.Deps {
+        if spec.DepConditions[depName] == DepCompleted {
+            continue
+        }
+        wait := waitForChecks(specs[depName])
+        if wait != "" {
+            fmt.Fprintf(&unit, "ExecStartPre=/bin/bash -c \"%s\"\n", systemdEscape(wait, true))
+        }
+    }
+    fmt.Fprintf(&unit, "ExecStart=/bin/bash -c \"%s\"\n", systemdEscape(spec.Cmd, true))
+
+    if !oneshot {
+        fmt.Fprintf(&unit, "Restart=%s\n", systemdRestart(spec.Restart))
+        if spec.RestartDelay > 0 {
+            fmt.Fprintf(&unit, "RestartSec=%s\n", systemdDuration(spec.RestartDelay))
+        }
+    }
+
+    stopSignal := spec.StopSignal
+    if stopSignal == 0 {
+        stopSignal = defaultStopSignal
+    }
+    fmt.Fprintf(&unit, "KillSignal=%s\n", signalName(stopSignal))
+    if spec.StopTimeout > 0 {
+        fmt.Fprintf(&unit, "TimeoutStopSec=%s\n", systemdDuration(spec.StopTimeout))
+    }
+    if output := systemdOutput(spec.Stdout); output != "" {
+        fmt.Fprintf(&unit, "StandardOutput=%s\n", output)
+    }
+    if output := systemdOutput(spec.Stderr); output != "" {
+        fmt.Fprintf(&unit, "StandardError=%s\n", output)
+    }
+
+    fmt.Fprintf(&unit, "\n[Install]\nWantedBy=%s.target\n", app)
+    return unit.String()
+}
+
+func systemdUnitName(app, serviceName string) string {
+    return fmt.Sprintf("%s-%s.service", app, serviceName)
+}
+
+// Map a restart policy to Restart=. systemd has no unless-stopped, but it
+// never restarts units stopped with systemctl either.
+func systemdRestart(policy RestartPolicy) string {
+    switch policy {
+    case RestartNo:
+        return "no"
+    case RestartOnFailure:
+        return "on-failure"
+    }
+    return "always"
+}
+
+// Map an output target to StandardOutput=, empty for the journal.
+func systemdOutput(target string) string {
+    switch target {
+    case "", outputInherit:
+        return ""
+    case outputDiscard:
+        return "null"
+    }
+    return "append:" + target
+}
+
+func systemdDuration(duration time.Duration) string {
+    return fmt.Sprintf("%gs", duration.Seconds())
+}
+
+// Escape a value for a double quoted unit file setting. Specifiers like %n are
+// always escaped, and with command also the $ of environment variable expansion.
+func systemdEscape(value string, command bool) string {
+    replacements := []string{`\`, `\\`, `"`, `\"`, "%", "%%", "\n", `\n`}
+    if command {
+        replacements = append(replacements, "$", "$$")
+    }
+    return strings.NewReplacer(replacements...).Replace(value)
+}
@@ -0,0 +1,209 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSystemdExporter(t *testing.T) {
+    specs := exportSpecs()
+
+    files, err := SystemdExporter{}.Export("shop", specs)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var names []string
+    for name := range files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    assertList(t, names, []string{"shop-db.service", "shop-migrate.service", "shop-web.service", "shop.target"})
+
+    assertString(t, files["shop-web.service"], `[Unit]
+Description=shop web
+PartOf=shop.target
+After=shop-db.service
+Requires=shop-db.service
+After=shop-migrate.service
+Requires=shop-migrate.service
+
+[Service]
+WorkingDirectory=/srv/app
+Environment="PORT=80"
+ExecStartPre=/bin/bash -c "until (echo > /dev/tcp/127.0.0.1/5432) 2>/dev/null; do sleep 1; done"
+ExecStart=/bin/bash -c "echo \"100%%\" $$PORT"
+Restart=always
+KillSignal=SIGTERM
+TimeoutStopSec=5s
+StandardOutput=append:/var/log/web.log
+
+[Install]
+WantedBy=shop.target
+`)
+
+    for name, want := range map[string]string{
+        "shop-db.service":      "Restart=on-failure\nRestartSec=1.5s\nKillSignal=SIGINT\n",
+        "shop-migrate.service": "Type=oneshot\nRemainAfterExit=yes\n",
+        "shop.target":          "Wants=shop-db.service shop-migrate.service shop-web.service\n",
+    } {
+        if !strings.Contains(files[name], want) {
+            t.Errorf("%s:\n%s\ndoesn't contain:\n%s", name, files[name], want)
+        }
+    }
+    if !strings.Contains(files["shop-db.service"], "WorkingDirectory=/srv/app\nUMask=0077\nUser=postgres\nSupplementaryGroups=ssl-cert\nNoNewPrivileges=yes\n") {
+        t.Errorf("shop-db.service:\n%s\ndoesn't set the umask and user", files["shop-db.service"])
+    }
+}
+
+func TestComposeExporter(t *testing.T) {
+    files, err := ComposeExporter{}.Export("shop", exportSpecs())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    assertString(t, files["docker-compose.yml"], `name: shop
+services:
+  db:
+    build: .
+    command:
+      - bash
+      - -c
+      - postgres -p 5432
+    ports:
+      - 5432:5432
+    healthcheck:
+      test:
+        - CMD
+        - bash
+        - -c
+        - (echo > /dev/tcp/127.0.0.1/5432) 2>/dev/null
+    restart: on-failure
+    stop_signal: SIGINT
+  migrate:
+    build: .
+    command:
+      - bash
+      - -c
+      - ./migrate
+    restart: "no"
+    stop_signal: SIGINT
+  web:
+    build: .
+    command:
+      - bash
+      - -c
+      - echo "100%" $$PORT
+    environment:
+      PORT: "80"
+    depends_on:
+      db:
+        condition: service_healthy
+      migrate:
+        condition: service_completed_successfully
+    restart: always
+    stop_signal: SIGTERM
+    stop_grace_period: 5s
+`)
+}
+
+func TestSupervisordExporter(t *testing.T) {
+    files, err := SupervisordExporter{}.Export("shop", exportSpecs())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    conf := files["shop.conf"]
+    for _, want := range []string{
+        "[group:shop]\nprograms=shop-db,shop-migrate,shop-web\n",
+        "directory=/srv/app\numask=077\nuser=postgres\npriority=100\n",
+        "[program:shop-migrate]\ncommand=/bin/bash -c \"./migrate\"\ndirectory=/srv/app\npriority=100\nautostart=true\nautorestart=false\nstartsecs=0\n",
+        `[program:shop-web]
+command=/bin/bash -c "until (echo > /dev/tcp/127.0.0.1/5432) 2>/dev/null; do sleep 1; done; echo \"100%%\" $PORT"
+directory=/srv/app
+environment=PORT="80"
+priority=200
+autostart=true
+autorestart=true
+stopsignal=TERM
+stopwaitsecs=5
+stopasgroup=true
+killasgroup=true
+stdout_logfile=/var/log/web.log
+`,
+    } {
+        if !strings.Contains(conf, want) {
+            t.Errorf("%s\ndoesn't contain:\n%s", conf, want)
+        }
+    }
+}
+
+func TestLaunchdExporter(t *testing.T) {
+    files, err := LaunchdExporter{}.Export("shop", exportSpecs())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for name, want := range map[string]string{
+        "shop.web.plist":     "        <string>until (echo &gt; /dev/tcp/127.0.0.1/5432) 2&gt;/dev/null; do sleep 1; done; echo &#34;100%&#34; $PORT</string>\n",
+        "shop.db.plist":      "    <key>KeepAlive</key>\n    <dict>\n        <key>SuccessfulExit</key>\n        <false/>\n    </dict>\n",
+        "shop.migrate.plist": "    <key>KeepAlive</key>\n    <false/>\n",
+    } {
+        if !strings.Contains(files[name], want) {
+            t.Errorf("%s:\n%s\ndoesn't contain:\n%s", name, files[name], want)
+        }
+    }
+    if !strings.Contains(files["shop.db.plist"], "    <key>UserName</key>\n    <string>postgres</string>\n") {
+        t.Errorf("shop.db.plist:\n%s\ndoesn't set the user", files["shop.db.plist"])
+    }
+}
+
+func TestExport(t *testing.T) {
+    foreman, _ := New(testDisabledProcfile)
+    files, err := foreman.Export(SystemdExporter{}, "app")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for serviceName, spec := range foreman.Specs() {
+        _, exported := files[systemdUnitName("app", serviceName)]
+        if exported != spec.Enabled {
+            t.Errorf("%s: got exported %t, want %t", serviceName, exported, spec.Enabled)
+        }
+    }
+    assertString(t, fmt.Sprint(len(files) > 1), "true")
+}
+
+// Services covering deps with conditions, checks, env and stop settings.
+func exportSpecs() map[string]ServiceSpec {
+    return map[string]ServiceSpec{
+        "db": {
+            Cmd:                 "postgres -p 5432",
+            Cwd:                 "/srv/app",
+            Umask:               "077",
+            User:                "postgres",
+            SupplementaryGroups: []string{"ssl-cert"},
+            NoNewPrivs:          true,
+            Enabled:             true,
+            Restart:             RestartOnFailure,
+            RestartDelay:        1500 * time.Millisecond,
+            Checks:              CheckSpec{TCPPorts: []string{"5432"}},
+        },
+        "migrate": {Cmd: "./migrate", Cwd: "/srv/app", Enabled: true, Restart: RestartNo},
+        "web": {
+            Cmd:           `echo "100%" $PORT`,
+            Cwd:           "/srv/app",
+            Env:           map[string]string{"PORT": "80"},
+            Enabled:       true,
+            Restart:       RestartAlways,
+            Deps:          []string{"db", "migrate"},
+            DepConditions: map[string]DepCondition{"db": DepHealthy, "migrate": DepCompleted},
+            StopSignal:    signalNames["TERM"],
+            StopTimeout:   5 * time.Second,
+            Stdout:        "/var/log/web.log",
+        },
+    }
+}
@@ -0,0 +1,81 @@
+package foreman
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Actions taken when checks fail, set with on_failure.
+const (
+    // ActionRestart interrupts the service, to be restarted by its restart policy.
+    ActionRestart = "restart"
+    // ActionStop stops the service and its dependents, without restarting them.
+    ActionStop = "stop"
+    // ActionSignal sends the service a signal, like SIGHUP to reload it.
+    ActionSignal = "signal"
+    // ActionLog only logs the failure.
+    ActionLog = "log-only"
+    // ActionRun runs a command, like an alert.
+    ActionRun = "run"
+)
+
+// FailureAction is what happens when the checks of a service fail.
+type FailureAction struct {
+    // Action is one of ActionRestart, ActionStop, ActionSignal, ActionLog or
+    // ActionRun, ActionRestart when empty.
+    Action string `yaml:"action"`
+    // Signal is sent with ActionSignal.
+    Signal syscall.Signal `yaml:"signal"`
+    // Run is the command run through the service shell with ActionRun.
+    Run string `yaml:"run"`
+}
+
+// Parse on_failure: restart, stop, log-only, or a mapping of signal or run.
+func parseFailureAction(field string, value any) (FailureAction, error) {
+    switch v := value.(type) {
+    case string:
+        switch v {
+        case ActionRestart, ActionStop, ActionLog:
+            return FailureAction{Action: v}, nil
+        }
+    case map[string]any:
+        if len(v) != 1 {
+            break
+        }
+        if name, ok := v[ActionSignal]; ok {
+            sig, err := parseStopSignal(field+"."+ActionSignal, name)
+            return FailureAction{Action: ActionSignal, Signal: sig}, err
+        }
+        if cmd, ok := v[ActionRun]; ok {
+            run, err := parseString(field+"."+ActionRun, cmd)
+            return FailureAction{Action: ActionRun, Run: run}, err
+        }
+    }
+    return FailureAction{}, fieldError(field, "expected restart, stop, log-only, signal: <signal> or run: <command>, got %v", value)
+}
+
+// Act on the failed checks of a service.
+func (f *Foreman) onCheckFailure(service Service, action FailureAction) {
+    serviceName := service.serviceName
+    switch action.Action {
+    case ActionStop:
+        f.log(LogRecord{Level: LevelWarning, Event: "check action", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: checks failed, stopping", service.pid, serviceName)})
+        go f.StopService(serviceName, true)
+    case ActionSignal:
+        f.runner.Signal(service.pid, action.Signal)
+    case ActionLog:
+        f.log(LogRecord{Level: LevelWarning, Event: "check action", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: checks failed", service.pid, serviceName)})
+    case ActionRun:
+        go func() {
+            command := f.command(service.shell, action.Run)
+            setServiceEnv(command, service.env)
+            command.Dir = service.cwd
+            err := runChild(command)
+            if err != nil {
+                f.log(LogRecord{Level: LevelError, Event: "check action", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%s: on_failure command failed: %v", serviceName, err)})
+            }
+        }()
+    default:
+        f.signalGroup(service.pid, syscall.SIGINT)
+    }
+}
@@ -0,0 +1,60 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Check the files a service creates once it is ready: the file_exists paths
+// must exist, the socket_exists paths must be unix sockets and the pid_file
+// must hold the pid of a running process.
+func (s *Service) checkFiles() error {
+    for _, path := range s.checks.fileExists {
+        _, err := os.Stat(path)
+        if err != nil {
+            return err
+        }
+    }
+
+    for _, path := range s.checks.socketExists {
+        info, err := os.Stat(path)
+        if err != nil {
+            return err
+        }
+        if info.Mode()&os.ModeSocket == 0 {
+            return fmt.Errorf("%s isn't a unix socket", path)
+        }
+    }
+
+    if s.checks.pidFile != "" {
+        content, err := os.ReadFile(s.checks.pidFile)
+        if err != nil {
+            return err
+        }
+        pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+        if err != nil || pid <= 0 {
+            return fmt.Errorf("%s: expected a pid, got %q", s.checks.pidFile, strings.TrimSpace(string(content)))
+        }
+        if !processAlive(pid) {
+            return fmt.Errorf("%s: process %d isn't running", s.checks.pidFile, pid)
+        }
+    }
+
+    return nil
+}
+
+// Resolve the paths of the file checks like the other paths of the Procfile.
+func (c Checks) resolvePaths(resolve func(string) string) Checks {
+    fileExists := make([]string, len(c.fileExists))
+    for i, path := range c.fileExists {
+        fileExists[i] = resolve(path)
+    }
+    socketExists := make([]string, len(c.socketExists))
+    for i, path := range c.socketExists {
+        socketExists[i] = resolve(path)
+    }
+    c.fileExists, c.socketExists, c.pidFile = fileExists, socketExists, resolve(c.pidFile)
+    return c
+}
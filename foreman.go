@@ -1,18 +1,24 @@
-package main
+// Package foreman runs Procfile-based services and supervises them:
+// it resolves their dependencies, restarts them and checks their health.
+package foreman
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-
-	"github.com/shirou/gopsutil/process"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -20,7 +26,31 @@ const (
     currentlyVisiting vertixStatus = 1
     visited vertixStatus = 2
 
-    checkInterval = 500 * time.Millisecond
+    defaultCheckInterval = 500 * time.Millisecond
+
+    CheckCmd = "cmd"
+    CheckTCP = "tcp"
+    CheckUDP = "udp"
+    CheckNetwork = "network"
+    CheckGRPC = "grpc"
+    CheckFile = "file"
+    CheckDNS = "dns"
+    CheckPing = "ping"
+    CheckLimits = "limits"
+
+    networkCheckTimeout = 2 * time.Second
+
+    depCheckAttempts = 3
+    depRetryInterval = 200 * time.Millisecond
+
+    lifecycleNew int32 = 0
+    lifecycleStarted int32 = 1
+    lifecycleStopped int32 = 2
+    defaultShutdownParallelism = 4
+    defaultStartupParallelism = 4
+    defaultStopTimeout = 10 * time.Second
+    defaultRestartWindow = time.Minute
+    maxRestartDelay = 5 * time.Minute
 )
 
 type vertixStatus int
@@ -28,229 +58,1694 @@ type vertixStatus int
 type dependencyGraph map[string][]string
 
 type Foreman struct {
+    mu sync.Mutex
     services map[string]Service
     active bool
+    notifier *stateNotifier
+    procfileDir string
+    procfileRelative bool
+    shutdownParallelism int
+    startupParallelism int
+    shutdownDelay time.Duration
+    warnDisabledDeps bool
+    output io.Writer
+    outputMu sync.Mutex
+    outputWG sync.WaitGroup
+    runner Runner
+    maxLineLength int
+    clock Clock
+    disabledChecks map[string]bool
+    color *bool
+    timestampFormat string
+    lifecycle int32
+    done chan struct{}
+    restartDependents bool
+    healthyDeps bool
+    events chan Event
+    hooks lifecycleHooks
+    checkInterval time.Duration
+    shell []string
+    logger Logger
+    env []string
+    workingDir string
+    healthy map[string]chan struct{}
+    logs logSubscribers
+    logFiles logFiles
+    logOutputs []LogOutput
+    envFiles []string
+    procfileTemplate bool
+    procfileFormat string
+    overrides []string
+    profiles []string
+    targets []string
+    targetGroups []string
+    formation map[string]int
+    decoder Decoder
+    port int
+    // procfilePath and options are what New was called with, to reload the Procfile.
+    subreaper bool
+    init bool
+    mainService string
+    // mainExited and mainExitCode record the exit of the main service.
+    mainExited bool
+    mainExitCode int
+    procfilePath string
+    options []Option
+    reloadMu sync.Mutex
 }
 
 type Service struct {
     serviceName string
+    // fromProcfile is set for the services of the Procfile, which Reload may remove.
+    fromProcfile bool
     active bool
-    process *os.Process
+    stopRequested bool
+    enabled bool
+    state State
+    pid int
+    exitCode int
+    exitSignal syscall.Signal
+    startedAt time.Time
+    restarts int
+    exited chan struct{}
     cmd string
-    runOnce bool
+    argv []string
+    cwd string
+    shell []string
+    umask string
+    user string
+    group string
+    supplementaryGroups []string
+    noNewPrivs bool
+    env map[string]string
+    stdout string
+    stderr string
+    binaryOutput string
+    logFile string
+    maxLogSize int64
+    maxLogFiles int
+    restart RestartPolicy
+    restartDelay time.Duration
+    backoffFactor float64
+    maxRestarts int
+    restartWindow time.Duration
+    restartHistory []time.Time
     deps []string
+    // before lists the services an init task runs before, which wait for it
+    // to complete successfully.
+    before []string
+    groups []string
+    depConditions map[string]DepCondition
+    depTimeouts map[string]time.Duration
+    depTimeout time.Duration
+    forwardSignals []syscall.Signal
+    stopSignal syscall.Signal
+    stopTimeout time.Duration
+    startTimeout time.Duration
+    startMode StartMode
+    startDelay time.Duration
+    watch Watch
+    hooks CommandHooks
+    schedule Schedule
+    // stopScheduler stops the scheduler of a scheduled service, nil while it
+    // isn't running. queued is set when a run is due while the previous one is
+    // still going, for the queue overlap policy.
+    stopScheduler chan struct{}
+    nextRun time.Time
+    queued bool
+    noHealthCheck bool
     checks Checks
+    readiness *Checks
+    liveness *Checks
+    customChecks []Check
+    checkHistory *checkHistory
+    unready bool
+    stopChecker chan struct{}
+    // paused is set while the process is stopped by PauseService, which
+    // resumes in pausedState.
+    paused bool
+    pausedState State
 }
 
 type Checks struct {
+    interval time.Duration
+    timeout time.Duration
+    retries int
+    successes int
+    initialDelay time.Duration
     cmd string
+    argv []string
     tcpPorts []string
     udpPorts []string
+    networkReady []string
+    grpc GRPCCheck
+    fileExists []string
+    socketExists []string
+    pidFile string
+    dns []DNSCheck
+    ping []string
+    maxMemory int64
+    maxCPUPercent float64
+    maxOpenFiles int
+    limitDuration time.Duration
+    onFailure FailureAction
 }
 
 // Parse and create a new foreman object.
 // it returns error if the file path is wrong or not in yml format.
-func New(procfilePath string) (*Foreman, error) {
-    foreman := &Foreman{
-    	services: make(map[string]Service),
-    	active:   true,
+// Relative paths in the Procfile are resolved against the Procfile's directory
+// unless WithoutProcfileRelativePaths is passed.
+func New(procfilePath string, opts ...Option) (*Foreman, error) {
+    foreman := newForeman(opts...)
+    foreman.procfilePath = procfilePath
+    foreman.options = opts
+
+    absProcfilePath, err := filepath.Abs(procfilePath)
+    if err != nil {
+        return nil, err
+    }
+    foreman.procfileDir = filepath.Dir(absProcfilePath)
+
+    dotEnv, err := loadDotEnv(foreman.procfileDir, foreman.envFiles)
+    if err != nil {
+        return nil, err
+    }
+
+    procfilePaths := append([]string{procfilePath}, foreman.overrides...)
+    procfileMap, positions, err := loadProcfiles(procfilePaths, foreman.procfileSettings(dotEnv))
+    if err != nil {
+        return nil, err
+    }
+
+    defaults := procfileMap[defaultsKey]
+    delete(procfileMap, defaultsKey)
+    if unknown := unknownKeys(defaultsKey, defaults); len(unknown) > 0 {
+        return nil, positions.locateError(defaultsKey, unknown[0].err())
+    }
+
+    if env, ok := procfileMap[envKey]; ok {
+        globalEnv, err := parseEnv(envKey, env)
+        if err != nil {
+            return nil, positions.locateError("", err)
+        }
+        dotEnv = mergeEnv(dotEnv, globalEnv)
+        delete(procfileMap, envKey)
     }
 
-    procfileData, err := os.ReadFile(procfilePath)
+    basePort, err := foreman.basePort(dotEnv)
     if err != nil {
         return nil, err
     }
 
-    procfileMap := map[string]map[string]any{}
-    err = yaml.Unmarshal(procfileData, procfileMap)
+    var serviceNames []string
+    for key := range procfileMap {
+        serviceNames = append(serviceNames, key)
+    }
+    sort.Strings(serviceNames)
+    if unknown := unknownFormation(foreman.formation, serviceNames); len(unknown) > 0 {
+        return nil, &UnknownServiceError{Service: unknown[0]}
+    }
+
+    scaled := make(map[string][]string)
+    for index, key := range serviceNames {
+        if unknown := unknownKeys(key, procfileMap[key]); len(unknown) > 0 {
+            return nil, positions.locateError(key, unknown[0].err())
+        }
+        serviceMap, member, err := applyProfiles(applyDefaults(procfileMap[key], defaults), foreman.profiles)
+        instances := 1
+        if err == nil {
+            instances, err = foreman.instanceCount(key, serviceMap)
+        }
+        if err != nil {
+            return nil, positions.locateError(key, serviceParseError(key, err))
+        }
+        if !member || instances == 0 {
+            serviceMap["enabled"] = false
+            instances = 1
+        }
+
+        for instance := 1; instance <= instances; instance++ {
+            serviceName := instanceName(key, instance, instances)
+            if _, ok := procfileMap[serviceName]; ok && instances > 1 {
+                return nil, positions.locateError(key, &ParseError{Service: key, Field: instancesKey, Cause: fmt.Errorf("instance %s is already a service", serviceName)})
+            }
+            // The assigned PORT overrides .env and the env block, but not env_file and the service env.
+            serviceEnv := mergeEnv(dotEnv, instanceEnv(servicePort(basePort, index), instance, instances))
+            instanceMap, err := interpolateService(serviceMap, serviceEnv, foreman.env, foreman.resolvePath)
+            service := Service{}
+            if err == nil {
+                service, err = parseService(instanceMap)
+            }
+            if err != nil {
+                return nil, positions.locateError(key, serviceParseError(key, err))
+            }
+            service.serviceName = serviceName
+            service.fromProcfile = true
+            if instances > 1 {
+                service.groups = append(service.groups, key)
+                scaled[key] = append(scaled[key], serviceName)
+            }
+            foreman.services[serviceName] = foreman.prepareService(service)
+        }
+    }
+    err = expandBefore(foreman.services, scaled)
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) {
+        return nil, positions.locateError(parseErr.Service, err)
+    }
+    foreman.expandInstanceDeps(scaled)
+
+    err = foreman.checkDisabledDeps()
     if err != nil {
         return nil, err
     }
 
-    for key, value := range procfileMap {
-        service := parseService(value)
-        service.serviceName = key
-        foreman.services[key] = service
+    err = foreman.checkPortConflicts()
+    if err != nil {
+        return nil, err
     }
 
     return foreman, nil
 }
 
+// Return err as a ParseError of the service.
+func serviceParseError(serviceName string, err error) error {
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) {
+        parseErr.Service = serviceName
+        return parseErr
+    }
+    return &ParseError{Service: serviceName, Cause: err}
+}
+
+// Create a foreman without services, with the options applied.
+func newForeman(opts ...Option) *Foreman {
+    foreman := &Foreman{
+    	services:         make(map[string]Service),
+    	active:           true,
+    	notifier:         newStateNotifier(),
+    	procfileRelative: true,
+    	shutdownParallelism: defaultShutdownParallelism,
+    	startupParallelism: defaultStartupParallelism,
+    	output:           os.Stdout,
+    	runner:           newExecRunner(),
+    	maxLineLength:    defaultMaxLineLength,
+    	clock:            realClock{},
+    	disabledChecks:   make(map[string]bool),
+    	done:             make(chan struct{}),
+    	restartDependents: true,
+    	events:           make(chan Event, eventsBufferSize),
+    	checkInterval:    defaultCheckInterval,
+    	shell:            defaultShell(),
+    	logger:           NewTextLogger(log.New(os.Stdout, "", 0)),
+    	healthy:          make(map[string]chan struct{}),
+    	procfileFormat:   FormatAuto,
+    }
+
+    for _, opt := range opts {
+        opt(foreman)
+    }
+    return foreman
+}
+
+// Create an enabled service running cmd after the given dependencies,
+// to be registered with AddService.
+func NewService(name, cmd string, deps ...string) Service {
+    return Service{
+    	serviceName: name,
+    	cmd:         cmd,
+    	deps:        deps,
+    	enabled:     true,
+    	restart:     RestartAlways,
+    }
+}
+
+// Resolve the paths of a newly defined service and set its initial state.
+func (f *Foreman) prepareService(service Service) Service {
+    service.cwd = f.resolvePath(service.cwd)
+    if service.cwd == "" {
+        service.cwd = f.workingDir
+    }
+    service.stdout = f.resolveOutputPath(service.stdout)
+    service.stderr = f.resolveOutputPath(service.stderr)
+    service.logFile = f.resolvePath(service.logFile)
+    service.checks = service.checks.resolvePaths(f.resolvePath)
+    service.watch = service.watch.resolvePaths(f.resolvePath)
+    for _, checks := range []*Checks{service.readiness, service.liveness} {
+        if checks != nil {
+            *checks = checks.resolvePaths(f.resolvePath)
+        }
+    }
+    service.state = StatePending
+    if !service.enabled {
+        service.state = StateDisabled
+    }
+    return service
+}
+
+// Register a service defined in code. The service is rejected if its name is
+// taken or it would make the dependency graph invalid. Services added after
+// Start aren't launched until StartService is called.
+func (f *Foreman) AddService(service Service) error {
+    serviceName := service.serviceName
+    if serviceName == "" {
+        return errors.New("service name is required")
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if _, ok := f.services[serviceName]; ok {
+        return fmt.Errorf("service %q already exists", serviceName)
+    }
+    f.services[serviceName] = f.prepareService(service)
+
+    err := f.validateGraph()
+    if err != nil {
+        delete(f.services, serviceName)
+        return err
+    }
+    return nil
+}
+
+// Stop a service if it's running and unregister it. It fails while
+// other services still depend on it.
+func (f *Foreman) RemoveService(serviceName string) error {
+    services := f.snapshot()
+    if _, ok := services[serviceName]; !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+
+    dependents := f.buildDependencyGraph().reverse()[serviceName]
+    if len(dependents) > 0 {
+        sort.Strings(dependents)
+        return fmt.Errorf("%s is a dependency of %s", serviceName, strings.Join(dependents, ", "))
+    }
+
+    f.stopScheduler(serviceName)
+    f.requestStop([]string{serviceName})
+    f.stopService(serviceName)
+
+    f.mu.Lock()
+    delete(f.services, serviceName)
+    f.mu.Unlock()
+    return nil
+}
+
+// Validate the dependency graph after the services changed. The caller holds f.mu.
+func (f *Foreman) validateGraph() error {
+    err := f.checkUnknownDeps()
+    if err != nil {
+        return err
+    }
+
+    cycle := graphOf(f.services).findCycle()
+    if cycle != nil {
+        return &CyclicDependencyError{Cycle: cycle}
+    }
+
+    err = f.checkDisabledDeps()
+    if err != nil {
+        return err
+    }
+    return f.checkPortConflicts()
+}
+
+// Resolve an output file path, leaving the special inherit and discard targets untouched.
+func (f *Foreman) resolveOutputPath(target string) string {
+    if target == outputInherit || target == outputDiscard {
+        return target
+    }
+    return f.resolvePath(target)
+}
+
+// Make sure every dependency of a service is a registered service.
+func (f *Foreman) checkUnknownDeps() error {
+    for serviceName, service := range f.services {
+        for _, depName := range service.deps {
+            if _, ok := f.services[depName]; !ok {
+                return fmt.Errorf("%s depends on unknown service %s", serviceName, depName)
+            }
+        }
+    }
+    return nil
+}
+
+// Make sure no enabled service depends on a disabled or a scheduled one.
+func (f *Foreman) checkDisabledDeps() error {
+    for serviceName, service := range f.services {
+        if !service.enabled {
+            continue
+        }
+
+        for _, depName := range service.deps {
+            dep, ok := f.services[depName]
+            if ok && dep.enabled && dep.scheduled() {
+                return fmt.Errorf("%s depends on scheduled service %s", serviceName, depName)
+            }
+            if !ok || dep.enabled {
+                continue
+            }
+
+            if f.warnDisabledDeps {
+                f.log(LogRecord{
+                	Level:   LevelWarning,
+                	Event:   "disabled dependency",
+                	Service: serviceName,
+                	Message: fmt.Sprintf("warning: %s depends on disabled service %s", serviceName, depName),
+                })
+                continue
+            }
+            return fmt.Errorf("%s depends on disabled service %s", serviceName, depName)
+        }
+    }
+
+    return nil
+}
+
+// Make sure no two enabled services declare the same tcp or udp port in their checks.
+// Ports chosen dynamically at runtime can't be detected here.
+func (f *Foreman) checkPortConflicts() error {
+    var serviceNames []string
+    for serviceName := range f.services {
+        serviceNames = append(serviceNames, serviceName)
+    }
+    sort.Strings(serviceNames)
+
+    owners := make(map[string]string)
+    for _, serviceName := range serviceNames {
+        service := f.services[serviceName]
+        if !service.enabled {
+            continue
+        }
+
+        declared := map[string][]string{
+            "tcp": service.checks.tcpPorts,
+            "udp": service.checks.udpPorts,
+        }
+        for _, portType := range []string{"tcp", "udp"} {
+            for _, port := range declared[portType] {
+                key := portType + "/" + port
+                owner, taken := owners[key]
+                if taken && owner != serviceName {
+                    return fmt.Errorf("%s port %s is declared by both %s and %s", portType, port, owner, serviceName)
+                }
+                owners[key] = serviceName
+            }
+        }
+    }
+
+    return nil
+}
+
+// Report the current status of every service.
+func (f *Foreman) Status() map[string]ServiceStatus {
+    now := f.clock.Now()
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    status := make(map[string]ServiceStatus, len(f.services))
+    for serviceName, service := range f.services {
+        status[serviceName] = service.status(now)
+    }
+    return status
+}
+
+// Report the current status of a single service.
+func (f *Foreman) StatusOf(serviceName string) (ServiceStatus, error) {
+    now := f.clock.Now()
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    service, ok := f.services[serviceName]
+    if !ok {
+        return ServiceStatus{}, &UnknownServiceError{Service: serviceName}
+    }
+    return service.status(now), nil
+}
+
+// Build the status of a service at now. The caller holds f.mu, which guards
+// the check history.
+func (s Service) status(now time.Time) ServiceStatus {
+    status := ServiceStatus{
+    	State:      s.state,
+    	Restarts:   s.restarts,
+    	ExitCode:   s.exitCode,
+    	ExitSignal: s.exitSignal,
+    }
+    if s.active {
+        status.PID = s.pid
+        status.Uptime = now.Sub(s.startedAt)
+    }
+    status.CheckHistory = s.checkHistory.list()
+    if s.scheduled() {
+        if s.pid != 0 {
+            status.LastRun = s.startedAt
+        }
+        status.NextRun = s.nextRun
+    }
+    return status
+}
+
+// Resolve a relative path from the Procfile against the working directory
+// given by WithWorkingDir, or else the Procfile's directory.
+func (f *Foreman) resolvePath(path string) string {
+    if path == "" || filepath.IsAbs(path) {
+        return path
+    }
+    if f.workingDir != "" {
+        return filepath.Join(f.workingDir, path)
+    }
+    if !f.procfileRelative {
+        return path
+    }
+    return filepath.Join(f.procfileDir, path)
+}
+
+// Build a command running cmd through shell, or the configured shell when it's
+// empty, with the extra environment. With the none shell cmd is split on spaces
+// and run directly.
+func (f *Foreman) command(shell []string, cmd string) *exec.Cmd {
+    if len(shell) == 0 {
+        shell = f.shell
+    }
+    if args := strings.Fields(cmd); shell[0] == shellNone && len(args) > 0 {
+        return f.exec(args)
+    }
+    return f.exec(append(append([]string{}, shell...), cmd))
+}
+
+// Build a command running argv directly with the extra environment.
+func (f *Foreman) exec(argv []string) *exec.Cmd {
+    command := exec.Command(argv[0], argv[1:]...)
+    if len(f.env) > 0 {
+        command.Env = append(os.Environ(), f.env...)
+    }
+    return command
+}
+
 // Start all the services and resolve their dependencies.
-func (f *Foreman) Start() error {
-    sigs := make(chan os.Signal)
+// It blocks until Stop is called, SIGINT, SIGTERM or SIGQUIT is received, the
+// main service exits or ctx is cancelled, in which case the services are
+// stopped and ctx.Err() is returned. SIGHUP reloads the Procfile. It can only
+// be called once.
+func (f *Foreman) Start(ctx context.Context) error {
+    if !atomic.CompareAndSwapInt32(&f.lifecycle, lifecycleNew, lifecycleStarted) {
+        return f.lifecycleError()
+    }
+
     depGraph := f.buildDependencyGraph()
 
-    if depGraph.isCyclic() {
-        errMsg := "Cyclic dependency detected"
-        return errors.New(errMsg)
+    cycle := depGraph.findCycle()
+    if cycle != nil {
+        atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+        return &CyclicDependencyError{Cycle: cycle}
     }
+    defer f.startForwarding()()
 
-    startList := depGraph.topSort()
-
-    for _, serviceName := range startList {
-        err := f.startService(serviceName)
+    startList, err := f.selectServices(depGraph)
+    if err == nil && f.mainService != "" {
+        if _, ok := f.service(f.mainService); !ok {
+            err = &UnknownServiceError{Service: f.mainService}
+        }
+    }
+    if err != nil {
+        atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+        return err
+    }
+    if f.subreaper {
+        err = setSubreaper()
         if err != nil {
+            atomic.StoreInt32(&f.lifecycle, lifecycleNew)
             return err
         }
+        f.reapChildren()
     }
 
-    signal.Notify(sigs, syscall.SIGCHLD, syscall.SIGINT)
-    for {
-        sig := <- sigs
-        switch sig {
-        case syscall.SIGINT:
-            f.sigIntHandler()
-        case syscall.SIGCHLD:
-            f.sigChildHandler()
+    // Signals are handled from now on, so one received while the services
+    // start stops them instead of killing foreman and orphaning them.
+    handled := f.handledSignals()
+    sigs := make(chan os.Signal, len(handled))
+    signal.Notify(sigs, handled...)
+    defer signal.Stop(sigs)
+    go f.handleSignals(sigs)
+
+    err = f.launch(startList)
+    if err != nil {
+        if atomic.LoadInt32(&f.lifecycle) == lifecycleStopped {
+            // Stop was called while the services were starting.
+            <-f.done
+            return f.mainExitError()
+        }
+        f.Stop(defaultStopTimeout)
+        return err
+    }
+
+    select {
+    case <-ctx.Done():
+        f.Stop(defaultStopTimeout)
+        return ctx.Err()
+    case <-f.done:
+        return f.mainExitError()
+    }
+}
+
+// Gracefully stop all the services in reverse dependency order and make Start return.
+// It returns once every service has exited, or with an error once timeout elapses.
+func (f *Foreman) Stop(timeout time.Duration) error {
+    if !atomic.CompareAndSwapInt32(&f.lifecycle, lifecycleStarted, lifecycleStopped) {
+        return f.lifecycleError()
+    }
+    // The state changes of the stopped services are delivered before the
+    // notifier goroutine ends.
+    defer f.notifier.close()
+    defer close(f.done)
+
+    stopped := make(chan struct{})
+    go func() {
+        f.stopAll()
+        close(stopped)
+    }()
+
+    select {
+    case <-stopped:
+        return nil
+    case <-f.clock.After(timeout):
+        return fmt.Errorf("timed out after %v waiting for services to stop", timeout)
+    }
+}
+
+// Describe why the current lifecycle state rejects a Start or Stop.
+func (f *Foreman) lifecycleError() error {
+    switch atomic.LoadInt32(&f.lifecycle) {
+    case lifecycleStarted:
+        return errors.New("foreman already started")
+    case lifecycleStopped:
+        return errors.New("foreman already stopped")
+    }
+    return errors.New("foreman not started")
+}
+
+// Register a callback invoked on every service state transition.
+// Callbacks run on a separate goroutine, in the order the transitions happened.
+func (f *Foreman) OnStateChange(callback StateChangeFunc) {
+    f.notifier.subscribe(callback)
+}
+
+// Copy the services map so it can be iterated without holding the lock.
+func (f *Foreman) snapshot() map[string]Service {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    services := make(map[string]Service, len(f.services))
+    for serviceName, service := range f.services {
+        services[serviceName] = service
+    }
+    return services
+}
+
+// Copy a single service so it can be read without holding the lock.
+func (f *Foreman) service(serviceName string) (Service, bool) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    service, ok := f.services[serviceName]
+    return service, ok
+}
+
+// Change a service under the lock and return a copy of it once changed, or
+// false when it doesn't exist.
+func (f *Foreman) updateService(serviceName string, update func(service *Service)) (Service, bool) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        return Service{}, false
+    }
+    update(&service)
+    f.services[serviceName] = service
+    return service, true
+}
+
+// Move a service to a new state and notify the registered callbacks.
+func (f *Foreman) setState(serviceName string, state State) {
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        return
+    }
+    old := service.state
+    service.state = state
+    f.services[serviceName] = service
+    if state == StateHealthy {
+        healthy := f.healthyChan(serviceName)
+        select {
+        case <-healthy:
+        default:
+            close(healthy)
         }
     }
+    // The transition is queued under the lock so concurrent transitions are
+    // delivered in the order they happened. The notifier never takes f.mu.
+    if old != state {
+        f.notifier.notify(stateTransition{serviceName: serviceName, old: old, new: state})
+    }
+    f.mu.Unlock()
 }
 
 func (f *Foreman) startService(serviceName string) error {
-    service := f.services[serviceName]
+    service, _ := f.service(serviceName)
 
-    err := f.checkDeps(serviceName)
+    err := f.waitDeps(serviceName)
     if err != nil {
-        return errors.New("Broken dependency")
+        f.setState(serviceName, StateFailed)
+        return err
     }
 
-    serviceExec := exec.Command("bash", "-c", service.cmd)
-    serviceExec.SysProcAttr = &syscall.SysProcAttr{
-    	Setpgid:                    true,
-    	Pgid:                       0,
+    f.setState(serviceName, StateStarting)
+
+    err = f.runCommandHooks(service, hookPreStart)
+    if err != nil {
+        f.setState(serviceName, StateFailed)
+        return &LaunchError{Service: serviceName, Cause: err}
     }
 
-    err = serviceExec.Start()
+    serviceExec := f.command(service.shell, service.cmd)
+    if len(service.argv) > 0 {
+        serviceExec = f.exec(service.argv)
+    }
+    setServiceEnv(serviceExec, service.env)
+    serviceExec.Dir = service.cwd
+    serviceExec.SysProcAttr = processGroupAttr()
+    err = setCredential(serviceExec, service)
+    if err == nil {
+        err = setChildUmask(serviceExec, service.umask)
+    }
     if err != nil {
-        return err
+        f.setState(serviceName, StateFailed)
+        return &LaunchError{Service: serviceName, Cause: err}
     }
 
+    outputFiles, err := f.attachOutput(serviceExec, service)
+    if err != nil {
+        f.setState(serviceName, StateFailed)
+        return &LaunchError{Service: serviceName, Cause: err}
+    }
+
+    pid, err := startWithNoNewPrivs(service.noNewPrivs, func() (int, error) {
+        return f.runner.Start(serviceExec)
+    })
+    closeFiles(outputFiles)
+    if err != nil {
+        f.setState(serviceName, StateFailed)
+        return &LaunchError{Service: serviceName, Cause: err}
+    }
+
+    f.mu.Lock()
+    service = f.services[serviceName]
+    // The runs of a scheduled service aren't restarts.
+    if service.pid != 0 && !service.scheduled() {
+        service.restarts++
+    }
     service.active = true
-    service.process = serviceExec.Process
+    service.pid = pid
+    service.startedAt = f.clock.Now()
+    service.exited = make(chan struct{})
+    service.stopRequested = false
+    service.queued = false
     f.services[serviceName] = service
+    f.mu.Unlock()
 
-    fmt.Printf("%d %s: process started\n", service.process.Pid, service.serviceName)
+    f.log(LogRecord{
+    	Event:   ServiceStarted.String(),
+    	Service: serviceName,
+    	PID:     pid,
+    	Message: fmt.Sprintf("%d %s: process started", pid, serviceName),
+    })
+    f.emit(Event{Type: ServiceStarted, Service: serviceName, PID: pid})
+    f.runStartHooks(serviceName, pid)
+    go f.runLoggedCommandHooks(service, hookPostStart)
 
-    go f.checker(serviceName)
+    go f.waiter(service)
+    if len(service.watch.Paths) > 0 {
+        go f.watchFiles(service)
+    }
+    if service.noHealthCheck {
+        f.setState(serviceName, StateHealthy)
+    } else {
+        f.restartChecker(serviceName)
+    }
 
     return nil
 }
 
-// Perform the checks needed on a specific pid.
-func (f *Foreman) checker(serviceName string) {
+// Wait for a service process to exit, kill what is left of its process group
+// and handle the exit.
+func (f *Foreman) waiter(service Service) {
+    exitCode, sig, _ := f.wait(service.pid)
+    f.killLeftovers(service)
+    f.runLoggedCommandHooks(service, hookPostStop)
+    f.exitHandler(service, exitCode, sig)
+}
+
+// Replace the checks of a running service without restarting its process.
+// The current checker is stopped and a new one starts with the new checks.
+func (f *Foreman) UpdateChecks(serviceName string, checks CheckSpec) error {
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        return &UnknownServiceError{Service: serviceName}
+    }
+    service.checks = checks.checks()
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    if service.active && !service.noHealthCheck {
+        f.restartChecker(serviceName)
+    }
+    return nil
+}
+
+// Stop the running checker of a service, if any, and start a new one.
+func (f *Foreman) restartChecker(serviceName string) {
+    f.mu.Lock()
     service := f.services[serviceName]
-    ticker := time.NewTicker(checkInterval)
+    if service.stopChecker != nil {
+        close(service.stopChecker)
+    }
+    service.stopChecker = make(chan struct{})
+    service.unready = service.readiness != nil
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    go f.checker(service)
+    if service.readiness != nil {
+        go f.readinessChecker(service)
+    }
+    if service.liveness != nil {
+        go f.livenessChecker(service)
+    }
+}
+
+// Perform the checks needed on a specific pid. The service is healthy while its
+// checks and its readiness checks pass.
+func (f *Foreman) checker(service Service) {
+    serviceName := service.serviceName
+    f.checkRounds(service, service.checks, true, func(healthy, interrupt bool) {
+        if !healthy && interrupt {
+            f.onCheckFailure(service, service.checks.onFailure)
+        }
+        f.mu.Lock()
+        unready := f.services[serviceName].unready
+        f.mu.Unlock()
+        if healthy && !unready {
+            f.setState(serviceName, StateHealthy)
+        } else {
+            f.setState(serviceName, StateFailed)
+        }
+    })
+}
+
+// Run the readiness checks of a service, which only keep it from being
+// healthy, until its checker is stopped.
+func (f *Foreman) readinessChecker(service Service) {
+    f.checkRounds(service, *service.readiness, false, func(ready, _ bool) {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        current := f.services[service.serviceName]
+        if current.pid == service.pid {
+            current.unready = !ready
+            f.services[service.serviceName] = current
+        }
+    })
+}
+
+// Run the liveness checks of a service, which interrupt it when they fail,
+// until its checker is stopped.
+func (f *Foreman) livenessChecker(service Service) {
+    f.checkRounds(service, *service.liveness, false, func(alive, _ bool) {
+        if !alive {
+            f.onCheckFailure(service, service.liveness.onFailure)
+        }
+    })
+}
+
+// Run rounds of checks every interval until the checker of the service is
+// stopped or its process exits, after the initial delay. done is called with
+// true once rounds passed successes times in a row, and with false once rounds
+// failed retries times in a row, with whether a failed check asks for the
+// service to be interrupted. A failure is reported once until the checks are
+// reported passing again, so the on_failure action isn't repeated every round. The dependencies are checked too when withDeps is
+// set, and the results of the rounds are kept in the check history.
+func (f *Foreman) checkRounds(service Service, checks Checks, withDeps bool, done func(passed, interrupt bool)) {
+    serviceName := service.serviceName
+    interval := checks.interval
+    if interval == 0 {
+        interval = f.checkInterval
+    }
+    retries := checks.retries
+    if retries == 0 {
+        retries = 1
+    }
+    successes := checks.successes
+    if successes == 0 {
+        successes = 1
+    }
+
+    if checks.initialDelay > 0 {
+        select {
+        case <-f.clock.After(checks.initialDelay):
+        case <-service.stopChecker:
+            return
+        }
+    }
+
+    // failures and passes count the rounds failed and passed in a row, and
+    // failureReported is set from a reported failure to the next reported pass.
+    failures, passes := 0, 0
+    failureReported := false
+    watch := &limitWatch{}
     for {
-        <-ticker.C
+        select {
+        case <-f.clock.After(interval):
+        case <-service.stopChecker:
+            return
+        }
 
-        err := syscall.Kill(service.process.Pid, 0)
+        err := f.runner.Signal(service.pid, 0)
         if err != nil {
             return
         }
 
-        err = f.checkDeps(serviceName)
-        if err != nil {
-            syscall.Kill(service.process.Pid, syscall.SIGINT)
+        passed := true
+        interrupt := false
+        var failed []string
+        fail := func(check string, err error, interruptService bool) {
+            passed = false
+            failed = append(failed, check)
+            interrupt = interrupt || interruptService
+            err = &CheckFailedError{Service: serviceName, Check: check, Cause: err}
+            f.emit(Event{Type: CheckFailed, Service: serviceName, PID: service.pid, Check: check, Err: err})
         }
 
-        err = service.checkCmd()
-        if err != nil {
-            syscall.Kill(service.process.Pid, syscall.SIGINT)
+        if withDeps {
+            err = f.checkDeps(serviceName)
+            if err != nil {
+                f.log(LogRecord{Level: LevelError, Event: "dependency lost", Service: serviceName, PID: service.pid, Message: err.Error()})
+                fail("deps", err, true)
+            }
+        }
+        checked := service
+        checked.checks = checks
+        if !withDeps {
+            // Custom checks run with the main checks only, like the dependencies.
+            checked.customChecks = nil
+        }
+        f.runChecks(checked, watch, fail)
+        if withDeps {
+            f.recordCheckResult(serviceName, CheckResult{Time: f.clock.Now(), Passed: passed, Failed: failed})
         }
 
-        err = service.checkPorts("tcp")
-        if err != nil {
-            syscall.Kill(service.process.Pid, syscall.SIGINT)
+        // Until the checks passed successes times or failed retries times in
+        // a row, the service keeps its state, so a blip doesn't flap it.
+        if passed {
+            failures = 0
+            passes++
+            if passes >= successes {
+                failureReported = false
+                done(true, false)
+            }
+            continue
         }
 
-        err = service.checkPorts("udp")
+        passes = 0
+        failures++
+        if failures >= retries && !failureReported {
+            failureReported = true
+            done(false, interrupt)
+        }
+    }
+}
+
+// Run every check of service.checks and the custom checks of the service,
+// calling fail for the failing ones with whether the failure asks for the
+// service to be interrupted. watch follows the resource usage of the service
+// from one round to the next.
+func (f *Foreman) runChecks(service Service, watch *limitWatch, fail func(check string, err error, interrupt bool)) {
+    for _, check := range f.serviceChecks(service, watch) {
+        if f.disabledChecks[check.category] {
+            continue
+        }
+        ctx, cancel := service.checks.checkContext(check.category)
+        err := check.check.Run(ctx)
+        cancel()
         if err != nil {
-            syscall.Kill(service.process.Pid, syscall.SIGINT)
+            fail(check.name, err, check.interrupt)
         }
     }
 }
 
+// Check every dependency of a service, reporting all the inactive ones at once.
 func (f *Foreman) checkDeps(serviceName string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
     service := f.services[serviceName]
 
+    var broken []*BrokenDependencyError
     for _, depName := range service.deps {
         depService := f.services[depName]
-        if !depService.active {
-            return errors.New("Broken dependency")
+        ready := depService.active || depService.completed()
+        if f.depCondition(service, depName) == DepCompleted {
+            ready = depService.exitedSuccessfully()
+        }
+        if !ready {
+            broken = append(broken, &BrokenDependencyError{Service: serviceName, Dep: depName})
         }
     }
 
-    return nil
+    if broken == nil {
+        return nil
+    }
+    return &DependenciesNotReadyError{Service: serviceName, Broken: broken}
 }
 
-// Handles incoming SIGINT.
+// Check the dependencies of a service before launching it, first waiting for
+// the healthy and completed conditions up to the dependency timeouts, then
+// retrying a bounded number of times to let the other dependencies come up.
+func (f *Foreman) waitDeps(serviceName string) error {
+    service, _ := f.service(serviceName)
+    var timedOut []*BrokenDependencyError
+    for _, depName := range service.deps {
+        if !f.waitDep(service, depName) {
+            timedOut = append(timedOut, &BrokenDependencyError{Service: serviceName, Dep: depName})
+        }
+    }
+    if timedOut != nil {
+        return &DependenciesNotReadyError{Service: serviceName, Broken: timedOut}
+    }
+
+    err := f.checkDeps(serviceName)
+    for attempt := 1; err != nil && attempt < depCheckAttempts; attempt++ {
+        f.log(LogRecord{Level: LevelWarning, Event: "dependencies not ready", Service: serviceName, Message: err.Error()})
+        <-f.clock.After(depRetryInterval)
+        err = f.checkDeps(serviceName)
+    }
+    return err
+}
+
+// Handles incoming SIGINT, SIGTERM and SIGQUIT.
 func (f *Foreman) sigIntHandler() {
+    f.Stop(defaultStopTimeout)
+}
+
+// Stop all services in reverse dependency order, dependents before their dependencies.
+func (f *Foreman) stopAll() {
+    f.mu.Lock()
     f.active = false
-    for _, service := range f.services {
-        syscall.Kill(service.process.Pid, syscall.SIGINT)
+    f.mu.Unlock()
+
+    var serviceNames []string
+    for serviceName := range f.snapshot() {
+        serviceNames = append(serviceNames, serviceName)
     }
-    os.Exit(0)
+    f.stopGroup(serviceNames)
 }
 
-// Handles incoming SIGCHLD.
-func (f *Foreman) sigChildHandler() {
-    for serviceName, service := range f.services {
-        childProcess, _ := process.NewProcess(int32(service.process.Pid))
-        childStatus, _ := childProcess.Status()
-        if childStatus == "Z" {
-            service.active = false
-            f.services[serviceName] = service
-            service.process.Wait()
-            fmt.Printf("%d %s: process stopped\n", service.process.Pid, service.serviceName)
-            if !service.runOnce && f.active {
-                f.startService(service.serviceName)
+// Stop a service, and with cascade every service depending on it first.
+// Without cascade it fails if any dependent is still active.
+// Stopped services are not restarted until started again.
+func (f *Foreman) StopService(serviceName string, cascade bool) error {
+    services := f.snapshot()
+    if _, ok := services[serviceName]; !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+
+    dependents := f.buildDependencyGraph().reverse().reachable(serviceName)
+    if !cascade {
+        for _, dependent := range dependents {
+            if services[dependent].active {
+                return fmt.Errorf("%s has active dependent %s", serviceName, dependent)
+            }
+        }
+    }
+
+    group := append(dependents, serviceName)
+    f.requestStop(group)
+    f.stopGroup(group)
+    return nil
+}
+
+// Block until a service has started and passed its checks at least once,
+// or ctx is done.
+func (f *Foreman) WaitHealthy(ctx context.Context, serviceName string) error {
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        return &UnknownServiceError{Service: serviceName}
+    }
+    healthy := f.healthyChan(serviceName)
+    f.mu.Unlock()
+
+    if !service.enabled {
+        return fmt.Errorf("%s is disabled", serviceName)
+    }
+
+    select {
+    case <-healthy:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Wait for a service with a start timeout to pass its checks, failing
+// if it exits or isn't healthy in time.
+func (f *Foreman) waitStarted(serviceName string) error {
+    f.mu.Lock()
+    service := f.services[serviceName]
+    healthy := f.healthyChan(serviceName)
+    f.mu.Unlock()
+
+    if service.startTimeout == 0 {
+        return nil
+    }
+
+    select {
+    case <-healthy:
+        return nil
+    case <-service.exited:
+        exited, _ := f.service(serviceName)
+        return &LaunchError{Service: serviceName, Cause: fmt.Errorf("exited with code %d before becoming healthy", exited.exitCode)}
+    case <-f.clock.After(service.startTimeout):
+        return &LaunchError{Service: serviceName, Cause: fmt.Errorf("not healthy after %v", service.startTimeout)}
+    }
+}
+
+// Return the channel closed once a service first becomes healthy. The caller holds f.mu.
+func (f *Foreman) healthyChan(serviceName string) chan struct{} {
+    healthy, ok := f.healthy[serviceName]
+    if !ok {
+        healthy = make(chan struct{})
+        f.healthy[serviceName] = healthy
+    }
+    return healthy
+}
+
+// Start a single service that isn't running, like a manual one, right away.
+// Its dependencies must already be active.
+func (f *Foreman) StartService(serviceName string) error {
+    service, ok := f.service(serviceName)
+    if !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+    if service.active {
+        return fmt.Errorf("%s is already running", serviceName)
+    }
+
+    return f.startService(serviceName)
+}
+
+// Stop and start a service again. Unless disabled by WithRestartDependents(false),
+// the active services depending on it are restarted as well.
+func (f *Foreman) RestartService(serviceName string) error {
+    services := f.snapshot()
+    if _, ok := services[serviceName]; !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+
+    depGraph := f.buildDependencyGraph()
+    group := []string{serviceName}
+    if f.restartDependents {
+        for _, dependent := range depGraph.reverse().reachable(serviceName) {
+            if services[dependent].active {
+                group = append(group, dependent)
+            }
+        }
+    }
+
+    f.requestStop(group)
+    f.stopGroup(group)
+
+    inGroup := make(map[string]bool)
+    for _, name := range group {
+        inGroup[name] = true
+    }
+    for _, name := range depGraph.topSort() {
+        if !inGroup[name] {
+            continue
+        }
+
+        err := f.startService(name)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Mark services as stopped on purpose so they aren't restarted when they exit.
+func (f *Foreman) requestStop(serviceNames []string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    for _, serviceName := range serviceNames {
+        service := f.services[serviceName]
+        service.stopRequested = true
+        f.services[serviceName] = service
+    }
+}
+
+// Start a group of services in dependency order. Services whose dependencies
+// in the group have all started are started concurrently, at most
+// startupParallelism at a time, after their start delay. No more services are
+// started after a failure.
+func (f *Foreman) startGroup(serviceNames []string) error {
+    services := f.snapshot()
+    dependents := graphOf(services).reverse()
+
+    inGroup := make(map[string]bool)
+    for _, serviceName := range serviceNames {
+        inGroup[serviceName] = true
+    }
+
+    pendingDeps := make(map[string]int)
+    for serviceName := range inGroup {
+        for _, depName := range services[serviceName].deps {
+            if inGroup[depName] {
+                pendingDeps[serviceName]++
+            }
+        }
+    }
+
+    type result struct {
+        serviceName string
+        err error
+    }
+    done := make(chan result)
+    sem := make(chan struct{}, f.startupParallelism)
+    start := func(serviceName string) {
+        go func() {
+            if delay := services[serviceName].startDelay; delay > 0 {
+                select {
+                case <-f.clock.After(delay):
+                case <-f.done:
+                }
+            }
+            sem <- struct{}{}
+            var err error
+            if atomic.LoadInt32(&f.lifecycle) == lifecycleStopped {
+                err = errors.New("foreman stopped while starting services")
+            } else {
+                err = f.startService(serviceName)
+            }
+            if err == nil {
+                err = f.waitStarted(serviceName)
+            }
+            <-sem
+            done <- result{serviceName: serviceName, err: err}
+        }()
+    }
+
+    running := 0
+    for serviceName := range inGroup {
+        if pendingDeps[serviceName] == 0 {
+            start(serviceName)
+            running++
+        }
+    }
+
+    var firstErr error
+    for ; running > 0; running-- {
+        started := <-done
+        if firstErr != nil {
+            continue
+        }
+        if started.err != nil {
+            firstErr = started.err
+            continue
+        }
+
+        for _, dependent := range dependents[started.serviceName] {
+            if !inGroup[dependent] {
+                continue
+            }
+            pendingDeps[dependent]--
+            if pendingDeps[dependent] == 0 {
+                start(dependent)
+                running++
+            }
+        }
+    }
+
+    return firstErr
+}
+
+// Stop a group of services in reverse dependency order. Services whose dependents
+// in the group have all stopped are stopped concurrently, at most
+// shutdownParallelism at a time, after waiting shutdownDelay.
+func (f *Foreman) stopGroup(serviceNames []string) {
+    services := f.snapshot()
+    dependents := f.buildDependencyGraph().reverse()
+
+    inGroup := make(map[string]bool)
+    for _, serviceName := range serviceNames {
+        inGroup[serviceName] = true
+    }
+
+    runningDependents := make(map[string]int)
+    for serviceName := range inGroup {
+        for _, dependent := range dependents[serviceName] {
+            if inGroup[dependent] {
+                runningDependents[serviceName]++
+            }
+        }
+    }
+
+    done := make(chan string)
+    sem := make(chan struct{}, f.shutdownParallelism)
+    stop := func(serviceName string, delay time.Duration) {
+        go func() {
+            if delay > 0 {
+                <-f.clock.After(delay)
+            }
+            sem <- struct{}{}
+            f.stopService(serviceName)
+            <-sem
+            done <- serviceName
+        }()
+    }
+
+    for serviceName := range inGroup {
+        if runningDependents[serviceName] == 0 {
+            stop(serviceName, 0)
+        }
+    }
+
+    for pending := len(inGroup); pending > 0; pending-- {
+        serviceName := <-done
+        for _, depName := range services[serviceName].deps {
+            if !inGroup[depName] {
+                continue
+            }
+            runningDependents[depName]--
+            if runningDependents[depName] == 0 {
+                stop(depName, f.shutdownDelay)
             }
         }
     }
 }
 
-// Perform the command in the checks.
-func (s *Service) checkCmd() error {
-    checkExec := exec.Command("bash", "-c", s.checks.cmd)
-    checkExec.SysProcAttr = &syscall.SysProcAttr{
-    	Setpgid:                    true,
-    	Pgid:                       0,
+// Send a single service its stop signal, SIGINT by default, and wait for it to exit.
+// If it sets a stop timeout and hasn't exited by then, it is killed. The whole
+// process group of the service gets the signals.
+func (f *Foreman) stopService(serviceName string) {
+    service, _ := f.service(serviceName)
+
+    if !service.active {
+        return
+    }
+
+    f.runLoggedCommandHooks(service, hookPreStop)
+    stopSignal := service.stopSignal
+    if stopSignal == 0 {
+        stopSignal = defaultStopSignal
+    }
+    f.signalGroup(service.pid, stopSignal)
+    if service.paused {
+        // A stopped process only handles the signal once continued.
+        f.signalGroup(service.pid, sigCONT)
+    }
+    if service.stopTimeout == 0 {
+        <-service.exited
+        return
+    }
+
+    select {
+    case <-service.exited:
+    case <-f.clock.After(service.stopTimeout):
+        f.log(LogRecord{
+        	Level:   LevelWarning,
+        	Event:   "killed",
+        	Service: serviceName,
+        	PID:     service.pid,
+        	Message: fmt.Sprintf("%d %s: still running after %v, killing it", service.pid, serviceName, service.stopTimeout),
+        })
+        f.signalGroup(service.pid, syscall.SIGKILL)
+        <-service.exited
+    }
+}
+
+// Handles the exit of a service process, restarting it as its restart policy says.
+func (f *Foreman) exitHandler(exited Service, exitCode int, sig syscall.Signal) {
+    f.mu.Lock()
+    service := f.services[exited.serviceName]
+    if service.pid == exited.pid {
+        service.active = false
+        service.exitCode = exitCode
+        service.exitSignal = sig
+        service.paused = false
+        f.services[exited.serviceName] = service
+    }
+    expected := service.stopRequested || !f.active
+    mainExited := !expected && exited.serviceName == f.mainService
+    restart := !expected && !mainExited && service.restart.shouldRestart(exitCode)
+    // A run queued while this one was going starts instead of a restart.
+    queued := !expected && service.queued
+    if queued {
+        restart = false
+    }
+    gaveUp := false
+    var delay time.Duration
+    if restart {
+        now := f.clock.Now()
+        service.restartHistory = service.recentRestarts(now)
+        if service.maxRestarts > 0 && len(service.restartHistory) >= service.maxRestarts {
+            restart = false
+            gaveUp = true
+        } else {
+            delay = service.backoffDelay(len(service.restartHistory))
+            service.restartHistory = append(service.restartHistory, now)
+        }
+        f.services[exited.serviceName] = service
+    }
+    f.mu.Unlock()
+
+    crashed := exitCode != 0 && !expected
+    eventType := ServiceStopped
+    level := LevelInfo
+    if crashed {
+        eventType = ServiceCrashed
+        level = LevelError
+    }
+    message := fmt.Sprintf("%d %s: process stopped", exited.pid, exited.serviceName)
+    if sig != 0 {
+        message += ", " + describeExit(exitCode, sig)
+    }
+    f.log(LogRecord{
+    	Level:    level,
+    	Event:    eventType.String(),
+    	Service:  exited.serviceName,
+    	PID:      exited.pid,
+    	ExitCode: exitCodeOf(exitCode),
+    	Signal:   signalNameOf(sig),
+    	Message:  message,
+    })
+    f.emit(Event{Type: eventType, Service: exited.serviceName, PID: exited.pid, ExitCode: exitCode, Signal: sig})
+    f.runExitHooks(exited.serviceName, exitCode, crashed)
+    switch {
+    case gaveUp:
+        f.log(LogRecord{
+        	Level:    LevelError,
+        	Event:    RestartLimitReached.String(),
+        	Service:  exited.serviceName,
+        	ExitCode: exitCodeOf(exitCode),
+        	Signal:   signalNameOf(sig),
+        	Message:  fmt.Sprintf("%s: restarted %d times within %v, giving up", exited.serviceName, service.maxRestarts, service.window()),
+        })
+        f.emit(Event{Type: RestartLimitReached, Service: exited.serviceName, PID: exited.pid, ExitCode: exitCode, Signal: sig})
+        f.setState(exited.serviceName, StateFailed)
+    case restart:
+        f.setState(exited.serviceName, StateRestarting)
+    case crashed:
+        f.setState(exited.serviceName, StateCrashed)
+    default:
+        f.setState(exited.serviceName, StateStopped)
+    }
+    close(exited.exited)
+
+    if mainExited {
+        f.mainServiceExited(exitCode)
+        return
+    }
+    if queued {
+        f.startService(exited.serviceName)
+        return
+    }
+    if !restart {
+        return
+    }
+    f.emit(Event{Type: RestartScheduled, Service: exited.serviceName, PID: exited.pid})
+
+    if delay > 0 {
+        <-f.clock.After(delay)
+
+        f.mu.Lock()
+        restart = f.active
+        f.mu.Unlock()
+        if !restart {
+            return
+        }
+    }
+
+    f.startService(exited.serviceName)
+}
+
+// Return the restart window, one minute unless the service sets its own.
+func (s Service) window() time.Duration {
+    if s.restartWindow > 0 {
+        return s.restartWindow
+    }
+    return defaultRestartWindow
+}
+
+// Drop the restarts that happened before the current restart window.
+func (s Service) recentRestarts(now time.Time) []time.Time {
+    var recent []time.Time
+    for _, restartedAt := range s.restartHistory {
+        if now.Sub(restartedAt) < s.window() {
+            recent = append(recent, restartedAt)
+        }
+    }
+    return recent
+}
+
+// Compute the delay before the next restart, multiplying restart_delay by
+// backoff_factor for every restart already made in the window.
+func (s Service) backoffDelay(recentRestarts int) time.Duration {
+    delay := float64(s.restartDelay)
+    if s.backoffFactor > 1 {
+        for i := 0; i < recentRestarts && delay < float64(maxRestartDelay); i++ {
+            delay *= s.backoffFactor
+        }
+    }
+    if delay > float64(maxRestartDelay) {
+        return maxRestartDelay
+    }
+    return time.Duration(delay)
+}
+
+// Report whether a service that isn't restarted has run and exited successfully,
+// which satisfies the services depending on it.
+func (s Service) completed() bool {
+    return s.restart == RestartNo && s.exitedSuccessfully()
+}
+
+// Perform the command in the checks, with the service environment and working
+// directory. A list of arguments is run without the shell. The process group
+// of the command is killed when ctx is done.
+func (f *Foreman) checkCmd(ctx context.Context, s Service) error {
+    checkExec := f.command(s.shell, s.checks.cmd)
+    if len(s.checks.argv) > 0 {
+        checkExec = f.exec(s.checks.argv)
     }
-    err := checkExec.Run()
+    setServiceEnv(checkExec, s.env)
+    checkExec.Dir = s.cwd
+    checkExec.SysProcAttr = processGroupAttr()
+
+    err := startChild(checkExec)
     if err != nil {
         return err
     }
-    return nil
+    exited := make(chan struct{})
+    go func() {
+        select {
+        case <-ctx.Done():
+            signalProcessGroup(checkExec.Process.Pid, syscall.SIGKILL)
+        case <-exited:
+        }
+    }()
+
+    err = waitChild(checkExec)
+    close(exited)
+    if err != nil && ctx.Err() == context.DeadlineExceeded {
+        return fmt.Errorf("timed out after %s", s.checks.timeout)
+    }
+    if err != nil && ctx.Err() != nil {
+        return ctx.Err()
+    }
+    return err
 }
 
-// Checks all ports in the checks.
-func (s *Service) checkPorts(portType string) error {
-    var ports []string
-    switch portType {
-    case "tcp":
-        ports = s.checks.tcpPorts
-    case "udp":
-        ports = s.checks.udpPorts
+// Return how long the checks reaching over the network wait: the checks
+// timeout, 2s by default.
+func (c Checks) networkTimeout() time.Duration {
+    if c.timeout > 0 {
+        return c.timeout
     }
+    return networkCheckTimeout
+}
+
+// Return the context a check of category runs with. It expires after
+// networkTimeout, except for a cmd check without a timeout, which may run
+// until it exits.
+func (c Checks) checkContext(category string) (context.Context, context.CancelFunc) {
+    if category == CheckCmd && c.timeout == 0 {
+        return context.WithCancel(context.Background())
+    }
+    return context.WithTimeout(context.Background(), c.networkTimeout())
+}
+
+// Resolve every host in the network_ready checks. Entries given as host:port
+// must also accept a tcp connection.
+func (s *Service) checkNetwork(ctx context.Context) error {
+    var dialer net.Dialer
+    for _, target := range s.checks.networkReady {
+        host, _, err := net.SplitHostPort(target)
+        if err != nil {
+            host = target
+        }
 
-    for _, port := range ports {
-        cmd := fmt.Sprintf("netstat -lnptu | grep %s | grep %s -m 1 | awk '{print $7}'", portType, port)
-        out, _ := exec.Command("bash", "-c", cmd).Output()
-        pid, err := strconv.Atoi(strings.Split(string(out), "/")[0])
-        if err != nil || pid != s.process.Pid {
+        _, err = net.DefaultResolver.LookupHost(ctx, host)
+        if err != nil {
             return err
         }
+
+        if host != target {
+            conn, err := dialer.DialContext(ctx, "tcp", target)
+            if err != nil {
+                return err
+            }
+            conn.Close()
+        }
     }
 
     return nil
 }
 
+// Return the dependency graph, mapping every service to the services it depends on.
+func (f *Foreman) DependencyGraph() map[string][]string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    graph := make(map[string][]string, len(f.services))
+    for serviceName, service := range f.services {
+        graph[serviceName] = append([]string{}, service.deps...)
+    }
+    return graph
+}
+
 // Build graph out of services dependencies.
 func (f *Foreman) buildDependencyGraph() dependencyGraph {
+    return graphOf(f.snapshot())
+}
+
+func graphOf(services map[string]Service) dependencyGraph {
     graph := dependencyGraph{}
 
-    for serviceName, service := range f.services {
+    for serviceName, service := range services {
         graph[serviceName] = service.deps
     }
 
@@ -259,32 +1754,89 @@ func (f *Foreman) buildDependencyGraph() dependencyGraph {
 
 // Check if graph is cyclic.
 func (g dependencyGraph) isCyclic() bool {
-    cyclic := false
+    return g.findCycle() != nil
+}
+
+// Find a dependency cycle in the graph, returning its services
+// with the first one repeated at the end, or nil if the graph is acyclic.
+func (g dependencyGraph) findCycle() []string {
+    var cycle []string
+    var path []string
     state := make(map[string]vertixStatus)
 
-    var dfs func(string)
-    dfs = func(vertix string) {
+    var dfs func(string) bool
+    dfs = func(vertix string) bool {
         if state[vertix] == visited {
-            return
+            return false
         }
 
         if state[vertix] == currentlyVisiting {
-            cyclic = true
-            return
+            for i, node := range path {
+                if node == vertix {
+                    cycle = append(append([]string{}, path[i:]...), vertix)
+                }
+            }
+            return true
         }
 
         state[vertix] = currentlyVisiting
+        path = append(path, vertix)
         for _, child := range g[vertix] {
-            dfs(child)
+            if dfs(child) {
+                return true
+            }
         }
+        path = path[:len(path)-1]
         state[vertix] = visited
+        return false
     }
 
+    vertices := make([]string, 0, len(g))
     for vertix := range g {
-        dfs(vertix)
+        vertices = append(vertices, vertix)
     }
+    sort.Strings(vertices)
+
+    for _, vertix := range vertices {
+        if dfs(vertix) {
+            return cycle
+        }
+    }
+
+    return nil
+}
+
+// Build the reverse graph, mapping each service to the services depending on it.
+func (g dependencyGraph) reverse() dependencyGraph {
+    reversed := dependencyGraph{}
 
-    return cyclic
+    for vertix, children := range g {
+        for _, child := range children {
+            reversed[child] = append(reversed[child], vertix)
+        }
+    }
+
+    return reversed
+}
+
+// List every vertix reachable from the given one, excluding itself.
+func (g dependencyGraph) reachable(vertix string) []string {
+    var out []string
+    seen := map[string]bool{vertix: true}
+
+    var dfs func(string)
+    dfs = func(vertix string) {
+        for _, child := range g[vertix] {
+            if !seen[child] {
+                seen[child] = true
+                out = append(out, child)
+                dfs(child)
+            }
+        }
+    }
+    dfs(vertix)
+
+    return out
 }
 
 // Topologically sort the dependency graph.
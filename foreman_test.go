@@ -1,12 +1,55 @@
-package main
+package foreman
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const testProcfile = "./Procfile-test"
 const testBadProcfile = "./Procfile-bad-test"
 const testCyclicProcfile = "./Procfile-cyclic-test"
+const testCwdProcfile = "./Procfile-cwd-test"
+const testChainProcfile = "./Procfile-chain-test"
+const testDisabledProcfile = "./Procfile-disabled-test"
+const testDisabledDepProcfile = "./Procfile-disabled-dep-test"
+const testOutputProcfile = "./Procfile-output-test"
+const testIncludeProcfile = "./Procfile-include-test"
+const testIncludeCycleProcfile = "./Procfile-include-cycle-test"
+const testSignalProcfile = "./Procfile-signal-test"
+const testRestartDelayProcfile = "./Procfile-restart-delay-test"
+const testParseErrorProcfile = "./Procfile-parse-error-test"
+const testNoHealthCheckProcfile = "./Procfile-no-health-check-test"
+const testDefaultsProcfile = "./Procfile-defaults-test"
+const testPortConflictProcfile = "./Procfile-port-conflict-test"
+const testDepsDownProcfile = "./Procfile-deps-down-test"
+const testStubbornProcfile = "./Procfile-stubborn-test"
+const testJobsProcfile = "./Procfile-jobs-test"
+const testStopProcfile = "./Procfile-stop-test"
+const testCrashLoopProcfile = "./Procfile-crash-loop-test"
+const testStartTimeoutProcfile = "./Procfile-start-timeout-test"
+const testDepConditionsProcfile = "./Procfile-dep-conditions-test"
+const testWideProcfile = "./Procfile-wide-test"
+const testDepTimeoutProcfile = "./Procfile-dep-timeout-test"
+const testInvalidProcfile = "./Procfile-invalid-test"
 
 func TestNew(t *testing.T) {
     t.Run("Parse existing procfile with correct syntax", func(t *testing.T) {
@@ -16,9 +59,9 @@ func TestNew(t *testing.T) {
         }
         sleeper := Service{
         	serviceName: "sleeper",
-        	process:     nil,
+        	pid:         0,
         	cmd:         "sleep infinity",
-        	runOnce:     true,
+        	restart:     RestartNo,
         	deps:        []string{"hello"},
         	checks:      Checks{
         		cmd:      "ls",
@@ -30,9 +73,9 @@ func TestNew(t *testing.T) {
 
         hello := Service{
         	serviceName: "hello",
-        	process:     nil,
+        	pid:         0,
         	cmd:         `echo "hello"`,
-        	runOnce:     true,
+        	restart:     RestartNo,
         	deps:        []string{},
         }
         want.services["hello"] = hello
@@ -56,6 +99,261 @@ func TestNew(t *testing.T) {
     })
 }
 
+func TestProcfileRelativePaths(t *testing.T) {
+    procfilePath, _ := filepath.Abs(testCwdProcfile)
+    procfileDir := filepath.Dir(procfilePath)
+
+    wd, _ := os.Getwd()
+    defer os.Chdir(wd)
+    os.Chdir(os.TempDir())
+
+    t.Run("resolve relative cwd against the Procfile directory", func(t *testing.T) {
+        foreman, err := New(procfilePath)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        assertString(t, foreman.services["relative"].cwd, filepath.Join(procfileDir, "scripts"))
+        assertString(t, foreman.services["absolute"].cwd, "/tmp")
+    })
+
+    t.Run("keep relative cwd when disabled", func(t *testing.T) {
+        foreman, err := New(procfilePath, WithoutProcfileRelativePaths())
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        assertString(t, foreman.services["relative"].cwd, "scripts")
+    })
+}
+
+func TestDisabledServices(t *testing.T) {
+    t.Run("disabled leaf is reported as disabled", func(t *testing.T) {
+        foreman, err := New(testDisabledProcfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        status := foreman.Status()
+        assertString(t, status["debugger"].State.String(), "disabled")
+        assertString(t, status["app"].State.String(), "pending")
+    })
+
+    t.Run("enabled service depending on a disabled one", func(t *testing.T) {
+        _, err := New(testDisabledDepProcfile)
+        assertError(t, err, "app depends on disabled service redis")
+    })
+
+    t.Run("warn instead of failing on a disabled dependency", func(t *testing.T) {
+        _, err := New(testDisabledDepProcfile, WithDisabledDependencyWarnings())
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+}
+
+func TestInclude(t *testing.T) {
+    t.Run("merge nested includes with local definitions winning", func(t *testing.T) {
+        foreman, err := New(testIncludeProcfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if len(foreman.services) != 3 {
+            t.Fatalf("got %d services, want 3", len(foreman.services))
+        }
+        assertString(t, foreman.services["app"].cmd, "sleep 10")
+        assertString(t, foreman.services["worker"].cmd, "sleep 10")
+        assertString(t, foreman.services["redis"].cmd, "redis-server --port 6010")
+    })
+
+    t.Run("detect include cycle", func(t *testing.T) {
+        _, err := New(testIncludeCycleProcfile)
+        if err == nil || !strings.HasPrefix(err.Error(), "include cycle detected: ") {
+            t.Errorf("expected include cycle error, got: %v", err)
+        }
+    })
+}
+
+func TestErrorTypes(t *testing.T) {
+    t.Run("cyclic dependency", func(t *testing.T) {
+        foreman, _ := New(testCyclicProcfile)
+        err := foreman.Start(context.Background())
+
+        var cyclicErr *CyclicDependencyError
+        if !errors.As(err, &cyclicErr) {
+            t.Fatalf("expected CyclicDependencyError, got: %v", err)
+        }
+        assertList(t, cyclicErr.Cycle, []string{"hello", "sleeper", "hello"})
+    })
+
+    t.Run("parse error", func(t *testing.T) {
+        _, err := New(testParseErrorProcfile)
+
+        var parseErr *ParseError
+        if !errors.As(err, &parseErr) {
+            t.Fatalf("expected ParseError, got: %v", err)
+        }
+        assertString(t, parseErr.Service, "app")
+        assertString(t, parseErr.Field, "run_once")
+        assertString(t, parseErr.Position.String(), testParseErrorProcfile+":3:5")
+        assertString(t, err.Error(), testParseErrorProcfile+":3:5: app: run_once: expected a boolean, got maybe")
+    })
+
+    t.Run("wrong type", func(t *testing.T) {
+        _, err := New(testInvalidProcfile)
+
+        var parseErr *ParseError
+        if !errors.As(err, &parseErr) {
+            t.Fatalf("expected ParseError, got: %v", err)
+        }
+    })
+
+    t.Run("broken dependency", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile)
+        err := foreman.startService("backend")
+
+        var brokenErr *BrokenDependencyError
+        if !errors.As(err, &brokenErr) {
+            t.Fatalf("expected BrokenDependencyError, got: %v", err)
+        }
+        assertString(t, brokenErr.Service, "backend")
+        assertString(t, brokenErr.Dep, "database")
+    })
+
+    t.Run("all broken dependencies are reported", func(t *testing.T) {
+        foreman, _ := New(testDepsDownProcfile, WithRunner(newFakeRunner()))
+        err := foreman.startService("web")
+
+        var notReadyErr *DependenciesNotReadyError
+        if !errors.As(err, &notReadyErr) {
+            t.Fatalf("expected DependenciesNotReadyError, got: %v", err)
+        }
+        assertString(t, err.Error(), "web: dependencies not ready: [redis, db]")
+        assertString(t, notReadyErr.Broken[1].Dep, "db")
+    })
+
+    t.Run("launch error", func(t *testing.T) {
+        foreman, _ := New(testCwdProcfile)
+        err := foreman.startService("relative")
+
+        var launchErr *LaunchError
+        if !errors.As(err, &launchErr) {
+            t.Fatalf("expected LaunchError, got: %v", err)
+        }
+        assertString(t, launchErr.Service, "relative")
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile)
+        err := foreman.StartService("cache")
+
+        var unknownErr *UnknownServiceError
+        if !errors.As(err, &unknownErr) {
+            t.Fatalf("expected UnknownServiceError, got: %v", err)
+        }
+        assertString(t, unknownErr.Service, "cache")
+    })
+
+    t.Run("check failed", func(t *testing.T) {
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()), WithClock(clock))
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second, Cmd: "false"})
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+
+        for {
+            select {
+            case event := <-foreman.Events():
+                if event.Type != CheckFailed {
+                    continue
+                }
+
+                var checkErr *CheckFailedError
+                if !errors.As(event.Err, &checkErr) {
+                    t.Fatalf("expected CheckFailedError, got: %v", event.Err)
+                }
+                assertString(t, checkErr.Service, "database")
+                assertString(t, checkErr.Check, CheckCmd)
+                return
+            case <-time.After(time.Second):
+                t.Fatal("timed out waiting for a failed check")
+            }
+        }
+    })
+}
+
+func TestDefaults(t *testing.T) {
+    foreman, err := New(testDefaultsProcfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("scalar defaults are overridden by the service", func(t *testing.T) {
+        assertString(t, string(foreman.services["web"].restart), "no")
+        assertString(t, string(foreman.services["worker"].restart), "always")
+        if foreman.services["worker"].restartDelay != time.Second {
+            t.Errorf("got:\n%v\nwant:\n%v", foreman.services["worker"].restartDelay, time.Second)
+        }
+    })
+
+    t.Run("map defaults are merged key-wise", func(t *testing.T) {
+        web := foreman.services["web"]
+        assertString(t, web.checks.cmd, "ls")
+        assertList(t, web.checks.tcpPorts, []string{"9090"})
+
+        worker := foreman.services["worker"]
+        assertString(t, worker.checks.cmd, "ls")
+        assertList(t, worker.checks.tcpPorts, []string{"8080"})
+    })
+
+    t.Run("inherit the shell, stop signal and env", func(t *testing.T) {
+        web := foreman.services["web"]
+        assertString(t, strings.Join(web.shell, " "), "bash -e -c")
+        assertString(t, web.env["LEVEL"]+" "+web.env["NAME"], "info web")
+
+        worker := foreman.services["worker"]
+        assertString(t, strings.Join(worker.shell, " "), "sh -c")
+        assertString(t, worker.stopSignal.String(), "terminated")
+        assertString(t, strings.Join(foreman.command(worker.shell, "true").Args, " "), "sh -c true")
+        assertString(t, strings.Join(foreman.command(nil, "true").Args, " "), "bash -c true")
+    })
+
+    t.Run("merge anchors", func(t *testing.T) {
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        err := os.WriteFile(procfile, []byte("web: &base\n    cmd: ./web\n    stop_signal: QUIT\nadmin:\n    <<: *base\n    cmd: ./admin\n"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        admin := foreman.services["admin"]
+        assertString(t, admin.cmd, "./admin")
+        assertString(t, admin.stopSignal.String(), "quit")
+    })
+
+    t.Run("report invalid shells", func(t *testing.T) {
+        _, err := parseShell("shell", "")
+        assertError(t, err, `shell: expected a shell like "sh -c", got an empty one`)
+        _, err = parseShell("shell", 1)
+        assertError(t, err, `shell: expected a shell like "sh -c" or a list of strings, got 1`)
+    })
+
+    if _, ok := foreman.services[defaultsKey]; ok {
+        t.Error("defaults must not be parsed as a service")
+    }
+}
+
+func TestPortConflicts(t *testing.T) {
+    _, err := New(testPortConflictProcfile)
+    assertError(t, err, "tcp port 8080 is declared by both api and web")
+}
+
 func TestBuildDependencyGraph(t *testing.T) {
     foreman, _ := New("Procfile")
 
@@ -94,96 +392,3209 @@ func TestTopSort(t *testing.T) {
     assertTopSortResult(t, foreman, got)
 }
 
-func assertForeman(t *testing.T, got, want *Foreman) {
-    t.Helper()
+func TestOnStateChange(t *testing.T) {
+    foreman, _ := New(testProcfile)
+    transitions := make(chan string, 4)
+    foreman.OnStateChange(func(name string, old, new State) {
+        transitions <- fmt.Sprintf("%s: %s -> %s", name, old, new)
+    })
 
-    for serviceName, service := range got.services {
-        assertService(t, service, want.services[serviceName])
+    foreman.setState("hello", StateStarting)
+    foreman.setState("hello", StateHealthy)
+    foreman.setState("hello", StateHealthy)
+    foreman.setState("hello", StateStopped)
+
+    want := []string{
+        "hello: pending -> starting",
+        "hello: starting -> healthy",
+        "hello: healthy -> stopped",
+    }
+    for _, transition := range want {
+        select {
+        case got := <-transitions:
+            if got != transition {
+                t.Errorf("got:\n%q\nwant:\n%q", got, transition)
+            }
+        case <-time.After(time.Second):
+            t.Fatalf("timed out waiting for %q", transition)
+        }
+    }
+
+    // Once closed, as by Stop, the notifier drops the transitions.
+    foreman.notifier.close()
+    foreman.notifier.close()
+    foreman.setState("hello", StateStarting)
+    select {
+    case got := <-transitions:
+        t.Errorf("unexpected transition after close: %q", got)
+    case <-time.After(50 * time.Millisecond):
     }
 }
 
-func assertService(t *testing.T, got, want Service) {
-    t.Helper()
+func TestOnStateChangeOrder(t *testing.T) {
+    foreman, _ := New(testProcfile)
+    const transitions = 200
+    delivered := make(chan [2]State, transitions)
+    foreman.OnStateChange(func(name string, old, new State) {
+        delivered <- [2]State{old, new}
+    })
 
-    if got.serviceName != want.serviceName {
-        t.Errorf("got:\n%q\nwant:\n%q", got.serviceName, want.serviceName)
+    // Every transition starts from the state the previous one ended in, even
+    // when they race.
+    states := []State{StateStarting, StateHealthy, StateFailed, StateRestarting}
+    var wg sync.WaitGroup
+    for i := 0; i < transitions; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            foreman.setState("hello", states[i%len(states)])
+        }(i)
     }
+    wg.Wait()
 
-    if got.process != want.process {
-        t.Errorf("got:\n%v\nwant:\n%v", got.process, want.process)
+    current := StatePending
+    for {
+        select {
+        case transition := <-delivered:
+            if transition[0] != current {
+                t.Fatalf("got a transition from %s delivered after one to %s", transition[0], current)
+            }
+            current = transition[1]
+        case <-time.After(100 * time.Millisecond):
+            assertString(t, current.String(), foreman.snapshot()["hello"].state.String())
+            return
+        }
     }
+}
 
-    if got.cmd != want.cmd {
-        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+func TestStopAll(t *testing.T) {
+    foreman, _ := New(testChainProcfile)
+    stopped := make(chan string, 3)
+    foreman.OnStateChange(func(name string, old, new State) {
+        if new == StateStopped {
+            stopped <- name
+        }
+    })
+
+    for _, serviceName := range foreman.buildDependencyGraph().topSort() {
+        err := foreman.startService(serviceName)
+        if err != nil {
+            t.Fatal(err)
+        }
     }
 
-    if got.cmd != want.cmd {
-        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+    foreman.stopAll()
+
+    for _, want := range []string{"frontend", "backend", "database"} {
+        select {
+        case got := <-stopped:
+            assertString(t, got, want)
+        case <-time.After(time.Second):
+            t.Fatalf("timed out waiting for %q to stop", want)
+        }
     }
+}
+
+func TestShutdownDelay(t *testing.T) {
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()), WithClock(clock), WithShutdownDelay(2*time.Second))
+    stopped := make(chan string, 3)
+    foreman.OnStateChange(func(name string, old, new State) {
+        if new == StateStopped {
+            stopped <- name
+        }
+    })
 
-    if got.runOnce != want.runOnce {
-        t.Errorf("got:\n%t\nwant:\n%t", got.runOnce, want.runOnce)
+    for _, serviceName := range foreman.buildDependencyGraph().topSort() {
+        foreman.startService(serviceName)
     }
+    go foreman.stopAll()
 
-    assertList(t, got.deps, want.deps)
+    assertString(t, <-stopped, "frontend")
+    for _, want := range []string{"backend", "database"} {
+        clock.waitForAfter(t, 2*time.Second)
+        select {
+        case got := <-stopped:
+            t.Fatalf("%s stopped before the shutdown delay", got)
+        case <-time.After(50 * time.Millisecond):
+        }
+
+        clock.advance(2 * time.Second)
+        select {
+        case got := <-stopped:
+            assertString(t, got, want)
+        case <-time.After(time.Second):
+            t.Fatalf("timed out waiting for %q to stop", want)
+        }
+    }
 }
 
-func assertChecks(t *testing.T, got, want *Checks) {
-    t.Helper()
+func TestOutputRouting(t *testing.T) {
+    foreman, _ := New(testOutputProcfile)
+    output := &bytes.Buffer{}
+    foreman.output = output
 
-    if got.cmd != want.cmd {
-        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+    errorsLog := filepath.Join(t.TempDir(), "errors.log")
+    service := foreman.services["noisy"]
+    service.stderr = errorsLog
+    foreman.services["noisy"] = service
+
+    err := foreman.startService("noisy")
+    if err != nil {
+        t.Fatal(err)
     }
+    waitForExit(t, foreman, "noisy")
+    foreman.outputWG.Wait()
 
-    assertList(t, got.tcpPorts, want.tcpPorts)
-    assertList(t, got.udpPorts, want.udpPorts)
+    assertString(t, output.String(), "noisy | out\n")
+
+    stderr, _ := os.ReadFile(errorsLog)
+    assertString(t, string(stderr), "err\n")
 }
 
-func assertList(t *testing.T, got, want []string) {
-    t.Helper()
+func TestOptions(t *testing.T) {
+    t.Run("resolve relative cwd against the working directory", func(t *testing.T) {
+        dir := t.TempDir()
+        foreman, err := New(testCwdProcfile, WithWorkingDir(dir))
+        if err != nil {
+            t.Fatal(err)
+        }
 
-    if len(want) != len(got) {
-        t.Errorf("got:\n%v\nwant:\n%v", got, want)
-    }
+        assertString(t, foreman.services["relative"].cwd, filepath.Join(dir, "scripts"))
+        assertString(t, foreman.services["absolute"].cwd, "/tmp")
+    })
 
-    n := len(want)
-    for i := 0; i < n; i++ {
-        if got[i] != want[i] {
-            t.Errorf("got:\n%v\nwant:\n%v", got, want)
+    t.Run("run commands through the configured shell and environment", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        logs := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile,
+            WithOutput(output),
+            WithShell("sh", "-c"),
+            WithEnv("GREETING=hello"),
+            WithLogger(log.New(logs, "", 0)),
+            WithCheckInterval(time.Second),
+        )
+        assertString(t, strings.Join(foreman.shell, " "), "sh -c")
+        assertString(t, foreman.checkInterval.String(), "1s")
+
+        service := foreman.services["noisy"]
+        service.cmd = "echo $GREETING $0"
+        service.stderr = outputDiscard
+        foreman.services["noisy"] = service
+
+        err := foreman.startService("noisy")
+        if err != nil {
+            t.Fatal(err)
         }
-    }
+        waitForExit(t, foreman, "noisy")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "noisy | hello sh\n")
+        if !strings.Contains(logs.String(), "noisy: process started") {
+            t.Errorf("expected the logger to receive foreman messages, got:\n%q", logs.String())
+        }
+    })
+
+    t.Run("run commands without a shell and with their umask", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile, WithOutput(output), WithLogger(log.New(io.Discard, "", 0)))
+
+        service := foreman.services["noisy"]
+        service.cmd = "sh -c umask"
+        service.shell = []string{shellNone}
+        service.umask = "027"
+        service.stderr = outputDiscard
+        foreman.services["noisy"] = service
+
+        err := foreman.startService("noisy")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "noisy")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "noisy | 0027\n")
+
+        // The umask is set by a shell executing the command, its arguments kept.
+        output.Reset()
+        service.argv = []string{"sh", "-c", `umask; echo "$0"`, "a  b"}
+        foreman.services["noisy"] = service
+        err = foreman.startService("noisy")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "noisy")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "noisy | 0027\nnoisy | a  b\n")
+    })
+
+    t.Run("run argument lists directly", func(t *testing.T) {
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        err := os.WriteFile(procfile, []byte("echo:\n    cmd: [echo, \"a  b\", \"${GREETING}\", \"$HOME\"]\n    env:\n        GREETING: hi\n    run_once: true\n"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        output := &bytes.Buffer{}
+        foreman, err := New(procfile, WithOutput(output), WithLogger(log.New(io.Discard, "", 0)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["echo"].cmd, `echo 'a  b' hi '$HOME'`)
+
+        err = foreman.startService("echo")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "echo")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "echo | a  b hi $HOME\n")
+
+        _, _, err = parseCmd("cmd", []any{})
+        assertError(t, err, "cmd: expected a command line or a list of arguments, got an empty list")
+    })
+
+    t.Run("parse umasks", func(t *testing.T) {
+        for _, value := range []any{"022", "0o22", 022} {
+            umask, err := parseUmask("umask", value)
+            if err != nil {
+                t.Fatal(err)
+            }
+            assertString(t, umask, "022")
+        }
+        _, err := parseUmask("umask", "999")
+        assertError(t, err, `umask: expected an octal umask like "022", got 999`)
+        _, err = parseShell("shell", "none -c")
+        assertError(t, err, "shell: none takes no flags, got none -c")
+    })
+
+    t.Run("run commands as another user without new privileges", func(t *testing.T) {
+        if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+            t.Skip("switching users takes root on Linux")
+        }
+        output := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile, WithOutput(output), WithLogger(log.New(io.Discard, "", 0)))
+
+        service := foreman.services["noisy"]
+        service.cmd = "echo $(id -u) $(id -G); grep NoNewPrivs /proc/self/status"
+        service.cwd = "/"
+        service.user = "65534"
+        service.group = "65534"
+        service.supplementaryGroups = []string{"65533"}
+        service.noNewPrivs = true
+        service.stderr = outputDiscard
+        foreman.services["noisy"] = service
+
+        err := foreman.startService("noisy")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "noisy")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "noisy | 65534 65534 65533\nnoisy | NoNewPrivs:\t1\n")
+    })
+
+    t.Run("parse users and groups", func(t *testing.T) {
+        for _, value := range []any{33, "33"} {
+            account, err := parseAccount("user", value)
+            if err != nil {
+                t.Fatal(err)
+            }
+            assertString(t, account, "33")
+        }
+        groups, err := parseAccountList("supplementary_groups", []any{"www-data", 4})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, groups, []string{"www-data", "4"})
+
+        _, err = parseAccount("user", -1)
+        assertError(t, err, "user: expected a name or a numeric id, got -1")
+        _, err = parseAccountList("supplementary_groups", "adm")
+        assertError(t, err, "supplementary_groups: expected a list of groups, got adm")
+
+        if runtime.GOOS == "windows" {
+            return
+        }
+        cmd := exec.Command("true")
+        cmd.SysProcAttr = processGroupAttr()
+        err = setCredential(cmd, Service{user: "no-such-user"})
+        assertError(t, err, "unknown user no-such-user")
+        err = setCredential(cmd, Service{user: "4000000000"})
+        assertError(t, err, "user 4000000000 has no account to take the primary group from, set the group")
+    })
 }
 
-func assertError(t *testing.T, err error, want string) {
-    t.Helper()
+func TestLogger(t *testing.T) {
+    t.Run("structured records", func(t *testing.T) {
+        records := make(chan LogRecord, 16)
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithStructuredLogger(recordLogger(records)))
 
-    if err == nil {
-        t.Fatal("Expected Error: open uknown_file: no such file or directory")
+        err := foreman.startService("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+        foreman.StopService("database", true)
+
+        var got []string
+        for len(got) < 2 {
+            select {
+            case record := <-records:
+                exitCode := "-"
+                if record.ExitCode != nil {
+                    exitCode = fmt.Sprintf("%d %s", *record.ExitCode, record.Signal)
+                }
+                got = append(got, fmt.Sprintf("%s %s %s %d %s: %s", record.Level, record.Event, record.Service, record.PID, exitCode, record.Message))
+            case <-time.After(5 * time.Second):
+                t.Fatalf("timed out waiting for log records, got %v", got)
+            }
+        }
+        assertList(t, got, []string{
+            "info started database 1 -: 1 database: process started",
+            "info stopped database 1 130 SIGINT: 1 database: process stopped, killed by SIGINT",
+        })
+    })
+
+    t.Run("json lines", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        logger := NewJSONLogger(output)
+
+        logger.Log(LogRecord{
+        	Time:     time.Unix(0, 0).UTC(),
+        	Level:    LevelError,
+        	Event:    "crashed",
+        	Service:  "web",
+        	PID:      42,
+        	ExitCode: exitCodeOf(0),
+        	Message:  "42 web: process stopped",
+        })
+        logger.Log(LogRecord{Time: time.Unix(0, 0).UTC(), Level: LevelInfo, Event: "note", Message: "hi"})
+
+        assertString(t, output.String(),
+            `{"time":"1970-01-01T00:00:00Z","level":"error","event":"crashed","service":"web","pid":42,"exit_code":0,"message":"42 web: process stopped"}`+"\n"+
+            `{"time":"1970-01-01T00:00:00Z","level":"info","event":"note","message":"hi"}`+"\n")
+    })
+}
+
+func TestTree(t *testing.T) {
+    foreman, _ := New(testChainProcfile)
+
+    database := foreman.services["database"]
+    database.active = true
+    database.pid = 42
+    foreman.services["database"] = database
+    foreman.setState("database", StateHealthy)
+    foreman.setState("backend", StateFailed)
+
+    want := "○ frontend (pending)\n" +
+        "  ✖ backend (failed)\n" +
+        "    ● database (healthy, pid 42)\n"
+    assertString(t, foreman.Tree(), want)
+
+    t.Run("services in a cycle", func(t *testing.T) {
+        database := foreman.services["database"]
+        database.deps = []string{"frontend"}
+        foreman.services["database"] = database
+
+        want := "✖ backend (failed) [cycle]\n" +
+            "  ● database (healthy, pid 42)\n" +
+            "    ○ frontend (pending)\n" +
+            "      ✖ backend (failed)\n"
+        assertString(t, foreman.Tree(), want)
+    })
+}
+
+func TestGraph(t *testing.T) {
+    foreman, _ := New(testDepConditionsProcfile)
+    status := map[string]ServiceStatus{
+        "db":      {State: StateHealthy},
+        "migrate": {State: StateStopped},
+        "web":     {State: StateStarting},
     }
 
-    if err.Error() != want {
-        t.Errorf("got:\n%q\nwant:\n%q", err.Error(), want)
+    t.Run("dot", func(t *testing.T) {
+        want := `digraph foreman {
+    node [shape=box, style="rounded,filled", fillcolor=white];
+    "db" [label="db\nhealthy", fillcolor=palegreen];
+    "migrate" [label="migrate\nstopped", fillcolor=lightgrey];
+    "web" [label="web\nstarting", fillcolor=gold];
+    "web" -> "db" [label="service_healthy"];
+    "web" -> "migrate" [label="service_completed_successfully"];
+}
+`
+        assertString(t, foreman.DOT(status), want)
+    })
+
+    t.Run("mermaid without status", func(t *testing.T) {
+        want := `flowchart TD
+    s0["db"]
+    s1["migrate"]
+    s2["web"]
+    s2 -->|service_healthy| s0
+    s2 -->|service_completed_successfully| s1
+`
+        assertString(t, foreman.Mermaid(nil), want)
+    })
+}
+
+func TestForwardSignal(t *testing.T) {
+    foreman, err := New(testSignalProcfile)
+    if err != nil {
+        t.Fatal(err)
     }
+    output := &bytes.Buffer{}
+    foreman.output = output
+
+    foreman.startService("reloader")
+    foreman.startService("bystander")
+    time.Sleep(200 * time.Millisecond)
+
+    foreman.forwardSignal(sigUSR1)
+    waitForExit(t, foreman, "reloader")
+    signalProcess(foreman.snapshot()["bystander"].pid, syscall.SIGKILL)
+    waitForExit(t, foreman, "bystander")
+    foreman.outputWG.Wait()
+
+    assertString(t, output.String(), "reloader | reloaded\n")
 }
 
-func assertGraph(t *testing.T, got, want map[string][]string) {
-    t.Helper()
+func TestStopSignals(t *testing.T) {
+    for _, sig := range []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT} {
+        t.Run(signalName(sig), func(t *testing.T) {
+            runner := newFakeRunner()
+            foreman, _ := New(testChainProcfile, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+            result := make(chan error)
+            go func() {
+                result <- foreman.Start(context.Background())
+            }()
+            for len(runner.startedPids()) != 3 {
+                time.Sleep(5 * time.Millisecond)
+            }
 
-    for key, value := range got {
-        assertList(t, value, want[key])
+            signalProcess(os.Getpid(), sig)
+            select {
+            case err := <-result:
+                if err != nil {
+                    t.Fatal(err)
+                }
+            case <-time.After(2 * time.Second):
+                t.Fatalf("Start didn't return on %s", signalName(sig))
+            }
+            for serviceName, status := range foreman.Status() {
+                assertString(t, serviceName+" "+status.State.String(), serviceName+" stopped")
+            }
+        })
     }
+
+    t.Run("reject forwarding them", func(t *testing.T) {
+        _, err := parseForwardSignals("forward_signals", []any{"HUP", "TERM"})
+        assertError(t, err, "forward_signals: TERM can't be forwarded")
+    })
 }
 
-func assertTopSortResult(t *testing.T, foreman *Foreman, got []string) {
-	t.Helper()
+func TestServiceAccessors(t *testing.T) {
+    runner := newFakeRunner()
+    foreman, _ := New(testChainProcfile, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
 
-	nodesSet := make(map[string]any)
-	for _, dep := range got {
-		for _, depDep := range foreman.services[dep].deps {
-			if _, ok := nodesSet[depDep]; !ok {
-				t.Fatalf("not expected to run %v before %v", dep, depDep)
-			}
-		}
-		nodesSet[dep] = 1
-	}
+    t.Run("report the status of a single service", func(t *testing.T) {
+        status, err := foreman.StatusOf("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprintf("%+v", status), fmt.Sprintf("%+v", foreman.Status()["database"]))
+
+        _, err = foreman.StatusOf("cache")
+        assertError(t, err, `unknown service "cache"`)
+    })
+
+    t.Run("change the services concurrently", func(t *testing.T) {
+        var wg sync.WaitGroup
+        for i := 0; i < 4; i++ {
+            wg.Add(3)
+            go func() {
+                defer wg.Done()
+                foreman.startService("database")
+                foreman.stopService("database")
+            }()
+            go func() {
+                defer wg.Done()
+                foreman.recordCheckResult("database", CheckResult{Passed: true})
+                foreman.AddCheck("backend", failingCheck{})
+            }()
+            go func() {
+                defer wg.Done()
+                foreman.Status()
+                foreman.StatusOf("database")
+                foreman.snapshot()
+            }()
+        }
+        wg.Wait()
+
+        service, ok := foreman.service("backend")
+        if !ok {
+            t.Fatal("backend is missing")
+        }
+        assertString(t, fmt.Sprint(len(service.customChecks)), "4")
+    })
+}
+
+func TestInitMode(t *testing.T) {
+    t.Run("stop with the exit code of the main service", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithMainService("backend"), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        result := make(chan error)
+        go func() {
+            result <- foreman.Start(context.Background())
+        }()
+        for len(runner.startedPids()) != 3 {
+            time.Sleep(5 * time.Millisecond)
+        }
+
+        runner.exit(2, 3)
+        select {
+        case err := <-result:
+            var mainErr *MainExitError
+            if !errors.As(err, &mainErr) {
+                t.Fatalf("expected a MainExitError, got %v", err)
+            }
+            assertString(t, err.Error(), "main service backend exited with code 3")
+        case <-time.After(time.Second):
+            t.Fatal("Start didn't return after the main service exited")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2 3]")
+        assertString(t, foreman.Status()["database"].State.String(), "stopped")
+    })
+
+    t.Run("reject an unknown main service", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()), WithMainService("cache"))
+        err := foreman.Start(context.Background())
+        assertError(t, err, `unknown service "cache"`)
+    })
+
+    t.Run("forward the signals to every service", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithInit(), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        for _, serviceName := range []string{"database", "backend"} {
+            service := foreman.services[serviceName]
+            service.restart = RestartNo
+            foreman.services[serviceName] = service
+            foreman.startService(serviceName)
+        }
+
+        foreman.forwardSignal(sigUSR1)
+        waitForExit(t, foreman, "database")
+        waitForExit(t, foreman, "backend")
+        status := foreman.Status()
+        assertString(t, fmt.Sprint(status["database"].ExitCode, status["backend"].ExitCode), "138 138")
+    })
+}
+
+func TestStopService(t *testing.T) {
+    runner := newFakeRunner()
+    foreman, _ := New(testChainProcfile, WithRunner(runner))
+    stopped := make(chan string, 3)
+    foreman.OnStateChange(func(name string, old, new State) {
+        if new == StateStopped {
+            stopped <- name
+        }
+    })
+
+    for _, serviceName := range foreman.buildDependencyGraph().topSort() {
+        foreman.startService(serviceName)
+    }
+
+    t.Run("refuse to stop a service with active dependents", func(t *testing.T) {
+        err := foreman.StopService("backend", false)
+        assertError(t, err, "backend has active dependent frontend")
+    })
+
+    t.Run("cascade to dependents first", func(t *testing.T) {
+        err := foreman.StopService("backend", true)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        for _, want := range []string{"frontend", "backend"} {
+            select {
+            case got := <-stopped:
+                assertString(t, got, want)
+            case <-time.After(time.Second):
+                t.Fatalf("timed out waiting for %q to stop", want)
+            }
+        }
+
+        status := foreman.Status()
+        assertString(t, status["database"].State.String(), "starting")
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2 3]")
+    })
+
+    foreman.stopAll()
+}
+
+func TestAddService(t *testing.T) {
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()))
+
+    t.Run("register a service defined in code", func(t *testing.T) {
+        err := foreman.AddService(NewService("worker", "sleep 10", "backend"))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        assertList(t, foreman.DependencyGraph()["worker"], []string{"backend"})
+        assertString(t, foreman.Status()["worker"].State.String(), "pending")
+    })
+
+    t.Run("reject a duplicate name", func(t *testing.T) {
+        err := foreman.AddService(NewService("database", "sleep 10"))
+        assertError(t, err, `service "database" already exists`)
+    })
+
+    t.Run("reject a service closing a cycle", func(t *testing.T) {
+        service := NewService("cache", "sleep 10", "cache")
+        err := foreman.AddService(service)
+
+        var cyclic *CyclicDependencyError
+        if !errors.As(err, &cyclic) {
+            t.Fatalf("expected a CyclicDependencyError, got %v", err)
+        }
+        if _, ok := foreman.Status()["cache"]; ok {
+            t.Error("expected the rejected service not to be registered")
+        }
+    })
+
+    t.Run("reject an unknown dependency", func(t *testing.T) {
+        err := foreman.AddService(NewService("cache", "sleep 10", "queue"))
+        assertError(t, err, "cache depends on unknown service queue")
+        if _, ok := foreman.Status()["cache"]; ok {
+            t.Error("expected the rejected service not to be registered")
+        }
+    })
+}
+
+func TestSpecs(t *testing.T) {
+    t.Run("inspect and modify specs before start", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile)
+
+        spec, err := foreman.Spec("backend")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, spec.Cmd, "sleep 10")
+        assertList(t, spec.Deps, []string{"database"})
+
+        spec.Cmd = "sleep 20"
+        err = foreman.SetSpec("backend", spec)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.Specs()["backend"].Cmd, "sleep 20")
+
+        spec.Deps = []string{"frontend"}
+        err = foreman.SetSpec("backend", spec)
+        var cyclic *CyclicDependencyError
+        if !errors.As(err, &cyclic) {
+            t.Fatalf("expected a CyclicDependencyError, got %v", err)
+        }
+        assertList(t, foreman.Specs()["backend"].Deps, []string{"database"})
+    })
+
+    t.Run("pass the spec environment to the service", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile, WithOutput(output))
+
+        spec, _ := foreman.Spec("noisy")
+        spec.Cmd = "echo $GREETING"
+        spec.Stderr = outputDiscard
+        spec.Env = map[string]string{"GREETING": "hello"}
+        foreman.SetSpec("noisy", spec)
+
+        err := foreman.startService("noisy")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "noisy")
+        foreman.outputWG.Wait()
+
+        assertString(t, output.String(), "noisy | hello\n")
+    })
+}
+
+func TestRemoveService(t *testing.T) {
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()))
+
+    t.Run("refuse to remove a dependency", func(t *testing.T) {
+        err := foreman.RemoveService("backend")
+        assertError(t, err, "backend is a dependency of frontend")
+    })
+
+    t.Run("stop and remove a running service", func(t *testing.T) {
+        for _, serviceName := range foreman.buildDependencyGraph().topSort() {
+            foreman.startService(serviceName)
+        }
+
+        err := foreman.RemoveService("frontend")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, ok := foreman.Status()["frontend"]; ok {
+            t.Error("expected frontend to be removed")
+        }
+        if !foreman.snapshot()["backend"].active {
+            t.Error("expected backend to keep running")
+        }
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        err := foreman.RemoveService("frontend")
+        assertError(t, err, `unknown service "frontend"`)
+    })
+}
+
+func TestDepConditions(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, _ := New(testDepConditionsProcfile, WithRunner(runner), WithClock(clock))
+    defer foreman.stopAll()
+
+    spec, _ := foreman.Spec("web")
+    assertList(t, spec.Deps, []string{"db", "migrate"})
+    assertString(t, string(spec.DepConditions["migrate"]), "service_completed_successfully")
+
+    foreman.startService("db")
+    foreman.startService("migrate")
+    started := make(chan error)
+    go func() {
+        started <- foreman.startService("web")
+    }()
+
+    assertNotStarted := func() {
+        t.Helper()
+        select {
+        case err := <-started:
+            t.Fatalf("web started before its dependency conditions were met: %v", err)
+        case <-time.After(50 * time.Millisecond):
+        }
+    }
+
+    assertNotStarted()
+    clock.waitForAfter(t, time.Second)
+    clock.advance(time.Second)
+    assertNotStarted()
+
+    runner.exit(2, 0)
+    select {
+    case err := <-started:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("timed out waiting for web to start")
+    }
+    assertString(t, fmt.Sprint(runner.startedPids()), "[1 2 3]")
+}
+
+func TestInitTasks(t *testing.T) {
+    procfilePath := filepath.Join(t.TempDir(), "Procfile")
+    writeProcfile := func(procfile string) {
+        err := os.WriteFile(procfilePath, []byte(procfile), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    writeProcfile(`
+migrate:
+  cmd: ./migrate
+  before: [api]
+api:
+  cmd: ./api
+worker:
+  cmd: ./worker
+  deps: [migrate]
+`)
+
+    t.Run("run before the services they list", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, err := New(procfilePath, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer foreman.stopAll()
+
+        specs := foreman.Specs()
+        assertString(t, string(specs["migrate"].Restart), "no")
+        assertList(t, specs["api"].Deps, []string{"migrate"})
+        assertString(t, string(specs["api"].DepConditions["migrate"]), "service_completed_successfully")
+        assertString(t, string(specs["worker"].DepConditions["migrate"]), "service_completed_successfully")
+
+        started := make(chan error)
+        go func() {
+            started <- foreman.startGroup([]string{"migrate", "api", "worker"})
+        }()
+        time.Sleep(50 * time.Millisecond)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+
+        runner.exit(1, 0)
+        select {
+        case err := <-started:
+            if err != nil {
+                t.Fatal(err)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for the services to start")
+        }
+        assertString(t, fmt.Sprint(len(runner.startedPids())), "3")
+    })
+
+    t.Run("a failing task keeps the services from starting", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(procfilePath, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        defer foreman.stopAll()
+
+        started := make(chan error)
+        go func() {
+            started <- foreman.startGroup([]string{"migrate", "api"})
+        }()
+        time.Sleep(50 * time.Millisecond)
+        runner.exit(1, 1)
+        select {
+        case err := <-started:
+            assertError(t, err, "api: dependencies not ready: [migrate]")
+        case <-time.After(2 * time.Second):
+            t.Fatal("timed out waiting for the start to fail")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        writeProcfile("migrate:\n  cmd: ./migrate\n  before: [api]\n")
+        _, err := New(procfilePath)
+        assertError(t, err, procfilePath+":3:3: migrate: before: unknown service api")
+        diagnostics := Validate(procfilePath)
+        if len(diagnostics) != 1 {
+            t.Fatalf("expected a single diagnostic, got %v", diagnostics)
+        }
+        assertString(t, diagnostics[0].String(), procfilePath+":3:3: migrate: before: unknown service api")
+    })
+}
+
+func TestHealthyDependencies(t *testing.T) {
+    t.Run("wait for dependencies to be healthy", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testDepTimeoutProcfile, WithRunner(runner), WithClock(clock), WithHealthyDependencies())
+        defer foreman.stopAll()
+
+        foreman.startService("db")
+        started := make(chan error)
+        go func() {
+            started <- foreman.startService("web")
+        }()
+
+        clock.waitForAfter(t, 3*time.Second)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+
+        select {
+        case err := <-started:
+            if err != nil {
+                t.Fatal(err)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for web to start")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+    })
+
+    t.Run("give up after the dependency timeout", func(t *testing.T) {
+        clock := newFakeClock()
+        foreman, _ := New(testDepTimeoutProcfile, WithRunner(newFakeRunner()), WithClock(clock), WithHealthyDependencies())
+        defer foreman.stopAll()
+
+        foreman.UpdateChecks("db", CheckSpec{Interval: time.Hour})
+        foreman.startService("db")
+        started := make(chan error)
+        go func() {
+            started <- foreman.startService("web")
+        }()
+
+        clock.waitForAfter(t, 3*time.Second)
+        clock.advance(3 * time.Second)
+
+        select {
+        case err := <-started:
+            assertError(t, err, "web: dependencies not ready: [db]")
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for web to give up")
+        }
+    })
+
+    t.Run("give up when the dependency exits before being healthy", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testDepTimeoutProcfile, WithRunner(runner), WithClock(clock), WithHealthyDependencies())
+        defer foreman.stopAll()
+
+        service := foreman.services["db"]
+        service.restart = RestartNo
+        foreman.services["db"] = service
+        foreman.UpdateChecks("db", CheckSpec{Interval: time.Hour})
+        foreman.startService("db")
+        started := make(chan error)
+        go func() {
+            started <- foreman.startService("web")
+        }()
+
+        // The dependency timeout is never reached: the exit alone ends the wait.
+        clock.waitForAfter(t, 3*time.Second)
+        runner.exit(1, 1)
+
+        select {
+        case err := <-started:
+            assertError(t, err, "web: dependencies not ready: [db]")
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for web to give up")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+    })
+}
+
+func TestStartService(t *testing.T) {
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()))
+
+    err := foreman.StartService("database")
+    if err != nil {
+        t.Fatal(err)
+    }
+    assertError(t, foreman.StartService("database"), "database is already running")
+    assertError(t, foreman.StartService("cache"), `unknown service "cache"`)
+
+    foreman.stopAll()
+}
+
+func TestRestartService(t *testing.T) {
+    t.Run("restart dependents too", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner))
+        for _, serviceName := range []string{"database", "backend", "frontend"} {
+            foreman.startService(serviceName)
+        }
+
+        err := foreman.RestartService("backend")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        services := foreman.snapshot()
+        assertString(t, fmt.Sprint(services["database"].pid), "1")
+        assertString(t, fmt.Sprint(services["backend"].pid), "4")
+        assertString(t, fmt.Sprint(services["frontend"].pid), "5")
+        foreman.stopAll()
+    })
+
+    t.Run("restart only the service", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithRestartDependents(false))
+        for _, serviceName := range []string{"database", "backend", "frontend"} {
+            foreman.startService(serviceName)
+        }
+
+        err := foreman.RestartService("backend")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        services := foreman.snapshot()
+        assertString(t, fmt.Sprint(services["backend"].pid), "4")
+        assertString(t, fmt.Sprint(services["frontend"].pid), "3")
+        foreman.stopAll()
+    })
+}
+
+func TestStartOnce(t *testing.T) {
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()))
+
+    results := make(chan error, 2)
+    for i := 0; i < 2; i++ {
+        go func() {
+            results <- foreman.Start(context.Background())
+        }()
+    }
+
+    select {
+    case err := <-results:
+        assertError(t, err, "foreman already started")
+    case <-time.After(time.Second):
+        t.Fatal("expected the second Start to fail")
+    }
+
+    err := foreman.Stop(time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err := <-results:
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("expected Start to return after Stop")
+    }
+
+    assertError(t, foreman.Stop(time.Second), "foreman already stopped")
+    assertError(t, foreman.Start(context.Background()), "foreman already stopped")
+    foreman.notifier.mu.Lock()
+    defer foreman.notifier.mu.Unlock()
+    if !foreman.notifier.closed {
+        t.Error("expected Stop to close the state notifier")
+    }
+}
+
+func TestStartupParallelism(t *testing.T) {
+    waitForPids := func(t *testing.T, runner *fakeRunner, want string) {
+        t.Helper()
+        deadline := time.Now().Add(time.Second)
+        for fmt.Sprint(runner.startedPids()) != want && time.Now().Before(deadline) {
+            time.Sleep(5 * time.Millisecond)
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), want)
+    }
+
+    t.Run("start independent services concurrently", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testWideProcfile, WithRunner(runner), WithClock(clock))
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go foreman.Start(ctx)
+
+        waitForPids(t, runner, "[1 2 3]")
+        for i := 0; i < 3 && len(runner.startedPids()) < 4; i++ {
+            clock.waitForAfter(t, time.Second)
+            time.Sleep(20 * time.Millisecond)
+            clock.advance(time.Second)
+            time.Sleep(20 * time.Millisecond)
+        }
+        waitForPids(t, runner, "[1 2 3 4]")
+    })
+
+    t.Run("limit the number of services starting at once", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testWideProcfile, WithRunner(runner), WithClock(newFakeClock()), WithStartupParallelism(1))
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go foreman.Start(ctx)
+
+        waitForPids(t, runner, "[1]")
+        time.Sleep(50 * time.Millisecond)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+    })
+}
+
+func TestStartContext(t *testing.T) {
+    runner := newFakeRunner()
+    foreman, _ := New(testChainProcfile, WithRunner(runner))
+    stopped := make(chan string, 3)
+    foreman.OnStateChange(func(name string, old, new State) {
+        if new == StateStopped {
+            stopped <- name
+        }
+    })
+
+    ctx, cancel := context.WithCancel(context.Background())
+    result := make(chan error)
+    go func() {
+        result <- foreman.Start(ctx)
+    }()
+
+    for len(runner.startedPids()) != 3 {
+        time.Sleep(5 * time.Millisecond)
+    }
+    cancel()
+
+    select {
+    case err := <-result:
+        if !errors.Is(err, context.Canceled) {
+            t.Errorf("got:\n%v\nwant:\n%v", err, context.Canceled)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("expected Start to return after the context is cancelled")
+    }
+
+    for _, want := range []string{"frontend", "backend", "database"} {
+        assertString(t, <-stopped, want)
+    }
+}
+
+func TestReload(t *testing.T) {
+    procfilePath := filepath.Join(t.TempDir(), "Procfile")
+    writeProcfile := func(procfile string) {
+        err := os.WriteFile(procfilePath, []byte(procfile), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    writeProcfile(`
+web:
+  cmd: ./web
+  deps: [api]
+api:
+  cmd: ./api
+worker:
+  cmd: ./worker
+`)
+
+    runner := newFakeRunner()
+    foreman, err := New(procfilePath, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go foreman.Start(ctx)
+    for len(runner.startedPids()) != 3 {
+        time.Sleep(5 * time.Millisecond)
+    }
+    before := foreman.Status()
+
+    t.Run("apply the changes of the Procfile", func(t *testing.T) {
+        writeProcfile(`
+web:
+  cmd: ./web
+  deps: [api]
+api:
+  cmd: ./api --verbose
+cron:
+  cmd: ./cron
+`)
+        err := foreman.Reload()
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        status := foreman.Status()
+        if _, ok := status["worker"]; ok {
+            t.Error("expected the dropped worker to be removed")
+        }
+        for _, serviceName := range []string{"web", "api", "cron"} {
+            if status[serviceName].PID == 0 {
+                t.Errorf("expected %s to run", serviceName)
+            }
+        }
+        // api changed, web is restarted as its dependent.
+        for _, serviceName := range []string{"web", "api"} {
+            if status[serviceName].PID == before[serviceName].PID {
+                t.Errorf("expected %s to be restarted", serviceName)
+            }
+        }
+        assertString(t, foreman.snapshot()["api"].cmd, "./api --verbose")
+    })
+
+    t.Run("leave unchanged services running", func(t *testing.T) {
+        before := foreman.Status()
+        err := foreman.Reload()
+        if err != nil {
+            t.Fatal(err)
+        }
+        for serviceName, status := range foreman.Status() {
+            if status.PID != before[serviceName].PID {
+                t.Errorf("expected %s to keep running", serviceName)
+            }
+        }
+    })
+
+    t.Run("keep the services when the Procfile is invalid", func(t *testing.T) {
+        writeProcfile(`
+web:
+  cmd: ./web
+  colour: red
+`)
+        err := foreman.Reload()
+        if err == nil {
+            t.Fatal("expected the invalid Procfile to be rejected")
+        }
+        if len(foreman.Status()) != 3 {
+            t.Errorf("expected the services to be kept, got %v", foreman.Status())
+        }
+    })
+}
+
+func TestWatch(t *testing.T) {
+    t.Run("parse watch", func(t *testing.T) {
+        watch, err := parseWatch("watch", map[string]any{"paths": []any{"src", "go.mod"}, "ignore": []any{"*.log"}, "debounce": "1s"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, watch.Paths, []string{"src", "go.mod"})
+        assertList(t, watch.Ignore, []string{"*.log"})
+        assertString(t, watch.Debounce.String(), "1s")
+
+        watch, err = parseWatch("watch", "src")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, watch.Paths, []string{"src"})
+
+        _, err = parseWatch("watch", map[string]any{"paths": "src", "ignore": []any{"["}})
+        assertError(t, err, `watch.ignore: invalid pattern "["`)
+    })
+
+    t.Run("match the changed paths", func(t *testing.T) {
+        watch := Watch{Paths: []string{"/app/src", "/app/go.mod"}, Ignore: []string{"*.log", "node_modules"}}
+        for path, want := range map[string]bool{
+            "/app/src/main.go":                 true,
+            "/app/go.mod":                      true,
+            "/app/go.sum":                      false,
+            "/app/src/debug.log":               false,
+            "/app/src/node_modules/x/index.js": false,
+        } {
+            if watch.matches(path) != want {
+                t.Errorf("expected matches(%q) to be %v", path, want)
+            }
+        }
+    })
+
+    t.Run("restart the service when its files change", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        dir := t.TempDir()
+        service := foreman.services["database"]
+        service.noHealthCheck = true
+        service.watch = Watch{Paths: []string{dir}, Ignore: []string{"*.log"}, Debounce: 3 * time.Second}
+        foreman.services["database"] = service
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        // The watcher starts in the background: keep changing the file until it's noticed.
+        done := make(chan struct{})
+        defer close(done)
+        go func() {
+            for {
+                select {
+                case <-done:
+                    return
+                case <-time.After(10 * time.Millisecond):
+                    os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+                }
+            }
+        }()
+        clock.waitForAfter(t, 3*time.Second)
+        clock.advance(3 * time.Second)
+
+        deadline := time.Now().Add(time.Second)
+        for len(runner.startedPids()) != 2 {
+            if time.Now().After(deadline) {
+                t.Fatal("expected the service to be restarted")
+            }
+            time.Sleep(5 * time.Millisecond)
+        }
+    })
+}
+
+func TestTargets(t *testing.T) {
+    t.Run("start the targets with their dependencies", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithTargets("backend"))
+        ctx, cancel := context.WithCancel(context.Background())
+        result := make(chan error)
+        go func() {
+            result <- foreman.Start(ctx)
+        }()
+
+        for len(runner.startedPids()) != 2 {
+            time.Sleep(5 * time.Millisecond)
+        }
+        time.Sleep(50 * time.Millisecond)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+        assertString(t, foreman.Status()["frontend"].State.String(), "pending")
+
+        cancel()
+        select {
+        case <-result:
+        case <-time.After(time.Second):
+            t.Fatal("Start didn't return after cancel")
+        }
+    })
+
+    t.Run("select the services of groups", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile, WithTargets("database"), WithGroups("web"))
+        frontend := foreman.services["frontend"]
+        frontend.groups = []string{"web"}
+        foreman.services["frontend"] = frontend
+        selected, err := foreman.selectServices(foreman.buildDependencyGraph())
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, selected, []string{"backend", "database", "frontend"})
+    })
+
+    t.Run("report unknown targets and empty groups", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile, WithTargets("cache"))
+        _, err := foreman.selectServices(foreman.buildDependencyGraph())
+        assertError(t, err, `unknown service "cache"`)
+
+        foreman, _ = New(testChainProcfile, WithGroups("web"))
+        _, err = foreman.selectServices(foreman.buildDependencyGraph())
+        assertError(t, err, `no enabled service in group "web"`)
+    })
+
+    t.Run("leave the manual services out", func(t *testing.T) {
+        procfilePath := filepath.Join(t.TempDir(), "Procfile")
+        err := os.WriteFile(procfilePath, []byte(`
+web:
+  cmd: ./web
+  deps: [db]
+db:
+  cmd: ./db
+  start: manual
+debug:
+  cmd: ./debug
+  start: manual
+`), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        foreman, _ := New(procfilePath)
+        selected, err := foreman.selectServices(foreman.buildDependencyGraph())
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, selected, []string{"db", "web"})
+
+        foreman, _ = New(procfilePath, WithTargets("debug"))
+        selected, err = foreman.selectServices(foreman.buildDependencyGraph())
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, selected, []string{"debug"})
+
+        _, err = parseStartMode("start", "later")
+        assertError(t, err, "start: expected auto or manual, got later")
+    })
+
+    t.Run("delay the start", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        defer foreman.stopAll()
+        backend := foreman.services["backend"]
+        backend.startDelay = 10 * time.Second
+        foreman.services["backend"] = backend
+
+        started := make(chan error)
+        go func() {
+            started <- foreman.startGroup([]string{"database", "backend", "frontend"})
+        }()
+        clock.waitForAfter(t, 10*time.Second)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+
+        clock.advance(10 * time.Second)
+        select {
+        case err := <-started:
+            if err != nil {
+                t.Fatal(err)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for the services to start")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2 3]")
+    })
+}
+
+func TestRunToCompletion(t *testing.T) {
+    t.Run("run every job and report exit codes", func(t *testing.T) {
+        foreman, _ := New(testJobsProcfile, WithOutput(io.Discard))
+        exitCodes, err := foreman.RunToCompletion(context.Background())
+
+        assertString(t, fmt.Sprint(exitCodes), "map[cleanup:0 migrate:0 seed:3]")
+        var completionErr *CompletionError
+        if !errors.As(err, &completionErr) {
+            t.Fatalf("expected CompletionError, got: %v", err)
+        }
+        assertString(t, err.Error(), "services failed: seed (exit code 3), report (dependency seed failed)")
+        assertString(t, fmt.Sprint(completionErr.ExitCode), "3")
+    })
+
+    t.Run("reject services that aren't run_once", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile)
+        _, err := foreman.RunToCompletion(context.Background())
+        assertError(t, err, "database is restarted on exit, set run_once or restart: no")
+    })
+}
+
+func TestExitStatus(t *testing.T) {
+    t.Run("report the signal killing a service", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        service := foreman.services["database"]
+        service.restart = RestartNo
+        foreman.services["database"] = service
+        foreman.startService("database")
+
+        runner.Signal(1, syscall.SIGKILL)
+        waitForExit(t, foreman, "database")
+        status, _ := foreman.StatusOf("database")
+        assertString(t, fmt.Sprint(status.ExitCode, " ", status.ExitSignal), "137 killed")
+
+        for {
+            select {
+            case event := <-foreman.Events():
+                if event.Type != ServiceCrashed {
+                    continue
+                }
+                assertString(t, fmt.Sprint(event.ExitCode, " ", event.Signal), "137 killed")
+            case <-time.After(time.Second):
+                t.Fatal("no crashed event")
+            }
+            break
+        }
+    })
+
+    t.Run("tell exits from kills with the default runner", func(t *testing.T) {
+        runner := newExecRunner()
+        pid, err := runner.Start(exec.Command("sh", "-c", "exit 3"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        exitCode, sig, err := runner.WaitSignal(pid)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(exitCode, " ", sig), "3 signal 0")
+
+        pid, err = runner.Start(exec.Command("sh", "-c", "kill -TERM $$"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        exitCode, sig, err = runner.WaitSignal(pid)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(exitCode, " ", sig), "143 terminated")
+    })
+}
+
+func TestRun(t *testing.T) {
+    t.Run("return the exit code", func(t *testing.T) {
+        foreman, _ := New(testJobsProcfile, WithOutput(io.Discard))
+        exitCode, err := foreman.Run(context.Background(), "seed", false)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        assertString(t, fmt.Sprint(exitCode), "3")
+        assertString(t, fmt.Sprint(foreman.snapshot()["migrate"].pid), "0")
+
+        // Run shuts down like Stop, ending the goroutines waiting on done.
+        select {
+        case <-foreman.done:
+        default:
+            t.Error("expected Run to close done")
+        }
+        foreman.notifier.mu.Lock()
+        defer foreman.notifier.mu.Unlock()
+        if !foreman.notifier.closed {
+            t.Error("expected Run to close the state notifier")
+        }
+    })
+
+    t.Run("run the dependencies first", func(t *testing.T) {
+        foreman, _ := New(testJobsProcfile, WithOutput(io.Discard))
+        exitCode, err := foreman.Run(context.Background(), "seed", true)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        assertString(t, fmt.Sprint(exitCode), "3")
+        if foreman.snapshot()["migrate"].pid == 0 {
+            t.Error("expected migrate to run")
+        }
+    })
+
+    t.Run("stop the dependencies when cancelled", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner))
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+
+        _, err := foreman.Run(ctx, "backend", true)
+        if !errors.Is(err, context.Canceled) {
+            t.Fatalf("expected context.Canceled, got: %v", err)
+        }
+        for serviceName, service := range foreman.snapshot() {
+            if service.active {
+                t.Errorf("expected %s to be stopped", serviceName)
+            }
+        }
+        assertString(t, fmt.Sprint(foreman.snapshot()["frontend"].pid), "0")
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        foreman, _ := New(testJobsProcfile)
+        _, err := foreman.Run(context.Background(), "unknown", false)
+        assertError(t, err, `unknown service "unknown"`)
+    })
+}
+
+func TestStartTimeout(t *testing.T) {
+    t.Run("fail start when a service exits early", func(t *testing.T) {
+        foreman, _ := New(testStartTimeoutProcfile)
+        err := foreman.Start(context.Background())
+
+        var launchErr *LaunchError
+        if !errors.As(err, &launchErr) {
+            t.Fatalf("expected LaunchError, got: %v", err)
+        }
+        assertString(t, err.Error(), "failed to launch dies: exited with code 3 before becoming healthy")
+    })
+
+    t.Run("fail start when a service isn't healthy in time", func(t *testing.T) {
+        clock := newFakeClock()
+        foreman, _ := New(testStartTimeoutProcfile, WithRunner(newFakeRunner()), WithClock(clock))
+        for serviceName, enabled := range map[string]bool{"dies": false, "slow": true} {
+            spec, _ := foreman.Spec(serviceName)
+            spec.Enabled = enabled
+            foreman.SetSpec(serviceName, spec)
+        }
+
+        started := make(chan error)
+        go func() {
+            started <- foreman.Start(context.Background())
+        }()
+        clock.waitForAfter(t, 5*time.Second)
+        clock.advance(5 * time.Second)
+
+        select {
+        case err := <-started:
+            assertError(t, err, "failed to launch slow: not healthy after 5s")
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for Start to fail")
+        }
+    })
+}
+
+func TestStopTimeout(t *testing.T) {
+    foreman, _ := New(testStubbornProcfile)
+    go foreman.Start(context.Background())
+    for !foreman.snapshot()["stubborn"].active {
+        time.Sleep(5 * time.Millisecond)
+    }
+    time.Sleep(100 * time.Millisecond)
+
+    err := foreman.Stop(200 * time.Millisecond)
+    assertError(t, err, "timed out after 200ms waiting for services to stop")
+
+    signalProcessGroup(foreman.snapshot()["stubborn"].pid, syscall.SIGKILL)
+    waitForExit(t, foreman, "stubborn")
+}
+
+func TestStopSignal(t *testing.T) {
+    t.Run("stop with the configured signal", func(t *testing.T) {
+        foreman, _ := New(testStopProcfile, WithRunner(newFakeRunner()))
+        foreman.startService("graceful")
+        foreman.stopService("graceful")
+
+        assertString(t, fmt.Sprint(foreman.Status()["graceful"].ExitCode), fmt.Sprint(128+int(syscall.SIGTERM)))
+    })
+
+    t.Run("kill a service ignoring its stop signal after the timeout", func(t *testing.T) {
+        foreman, _ := New(testStopProcfile)
+        err := foreman.startService("stubborn")
+        if err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(100 * time.Millisecond)
+
+        stopped := make(chan struct{})
+        go func() {
+            foreman.stopService("stubborn")
+            close(stopped)
+        }()
+
+        select {
+        case <-stopped:
+        case <-time.After(5 * time.Second):
+            t.Fatal("timed out waiting for stubborn to be killed")
+        }
+        signalProcessGroup(foreman.snapshot()["stubborn"].pid, syscall.SIGKILL)
+    })
+
+    t.Run("reject signals that can't stop a service", func(t *testing.T) {
+        _, err := parseStopSignal("stop_signal", "CHLD")
+        assertError(t, err, "stop_signal: CHLD can't stop a service")
+    })
+}
+
+func TestEvents(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock))
+
+    foreman.startService("database")
+    runner.exit(1, 2)
+
+    want := []Event{
+        {Type: ServiceStarted, Service: "database", PID: 1},
+        {Type: ServiceCrashed, Service: "database", PID: 1, ExitCode: 2},
+        {Type: RestartScheduled, Service: "database", PID: 1},
+        {Type: ServiceStarted, Service: "database", PID: 2},
+    }
+    for _, wantEvent := range want {
+        select {
+        case got := <-foreman.Events():
+            if got.Type != wantEvent.Type || got.Service != wantEvent.Service ||
+                got.PID != wantEvent.PID || got.ExitCode != wantEvent.ExitCode {
+                t.Errorf("got:\n%+v\nwant:\n%+v", got, wantEvent)
+            }
+            if !got.Time.Equal(clock.Now()) {
+                t.Errorf("expected the event to be timestamped with the clock, got %v", got.Time)
+            }
+        case <-time.After(time.Second):
+            t.Fatalf("timed out waiting for %s event", wantEvent.Type)
+        }
+    }
+
+    foreman.stopAll()
+    got := <-foreman.Events()
+    assertString(t, got.Type.String(), "stopped")
+}
+
+func TestLifecycleHooks(t *testing.T) {
+    runner := newFakeRunner()
+    foreman, _ := New(testChainProcfile, WithRunner(runner))
+
+    calls := make(chan string, 10)
+    foreman.OnStart(func(service string, pid int) {
+        calls <- fmt.Sprintf("start %s %d", service, pid)
+    })
+    foreman.OnCrash(func(service string, exitCode int) {
+        calls <- fmt.Sprintf("crash %s %d", service, exitCode)
+    }, "database")
+    foreman.OnCrash(func(service string, exitCode int) {
+        calls <- "crash hook for another service"
+    }, "frontend")
+    foreman.OnStop(func(service string, exitCode int) {
+        calls <- fmt.Sprintf("stop %s", service)
+    })
+
+    foreman.startService("database")
+    runner.exit(1, 3)
+    for _, want := range []string{"start database 1", "crash database 3", "start database 2"} {
+        assertString(t, <-calls, want)
+    }
+
+    foreman.stopAll()
+    assertString(t, <-calls, "stop database")
+}
+
+func TestPause(t *testing.T) {
+    // Return the state of a process, like S when sleeping or T when stopped.
+    processState := func(pid string) string {
+        stat, _ := os.ReadFile("/proc/" + pid + "/stat")
+        fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+        if len(fields) == 0 {
+            return ""
+        }
+        return fields[0]
+    }
+    waitState := func(t *testing.T, pid, state string) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for processState(pid) != state {
+            if time.Now().After(deadline) {
+                t.Fatalf("process %s is in state %s instead of %s", pid, processState(pid), state)
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+
+    foreman, _ := New(testChainProcfile, WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+    childPID := filepath.Join(t.TempDir(), "child.pid")
+    service := foreman.services["database"]
+    service.cmd = fmt.Sprintf("sleep 60 & echo $! > %s; wait", childPID)
+    service.restart = RestartNo
+    foreman.services["database"] = service
+    err := foreman.startService("database")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer func() {
+        foreman.stopService("database")
+        waitForExit(t, foreman, "database")
+    }()
+    var pid string
+    for pid == "" {
+        content, _ := os.ReadFile(childPID)
+        pid = strings.TrimSpace(string(content))
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    err = foreman.PauseService("database")
+    if err != nil {
+        t.Fatal(err)
+    }
+    waitState(t, pid, "T")
+    assertString(t, foreman.Status()["database"].State.String(), "paused")
+    paused, _ := foreman.service("database")
+    if paused.stopChecker != nil {
+        t.Error("the checks of the paused service are still running")
+    }
+    err = foreman.PauseService("database")
+    assertError(t, err, "database is already paused")
+
+    err = foreman.ResumeService("database")
+    if err != nil {
+        t.Fatal(err)
+    }
+    waitState(t, pid, "S")
+    assertString(t, foreman.Status()["database"].State.String(), "starting")
+    resumed, _ := foreman.service("database")
+    if resumed.stopChecker == nil {
+        t.Error("the checks of the resumed service didn't start again")
+    }
+    err = foreman.ResumeService("database")
+    assertError(t, err, "database isn't paused")
+
+    t.Run("stop a paused service", func(t *testing.T) {
+        err := foreman.PauseService("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = foreman.StopService("database", false)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.Status()["database"].State.String(), "stopped")
+        err = foreman.PauseService("database")
+        assertError(t, err, "database isn't running")
+    })
+
+    t.Run("stay paused when the service can't be resumed", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile, WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        service := foreman.services["database"]
+        service.paused = true
+        service.pid = 1 << 30
+        foreman.services["database"] = service
+
+        err := foreman.ResumeService("database")
+        if err == nil {
+            t.Fatal("expected resuming a missing process to fail")
+        }
+        service, _ = foreman.service("database")
+        if !service.paused {
+            t.Error("expected the service to stay paused")
+        }
+    })
+}
+
+func TestProcessGroup(t *testing.T) {
+    // Report whether a process exited, zombies included.
+    processGone := func(pid string) bool {
+        stat, err := os.ReadFile("/proc/" + pid + "/stat")
+        return err != nil || strings.Contains(string(stat), ") Z ")
+    }
+    waitGone := func(t *testing.T, pid string) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for !processGone(pid) {
+            if time.Now().After(deadline) {
+                t.Fatalf("process %s started by the service is still running", pid)
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+    startService := func(t *testing.T, cmd string) (*Foreman, string) {
+        t.Helper()
+        foreman, _ := New(testChainProcfile, WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        childPID := filepath.Join(t.TempDir(), "child.pid")
+        service := foreman.services["database"]
+        service.cmd = fmt.Sprintf(cmd, childPID)
+        service.noHealthCheck = true
+        service.restart = RestartNo
+        foreman.services["database"] = service
+        err := foreman.startService("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        deadline := time.Now().Add(2 * time.Second)
+        for {
+            content, _ := os.ReadFile(childPID)
+            if pid := strings.TrimSpace(string(content)); pid != "" {
+                return foreman, pid
+            }
+            if time.Now().After(deadline) {
+                t.Fatal("timed out waiting for the service to start its child")
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+
+    t.Run("stop the processes started by the service", func(t *testing.T) {
+        foreman, pid := startService(t, "sleep 60 & echo $! > %s; wait")
+        service := foreman.services["database"]
+        service.stopSignal = syscall.SIGTERM
+        foreman.services["database"] = service
+
+        foreman.stopAll()
+        waitGone(t, pid)
+    })
+
+    t.Run("kill the processes left once the service exits", func(t *testing.T) {
+        // Background commands of a shell ignore SIGINT, the default stop signal.
+        foreman, pid := startService(t, "sleep 60 & echo $! > %s; wait")
+        foreman.stopAll()
+        waitGone(t, pid)
+
+        foreman, pid = startService(t, "sleep 60 & echo $! > %s")
+        waitForExit(t, foreman, "database")
+        waitGone(t, pid)
+    })
+}
+
+func TestSubreaper(t *testing.T) {
+    err := setSubreaper()
+    if err != nil {
+        t.Skip(err)
+    }
+    foreman, _ := New(testChainProcfile, WithSubreaper(), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+
+    t.Run("reap the adopted orphans", func(t *testing.T) {
+        childPID := filepath.Join(t.TempDir(), "child.pid")
+        service := foreman.services["database"]
+        service.cmd = fmt.Sprintf("(sleep 60 & echo $! > %s)", childPID)
+        service.noHealthCheck = true
+        service.restart = RestartNo
+        foreman.services["database"] = service
+        err := foreman.startService("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForExit(t, foreman, "database")
+
+        content, err := os.ReadFile(childPID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+        // The orphan was killed with the process group of the service, and
+        // re-parented to foreman instead of init.
+        waitForZombie(t, pid)
+
+        foreman.sigChildHandler()
+        if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+            t.Errorf("process %d wasn't reaped", pid)
+        }
+    })
+
+    t.Run("hand the wait status over to the children foreman waits for", func(t *testing.T) {
+        startReaping()
+        defer stopReaping()
+
+        command := exec.Command("sh", "-c", "exit 3")
+        err := startChild(command)
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForZombie(t, command.Process.Pid)
+        foreman.sigChildHandler()
+        err = waitChild(command)
+        assertError(t, err, "exit status 3")
+
+        runner := newExecRunner()
+        pid, err := runner.Start(exec.Command("sh", "-c", "kill -9 $$"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        waitForZombie(t, pid)
+        foreman.sigChildHandler()
+        exitCode, err := runner.Wait(pid)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(exitCode), "137")
+    })
+
+    t.Run("leave the children to their own wait when not reaping", func(t *testing.T) {
+        command := exec.Command("sh", "-c", "exit 3")
+        err := runChild(command)
+        assertError(t, err, "exit status 3")
+    })
+}
+
+// Wait until pid exited and is a zombie child of the test process.
+func waitForZombie(t *testing.T, pid int) {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        stat, _ := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+        fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+        if len(fields) >= 2 && fields[0] == "Z" && fields[1] == strconv.Itoa(os.Getpid()) {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("process %d didn't exit as a child of foreman", pid)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestSchedule(t *testing.T) {
+    t.Run("parse schedule", func(t *testing.T) {
+        schedule, err := parseSchedule("schedule", "*/5 * * * *")
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, schedule.Cron, "*/5 * * * *")
+
+        schedule, err = parseSchedule("schedule", map[string]any{"cron": "@hourly", "overlap": "queue"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, string(schedule.Overlap), "queue")
+
+        _, err = parseSchedule("schedule", "*/5 * *")
+        assertError(t, err, `schedule.cron: invalid cron expression "*/5 * *": expected exactly 5 fields, found 3: [*/5 * *]`)
+        _, err = parseSchedule("schedule", map[string]any{"cron": "@daily", "overlap": "wait"})
+        assertError(t, err, "schedule.overlap: expected skip, queue or kill-previous, got wait")
+
+        service, err := parseService(map[string]any{"cmd": "./backup.sh", "schedule": "@daily"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, string(service.restart), "no")
+    })
+
+    // Start the scheduler of database, due every 5 minutes, and run it once.
+    startScheduled := func(t *testing.T, overlap OverlapPolicy) (*Foreman, *fakeRunner, *fakeClock) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        service := foreman.services["database"]
+        service.noHealthCheck = true
+        service.schedule = Schedule{Cron: "*/5 * * * *", Overlap: overlap}
+        service.restart = RestartNo
+        foreman.services["database"] = service
+
+        foreman.startScheduler("database")
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, foreman.Status()["database"].NextRun.Sub(time.Unix(0, 0)).String(), "5m0s")
+        clock.advance(5 * time.Minute)
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+        return foreman, runner, clock
+    }
+
+    t.Run("skip a run while the previous one is going", func(t *testing.T) {
+        foreman, runner, clock := startScheduled(t, "")
+        defer foreman.stopAll()
+        status := foreman.Status()["database"]
+        assertString(t, status.LastRun.Sub(time.Unix(0, 0)).String(), "5m0s")
+        assertString(t, status.NextRun.Sub(time.Unix(0, 0)).String(), "10m0s")
+
+        clock.advance(5 * time.Minute)
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+
+        runner.exit(1, 3)
+        waitForExit(t, foreman, "database")
+        status = foreman.Status()["database"]
+        assertString(t, status.State.String(), "crashed")
+        assertString(t, fmt.Sprint(status.ExitCode, status.Restarts), "3 0")
+
+        clock.advance(5 * time.Minute)
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+    })
+
+    t.Run("queue a run until the previous one exits", func(t *testing.T) {
+        foreman, runner, clock := startScheduled(t, OverlapQueue)
+        defer foreman.stopAll()
+        clock.advance(5 * time.Minute)
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+
+        runner.exit(1, 0)
+        deadline := time.Now().Add(time.Second)
+        for len(runner.startedPids()) < 2 && time.Now().Before(deadline) {
+            time.Sleep(5 * time.Millisecond)
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+    })
+
+    t.Run("stop the previous run before the next one", func(t *testing.T) {
+        foreman, runner, clock := startScheduled(t, OverlapKillPrevious)
+        defer foreman.stopAll()
+        clock.advance(5 * time.Minute)
+        clock.waitForAfter(t, 5*time.Minute)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+        if runner.Signal(1, 0) == nil {
+            t.Error("expected the previous run to be stopped")
+        }
+    })
+
+    t.Run("stop the scheduler", func(t *testing.T) {
+        foreman, runner, clock := startScheduled(t, "")
+        runner.exit(1, 0)
+        waitForExit(t, foreman, "database")
+        foreman.stopScheduler("database")
+        if !foreman.Status()["database"].NextRun.IsZero() {
+            t.Error("expected no next run once the scheduler is stopped")
+        }
+        clock.advance(5 * time.Minute)
+        time.Sleep(20 * time.Millisecond)
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+    })
+
+    t.Run("reject services depending on a scheduled service", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        spec, _ := foreman.Spec("database")
+        spec.Schedule = Schedule{Cron: "@hourly"}
+        err := foreman.SetSpec("database", spec)
+        assertError(t, err, "backend depends on scheduled service database")
+    })
+}
+
+func TestCommandHooks(t *testing.T) {
+    t.Run("run the hooks around the service lifecycle", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        record := filepath.Join(t.TempDir(), "hooks")
+        hooks, err := parseCommandHooks("hooks", map[string]any{
+            "pre_start":  []any{"echo pre_start $HOOK_VALUE >> " + record},
+            "post_start": "echo post_start >> " + record,
+            "pre_stop":   "echo pre_stop >> " + record,
+            "post_stop":  "echo post_stop >> " + record,
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        service := foreman.services["database"]
+        service.noHealthCheck = true
+        service.env = map[string]string{"HOOK_VALUE": "1"}
+        service.hooks = hooks
+        foreman.services["database"] = service
+
+        err = foreman.startService("database")
+        if err != nil {
+            t.Fatal(err)
+        }
+        readRecord := func(lines int) []string {
+            deadline := time.Now().Add(time.Second)
+            for {
+                content, _ := os.ReadFile(record)
+                got := strings.Fields(strings.ReplaceAll(string(content), " 1", "_1"))
+                if len(got) >= lines || time.Now().After(deadline) {
+                    return got
+                }
+                time.Sleep(5 * time.Millisecond)
+            }
+        }
+        assertList(t, readRecord(2), []string{"pre_start_1", "post_start"})
+
+        foreman.stopAll()
+        assertList(t, readRecord(4), []string{"pre_start_1", "post_start", "pre_stop", "post_stop"})
+    })
+
+    t.Run("a failing pre_start hook aborts the launch", func(t *testing.T) {
+        runner := newFakeRunner()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        service := foreman.services["database"]
+        service.hooks = CommandHooks{PreStart: []string{"true", "exit 3", "true"}}
+        foreman.services["database"] = service
+
+        err := foreman.startService("database")
+        var launchErr *LaunchError
+        if !errors.As(err, &launchErr) {
+            t.Fatalf("expected a LaunchError, got %v", err)
+        }
+        assertString(t, err.Error(), `failed to launch database: pre_start hook "exit 3" failed: exit status 3`)
+        if len(runner.startedPids()) != 0 {
+            t.Error("expected the service not to be launched")
+        }
+        assertString(t, foreman.Status()["database"].State.String(), "failed")
+    })
+}
+
+func TestWaitHealthy(t *testing.T) {
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(newFakeRunner()), WithClock(clock))
+    defer foreman.stopAll()
+
+    t.Run("time out before the service is healthy", func(t *testing.T) {
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        defer cancel()
+
+        err := foreman.WaitHealthy(ctx, "database")
+        if !errors.Is(err, context.DeadlineExceeded) {
+            t.Fatalf("expected a deadline error, got: %v", err)
+        }
+    })
+
+    t.Run("return once the checks passed", func(t *testing.T) {
+        waited := make(chan error, 1)
+        go func() {
+            waited <- foreman.WaitHealthy(context.Background(), "database")
+        }()
+
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second})
+        foreman.startService("database")
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+
+        select {
+        case err := <-waited:
+            if err != nil {
+                t.Fatal(err)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for database to be healthy")
+        }
+
+        err := foreman.WaitHealthy(context.Background(), "database")
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    t.Run("unknown service", func(t *testing.T) {
+        err := foreman.WaitHealthy(context.Background(), "cache")
+        assertError(t, err, `unknown service "cache"`)
+    })
+}
+
+func TestStatus(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock))
+    defer foreman.stopAll()
+
+    foreman.UpdateChecks("database", CheckSpec{Interval: time.Hour})
+    foreman.startService("database")
+    clock.advance(5 * time.Second)
+
+    status := foreman.Status()["database"]
+    assertString(t, fmt.Sprintf("%+v", status), "{State:starting PID:1 Uptime:5s Restarts:0 ExitCode:0 ExitSignal:signal 0 CheckHistory:[] LastRun:0001-01-01 00:00:00 +0000 UTC NextRun:0001-01-01 00:00:00 +0000 UTC}")
+    assertString(t, fmt.Sprintf("%+v", foreman.Status()["backend"]), "{State:pending PID:0 Uptime:0s Restarts:0 ExitCode:0 ExitSignal:signal 0 CheckHistory:[] LastRun:0001-01-01 00:00:00 +0000 UTC NextRun:0001-01-01 00:00:00 +0000 UTC}")
+
+    runner.exit(1, 2)
+    for event := range foreman.Events() {
+        if event.Type == ServiceStarted && event.PID == 2 {
+            break
+        }
+    }
+
+    status = foreman.Status()["database"]
+    assertString(t, fmt.Sprintf("%+v", status), "{State:starting PID:2 Uptime:0s Restarts:1 ExitCode:2 ExitSignal:signal 0 CheckHistory:[] LastRun:0001-01-01 00:00:00 +0000 UTC NextRun:0001-01-01 00:00:00 +0000 UTC}")
+}
+
+func TestUsage(t *testing.T) {
+    foreman, _ := New(testChainProcfile)
+    defer foreman.stopAll()
+    foreman.startService("database")
+
+    usage := foreman.Usage(10 * time.Millisecond)
+    assertString(t, fmt.Sprint(len(usage)), "1")
+    database := usage["database"]
+    assertString(t, fmt.Sprint(database.PID), fmt.Sprint(foreman.snapshot()["database"].pid))
+    if database.RSS == 0 || database.OpenFDs == 0 {
+        t.Errorf("expected memory and open files, got %+v", database)
+    }
+}
+
+func TestRestartWithFakeRunner(t *testing.T) {
+    runner := newFakeRunner()
+    foreman, _ := New(testChainProcfile, WithRunner(runner))
+    transitions := make(chan State, 3)
+    foreman.OnStateChange(func(name string, old, new State) {
+        transitions <- new
+    })
+
+    err := foreman.startService("database")
+    if err != nil {
+        t.Fatal(err)
+    }
+    runner.exit(1, 1)
+
+    for _, want := range []State{StateStarting, StateRestarting, StateStarting} {
+        select {
+        case got := <-transitions:
+            assertString(t, got.String(), want.String())
+        case <-time.After(time.Second):
+            t.Fatalf("timed out waiting for %s", want)
+        }
+    }
+
+    assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+    foreman.stopAll()
+}
+
+func TestCrashLoop(t *testing.T) {
+    t.Run("back off and give up after max restarts", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testCrashLoopProcfile, WithRunner(runner), WithClock(clock))
+        started := make(chan int, 4)
+        foreman.OnStart(func(service string, pid int) {
+            started <- pid
+        })
+        failed := make(chan string, 1)
+        foreman.OnStateChange(func(name string, old, new State) {
+            if new == StateFailed {
+                failed <- name
+            }
+        })
+
+        foreman.startService("flaky")
+        <-started
+        for pid, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+            runner.exit(pid+1, 1)
+            clock.waitForAfter(t, delay)
+            clock.advance(delay)
+            <-started
+        }
+
+        runner.exit(4, 1)
+        select {
+        case got := <-failed:
+            assertString(t, got, "flaky")
+        case <-time.After(time.Second):
+            t.Fatal("timed out waiting for flaky to fail")
+        }
+        assertString(t, fmt.Sprint(runner.startedPids()), "[1 2 3 4]")
+
+        for event := range foreman.Events() {
+            if event.Type == RestartLimitReached {
+                assertString(t, event.Service, "flaky")
+                break
+            }
+        }
+    })
+
+    t.Run("cap the backoff delay", func(t *testing.T) {
+        service := Service{restartDelay: time.Minute, backoffFactor: 10}
+        assertString(t, service.backoffDelay(5).String(), maxRestartDelay.String())
+    })
+
+    t.Run("forget restarts outside the window", func(t *testing.T) {
+        now := time.Unix(1000, 0)
+        service := Service{restartHistory: []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Second)}}
+        assertString(t, fmt.Sprint(len(service.recentRestarts(now))), "1")
+    })
+}
+
+func TestRestartPolicy(t *testing.T) {
+    cases := map[RestartPolicy]string{
+        RestartAlways:    "[1 2 3]",
+        RestartOnFailure: "[1 2]",
+        RestartNo:        "[1]",
+    }
+
+    for policy, want := range cases {
+        t.Run(string(policy), func(t *testing.T) {
+            runner := newFakeRunner()
+            foreman, _ := New(testChainProcfile, WithRunner(runner))
+            defer foreman.stopAll()
+
+            service := foreman.services["database"]
+            service.restart = policy
+            foreman.services["database"] = service
+            started := make(chan int, 3)
+            foreman.OnStart(func(service string, pid int) {
+                started <- pid
+            })
+
+            foreman.startService("database")
+            <-started
+            runner.exit(1, 1)
+            if policy != RestartNo {
+                <-started
+            }
+            runner.exit(2, 0)
+            if policy == RestartAlways {
+                <-started
+            } else {
+                waitForExit(t, foreman, "database")
+            }
+
+            assertString(t, fmt.Sprint(runner.startedPids()), want)
+        })
+    }
+}
+
+func TestRestartDelay(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, err := New(testRestartDelayProcfile, WithRunner(runner), WithClock(clock))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    foreman.startService("flaky")
+    runner.exit(1, 1)
+    clock.waitForAfter(t, 3*time.Second)
+
+    clock.advance(2 * time.Second)
+    time.Sleep(50 * time.Millisecond)
+    assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+
+    clock.advance(time.Second)
+    deadline := time.Now().Add(time.Second)
+    for len(runner.startedPids()) != 2 && time.Now().Before(deadline) {
+        time.Sleep(5 * time.Millisecond)
+    }
+    assertString(t, fmt.Sprint(runner.startedPids()), "[1 2]")
+    foreman.stopAll()
+}
+
+func TestUpdateChecks(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock))
+
+    foreman.UpdateChecks("database", CheckSpec{Interval: time.Second})
+    foreman.startService("database")
+    clock.waitForAfter(t, time.Second)
+
+    err := foreman.UpdateChecks("database", CheckSpec{Interval: 3 * time.Second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    clock.waitForAfter(t, 3*time.Second)
+
+    assertString(t, fmt.Sprint(runner.startedPids()), "[1]")
+    foreman.stopAll()
+}
+
+func TestCheckRetries(t *testing.T) {
+    runner := newFakeRunner()
+    clock := newFakeClock()
+    foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+    foreman.UpdateChecks("database", CheckSpec{Interval: time.Second, Cmd: "false", Retries: 2, InitialDelay: 5 * time.Second})
+    foreman.startService("database")
+
+    clock.waitForAfter(t, 5*time.Second)
+    clock.advance(5 * time.Second)
+    clock.waitForAfter(t, time.Second)
+    clock.advance(time.Second)
+
+    // The first failure is retried: the checker waits for the next round.
+    clock.waitForAfter(t, time.Second)
+    if err := runner.Signal(1, 0); err != nil {
+        t.Fatal("expected the service to survive its first failed check")
+    }
+    assertString(t, foreman.Status()["database"].State.String(), "starting")
+
+    clock.advance(time.Second)
+    waitForExit(t, foreman, "database")
+    foreman.stopAll()
+}
+
+func TestCheckHistory(t *testing.T) {
+    t.Run("a service is healthy again after successes rounds in a row", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithLogger(log.New(io.Discard, "", 0)))
+        ready := filepath.Join(t.TempDir(), "ready")
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second, FileExists: []string{ready}, Successes: 2})
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        round := func() {
+            clock.waitForAfter(t, time.Second)
+            clock.advance(time.Second)
+        }
+        round()
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "failed")
+
+        err := os.WriteFile(ready, nil, 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        round()
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "failed")
+        round()
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "healthy")
+
+        var results []string
+        for _, result := range foreman.Status()["database"].CheckHistory {
+            results = append(results, fmt.Sprint(result.Passed, result.Failed))
+        }
+        assertList(t, results, []string{"false [file]", "true []", "true []"})
+    })
+
+    t.Run("the history keeps the latest results", func(t *testing.T) {
+        var history *checkHistory
+        assertString(t, fmt.Sprint(history.list()), "[]")
+
+        history = &checkHistory{}
+        for i := 0; i < checkHistorySize+5; i++ {
+            history.add(CheckResult{Passed: i%2 == 0, Failed: []string{fmt.Sprint(i)}})
+        }
+        results := history.list()
+        if len(results) != checkHistorySize {
+            t.Fatalf("expected %d results, got %d", checkHistorySize, len(results))
+        }
+        assertString(t, results[0].Failed[0], "5")
+        assertString(t, results[checkHistorySize-1].Failed[0], fmt.Sprint(checkHistorySize+4))
+    })
+}
+
+func TestReadinessAndLiveness(t *testing.T) {
+    t.Run("readiness checks keep the service from being healthy", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithLogger(log.New(io.Discard, "", 0)))
+        ready := filepath.Join(t.TempDir(), "ready")
+        service := foreman.services["database"]
+        service.checks = Checks{interval: time.Second}
+        service.readiness = &Checks{interval: 2 * time.Second, fileExists: []string{ready}}
+        foreman.services["database"] = service
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        clock.waitForAfter(t, 2*time.Second)
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "failed")
+
+        err := os.WriteFile(ready, nil, 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        clock.advance(time.Second)
+        clock.waitForAfter(t, 2*time.Second)
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "healthy")
+        if err := runner.Signal(1, 0); err != nil {
+            t.Error("readiness checks must not interrupt the service")
+        }
+    })
+
+    t.Run("failing liveness checks interrupt the service", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithOutput(io.Discard), WithLogger(log.New(io.Discard, "", 0)))
+        service := foreman.services["database"]
+        service.checks = Checks{interval: time.Minute}
+        service.liveness = &Checks{interval: time.Second, fileExists: []string{filepath.Join(t.TempDir(), "alive")}}
+        foreman.services["database"] = service
+        foreman.startService("database")
+
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        waitForExit(t, foreman, "database")
+        foreman.stopAll()
+    })
+}
+
+func TestFailureActions(t *testing.T) {
+    t.Run("log failures only", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        records := make(chan LogRecord, 16)
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithStructuredLogger(recordLogger(records)))
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second, Cmd: "false", OnFailure: FailureAction{Action: ActionLog}})
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        for record := range records {
+            if record.Event == "check action" {
+                assertString(t, record.Message, "1 database: checks failed")
+                break
+            }
+        }
+        if err := runner.Signal(1, 0); err != nil {
+            t.Error("log-only must not interrupt the service")
+        }
+
+        // The action isn't repeated while the checks keep failing.
+        for i := 0; i < 3; i++ {
+            clock.waitForAfter(t, time.Second)
+            clock.advance(time.Second)
+        }
+        clock.waitForAfter(t, time.Second)
+        for len(records) > 0 {
+            record := <-records
+            if record.Event == "check action" {
+                t.Errorf("unexpected repeated action: %s", record.Message)
+            }
+        }
+    })
+
+    t.Run("parse actions", func(t *testing.T) {
+        for want, value := range map[string]any{
+            "stop":          "stop",
+            "signal hangup": map[string]any{"signal": "HUP"},
+            "run ./alert":   map[string]any{"run": "./alert"},
+        } {
+            action, err := parseFailureAction("on_failure", value)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := action.Action
+            if action.Signal != 0 {
+                got += " " + action.Signal.String()
+            }
+            if action.Run != "" {
+                got += " " + action.Run
+            }
+            assertString(t, got, want)
+        }
+
+        _, err := parseFailureAction("on_failure", "reboot")
+        assertError(t, err, "on_failure: expected restart, stop, log-only, signal: <signal> or run: <command>, got reboot")
+    })
+}
+
+// failingCheck is a custom check that always fails.
+type failingCheck struct{}
+
+func (failingCheck) Run(ctx context.Context) error {
+    if _, ok := ctx.Deadline(); !ok {
+        return errors.New("expected a deadline")
+    }
+    return errors.New("queue is stuck")
+}
+
+func (failingCheck) Describe() string {
+    return "queue depth"
+}
+
+func TestCustomChecks(t *testing.T) {
+    t.Run("failing custom checks interrupt the service", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithLogger(log.New(io.Discard, "", 0)))
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second})
+        err := foreman.AddCheck("database", failingCheck{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        foreman.startService("database")
+
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        waitForExit(t, foreman, "database")
+        for event := range foreman.Events() {
+            if event.Type == CheckFailed {
+                assertString(t, event.Check, "queue depth")
+                assertString(t, errors.Unwrap(event.Err).Error(), "queue is stuck")
+                break
+            }
+        }
+        foreman.stopAll()
+    })
+
+    t.Run("custom checks can be disabled", func(t *testing.T) {
+        runner := newFakeRunner()
+        clock := newFakeClock()
+        foreman, _ := New(testChainProcfile, WithRunner(runner), WithClock(clock), WithDisabledChecks(CheckCustom), WithLogger(log.New(io.Discard, "", 0)))
+        foreman.UpdateChecks("database", CheckSpec{Interval: time.Second})
+        foreman.AddCheck("database", failingCheck{})
+        foreman.startService("database")
+        defer foreman.stopAll()
+
+        clock.waitForAfter(t, time.Second)
+        clock.advance(time.Second)
+        clock.waitForAfter(t, time.Second)
+        assertString(t, foreman.Status()["database"].State.String(), "healthy")
+    })
+
+    t.Run("unknown services", func(t *testing.T) {
+        foreman, _ := New(testChainProcfile)
+        err := foreman.AddCheck("cache", failingCheck{})
+        var unknown *UnknownServiceError
+        if !errors.As(err, &unknown) {
+            t.Errorf("expected an UnknownServiceError, got %v", err)
+        }
+    })
+}
+
+func TestCheckTimeout(t *testing.T) {
+    foreman, _ := New(testChainProcfile)
+    service := foreman.services["database"]
+    service.checks = Checks{cmd: "sleep 10", timeout: 50 * time.Millisecond}
+    ctx, cancel := service.checks.checkContext(CheckCmd)
+    defer cancel()
+    err := foreman.checkCmd(ctx, service)
+    assertError(t, err, "timed out after 50ms")
+
+    service.checks = Checks{cmd: "true", timeout: time.Second}
+    ctx, cancel = service.checks.checkContext(CheckCmd)
+    defer cancel()
+    err = foreman.checkCmd(ctx, service)
+    if err != nil {
+        t.Errorf("expected the check to pass within its timeout, got %v", err)
+    }
+
+    // A cmd without a timeout still stops with its context.
+    service.checks = Checks{cmd: "sleep 10"}
+    ctx, cancel = context.WithCancel(context.Background())
+    time.AfterFunc(50*time.Millisecond, cancel)
+    err = foreman.checkCmd(ctx, service)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("expected the check to be cancelled, got %v", err)
+    }
+}
+
+func TestCheckCmdEnvironment(t *testing.T) {
+    foreman, _ := New(testChainProcfile)
+    service := foreman.services["database"]
+    service.cwd = t.TempDir()
+    service.env = map[string]string{"CHECK_TOKEN": "secret"}
+    err := os.WriteFile(filepath.Join(service.cwd, "ready"), nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    service.checks = Checks{cmd: `test "$CHECK_TOKEN" = secret && test -f ready`}
+    err = foreman.checkCmd(context.Background(), service)
+    if err != nil {
+        t.Errorf("expected the check to see the service environment and directory, got %v", err)
+    }
+
+    var checks Checks
+    err = parseCheck("checks", map[string]any{"cmd": []any{"test", "$CHECK_TOKEN", "=", "secret"}}, &checks)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Without the shell, the variable isn't expanded.
+    service.checks = checks
+    err = foreman.checkCmd(context.Background(), service)
+    if err == nil {
+        t.Error("expected the arguments to be run without the shell")
+    }
+    checks.argv = []string{"test", "-f", "ready"}
+    service.checks = checks
+    err = foreman.checkCmd(context.Background(), service)
+    if err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+}
+
+func TestNoHealthCheck(t *testing.T) {
+    foreman, _ := New(testNoHealthCheckProcfile)
+    markers := t.TempDir()
+    for _, serviceName := range []string{"checked", "unchecked"} {
+        service := foreman.services[serviceName]
+        service.checks.cmd = "touch " + filepath.Join(markers, serviceName)
+        foreman.services[serviceName] = service
+        foreman.startService(serviceName)
+    }
+
+    time.Sleep(2 * defaultCheckInterval)
+    foreman.stopAll()
+
+    if _, err := os.Stat(filepath.Join(markers, "checked")); err != nil {
+        t.Errorf("expected the checked service to run its check: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(markers, "unchecked")); err == nil {
+        t.Error("expected no check subprocess for the no_health_check service")
+    }
+}
+
+func TestCheckNetwork(t *testing.T) {
+    t.Run("resolvable host", func(t *testing.T) {
+        service := Service{checks: Checks{networkReady: []string{"localhost"}}}
+        err := service.checkNetwork(context.Background())
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+
+    t.Run("unresolvable host", func(t *testing.T) {
+        service := Service{checks: Checks{networkReady: []string{"does-not-exist.invalid"}}}
+        err := service.checkNetwork(context.Background())
+        if err == nil {
+            t.Error("expected a resolution error")
+        }
+    })
+}
+
+func TestCheckDNSAndPing(t *testing.T) {
+    t.Run("resolve names to the expected addresses", func(t *testing.T) {
+        checks, err := parseDNSChecks("dns", []any{"localhost", map[string]any{"name": "localhost", "expect": []any{"127.0.0.1"}}})
+        if err != nil {
+            t.Fatal(err)
+        }
+        service := Service{checks: Checks{dns: checks}}
+        err = service.checkDNS(context.Background())
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        service.checks.dns = []DNSCheck{{Name: "localhost", Expect: []string{"10.0.0.1"}}}
+        err = service.checkDNS(context.Background())
+        if err == nil || !strings.Contains(err.Error(), "expected 10.0.0.1") {
+            t.Errorf("expected an unexpected address error, got %v", err)
+        }
+    })
+
+    t.Run("ping hosts and ports", func(t *testing.T) {
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        service := Service{checks: Checks{ping: []string{listener.Addr().String()}}}
+        err = service.checkPing(context.Background())
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+        listener.Close()
+        err = service.checkPing(context.Background())
+        if err == nil {
+            t.Error("expected a connection error")
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+        err = ping(ctx, "127.0.0.1")
+        if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EPERM) {
+            t.Skip("ICMP sockets aren't allowed")
+        }
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+}
+
+func TestCheckLimits(t *testing.T) {
+    t.Run("parse the limits", func(t *testing.T) {
+        var checks Checks
+        err := parseCheck("checks", map[string]any{"max_memory": "512MB", "max_cpu_percent": 90, "max_open_files": 1000, "limit_duration": "30s"}, &checks)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if checks.maxMemory != 512<<20 || checks.maxCPUPercent != 90 || checks.maxOpenFiles != 1000 || checks.limitDuration != 30*time.Second {
+            t.Errorf("unexpected limits: %+v", checks)
+        }
+
+        err = parseCheck("checks", map[string]any{"max_cpu_percent": "-1"}, &checks)
+        if err == nil {
+            t.Error("expected a negative percentage to be rejected")
+        }
+    })
+
+    t.Run("fail once a limit is exceeded for the limit duration", func(t *testing.T) {
+        watch := &limitWatch{}
+        checks := Checks{maxOpenFiles: 1, limitDuration: time.Minute}
+        now := time.Now()
+        err := watch.check(os.Getpid(), checks, now)
+        if err != nil {
+            t.Errorf("expected the limit to be tolerated for a minute, got %v", err)
+        }
+        err = watch.check(os.Getpid(), checks, now.Add(time.Minute))
+        if err == nil || !strings.Contains(err.Error(), "open files exceed 1") {
+            t.Errorf("expected an open files error, got %v", err)
+        }
+
+        // Going back under the limits resets the duration.
+        checks.maxOpenFiles = 1 << 20
+        err = watch.check(os.Getpid(), checks, now.Add(2*time.Minute))
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+        checks.maxOpenFiles, checks.maxMemory = 0, 1
+        err = watch.check(os.Getpid(), checks, now.Add(3*time.Minute))
+        if err != nil {
+            t.Errorf("expected the limit to be tolerated for a minute, got %v", err)
+        }
+    })
+}
+
+func TestCheckPorts(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer listener.Close()
+    port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+    t.Run("port listened on by the service", func(t *testing.T) {
+        service := Service{pid: os.Getpid(), checks: Checks{tcpPorts: []string{port}}}
+        err := service.checkPorts("tcp")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+
+    t.Run("port listened on by another process", func(t *testing.T) {
+        // No process has a pid above the kernel limit of 2^22.
+        service := Service{pid: 1<<22 + 1, checks: Checks{tcpPorts: []string{port}}}
+        err := service.checkPorts("tcp")
+        assertError(t, err, fmt.Sprintf("tcp port %s isn't listened on by pid %d", port, 1<<22+1))
+    })
+
+    t.Run("port nobody listens on", func(t *testing.T) {
+        service := Service{pid: os.Getpid(), checks: Checks{udpPorts: []string{port}}}
+        err := service.checkPorts("udp")
+        assertError(t, err, fmt.Sprintf("udp port %s isn't listened on by pid %d", port, os.Getpid()))
+    })
+}
+
+func TestCheckGRPC(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := grpc.NewServer()
+    healthServer := health.NewServer()
+    healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_NOT_SERVING)
+    healthpb.RegisterHealthServer(server, healthServer)
+    go server.Serve(listener)
+    defer server.Stop()
+    address := listener.Addr().String()
+
+    t.Run("serving server", func(t *testing.T) {
+        service := Service{checks: Checks{grpc: GRPCCheck{Address: address}}}
+        err := service.checkGRPC(context.Background())
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+
+    t.Run("service not serving", func(t *testing.T) {
+        service := Service{checks: Checks{grpc: GRPCCheck{Address: address, Service: "orders"}}}
+        err := service.checkGRPC(context.Background())
+        assertError(t, err, address+" is NOT_SERVING")
+    })
+
+    t.Run("parse checks", func(t *testing.T) {
+        check, err := parseGRPCCheck("grpc", map[string]any{"address": "localhost:50051", "service": "orders"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, check.Address+" "+check.Service, "localhost:50051 orders")
+
+        _, err = parseGRPCCheck("grpc", map[string]any{"service": "orders"})
+        assertError(t, err, "grpc.address: an address is required")
+    })
+}
+
+func TestCheckFiles(t *testing.T) {
+    dir := t.TempDir()
+    ready := filepath.Join(dir, "ready")
+    socket := filepath.Join(dir, "app.sock")
+    pidFile := filepath.Join(dir, "app.pid")
+
+    service := Service{checks: Checks{fileExists: []string{ready}, socketExists: []string{socket}, pidFile: pidFile}}
+    err := service.checkFiles()
+    assertError(t, err, "stat "+ready+": no such file or directory")
+
+    err = os.WriteFile(ready, nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(socket, nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = service.checkFiles()
+    assertError(t, err, socket+" isn't a unix socket")
+
+    os.Remove(socket)
+    listener, err := net.Listen("unix", socket)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer listener.Close()
+    err = os.WriteFile(pidFile, []byte("4194305\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = service.checkFiles()
+    assertError(t, err, pidFile+": process 4194305 isn't running")
+
+    err = os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = service.checkFiles()
+    if err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+}
+
+func waitForExit(t *testing.T, foreman *Foreman, serviceName string) {
+    t.Helper()
+
+    select {
+    case <-foreman.snapshot()[serviceName].exited:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("timed out waiting for %s to exit", serviceName)
+    }
+}
+
+func assertForeman(t *testing.T, got, want *Foreman) {
+    t.Helper()
+
+    for serviceName, service := range got.services {
+        assertService(t, service, want.services[serviceName])
+    }
+}
+
+func assertService(t *testing.T, got, want Service) {
+    t.Helper()
+
+    if got.serviceName != want.serviceName {
+        t.Errorf("got:\n%q\nwant:\n%q", got.serviceName, want.serviceName)
+    }
+
+    if got.pid != want.pid {
+        t.Errorf("got:\n%v\nwant:\n%v", got.pid, want.pid)
+    }
+
+    if got.cmd != want.cmd {
+        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+    }
+
+    if got.cmd != want.cmd {
+        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+    }
+
+    if got.restart != want.restart {
+        t.Errorf("got:\n%q\nwant:\n%q", got.restart, want.restart)
+    }
+
+    assertList(t, got.deps, want.deps)
+}
+
+func assertChecks(t *testing.T, got, want *Checks) {
+    t.Helper()
+
+    if got.cmd != want.cmd {
+        t.Errorf("got:\n%q\nwant:\n%q", got.cmd, want.cmd)
+    }
+
+    assertList(t, got.tcpPorts, want.tcpPorts)
+    assertList(t, got.udpPorts, want.udpPorts)
+}
+
+func assertString(t *testing.T, got, want string) {
+    t.Helper()
+
+    if got != want {
+        t.Errorf("got:\n%q\nwant:\n%q", got, want)
+    }
+}
+
+func assertList(t *testing.T, got, want []string) {
+    t.Helper()
+
+    if len(want) != len(got) {
+        t.Errorf("got:\n%v\nwant:\n%v", got, want)
+    }
+
+    n := len(want)
+    for i := 0; i < n; i++ {
+        if got[i] != want[i] {
+            t.Errorf("got:\n%v\nwant:\n%v", got, want)
+        }
+    }
+}
+
+func assertError(t *testing.T, err error, want string) {
+    t.Helper()
+
+    if err == nil {
+        t.Fatal("Expected Error: open uknown_file: no such file or directory")
+    }
+
+    if err.Error() != want {
+        t.Errorf("got:\n%q\nwant:\n%q", err.Error(), want)
+    }
+}
+
+func assertGraph(t *testing.T, got, want map[string][]string) {
+    t.Helper()
+
+    for key, value := range got {
+        assertList(t, value, want[key])
+    }
+}
+
+func assertTopSortResult(t *testing.T, foreman *Foreman, got []string) {
+	t.Helper()
+
+	nodesSet := make(map[string]any)
+	for _, dep := range got {
+		for _, depDep := range foreman.services[dep].deps {
+			if _, ok := nodesSet[depDep]; !ok {
+				t.Fatalf("not expected to run %v before %v", dep, depDep)
+			}
+		}
+		nodesSet[dep] = 1
+	}
+}
+
+// Simulates processes so lifecycle logic can be tested without real subprocesses.
+type fakeRunner struct {
+    mu sync.Mutex
+    started []int
+    exits map[int]chan int
+    killedBy map[int]syscall.Signal
+}
+
+func newFakeRunner() *fakeRunner {
+    return &fakeRunner{
+        exits: make(map[int]chan int),
+        killedBy: make(map[int]syscall.Signal),
+    }
+}
+
+func (r *fakeRunner) Start(cmd *exec.Cmd) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    pid := len(r.started) + 1
+    r.started = append(r.started, pid)
+    r.exits[pid] = make(chan int, 1)
+    return pid, nil
+}
+
+func (r *fakeRunner) Signal(pid int, sig syscall.Signal) error {
+    if sig == 0 {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        if _, alive := r.exits[pid]; !alive {
+            return syscall.ESRCH
+        }
+        return nil
+    }
+
+    r.mu.Lock()
+    if _, killed := r.killedBy[pid]; !killed {
+        r.killedBy[pid] = sig
+    }
+    r.mu.Unlock()
+    r.exit(pid, 128+int(sig))
+    return nil
+}
+
+func (r *fakeRunner) Wait(pid int) (int, error) {
+    code, _, err := r.WaitSignal(pid)
+    return code, err
+}
+
+func (r *fakeRunner) WaitSignal(pid int) (int, syscall.Signal, error) {
+    r.mu.Lock()
+    exit := r.exits[pid]
+    r.mu.Unlock()
+
+    code := <-exit
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.exits, pid)
+    sig := r.killedBy[pid]
+    if code != 128+int(sig) {
+        // It exited on its own before the signal was delivered.
+        sig = 0
+    }
+    return code, sig, nil
+}
+
+// Make a simulated process exit with the given code.
+func (r *fakeRunner) exit(pid, code int) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    select {
+    case r.exits[pid] <- code:
+    default:
+    }
+}
+
+func (r *fakeRunner) startedPids() []int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]int{}, r.started...)
+}
+
+// A clock that only moves forward when the test advances it.
+type fakeClock struct {
+    mu sync.Mutex
+    now time.Time
+    waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+    duration time.Duration
+    deadline time.Time
+    fired chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+    return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    fired := make(chan time.Time, 1)
+    c.waiters = append(c.waiters, fakeWaiter{duration: d, deadline: c.now.Add(d), fired: fired})
+    return fired
+}
+
+// Move the clock forward, firing every waiter whose deadline has passed.
+func (c *fakeClock) advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.now = c.now.Add(d)
+    var pending []fakeWaiter
+    for _, waiter := range c.waiters {
+        if waiter.deadline.After(c.now) {
+            pending = append(pending, waiter)
+        } else {
+            waiter.fired <- c.now
+        }
+    }
+    c.waiters = pending
+}
+
+// Block until something waits on the clock for the given duration.
+func (c *fakeClock) waitForAfter(t *testing.T, d time.Duration) {
+    t.Helper()
+
+    deadline := time.Now().Add(time.Second)
+    for time.Now().Before(deadline) {
+        c.mu.Lock()
+        for _, waiter := range c.waiters {
+            if waiter.duration == d {
+                c.mu.Unlock()
+                return
+            }
+        }
+        c.mu.Unlock()
+        time.Sleep(5 * time.Millisecond)
+    }
+    t.Fatalf("timed out waiting for a %v timer", d)
+}
+
+type recordLogger chan LogRecord
+
+func (l recordLogger) Log(record LogRecord) {
+    l <- record
 }
@@ -0,0 +1,124 @@
+package foreman
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+    forwardBatchSize = 100
+    forwardFlushInterval = time.Second
+    forwardRetries = 3
+    forwardRetryDelay = time.Second
+    forwardStopTimeout = 5 * time.Second
+)
+
+// LogOutput receives the service output lines forwarded by foreman, in batches.
+type LogOutput interface {
+    Send(lines []LogLine) error
+}
+
+// Forward the output lines of every service to output, like NewFluentdOutput or
+// NewHTTPOutput, while Start or Run runs. Lines are sent in batches of up to
+// 100 lines or every second, and a failed batch is retried 3 times before
+// being dropped. Lines are dropped too while output falls behind, rather than
+// blocking the services.
+func WithLogForwarding(output LogOutput) Option {
+    return func(f *Foreman) {
+        f.logOutputs = append(f.logOutputs, output)
+    }
+}
+
+// Start forwarding output lines to every log output. The returned function
+// sends the pending lines and waits for the forwarders, up to forwardStopTimeout.
+func (f *Foreman) startForwarding() func() {
+    var wg sync.WaitGroup
+    stop := make(chan struct{})
+    for _, output := range f.logOutputs {
+        lines, cancel := f.SubscribeLogs("")
+        wg.Add(1)
+        go func(output LogOutput) {
+            defer wg.Done()
+            defer cancel()
+            f.forwardLogs(output, lines, stop)
+        }(output)
+    }
+
+    return func() {
+        close(stop)
+        forwarded := make(chan struct{})
+        go func() {
+            wg.Wait()
+            close(forwarded)
+        }()
+        select {
+        case <-forwarded:
+        case <-f.clock.After(forwardStopTimeout):
+        }
+    }
+}
+
+// Batch the lines sent to output until stop is closed, then send what is left.
+func (f *Foreman) forwardLogs(output LogOutput, lines <-chan LogLine, stop <-chan struct{}) {
+    var batch []LogLine
+    flush := func() {
+        if len(batch) > 0 {
+            f.sendBatch(output, batch, stop)
+            batch = nil
+        }
+    }
+
+    timeout := f.clock.After(forwardFlushInterval)
+    for {
+        select {
+        case line := <-lines:
+            batch = append(batch, line)
+            if len(batch) >= forwardBatchSize {
+                flush()
+            }
+        case <-timeout:
+            flush()
+            timeout = f.clock.After(forwardFlushInterval)
+        case <-stop:
+            for len(lines) > 0 {
+                batch = append(batch, <-lines)
+            }
+            flush()
+            return
+        }
+    }
+}
+
+// Send a batch, retrying with a doubling delay unless foreman is stopping.
+func (f *Foreman) sendBatch(output LogOutput, batch []LogLine, stop <-chan struct{}) {
+    delay := forwardRetryDelay
+    for attempt := 0; ; attempt++ {
+        err := output.Send(batch)
+        if err == nil {
+            return
+        }
+
+        if attempt == forwardRetries {
+            f.log(LogRecord{
+            	Level:   LevelError,
+            	Event:   "log forwarding failed",
+            	Message: fmt.Sprintf("dropped %d output lines: %v", len(batch), err),
+            })
+            return
+        }
+        f.log(LogRecord{
+        	Level:   LevelWarning,
+        	Event:   "log forwarding failed",
+        	Message: fmt.Sprintf("forwarding output failed, retrying in %v: %v", delay, err),
+        })
+
+        select {
+        case <-f.clock.After(delay):
+        case <-stop:
+            // Make a last attempt without waiting.
+            attempt = forwardRetries - 1
+        }
+        delay *= 2
+    }
+}
@@ -0,0 +1,129 @@
+package foreman
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const fluentdDialTimeout = 5 * time.Second
+
+// FluentdOutput forwards output lines to Fluentd or Fluent Bit with the forward
+// protocol. Every batch is sent as one message per service tagged tag.service,
+// with records holding the service name and the line under "log".
+type FluentdOutput struct {
+    address string
+    tag string
+
+    mu sync.Mutex
+    conn net.Conn
+}
+
+// Create an output forwarding to the forward input listening on address, like localhost:24224.
+func NewFluentdOutput(address, tag string) *FluentdOutput {
+    return &FluentdOutput{address: address, tag: tag}
+}
+
+func (o *FluentdOutput) Send(lines []LogLine) error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    if o.conn == nil {
+        conn, err := net.DialTimeout("tcp", o.address, fluentdDialTimeout)
+        if err != nil {
+            return err
+        }
+        o.conn = conn
+    }
+
+    writer := bufio.NewWriter(o.conn)
+    var services []string
+    byService := make(map[string][]LogLine)
+    for _, line := range lines {
+        if _, ok := byService[line.Service]; !ok {
+            services = append(services, line.Service)
+        }
+        byService[line.Service] = append(byService[line.Service], line)
+    }
+    for _, serviceName := range services {
+        writeFluentdMessage(writer, o.tag+"."+serviceName, byService[serviceName])
+    }
+
+    err := writer.Flush()
+    if err != nil {
+        // Reconnect on the next batch.
+        o.conn.Close()
+        o.conn = nil
+    }
+    return err
+}
+
+// Write a forward mode message: [tag, [[time, record], ...]].
+func writeFluentdMessage(w *bufio.Writer, tag string, lines []LogLine) {
+    writeMsgpackArray(w, 2)
+    writeMsgpackString(w, tag)
+    writeMsgpackArray(w, len(lines))
+    for _, line := range lines {
+        writeMsgpackArray(w, 2)
+        writeMsgpackEventTime(w, line.Time)
+        writeMsgpackMap(w, 2)
+        writeMsgpackString(w, "service")
+        writeMsgpackString(w, line.Service)
+        writeMsgpackString(w, "log")
+        writeMsgpackString(w, line.Line)
+    }
+}
+
+func writeMsgpackArray(w *bufio.Writer, n int) {
+    switch {
+    case n < 16:
+        w.WriteByte(0x90 | byte(n))
+    case n <= 0xffff:
+        w.WriteByte(0xdc)
+        binary.Write(w, binary.BigEndian, uint16(n))
+    default:
+        w.WriteByte(0xdd)
+        binary.Write(w, binary.BigEndian, uint32(n))
+    }
+}
+
+func writeMsgpackMap(w *bufio.Writer, n int) {
+    switch {
+    case n < 16:
+        w.WriteByte(0x80 | byte(n))
+    case n <= 0xffff:
+        w.WriteByte(0xde)
+        binary.Write(w, binary.BigEndian, uint16(n))
+    default:
+        w.WriteByte(0xdf)
+        binary.Write(w, binary.BigEndian, uint32(n))
+    }
+}
+
+func writeMsgpackString(w *bufio.Writer, s string) {
+    n := len(s)
+    switch {
+    case n < 32:
+        w.WriteByte(0xa0 | byte(n))
+    case n <= 0xff:
+        w.WriteByte(0xd9)
+        w.WriteByte(byte(n))
+    case n <= 0xffff:
+        w.WriteByte(0xda)
+        binary.Write(w, binary.BigEndian, uint16(n))
+    default:
+        w.WriteByte(0xdb)
+        binary.Write(w, binary.BigEndian, uint32(n))
+    }
+    w.WriteString(s)
+}
+
+// Write the EventTime extension of the forward protocol, seconds and nanoseconds.
+func writeMsgpackEventTime(w *bufio.Writer, t time.Time) {
+    w.WriteByte(0xd7)
+    w.WriteByte(0x00)
+    binary.Write(w, binary.BigEndian, uint32(t.Unix()))
+    binary.Write(w, binary.BigEndian, uint32(t.Nanosecond()))
+}
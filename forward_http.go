@@ -0,0 +1,50 @@
+package foreman
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const httpOutputTimeout = 10 * time.Second
+
+// HTTPOutput forwards output lines to an HTTP endpoint, POSTing every batch as
+// a JSON array of {"time", "service", "line"} objects.
+type HTTPOutput struct {
+    url string
+    client *http.Client
+}
+
+type httpLogLine struct {
+    Time time.Time `json:"time"`
+    Service string `json:"service"`
+    Line string `json:"line"`
+}
+
+// Create an output POSTing to url.
+func NewHTTPOutput(url string) *HTTPOutput {
+    return &HTTPOutput{url: url, client: &http.Client{Timeout: httpOutputTimeout}}
+}
+
+func (o *HTTPOutput) Send(lines []LogLine) error {
+    batch := make([]httpLogLine, len(lines))
+    for i, line := range lines {
+        batch[i] = httpLogLine{Time: line.Time, Service: line.Service, Line: line.Line}
+    }
+    body, err := json.Marshal(batch)
+    if err != nil {
+        return err
+    }
+
+    response, err := o.client.Post(o.url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    response.Body.Close()
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return fmt.Errorf("POST %s: %s", o.url, response.Status)
+    }
+    return nil
+}
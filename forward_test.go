@@ -0,0 +1,141 @@
+package foreman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwarding(t *testing.T) {
+    t.Run("send the pending lines on stop", func(t *testing.T) {
+        output := &fakeLogOutput{}
+        foreman, _ := New(testChainProcfile, WithClock(newFakeClock()), WithLogForwarding(output))
+
+        stop := foreman.startForwarding()
+        foreman.publishLog("backend", "one")
+        foreman.publishLog("frontend", "two")
+        stop()
+
+        assertList(t, output.sent(), []string{"backend one, frontend two"})
+    })
+
+    t.Run("retry a failed batch", func(t *testing.T) {
+        output := &fakeLogOutput{failures: 1}
+        foreman, _ := New(testChainProcfile, WithClock(newFakeClock()), WithLogForwarding(output), WithLogger(log.New(io.Discard, "", 0)))
+
+        stop := foreman.startForwarding()
+        foreman.publishLog("backend", "one")
+        stop()
+
+        assertList(t, output.sent(), []string{"backend one"})
+        assertString(t, fmt.Sprint(output.calls), "2")
+    })
+}
+
+func TestHTTPOutput(t *testing.T) {
+    var body []byte
+    status := http.StatusOK
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ = io.ReadAll(r.Body)
+        w.WriteHeader(status)
+    }))
+    defer server.Close()
+
+    output := NewHTTPOutput(server.URL)
+    lines := []LogLine{{Service: "web", Time: time.Unix(0, 0).UTC(), Line: "listening"}}
+
+    err := output.Send(lines)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assertString(t, string(body), `[{"time":"1970-01-01T00:00:00Z","service":"web","line":"listening"}]`)
+
+    status = http.StatusServiceUnavailable
+    err = output.Send(lines)
+    assertError(t, err, fmt.Sprintf("POST %s: 503 Service Unavailable", server.URL))
+}
+
+func TestFluentdOutput(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer listener.Close()
+
+    received := make(chan []byte)
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        buf := make([]byte, 1024)
+        n, _ := conn.Read(buf)
+        received <- buf[:n]
+    }()
+
+    output := NewFluentdOutput(listener.Addr().String(), "foreman")
+    err = output.Send([]LogLine{{Service: "web", Time: time.Unix(1, 2), Line: "hi"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := []byte{0x92, 0xab}
+    want = append(want, "foreman.web"...)
+    want = append(want, 0x91, 0x92, 0xd7, 0x00, 0, 0, 0, 1, 0, 0, 0, 2, 0x82, 0xa7)
+    want = append(want, "service"...)
+    want = append(want, 0xa3)
+    want = append(want, "web"...)
+    want = append(want, 0xa3)
+    want = append(want, "log"...)
+    want = append(want, 0xa2)
+    want = append(want, "hi"...)
+
+    select {
+    case got := <-received:
+        if !bytes.Equal(got, want) {
+            t.Errorf("got:\n% x\nwant:\n% x", got, want)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("timed out waiting for the forwarded message")
+    }
+}
+
+// fakeLogOutput records the batches it is sent, failing the first failures calls.
+type fakeLogOutput struct {
+    mu sync.Mutex
+    failures int
+    calls int
+    batches []string
+}
+
+func (o *fakeLogOutput) Send(lines []LogLine) error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    o.calls++
+    if o.calls <= o.failures {
+        return errors.New("unavailable")
+    }
+    var batch []string
+    for _, line := range lines {
+        batch = append(batch, line.Service+" "+line.Line)
+    }
+    o.batches = append(o.batches, strings.Join(batch, ", "))
+    return nil
+}
+
+func (o *fakeLogOutput) sent() []string {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return o.batches
+}
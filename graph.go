@@ -0,0 +1,116 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Colors of the states in rendered graphs.
+var stateColors = map[State]string{
+    StateStarting:   "gold",
+    StateRestarting: "gold",
+    StateHealthy:    "palegreen",
+    StateFailed:     "salmon",
+    StateCrashed:    "salmon",
+    StateStopped:    "lightgrey",
+    StateDisabled:   "lightgrey",
+    StatePaused:     "lightblue",
+}
+
+// Render the dependency graph in Graphviz DOT, with an edge from every service to
+// each of its dependencies labeled with the condition waited for. Services are
+// filled with the color of their state in status, which may be nil.
+func (f *Foreman) DOT(status map[string]ServiceStatus) string {
+    services := f.snapshot()
+
+    var dot strings.Builder
+    dot.WriteString("digraph foreman {\n    node [shape=box, style=\"rounded,filled\", fillcolor=white];\n")
+    for _, serviceName := range sortedServiceNames(services) {
+        service := services[serviceName]
+        attributes := []string{}
+        if serviceStatus, ok := status[serviceName]; ok {
+            attributes = append(attributes, fmt.Sprintf("label=%q", serviceName+"\n"+serviceStatus.State.String()))
+            if color, ok := stateColors[serviceStatus.State]; ok {
+                attributes = append(attributes, "fillcolor="+color)
+            }
+        }
+        if !service.enabled {
+            attributes = append(attributes, `style="rounded,dashed"`)
+        }
+
+        fmt.Fprintf(&dot, "    %q", serviceName)
+        if len(attributes) > 0 {
+            fmt.Fprintf(&dot, " [%s]", strings.Join(attributes, ", "))
+        }
+        dot.WriteString(";\n")
+    }
+
+    for _, serviceName := range sortedServiceNames(services) {
+        service := services[serviceName]
+        for _, depName := range service.deps {
+            fmt.Fprintf(&dot, "    %q -> %q", serviceName, depName)
+            if condition := service.depConditions[depName]; condition != "" && condition != DepStarted {
+                fmt.Fprintf(&dot, " [label=%q]", condition)
+            }
+            dot.WriteString(";\n")
+        }
+    }
+
+    dot.WriteString("}\n")
+    return dot.String()
+}
+
+// Render the dependency graph as a Mermaid flowchart, like DOT.
+func (f *Foreman) Mermaid(status map[string]ServiceStatus) string {
+    services := f.snapshot()
+    names := sortedServiceNames(services)
+    ids := make(map[string]string, len(names))
+    for i, serviceName := range names {
+        ids[serviceName] = fmt.Sprintf("s%d", i)
+    }
+
+    var mermaid strings.Builder
+    mermaid.WriteString("flowchart TD\n")
+    for _, serviceName := range names {
+        label := serviceName
+        if serviceStatus, ok := status[serviceName]; ok {
+            label += "<br/>" + serviceStatus.State.String()
+        }
+        fmt.Fprintf(&mermaid, "    %s[\"%s\"]\n", ids[serviceName], strings.ReplaceAll(label, `"`, "#quot;"))
+    }
+
+    for _, serviceName := range names {
+        service := services[serviceName]
+        for _, depName := range service.deps {
+            depID, ok := ids[depName]
+            if !ok {
+                continue
+            }
+            if condition := service.depConditions[depName]; condition != "" && condition != DepStarted {
+                fmt.Fprintf(&mermaid, "    %s -->|%s| %s\n", ids[serviceName], condition, depID)
+                continue
+            }
+            fmt.Fprintf(&mermaid, "    %s --> %s\n", ids[serviceName], depID)
+        }
+    }
+
+    for _, serviceName := range names {
+        if serviceStatus, ok := status[serviceName]; ok {
+            if color, ok := stateColors[serviceStatus.State]; ok {
+                fmt.Fprintf(&mermaid, "    style %s fill:%s\n", ids[serviceName], color)
+            }
+        }
+    }
+
+    return mermaid.String()
+}
+
+func sortedServiceNames(services map[string]Service) []string {
+    names := make([]string, 0, len(services))
+    for serviceName := range services {
+        names = append(names, serviceName)
+    }
+    sort.Strings(names)
+    return names
+}
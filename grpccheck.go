@@ -0,0 +1,68 @@
+package foreman
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// GRPCCheck is a check of the grpc.health.v1 health checking protocol.
+type GRPCCheck struct {
+    // Address is the host:port of the gRPC server.
+    Address string `yaml:"address"`
+    // Service is the name of the service asked about, empty for the whole server.
+    Service string `yaml:"service"`
+}
+
+// Parse a gRPC check, either an address or a mapping of address and service.
+func parseGRPCCheck(field string, value any) (GRPCCheck, error) {
+    if address, ok := value.(string); ok && address != "" {
+        return GRPCCheck{Address: address}, nil
+    }
+
+    checkMap, ok := value.(map[string]any)
+    if !ok {
+        return GRPCCheck{}, fieldError(field, "expected an address or a mapping of address and service, got %v", value)
+    }
+    var check GRPCCheck
+    var err error
+    for key, value := range checkMap {
+        switch key {
+        case "address":
+            check.Address, err = parseString(field+".address", value)
+        case "service":
+            check.Service, err = parseString(field+".service", value)
+        default:
+            err = fieldError(field+"."+key, "unknown key")
+        }
+        if err != nil {
+            return GRPCCheck{}, err
+        }
+    }
+    if check.Address == "" {
+        return GRPCCheck{}, fieldError(field+".address", "an address is required")
+    }
+    return check, nil
+}
+
+// Ask the gRPC server of the check whether its service is serving.
+func (s *Service) checkGRPC(ctx context.Context) error {
+    check := s.checks.grpc
+    conn, err := grpc.DialContext(ctx, check.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: check.Service})
+    if err != nil {
+        return err
+    }
+    if response.Status != healthpb.HealthCheckResponse_SERVING {
+        return fmt.Errorf("%s is %s", check.Address, response.Status)
+    }
+    return nil
+}
@@ -0,0 +1,52 @@
+package foreman
+
+import "time"
+
+// checkHistorySize is how many results of rounds of checks are kept per service.
+const checkHistorySize = 20
+
+// CheckResult is the outcome of a round of the checks of a service.
+type CheckResult struct {
+    Time time.Time
+    Passed bool
+    // Failed names the checks that failed in the round.
+    Failed []string
+}
+
+// checkHistory keeps the latest results of the checks of a service in a ring buffer.
+type checkHistory struct {
+    results [checkHistorySize]CheckResult
+    // next is where the next result goes, count how many results are kept.
+    next int
+    count int
+}
+
+func (h *checkHistory) add(result CheckResult) {
+    h.results[h.next] = result
+    h.next = (h.next + 1) % checkHistorySize
+    if h.count < checkHistorySize {
+        h.count++
+    }
+}
+
+// Return the kept results, oldest first.
+func (h *checkHistory) list() []CheckResult {
+    if h == nil || h.count == 0 {
+        return nil
+    }
+    results := make([]CheckResult, 0, h.count)
+    for i := h.next - h.count; i < h.next; i++ {
+        results = append(results, h.results[(i+checkHistorySize)%checkHistorySize])
+    }
+    return results
+}
+
+// Record the result of a round of the checks of a service.
+func (f *Foreman) recordCheckResult(serviceName string, result CheckResult) {
+    f.updateService(serviceName, func(service *Service) {
+        if service.checkHistory == nil {
+            service.checkHistory = &checkHistory{}
+        }
+        service.checkHistory.add(result)
+    })
+}
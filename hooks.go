@@ -0,0 +1,206 @@
+package foreman
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Lifecycle points the commands of a service's hooks run at.
+const (
+    hookPreStart = "pre_start"
+    hookPostStart = "post_start"
+    hookPreStop = "pre_stop"
+    hookPostStop = "post_stop"
+)
+
+// StartHook is called after a service process is launched.
+type StartHook func(service string, pid int)
+
+// ExitHook is called after a service process exits.
+type ExitHook func(service string, exitCode int)
+
+type lifecycleHooks struct {
+    mu sync.Mutex
+    start []startHook
+    stop []exitHook
+    crash []exitHook
+}
+
+// The services a hook applies to, nil meaning all of them.
+type hookFilter map[string]bool
+
+type startHook struct {
+    filter hookFilter
+    hook StartHook
+}
+
+type exitHook struct {
+    filter hookFilter
+    hook ExitHook
+}
+
+// Register a hook called when a service starts, for the given services or all of them.
+func (f *Foreman) OnStart(hook StartHook, services ...string) {
+    f.hooks.mu.Lock()
+    defer f.hooks.mu.Unlock()
+    f.hooks.start = append(f.hooks.start, startHook{filter: newHookFilter(services), hook: hook})
+}
+
+// Register a hook called when a service exits cleanly or is stopped by foreman,
+// for the given services or all of them.
+func (f *Foreman) OnStop(hook ExitHook, services ...string) {
+    f.hooks.mu.Lock()
+    defer f.hooks.mu.Unlock()
+    f.hooks.stop = append(f.hooks.stop, exitHook{filter: newHookFilter(services), hook: hook})
+}
+
+// Register a hook called when a service exits unexpectedly with a non-zero code,
+// for the given services or all of them.
+func (f *Foreman) OnCrash(hook ExitHook, services ...string) {
+    f.hooks.mu.Lock()
+    defer f.hooks.mu.Unlock()
+    f.hooks.crash = append(f.hooks.crash, exitHook{filter: newHookFilter(services), hook: hook})
+}
+
+func newHookFilter(services []string) hookFilter {
+    if len(services) == 0 {
+        return nil
+    }
+
+    filter := make(hookFilter)
+    for _, service := range services {
+        filter[service] = true
+    }
+    return filter
+}
+
+func (f hookFilter) matches(service string) bool {
+    return f == nil || f[service]
+}
+
+func (f *Foreman) runStartHooks(service string, pid int) {
+    f.hooks.mu.Lock()
+    hooks := f.hooks.start
+    f.hooks.mu.Unlock()
+
+    for _, h := range hooks {
+        if h.filter.matches(service) {
+            h.hook(service, pid)
+        }
+    }
+}
+
+func (f *Foreman) runExitHooks(service string, exitCode int, crashed bool) {
+    f.hooks.mu.Lock()
+    hooks := f.hooks.stop
+    if crashed {
+        hooks = f.hooks.crash
+    }
+    f.hooks.mu.Unlock()
+
+    for _, h := range hooks {
+        if h.filter.matches(service) {
+            h.hook(service, exitCode)
+        }
+    }
+}
+
+// CommandHooks are the commands a service runs at points of its lifecycle, with
+// its environment, working directory and shell.
+type CommandHooks struct {
+    // PreStart runs before the process is launched, which a failure aborts.
+    PreStart []string `yaml:"pre_start"`
+    // PostStart runs once the process is launched, alongside it.
+    PostStart []string `yaml:"post_start"`
+    // PreStop runs before the stop signal is sent.
+    PreStop []string `yaml:"pre_stop"`
+    // PostStop runs once the process exited, before it is restarted.
+    PostStop []string `yaml:"post_stop"`
+}
+
+// Parse hooks, a mapping of lifecycle points to a command or a list of commands.
+func parseCommandHooks(field string, value any) (CommandHooks, error) {
+    hooksMap, ok := value.(map[string]any)
+    if !ok {
+        return CommandHooks{}, fieldError(field, "expected a mapping of pre_start, post_start, pre_stop and post_stop, got %v", value)
+    }
+
+    var hooks CommandHooks
+    var err error
+    for key, value := range hooksMap {
+        switch key {
+        case hookPreStart:
+            hooks.PreStart, err = parseCommands(field+"."+key, value)
+        case hookPostStart:
+            hooks.PostStart, err = parseCommands(field+"."+key, value)
+        case hookPreStop:
+            hooks.PreStop, err = parseCommands(field+"."+key, value)
+        case hookPostStop:
+            hooks.PostStop, err = parseCommands(field+"."+key, value)
+        default:
+            err = fieldError(field+"."+key, "unknown key")
+        }
+        if err != nil {
+            return CommandHooks{}, err
+        }
+    }
+    return hooks, nil
+}
+
+// Parse a command line or a list of them.
+func parseCommands(field string, value any) ([]string, error) {
+    if cmd, ok := value.(string); ok {
+        return []string{cmd}, nil
+    }
+    cmds, err := parseStringList(field, value)
+    if err != nil {
+        return nil, fieldError(field, "expected a command or a list of commands, got %v", value)
+    }
+    return cmds, nil
+}
+
+func (h CommandHooks) commands(point string) []string {
+    switch point {
+    case hookPreStart:
+        return h.PreStart
+    case hookPostStart:
+        return h.PostStart
+    case hookPreStop:
+        return h.PreStop
+    case hookPostStop:
+        return h.PostStop
+    }
+    return nil
+}
+
+// Run the hook commands of a service for a lifecycle point in order, with the
+// output of the service, stopping at the first failing one.
+func (f *Foreman) runCommandHooks(service Service, point string) error {
+    for _, cmd := range service.hooks.commands(point) {
+        command := f.command(service.shell, cmd)
+        setServiceEnv(command, service.env)
+        command.Dir = service.cwd
+        outputFiles, err := f.attachOutput(command, service)
+        if err != nil {
+            return err
+        }
+        err = startChild(command)
+        closeFiles(outputFiles)
+        if err == nil {
+            err = waitChild(command)
+        }
+        if err != nil {
+            return fmt.Errorf("%s hook %q failed: %w", point, cmd, err)
+        }
+    }
+    return nil
+}
+
+// Run the hook commands of a service whose failure doesn't change its
+// course, logging why they failed.
+func (f *Foreman) runLoggedCommandHooks(service Service, point string) {
+    err := f.runCommandHooks(service, point)
+    if err != nil {
+        f.log(LogRecord{Level: LevelWarning, Event: "hook", Service: service.serviceName, PID: service.pid, Message: fmt.Sprintf("%s: %v", service.serviceName, err)})
+    }
+}
@@ -0,0 +1,29 @@
+package foreman
+
+import "os"
+
+// initForwardedSignals are the signals every service gets in init mode, on top
+// of SIGHUP which also reloads the Procfile.
+var initForwardedSignals = []os.Signal{sigUSR1, sigUSR2, sigWINCH}
+
+// Stop foreman once the main service exited on its own, whatever its restart
+// policy, keeping its exit code for Start to return.
+func (f *Foreman) mainServiceExited(exitCode int) {
+    f.mu.Lock()
+    f.mainExited = true
+    f.mainExitCode = exitCode
+    f.mu.Unlock()
+
+    f.Stop(defaultStopTimeout)
+}
+
+// Return the error Start returns once it stopped: a MainExitError when the
+// main service exited on its own with a non-zero exit code, nil otherwise.
+func (f *Foreman) mainExitError() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if !f.mainExited || f.mainExitCode == 0 {
+        return nil
+    }
+    return &MainExitError{Service: f.mainService, ExitCode: f.mainExitCode}
+}
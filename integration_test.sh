@@ -68,7 +68,7 @@ Clean() {
     kill -SIGINT $foreman
 }
 
-go build -o foreman foreman.go main.go procfile_parser.go
+go build -o foreman ./cmd/foreman
 
 TestRestartAfterTermination
 TestTerminateRunOnceService
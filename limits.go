@@ -0,0 +1,112 @@
+package foreman
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// limitWatch follows the resource usage of a service across rounds of checks.
+type limitWatch struct {
+    // proc keeps the CPU times of the previous round, to measure the CPU usage
+    // between rounds.
+    proc *process.Process
+    // exceededSince is when the limits started being exceeded, zero while they aren't.
+    exceededSince time.Time
+}
+
+// Report whether the checks set a memory, CPU or open files limit.
+func (c Checks) hasLimits() bool {
+    return c.maxMemory > 0 || c.maxCPUPercent > 0 || c.maxOpenFiles > 0
+}
+
+// Check the resource usage of pid against the limits of checks. It fails once
+// a limit is exceeded for the limit_duration of the checks, without a break.
+func (w *limitWatch) check(pid int, checks Checks, now time.Time) error {
+    if w.proc == nil || int(w.proc.Pid) != pid {
+        proc, err := process.NewProcess(int32(pid))
+        if err != nil {
+            return err
+        }
+        w.proc, w.exceededSince = proc, time.Time{}
+    }
+
+    err := w.exceeded(checks)
+    if err == nil {
+        w.exceededSince = time.Time{}
+        return nil
+    }
+    if w.exceededSince.IsZero() {
+        w.exceededSince = now
+    }
+    if now.Sub(w.exceededSince) < checks.limitDuration {
+        return nil
+    }
+    if checks.limitDuration > 0 {
+        return fmt.Errorf("%v for %s", err, now.Sub(w.exceededSince))
+    }
+    return err
+}
+
+// Return an error naming the first limit the process exceeds, if any.
+func (w *limitWatch) exceeded(checks Checks) error {
+    if checks.maxMemory > 0 {
+        memory, err := w.proc.MemoryInfo()
+        if err != nil {
+            return err
+        }
+        if int64(memory.RSS) > checks.maxMemory {
+            return fmt.Errorf("memory usage %d bytes exceeds %d", memory.RSS, checks.maxMemory)
+        }
+    }
+
+    if checks.maxCPUPercent > 0 {
+        // The CPU usage is measured from one round to the next, so the
+        // first measure of a process is always 0.
+        cpu, err := w.proc.Percent(0)
+        if err != nil {
+            return err
+        }
+        if cpu > checks.maxCPUPercent {
+            return fmt.Errorf("cpu usage %.1f%% exceeds %g%%", cpu, checks.maxCPUPercent)
+        }
+    }
+
+    if checks.maxOpenFiles > 0 {
+        fds, err := w.proc.NumFDs()
+        if err != nil {
+            return err
+        }
+        if int(fds) > checks.maxOpenFiles {
+            return fmt.Errorf("%d open files exceed %d", fds, checks.maxOpenFiles)
+        }
+    }
+    return nil
+}
+
+// Parse a positive percentage like max_cpu_percent, which may exceed 100 for
+// processes using several CPUs.
+func parsePercent(field string, value any) (float64, error) {
+    var percent float64
+    switch v := value.(type) {
+    case int:
+        percent = float64(v)
+    case float64:
+        percent = v
+    case string:
+        parsed, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return 0, fieldError(field, "expected a percentage, got %v", value)
+        }
+        percent = parsed
+    default:
+        return 0, fieldError(field, "expected a percentage, got %v", value)
+    }
+
+    if percent <= 0 {
+        return 0, fieldError(field, "percentage must be positive, got %v", value)
+    }
+    return percent, nil
+}
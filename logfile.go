@@ -0,0 +1,195 @@
+package foreman
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultMaxLogFiles = 5
+
+// logFile is the log_file of a service, shared by the output streams of its
+// processes. It is open while a stream uses it and rotated once it grows past
+// maxSize, keeping maxFiles gzipped files named path.1.gz, the newest, to path.N.gz.
+type logFile struct {
+    mu sync.Mutex
+    path string
+    maxSize int64
+    maxFiles int
+    users int
+    file *os.File
+    size int64
+}
+
+type logFiles struct {
+    mu sync.Mutex
+    files map[string]*logFile
+}
+
+// Open the log file of a service for one more output stream.
+func (f *Foreman) acquireLogFile(service Service) (*logFile, error) {
+    f.logFiles.mu.Lock()
+    if f.logFiles.files == nil {
+        f.logFiles.files = make(map[string]*logFile)
+    }
+    file, ok := f.logFiles.files[service.serviceName]
+    if !ok || file.path != service.logFile {
+        file = &logFile{path: service.logFile}
+        f.logFiles.files[service.serviceName] = file
+    }
+    f.logFiles.mu.Unlock()
+
+    maxFiles := service.maxLogFiles
+    if maxFiles == 0 {
+        maxFiles = defaultMaxLogFiles
+    }
+    return file, file.acquire(service.maxLogSize, maxFiles)
+}
+
+// Return the open log file of a service, nil if it has none.
+func (f *Foreman) serviceLogFile(serviceName string) *logFile {
+    f.logFiles.mu.Lock()
+    defer f.logFiles.mu.Unlock()
+    return f.logFiles.files[serviceName]
+}
+
+func (l *logFile) acquire(maxSize int64, maxFiles int) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.maxSize, l.maxFiles = maxSize, maxFiles
+    if l.file == nil {
+        err := l.open()
+        if err != nil {
+            return err
+        }
+    }
+    l.users++
+    return nil
+}
+
+// Close the file once no stream uses it anymore.
+func (l *logFile) release() {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.users--
+    if l.users == 0 && l.file != nil {
+        l.file.Close()
+        l.file = nil
+    }
+}
+
+func (l *logFile) open() error {
+    err := os.MkdirAll(filepath.Dir(l.path), 0755)
+    if err != nil {
+        return err
+    }
+    file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+    l.file, l.size = file, info.Size()
+    return nil
+}
+
+// Append a line printed at time t, rotating the file first if the line doesn't
+// fit. A failed rotation is reported once the line is written to the current file.
+func (l *logFile) writeLine(t time.Time, line string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    entry := t.Format(time.RFC3339) + " " + line + "\n"
+    var rotateErr error
+    if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(entry)) > l.maxSize {
+        rotateErr = l.rotate()
+    }
+    if l.file == nil {
+        if rotateErr != nil {
+            return rotateErr
+        }
+        return fmt.Errorf("%s isn't open", l.path)
+    }
+
+    n, err := io.WriteString(l.file, entry)
+    l.size += int64(n)
+    if err != nil {
+        return err
+    }
+    return rotateErr
+}
+
+// Shift the rotated files, gzip the current file to path.1.gz and start a new
+// one. When that fails, the current file is reopened to keep logging to it.
+func (l *logFile) rotate() error {
+    err := l.file.Close()
+    l.file = nil
+    if err == nil {
+        err = l.shift()
+    }
+    if err != nil {
+        err = fmt.Errorf("rotating %s: %v", l.path, err)
+    }
+
+    openErr := l.open()
+    if err == nil {
+        err = openErr
+    }
+    return err
+}
+
+// Move the closed current file to path.1.gz, after shifting the older ones.
+func (l *logFile) shift() error {
+    os.Remove(l.rotatedPath(l.maxFiles))
+    for i := l.maxFiles - 1; i >= 1; i-- {
+        err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1))
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    err := gzipFile(l.path, l.rotatedPath(1))
+    if err != nil {
+        return err
+    }
+    return os.Remove(l.path)
+}
+
+func (l *logFile) rotatedPath(i int) string {
+    return fmt.Sprintf("%s.%d.gz", l.path, i)
+}
+
+func gzipFile(source, target string) error {
+    in, err := os.Open(source)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+
+    out, err := os.Create(target)
+    if err != nil {
+        return err
+    }
+    compressor := gzip.NewWriter(out)
+    _, err = io.Copy(compressor, in)
+    if err == nil {
+        err = compressor.Close()
+    }
+    closeErr := out.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(target)
+    }
+    return err
+}
@@ -0,0 +1,89 @@
+package foreman
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Levels of the records foreman logs.
+const (
+    LevelInfo = "info"
+    LevelWarning = "warning"
+    LevelError = "error"
+)
+
+// LogRecord is a message foreman logs about itself and its services.
+type LogRecord struct {
+    Time time.Time `json:"time"`
+    Level string `json:"level"`
+    // Event names what happened, like "started" or "restart limit reached".
+    Event string `json:"event"`
+    Service string `json:"service,omitempty"`
+    PID int `json:"pid,omitempty"`
+    // ExitCode is set for records about exited processes, and Signal when a
+    // signal killed them, like "SIGKILL".
+    ExitCode *int `json:"exit_code,omitempty"`
+    Signal string `json:"signal,omitempty"`
+    // Message is the record as a line of text.
+    Message string `json:"message"`
+}
+
+// Logger writes the records foreman logs, see WithStructuredLogger.
+type Logger interface {
+    Log(record LogRecord)
+}
+
+type textLogger struct {
+    logger *log.Logger
+}
+
+type jsonLogger struct {
+    mu sync.Mutex
+    encoder *json.Encoder
+}
+
+// Create a logger writing the message of every record as a line to logger.
+// It is the default, writing to stdout.
+func NewTextLogger(logger *log.Logger) Logger {
+    return textLogger{logger: logger}
+}
+
+// Create a logger writing every record to w as a JSON object on its own line.
+func NewJSONLogger(w io.Writer) Logger {
+    return &jsonLogger{encoder: json.NewEncoder(w)}
+}
+
+func (l textLogger) Log(record LogRecord) {
+    l.logger.Println(record.Message)
+}
+
+func (l *jsonLogger) Log(record LogRecord) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.encoder.Encode(record)
+}
+
+// Log a record stamped with the current time.
+func (f *Foreman) log(record LogRecord) {
+    record.Time = f.clock.Now()
+    if record.Level == "" {
+        record.Level = LevelInfo
+    }
+    f.logger.Log(record)
+}
+
+func exitCodeOf(code int) *int {
+    return &code
+}
+
+// Return the name of the signal that killed a process, empty when none did.
+func signalNameOf(sig syscall.Signal) string {
+    if sig == 0 {
+        return ""
+    }
+    return signalName(sig)
+}
@@ -0,0 +1,148 @@
+package foreman
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+    logSubscriberBufferSize = 256
+    logHistorySize = 1000
+)
+
+// LogLine is a single line of service output.
+type LogLine struct {
+    Service string
+    Time time.Time
+    Line string
+    seq uint64
+}
+
+// LogQuery selects the output lines of a service to read.
+type LogQuery struct {
+    // Since skips the lines printed before it, unless zero.
+    Since time.Time
+    // Tail keeps only the last Tail past lines, or all of them when negative.
+    Tail int
+    // Follow keeps reading the lines printed from now on.
+    Follow bool
+}
+
+type logSubscriber struct {
+    serviceName string
+    lines chan LogLine
+}
+
+type logSubscribers struct {
+    mu sync.Mutex
+    next int
+    seq uint64
+    subscribers map[int]logSubscriber
+    history map[string][]LogLine
+}
+
+// Subscribe to the output lines of a service, or of every service when
+// serviceName is empty. Only output printed by foreman is published, and lines
+// are dropped rather than blocking the service when the subscriber falls behind.
+// The returned function cancels the subscription.
+func (f *Foreman) SubscribeLogs(serviceName string) (<-chan LogLine, func()) {
+    f.logs.mu.Lock()
+    defer f.logs.mu.Unlock()
+    return f.subscribeLogs(serviceName)
+}
+
+// Return the last output lines of a service, or of every service when
+// serviceName is empty, oldest first. Up to logHistorySize lines are kept per service.
+func (f *Foreman) LogHistory(serviceName string, query LogQuery) []LogLine {
+    f.logs.mu.Lock()
+    defer f.logs.mu.Unlock()
+    return f.logHistory(serviceName, query)
+}
+
+// Return the past lines matching query and, when following, subscribe to the
+// next ones without missing or repeating a line in between.
+func (f *Foreman) queryLogs(serviceName string, query LogQuery) ([]LogLine, <-chan LogLine, func()) {
+    f.logs.mu.Lock()
+    defer f.logs.mu.Unlock()
+
+    history := f.logHistory(serviceName, query)
+    if !query.Follow {
+        return history, nil, func() {}
+    }
+    lines, cancel := f.subscribeLogs(serviceName)
+    return history, lines, cancel
+}
+
+// Subscribe to the output lines of a service. The caller holds f.logs.mu.
+func (f *Foreman) subscribeLogs(serviceName string) (<-chan LogLine, func()) {
+    if f.logs.subscribers == nil {
+        f.logs.subscribers = make(map[int]logSubscriber)
+    }
+    id := f.logs.next
+    f.logs.next++
+    lines := make(chan LogLine, logSubscriberBufferSize)
+    f.logs.subscribers[id] = logSubscriber{serviceName: serviceName, lines: lines}
+
+    var once sync.Once
+    cancel := func() {
+        once.Do(func() {
+            f.logs.mu.Lock()
+            defer f.logs.mu.Unlock()
+            delete(f.logs.subscribers, id)
+            close(lines)
+        })
+    }
+    return lines, cancel
+}
+
+// Collect the past lines matching query. The caller holds f.logs.mu.
+func (f *Foreman) logHistory(serviceName string, query LogQuery) []LogLine {
+    var history []LogLine
+    for lineService, lines := range f.logs.history {
+        if serviceName != "" && lineService != serviceName {
+            continue
+        }
+        for _, line := range lines {
+            if !line.Time.Before(query.Since) {
+                history = append(history, line)
+            }
+        }
+    }
+
+    sort.Slice(history, func(i, j int) bool {
+        return history[i].seq < history[j].seq
+    })
+    if query.Tail >= 0 && len(history) > query.Tail {
+        history = history[len(history)-query.Tail:]
+    }
+    return history
+}
+
+// Record an output line and publish it to the matching subscribers without blocking.
+func (f *Foreman) publishLog(serviceName, line string) {
+    f.logs.mu.Lock()
+    defer f.logs.mu.Unlock()
+
+    f.logs.seq++
+    logLine := LogLine{Service: serviceName, Time: f.clock.Now(), Line: line, seq: f.logs.seq}
+
+    if f.logs.history == nil {
+        f.logs.history = make(map[string][]LogLine)
+    }
+    history := append(f.logs.history[serviceName], logLine)
+    if len(history) > logHistorySize {
+        history = history[len(history)-logHistorySize:]
+    }
+    f.logs.history[serviceName] = history
+
+    for _, subscriber := range f.logs.subscribers {
+        if subscriber.serviceName != "" && subscriber.serviceName != serviceName {
+            continue
+        }
+        select {
+        case subscriber.lines <- logLine:
+        default:
+        }
+    }
+}
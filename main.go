@@ -1,13 +0,0 @@
-package main
-
-func main() {
-    foreman, err := New("./Procfile")
-    if err != nil {
-        panic(err)
-    }
-
-    err = foreman.Start()
-    if err != nil {
-        panic(err)
-    }
-}
@@ -0,0 +1,168 @@
+package foreman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DNSCheck is a name that must resolve, to the expected addresses when given.
+type DNSCheck struct {
+    Name string `yaml:"name"`
+    Expect []string `yaml:"expect"`
+}
+
+// Parse dns checks, a name, a mapping of name and expected addresses, or a
+// list of them.
+func parseDNSChecks(field string, value any) ([]DNSCheck, error) {
+    list, ok := value.([]any)
+    if !ok {
+        list = []any{value}
+    }
+
+    var checks []DNSCheck
+    for _, item := range list {
+        if name, ok := item.(string); ok && name != "" {
+            checks = append(checks, DNSCheck{Name: name})
+            continue
+        }
+        checkMap, ok := item.(map[string]any)
+        if !ok {
+            return nil, fieldError(field, "expected a name or a mapping of name and expect, got %v", item)
+        }
+        var check DNSCheck
+        var err error
+        for key, value := range checkMap {
+            switch key {
+            case "name":
+                check.Name, err = parseString(field+".name", value)
+            case "expect":
+                check.Expect, err = parseStringList(field+".expect", value)
+            default:
+                err = fieldError(field+"."+key, "unknown key")
+            }
+            if err != nil {
+                return nil, err
+            }
+        }
+        if check.Name == "" {
+            return nil, fieldError(field+".name", "a name is required")
+        }
+        checks = append(checks, check)
+    }
+    return checks, nil
+}
+
+// Resolve the names of the dns checks and compare them with their expected addresses.
+func (s *Service) checkDNS(ctx context.Context) error {
+    for _, check := range s.checks.dns {
+        addresses, err := net.DefaultResolver.LookupHost(ctx, check.Name)
+        if err != nil {
+            return err
+        }
+
+        resolved := make(map[string]bool, len(addresses))
+        for _, address := range addresses {
+            resolved[address] = true
+        }
+        for _, expected := range check.Expect {
+            if !resolved[expected] {
+                return fmt.Errorf("%s resolves to %v, expected %s", check.Name, addresses, expected)
+            }
+        }
+    }
+    return nil
+}
+
+// Ping the hosts of the ping checks: host:port entries must accept a tcp
+// connection, and hosts must answer an ICMP echo.
+func (s *Service) checkPing(ctx context.Context) error {
+    var dialer net.Dialer
+    for _, target := range s.checks.ping {
+        var err error
+        if _, _, splitErr := net.SplitHostPort(target); splitErr == nil {
+            var conn net.Conn
+            conn, err = dialer.DialContext(ctx, "tcp", target)
+            if err == nil {
+                conn.Close()
+            }
+        } else {
+            err = ping(ctx, target)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Send an ICMP echo to host and wait for its reply until ctx expires.
+// Unprivileged ping sockets are used when the system allows them, raw sockets
+// otherwise.
+func ping(ctx context.Context, host string) error {
+    addr, err := net.ResolveIPAddr("ip", host)
+    if err != nil {
+        return err
+    }
+
+    network, rawNetwork, protocol := "udp4", "ip4:icmp", 1
+    var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+    if addr.IP.To4() == nil {
+        network, rawNetwork, protocol = "udp6", "ip6:ipv6-icmp", 58
+        echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+    }
+
+    var target net.Addr = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+    raw := false
+    conn, err := icmp.ListenPacket(network, "")
+    if err != nil {
+        target, raw = addr, true
+        conn, err = icmp.ListenPacket(rawNetwork, "")
+    }
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    id := os.Getpid() & 0xffff
+    request, err := (&icmp.Message{
+    	Type: echoType,
+    	Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("foreman")},
+    }).Marshal(nil)
+    if err != nil {
+        return err
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    _, err = conn.WriteTo(request, target)
+    if err != nil {
+        return err
+    }
+
+    reply := make([]byte, 1500)
+    for {
+        n, _, err := conn.ReadFrom(reply)
+        var netErr net.Error
+        if errors.As(err, &netErr) && netErr.Timeout() {
+            return fmt.Errorf("%s didn't answer the ping in time", host)
+        }
+        if err != nil {
+            return err
+        }
+        message, err := icmp.ParseMessage(protocol, reply[:n])
+        if err != nil || message.Type != replyType {
+            continue
+        }
+        // Unprivileged sockets rewrite the id, raw ones see every reply.
+        if echo, ok := message.Body.(*icmp.Echo); ok && echo.Seq == 1 && (!raw || echo.ID == id) {
+            return nil
+        }
+    }
+}
@@ -0,0 +1,35 @@
+package foreman
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Run start on a thread of its own with no_new_privs set, which the process it
+// forks inherits: setuid binaries and file capabilities can't raise its
+// privileges anymore. The flag can't be cleared, so the thread is discarded.
+func startWithNoNewPrivs(noNewPrivs bool, start func() (int, error)) (int, error) {
+    if !noNewPrivs {
+        return start()
+    }
+
+    type result struct {
+        pid int
+        err error
+    }
+    started := make(chan result, 1)
+    go func() {
+        // The goroutine exits with the thread locked, which terminates it.
+        runtime.LockOSThread()
+        err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+        if err != nil {
+            started <- result{err: err}
+            return
+        }
+        pid, err := start()
+        started <- result{pid: pid, err: err}
+    }()
+    r := <-started
+    return r.pid, r.err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package foreman
+
+import "errors"
+
+func startWithNoNewPrivs(noNewPrivs bool, start func() (int, error)) (int, error) {
+    if noNewPrivs {
+        return 0, errors.New("no_new_privs is only supported on Linux")
+    }
+    return start()
+}
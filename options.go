@@ -0,0 +1,281 @@
+package foreman
+
+import (
+	"io"
+	"log"
+	"time"
+)
+
+// Option configures optional behaviour of a Foreman created by New.
+type Option func(*Foreman)
+
+// Resolve relative paths in the Procfile against foreman's own working
+// directory instead of the directory containing the Procfile.
+func WithoutProcfileRelativePaths() Option {
+    return func(f *Foreman) {
+        f.procfileRelative = false
+    }
+}
+
+// Limit how many services are stopped concurrently during shutdown.
+func WithShutdownParallelism(n int) Option {
+    return func(f *Foreman) {
+        if n > 0 {
+            f.shutdownParallelism = n
+        }
+    }
+}
+
+// Print a warning instead of failing when an enabled service depends on a disabled one.
+func WithDisabledDependencyWarnings() Option {
+    return func(f *Foreman) {
+        f.warnDisabledDeps = true
+    }
+}
+
+// Launch and control service processes through a custom Runner.
+func WithRunner(runner Runner) Option {
+    return func(f *Foreman) {
+        f.runner = runner
+    }
+}
+
+// Truncate service output lines longer than n bytes.
+func WithMaxLineLength(n int) Option {
+    return func(f *Foreman) {
+        if n > 0 {
+            f.maxLineLength = n
+        }
+    }
+}
+
+// Use a custom Clock for delays, mainly useful in tests.
+func WithClock(clock Clock) Option {
+    return func(f *Foreman) {
+        f.clock = clock
+    }
+}
+
+// Skip the given check categories (CheckCmd, CheckTCP, CheckUDP, CheckGRPC, CheckFile,
+// CheckNetwork, CheckDNS, CheckPing, CheckLimits, CheckCustom) for every service.
+func WithDisabledChecks(categories ...string) Option {
+    return func(f *Foreman) {
+        for _, category := range categories {
+            f.disabledChecks[category] = true
+        }
+    }
+}
+
+// Write the prefixed service output to w instead of stdout.
+func WithOutput(w io.Writer) Option {
+    return func(f *Foreman) {
+        f.output = w
+    }
+}
+
+// Force colored service prefixes on or off instead of detecting a terminal.
+func WithColor(enabled bool) Option {
+    return func(f *Foreman) {
+        f.color = &enabled
+    }
+}
+
+// Start every service output line with the time it was printed, formatted with
+// the time.Format layout, e.g. WithTimestampFormat("15:04:05").
+func WithTimestampFormat(layout string) Option {
+    return func(f *Foreman) {
+        f.timestampFormat = layout
+    }
+}
+
+// Choose whether RestartService also restarts the services depending on the restarted one.
+func WithRestartDependents(enabled bool) Option {
+    return func(f *Foreman) {
+        f.restartDependents = enabled
+    }
+}
+
+// Wait d between health checks of services that don't set their own interval.
+func WithCheckInterval(d time.Duration) Option {
+    return func(f *Foreman) {
+        if d > 0 {
+            f.checkInterval = d
+        }
+    }
+}
+
+// Run service and check commands through shell with the given flags
+// instead of bash -c, e.g. WithShell("sh", "-c").
+func WithShell(shell string, flags ...string) Option {
+    return func(f *Foreman) {
+        f.shell = append([]string{shell}, flags...)
+    }
+}
+
+// Write foreman's own messages to logger instead of stdout.
+func WithLogger(logger *log.Logger) Option {
+    return func(f *Foreman) {
+        f.logger = NewTextLogger(logger)
+    }
+}
+
+// Hand foreman's own messages to logger as records, e.g. NewJSONLogger(os.Stdout).
+func WithStructuredLogger(logger Logger) Option {
+    return func(f *Foreman) {
+        f.logger = logger
+    }
+}
+
+// Read the variables of every service from the given .env files instead of the
+// .env file next to the Procfile. Later files override earlier ones.
+func WithEnvFiles(paths ...string) Option {
+    return func(f *Foreman) {
+        f.envFiles = append(f.envFiles, paths...)
+    }
+}
+
+// Render the Procfile as a Go template before parsing it, with the environment
+// and the .env variables under .Env.
+func WithProcfileTemplate() Option {
+    return func(f *Foreman) {
+        f.procfileTemplate = true
+    }
+}
+
+// Merge more Procfiles over the Procfile passed to New, in order. Their services
+// are added, or override the fields of the services already defined. Relative
+// paths in them are still resolved against the first Procfile's directory.
+func WithOverrides(procfilePaths ...string) Option {
+    return func(f *Foreman) {
+        f.overrides = append(f.overrides, procfilePaths...)
+    }
+}
+
+// Select profiles: their overrides are applied to the services belonging to them,
+// in order, and the services with profiles belonging to none are disabled.
+func WithProfiles(profiles ...string) Option {
+    return func(f *Foreman) {
+        f.profiles = append(f.profiles, profiles...)
+    }
+}
+
+// Start only these services, and the services they depend on, instead of every
+// enabled service.
+func WithTargets(serviceNames ...string) Option {
+    return func(f *Foreman) {
+        f.targets = append(f.targets, serviceNames...)
+    }
+}
+
+// Start only the services of these groups, and the services they depend on,
+// instead of every enabled service. Combined with WithTargets, both are started.
+func WithGroups(groups ...string) Option {
+    return func(f *Foreman) {
+        f.targetGroups = append(f.targetGroups, groups...)
+    }
+}
+
+// Run counts instances of the services, like ParseFormation's result, instead
+// of their instances field. A count of 0 disables the service.
+func WithFormation(counts map[string]int) Option {
+    return func(f *Foreman) {
+        if f.formation == nil {
+            f.formation = make(map[string]int)
+        }
+        for serviceName, count := range counts {
+            f.formation[serviceName] = count
+        }
+    }
+}
+
+// Read the Procfile in format, like FormatYAML or FormatTOML, instead of
+// detecting it from its extension and content.
+func WithProcfileFormat(format string) Option {
+    return func(f *Foreman) {
+        f.procfileFormat = format
+    }
+}
+
+// Read the Procfile and the files it includes with decoder, for formats foreman doesn't know.
+func WithDecoder(decoder Decoder) Option {
+    return func(f *Foreman) {
+        f.decoder = decoder
+    }
+}
+
+// Number the PORT of services from port instead of PORT or 5000. The services,
+// in name order, get port, port+100, port+200 and so on.
+func WithBasePort(port int) Option {
+    return func(f *Foreman) {
+        f.port = port
+    }
+}
+
+// Add KEY=VALUE entries to the environment inherited by every service.
+func WithEnv(env ...string) Option {
+    return func(f *Foreman) {
+        f.env = append(f.env, env...)
+    }
+}
+
+// Run services without a cwd in dir and resolve relative Procfile paths against it.
+func WithWorkingDir(dir string) Option {
+    return func(f *Foreman) {
+        f.workingDir = dir
+    }
+}
+
+// Wait d after a service stopped before stopping the services it depends on during shutdown.
+func WithShutdownDelay(d time.Duration) Option {
+    return func(f *Foreman) {
+        f.shutdownDelay = d
+    }
+}
+
+// Limit how many services are started concurrently.
+func WithStartupParallelism(n int) Option {
+    return func(f *Foreman) {
+        if n > 0 {
+            f.startupParallelism = n
+        }
+    }
+}
+
+// Wait for dependencies listed without a condition to pass their health checks
+// before starting a service, as if they were given the service_healthy condition.
+func WithHealthyDependencies() Option {
+    return func(f *Foreman) {
+        f.healthyDeps = true
+    }
+}
+
+// Run foreman as the init process of a container: it reaps every zombie, like
+// with WithSubreaper, forwards SIGHUP, SIGUSR1, SIGUSR2 and SIGWINCH to every
+// service and stops on SIGTERM and SIGQUIT like on SIGINT. See WithMainService
+// to exit with the exit code of a service.
+func WithInit() Option {
+    return func(f *Foreman) {
+        f.init = true
+        f.subreaper = true
+    }
+}
+
+// Stop every service once the main service exits on its own, whatever its
+// restart policy. Start then returns a MainExitError with its exit code unless
+// it is 0.
+func WithMainService(serviceName string) Option {
+    return func(f *Foreman) {
+        f.mainService = serviceName
+    }
+}
+
+// Make foreman the subreaper of the processes the services start, on Linux:
+// processes orphaned by a double fork are re-parented to foreman, which reaps
+// them instead of leaving zombies, like a lightweight init. Start fails on
+// other systems. Only use it when foreman starts no other process than its
+// own, as it reaps every child it doesn't wait for itself.
+func WithSubreaper() Option {
+    return func(f *Foreman) {
+        f.subreaper = true
+    }
+}
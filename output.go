@@ -0,0 +1,195 @@
+package foreman
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+    outputInherit = "inherit"
+    outputDiscard = "discard"
+
+    binaryEscape = "escape"
+    binarySuppress = "suppress"
+
+    defaultMaxLineLength = 64 * 1024
+    truncatedMarker = " …[truncated]"
+    binarySuppressedMarker = "[binary output suppressed]"
+)
+
+// Route the service stdout and stderr to their configured sinks.
+// It returns the files that must be closed once the process has started.
+func (f *Foreman) attachOutput(serviceExec *exec.Cmd, service Service) ([]*os.File, error) {
+    stdout, err := f.outputSink(service, service.stdout, os.Stdout)
+    if err != nil {
+        return nil, err
+    }
+
+    stderr, err := f.outputSink(service, service.stderr, os.Stderr)
+    if err != nil {
+        closeFiles([]*os.File{stdout})
+        return nil, err
+    }
+
+    if stdout != nil {
+        serviceExec.Stdout = stdout
+    }
+    if stderr != nil {
+        serviceExec.Stderr = stderr
+    }
+
+    return []*os.File{stdout, stderr}, nil
+}
+
+// Open the file a service stream is written to.
+// A nil file means the stream is discarded.
+func (f *Foreman) outputSink(service Service, target string, inherit *os.File) (*os.File, error) {
+    switch target {
+    case "":
+        return f.prefixedOutput(service)
+    case outputInherit:
+        return inherit, nil
+    case outputDiscard:
+        return nil, nil
+    }
+
+    return os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Create a pipe whose lines are copied to the foreman output prefixed by the
+// service name, and to the service log file if it has one.
+func (f *Foreman) prefixedOutput(service Service) (*os.File, error) {
+    reader, writer, err := os.Pipe()
+    if err != nil {
+        return nil, err
+    }
+
+    var logFile *logFile
+    if service.logFile != "" {
+        logFile, err = f.acquireLogFile(service)
+        if err != nil {
+            reader.Close()
+            writer.Close()
+            return nil, err
+        }
+    }
+
+    f.outputWG.Add(1)
+    go func() {
+        defer f.outputWG.Done()
+        defer reader.Close()
+        if logFile != nil {
+            defer logFile.release()
+        }
+        f.copyLines(reader, service.serviceName, service.binaryOutput)
+    }()
+
+    return writer, nil
+}
+
+// Copy every line from reader to the foreman output with the service prefix,
+// after the time of the line when a timestamp format is set. Lines longer than maxLineLength are truncated without being buffered whole.
+func (f *Foreman) copyLines(reader io.Reader, serviceName, binaryOutput string) {
+    color := f.useColor()
+    prefix := serviceName
+    if color {
+        prefix = colorize(serviceName)
+    }
+
+    logFile := f.serviceLogFile(serviceName)
+    lines := bufio.NewReaderSize(reader, f.maxLineLength)
+    for {
+        chunk, err := lines.ReadSlice('\n')
+        line := formatLine(chunk, err == bufio.ErrBufferFull, binaryOutput)
+        if !color {
+            line = stripANSI(line)
+        }
+
+        for err == bufio.ErrBufferFull {
+            _, err = lines.ReadSlice('\n')
+        }
+
+        if len(chunk) > 0 {
+            now := f.clock.Now()
+            linePrefix := prefix
+            if f.timestampFormat != "" {
+                linePrefix = now.Format(f.timestampFormat) + " " + prefix
+            }
+            f.outputMu.Lock()
+            fmt.Fprintf(f.output, "%s | %s\n", linePrefix, line)
+            f.outputMu.Unlock()
+            f.publishLog(serviceName, stripANSI(line))
+            if logFile != nil {
+                writeErr := logFile.writeLine(now, stripANSI(line))
+                if writeErr != nil {
+                    f.log(LogRecord{
+                    	Level:   LevelError,
+                    	Event:   "log file error",
+                    	Service: serviceName,
+                    	Message: fmt.Sprintf("%s: writing %s: %v", serviceName, logFile.path, writeErr),
+                    })
+                }
+            }
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+// Make a raw output line safe to print, escaping or suppressing binary data.
+func formatLine(line []byte, truncated bool, binaryOutput string) string {
+    line = bytes.TrimSuffix(line, []byte("\n"))
+
+    var formatted string
+    switch {
+    case !isBinary(line):
+        formatted = string(line)
+    case binaryOutput == binarySuppress:
+        formatted = binarySuppressedMarker
+    default:
+        formatted = escapeBinary(line)
+    }
+
+    if truncated {
+        formatted += truncatedMarker
+    }
+    return formatted
+}
+
+// Report whether a line holds invalid UTF-8 or NUL bytes.
+func isBinary(line []byte) bool {
+    return !utf8.Valid(line) || bytes.IndexByte(line, 0) >= 0
+}
+
+// Hex-escape the invalid UTF-8 bytes and control characters of a line, the
+// ESC of terminal sequences included.
+func escapeBinary(line []byte) string {
+    var escaped bytes.Buffer
+    for len(line) > 0 {
+        r, size := utf8.DecodeRune(line)
+        if (r == utf8.RuneError && size == 1) || (unicode.IsControl(r) && r != '\t') {
+            for _, b := range line[:size] {
+                fmt.Fprintf(&escaped, "\\x%02x", b)
+            }
+        } else {
+            escaped.Write(line[:size])
+        }
+        line = line[size:]
+    }
+    return escaped.String()
+}
+
+func closeFiles(files []*os.File) {
+    for _, file := range files {
+        if file != nil && file != os.Stdout && file != os.Stderr {
+            file.Close()
+        }
+    }
+}
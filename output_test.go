@@ -0,0 +1,175 @@
+package foreman
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyLines(t *testing.T) {
+    t.Run("truncate a huge line", func(t *testing.T) {
+        foreman := &Foreman{maxLineLength: 1024, clock: realClock{}}
+        output := &bytes.Buffer{}
+        foreman.output = output
+
+        hugeLine := strings.Repeat("a", 10*1024*1024) + "\nnext\n"
+        foreman.copyLines(strings.NewReader(hugeLine), "app", binaryEscape)
+
+        want := "app | " + strings.Repeat("a", 1024) + truncatedMarker + "\napp | next\n"
+        assertString(t, output.String(), want)
+    })
+
+    t.Run("escape binary output", func(t *testing.T) {
+        foreman := &Foreman{maxLineLength: defaultMaxLineLength, clock: realClock{}}
+        output := &bytes.Buffer{}
+        foreman.output = output
+
+        foreman.copyLines(bytes.NewReader([]byte("ok\x00\xff\n")), "app", binaryEscape)
+
+        assertString(t, output.String(), `app | ok\x00\xff`+"\n")
+    })
+
+    t.Run("escape terminal sequences in binary output", func(t *testing.T) {
+        foreman := &Foreman{maxLineLength: defaultMaxLineLength, clock: realClock{}}
+        output := &bytes.Buffer{}
+        foreman.output = output
+
+        foreman.copyLines(bytes.NewReader([]byte("\x00\x1b[2J\x1b]0;title\x07\u009b0m\n")), "app", binaryEscape)
+
+        assertString(t, output.String(), `app | \x00\x1b[2J\x1b]0;title\x07\xc2\x9b0m`+"\n")
+    })
+
+    t.Run("suppress binary output", func(t *testing.T) {
+        foreman := &Foreman{maxLineLength: defaultMaxLineLength, clock: realClock{}}
+        output := &bytes.Buffer{}
+        foreman.output = output
+
+        foreman.copyLines(bytes.NewReader([]byte("\x89PNG\x00\x01\ntext\n")), "app", binarySuppress)
+
+        assertString(t, output.String(), "app | "+binarySuppressedMarker+"\napp | text\n")
+    })
+
+    t.Run("timestamp every line", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        clock := newFakeClock()
+        clock.advance(90 * time.Second)
+        foreman, _ := New(testOutputProcfile, WithOutput(output), WithClock(clock), WithTimestampFormat("04:05"))
+
+        foreman.copyLines(strings.NewReader("one\ntwo\n"), "app", binaryEscape)
+
+        assertString(t, output.String(), "01:30 app | one\n01:30 app | two\n")
+    })
+}
+
+func TestColor(t *testing.T) {
+    t.Run("no ANSI escapes on a non-TTY output", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile, WithOutput(output))
+
+        foreman.copyLines(strings.NewReader("\x1b[31mred\x1b[0m\n"), "app", binaryEscape)
+
+        assertString(t, output.String(), "app | red\n")
+    })
+
+    t.Run("forced color keeps a stable prefix color", func(t *testing.T) {
+        output := &bytes.Buffer{}
+        foreman, _ := New(testOutputProcfile, WithOutput(output), WithColor(true))
+
+        foreman.copyLines(strings.NewReader("one\ntwo\n"), "app", binaryEscape)
+
+        prefix := colorize("app")
+        assertString(t, output.String(), prefix+" | one\n"+prefix+" | two\n")
+        if !strings.HasPrefix(prefix, "\x1b[") {
+            t.Errorf("expected a colored prefix, got %q", prefix)
+        }
+    })
+}
+
+func TestLogFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "app.log")
+    output := &bytes.Buffer{}
+    clock := newFakeClock()
+    foreman, _ := New(testOutputProcfile, WithOutput(output), WithClock(clock))
+
+    service := Service{serviceName: "app", logFile: path, maxLogSize: 70, maxLogFiles: 2}
+    logFile, err := foreman.acquireLogFile(service)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Entries take about 30 bytes, so the file is rotated every two lines.
+    foreman.copyLines(strings.NewReader("line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\n"), "app", binaryEscape)
+    logFile.release()
+
+    readGzip := func(path string) string {
+        t.Helper()
+        file, err := os.Open(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer file.Close()
+        reader, err := gzip.NewReader(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, err := io.ReadAll(reader)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return string(content)
+    }
+
+    stamp := clock.Now().Format(time.RFC3339)
+    current, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assertString(t, string(current), stamp+" line 7\n")
+    assertString(t, readGzip(path+".1.gz"), stamp+" line 5\n"+stamp+" line 6\n")
+    assertString(t, readGzip(path+".2.gz"), stamp+" line 3\n"+stamp+" line 4\n")
+    if _, err := os.Stat(path + ".3.gz"); !os.IsNotExist(err) {
+        t.Errorf("expected only 2 rotated files, got %s.3.gz", path)
+    }
+}
+
+func TestLogFileRotationFailure(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "app.log")
+    // A directory in the way of the rotated file makes every rotation fail.
+    err := os.MkdirAll(filepath.Join(path+".1.gz", "keep"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    logFile := &logFile{path: path}
+    err = logFile.acquire(70, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    now := newFakeClock().Now()
+    stamp := now.Format(time.RFC3339)
+    for i := 1; i <= 4; i++ {
+        err := logFile.writeLine(now, fmt.Sprintf("line %d", i))
+        if i <= 2 && err != nil {
+            t.Fatalf("line %d: unexpected error: %v", i, err)
+        }
+        if i > 2 && (err == nil || !strings.HasPrefix(err.Error(), "rotating "+path)) {
+            t.Errorf("line %d: expected a rotation error, got %v", i, err)
+        }
+    }
+    logFile.release()
+
+    // The lines keep going to the current file.
+    current, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assertString(t, string(current), stamp+" line 1\n"+stamp+" line 2\n"+stamp+" line 3\n"+stamp+" line 4\n")
+
+    err = logFile.writeLine(now, "line 5")
+    assertError(t, err, path+" isn't open")
+}
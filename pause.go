@@ -0,0 +1,77 @@
+package foreman
+
+import "fmt"
+
+// Suspend a running service with SIGSTOP to its process group, for example to
+// tell whether it is the one eating the CPU. Its checks are suspended too, so
+// it isn't interrupted for failing them while paused.
+func (f *Foreman) PauseService(serviceName string) error {
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        return &UnknownServiceError{Service: serviceName}
+    }
+    if !service.active {
+        f.mu.Unlock()
+        return fmt.Errorf("%s isn't running", serviceName)
+    }
+    if service.paused {
+        f.mu.Unlock()
+        return fmt.Errorf("%s is already paused", serviceName)
+    }
+    if service.stopChecker != nil {
+        close(service.stopChecker)
+        service.stopChecker = nil
+    }
+    service.paused = true
+    service.pausedState = service.state
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    err := f.signalGroup(service.pid, sigSTOP)
+    if err != nil {
+        f.updateService(serviceName, func(service *Service) {
+            service.paused = false
+        })
+        if !service.noHealthCheck {
+            f.restartChecker(serviceName)
+        }
+        return err
+    }
+    f.log(LogRecord{Event: "paused", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: paused", service.pid, serviceName)})
+    f.setState(serviceName, StatePaused)
+    return nil
+}
+
+// Continue a paused service with SIGCONT, back in the state it was paused in,
+// and resume its checks.
+func (f *Foreman) ResumeService(serviceName string) error {
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        return &UnknownServiceError{Service: serviceName}
+    }
+    if !service.paused {
+        f.mu.Unlock()
+        return fmt.Errorf("%s isn't paused", serviceName)
+    }
+    service.paused = false
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    err := f.signalGroup(service.pid, sigCONT)
+    if err != nil {
+        f.updateService(serviceName, func(service *Service) {
+            service.paused = true
+        })
+        return err
+    }
+    f.log(LogRecord{Event: "resumed", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: resumed", service.pid, serviceName)})
+    f.setState(serviceName, service.pausedState)
+    if !service.noHealthCheck {
+        f.restartChecker(serviceName)
+    }
+    return nil
+}
@@ -0,0 +1,41 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+    defaultBasePort = 5000
+    servicePortStep = 100
+)
+
+// Choose the port services are numbered from: the WithBasePort port, else PORT
+// from the .env files and the env block, else PORT from foreman's environment,
+// else 5000.
+func (f *Foreman) basePort(dotEnv map[string]string) (int, error) {
+    if f.port != 0 {
+        return f.port, nil
+    }
+
+    value, ok := dotEnv["PORT"]
+    if !ok {
+        value, ok = os.LookupEnv("PORT")
+    }
+    if !ok || value == "" {
+        return defaultBasePort, nil
+    }
+
+    port, err := strconv.Atoi(value)
+    if err != nil || port <= 0 || port > 65535 {
+        return 0, fmt.Errorf("PORT: expected a port number, got %q", value)
+    }
+    return port, nil
+}
+
+// Return the PORT of the service at index in the sorted service names, spacing
+// services 100 ports apart like Heroku's foreman.
+func servicePort(base, index int) int {
+    return base + servicePortStep*index
+}
@@ -0,0 +1,48 @@
+package foreman
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// portOwner tells the ports a service's processes listen on.
+type portOwner interface {
+    // Report whether one of the processes waits for clients on port with
+    // protocol, tcp or udp.
+    listens(protocol string, port int) (bool, error)
+}
+
+// Checks all ports in the checks: each must be listened on by the service
+// process or a process of its process group.
+func (s *Service) checkPorts(portType string) error {
+    var ports []string
+    switch portType {
+    case "tcp":
+        ports = s.checks.tcpPorts
+    case "udp":
+        ports = s.checks.udpPorts
+    }
+    if len(ports) == 0 {
+        return nil
+    }
+
+    owner, err := newPortOwner(s.pid)
+    if err != nil {
+        return err
+    }
+    for _, port := range ports {
+        portNumber, err := strconv.Atoi(port)
+        if err != nil {
+            return fmt.Errorf("invalid %s port %q", portType, port)
+        }
+        listening, err := owner.listens(portType, portNumber)
+        if err != nil {
+            return err
+        }
+        if !listening {
+            return fmt.Errorf("%s port %d isn't listened on by pid %d", portType, portNumber, s.pid)
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package foreman
+
+import "github.com/shirou/gopsutil/net"
+
+// Return the pids of the processes waiting for clients on port with protocol,
+// tcp or udp, read from the connection table of the system: lsof on macOS and
+// the IP helper API on Windows.
+func listeningPids(protocol string, port int) ([]int32, error) {
+    connections, err := net.Connections(protocol)
+    if err != nil {
+        return nil, err
+    }
+    var pids []int32
+    for _, connection := range connections {
+        if int(connection.Laddr.Port) != port {
+            continue
+        }
+        // Udp sockets have no state, bound ones wait for clients.
+        if protocol == "udp" || connection.Status == "LISTEN" {
+            pids = append(pids, connection.Pid)
+        }
+    }
+    return pids, nil
+}
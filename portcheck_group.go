@@ -0,0 +1,33 @@
+//go:build !linux && !windows
+
+package foreman
+
+import "golang.org/x/sys/unix"
+
+// groupPortOwner owns the sockets of the processes of a process group, found
+// without /proc by looking up the group of the listening processes.
+type groupPortOwner struct {
+    pgid int
+}
+
+// Return the owner of the sockets of pid and of the process group it leads.
+func newPortOwner(pid int) (portOwner, error) {
+    return &groupPortOwner{pgid: pid}, nil
+}
+
+func (o *groupPortOwner) listens(protocol string, port int) (bool, error) {
+    pids, err := listeningPids(protocol, port)
+    if err != nil {
+        return false, err
+    }
+    for _, pid := range pids {
+        if int(pid) == o.pgid {
+            return true, nil
+        }
+        pgid, err := unix.Getpgid(int(pid))
+        if err == nil && pgid == o.pgid {
+            return true, nil
+        }
+    }
+    return false, nil
+}
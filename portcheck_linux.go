@@ -0,0 +1,142 @@
+package foreman
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+    // procRoot is where the proc filesystem is mounted.
+    procRoot = "/proc"
+    // tcpListen and udpUnconnected are the socket states of /proc/net/tcp and
+    // /proc/net/udp of sockets waiting for clients.
+    tcpListen = "0A"
+    udpUnconnected = "07"
+)
+
+// procPortOwner owns the sockets of a process group, read from /proc.
+type procPortOwner struct {
+    root string
+    sockets map[string]bool
+}
+
+// Return the owner of the sockets of pid and of the process group it leads.
+func newPortOwner(pid int) (portOwner, error) {
+    sockets, err := processGroupSockets(procRoot, pid)
+    if err != nil {
+        return nil, err
+    }
+    return &procPortOwner{root: procRoot, sockets: sockets}, nil
+}
+
+func (o *procPortOwner) listens(protocol string, port int) (bool, error) {
+    inodes, err := listeningSockets(o.root, protocol, port)
+    if err != nil {
+        return false, err
+    }
+    return anyOwned(inodes, o.sockets), nil
+}
+
+// Return the inodes of the ipv4 and ipv6 sockets of protocol, tcp or udp,
+// waiting for clients on port.
+func listeningSockets(root, protocol string, port int) ([]string, error) {
+    state := tcpListen
+    if protocol == "udp" {
+        state = udpUnconnected
+    }
+
+    var inodes []string
+    for _, table := range []string{protocol, protocol + "6"} {
+        file, err := os.Open(filepath.Join(root, "net", table))
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        scanner := bufio.NewScanner(file)
+        scanner.Scan() // Skip the header.
+        for scanner.Scan() {
+            // sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+            fields := strings.Fields(scanner.Text())
+            if len(fields) < 10 || fields[3] != state {
+                continue
+            }
+            colon := strings.LastIndex(fields[1], ":")
+            localPort, err := strconv.ParseUint(fields[1][colon+1:], 16, 16)
+            if err == nil && int(localPort) == port {
+                inodes = append(inodes, fields[9])
+            }
+        }
+        err = scanner.Err()
+        file.Close()
+        if err != nil {
+            return nil, err
+        }
+    }
+    return inodes, nil
+}
+
+// Return the inodes of the sockets open by pid and by the processes of the
+// process group pid leads.
+func processGroupSockets(root string, pid int) (map[string]bool, error) {
+    entries, err := os.ReadDir(root)
+    if err != nil {
+        return nil, err
+    }
+
+    sockets := make(map[string]bool)
+    for _, entry := range entries {
+        processID, err := strconv.Atoi(entry.Name())
+        if err != nil {
+            continue
+        }
+        if processID != pid && processGroup(root, processID) != pid {
+            continue
+        }
+
+        fdDir := filepath.Join(root, entry.Name(), "fd")
+        fds, err := os.ReadDir(fdDir)
+        if err != nil {
+            // The process exited or belongs to another user.
+            continue
+        }
+        for _, fd := range fds {
+            link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+            if err == nil && strings.HasPrefix(link, "socket:[") {
+                sockets[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+            }
+        }
+    }
+    return sockets, nil
+}
+
+// Return the process group of a process, or 0 when it can't be read.
+func processGroup(root string, pid int) int {
+    stat, err := os.ReadFile(filepath.Join(root, strconv.Itoa(pid), "stat"))
+    if err != nil {
+        return 0
+    }
+    // The command name, in parentheses, may hold spaces: the fields after it
+    // are the state, the parent pid and the process group.
+    fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+    if len(fields) < 3 {
+        return 0
+    }
+    pgid, _ := strconv.Atoi(fields[2])
+    return pgid
+}
+
+// Report whether one of the inodes is owned.
+func anyOwned(inodes []string, owned map[string]bool) bool {
+    for _, inode := range inodes {
+        if owned[inode] {
+            return true
+        }
+    }
+    return false
+}
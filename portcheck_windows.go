@@ -0,0 +1,46 @@
+package foreman
+
+import "github.com/shirou/gopsutil/process"
+
+// treePortOwner owns the sockets of a process and of its descendants, Windows
+// having no process groups to find them by.
+type treePortOwner struct {
+    pids map[int32]bool
+}
+
+// Return the owner of the sockets of pid and of the processes it started.
+func newPortOwner(pid int) (portOwner, error) {
+    pids := make(map[int32]bool)
+    root, err := process.NewProcess(int32(pid))
+    if err == nil {
+        processTree(root, pids)
+    }
+    // A process that doesn't exist owns no sockets.
+    return &treePortOwner{pids: pids}, nil
+}
+
+// Add proc and its descendants to pids.
+func processTree(proc *process.Process, pids map[int32]bool) {
+    pids[proc.Pid] = true
+    children, err := proc.Children()
+    if err != nil {
+        // The process has no children or exited.
+        return
+    }
+    for _, child := range children {
+        processTree(child, pids)
+    }
+}
+
+func (o *treePortOwner) listens(protocol string, port int) (bool, error) {
+    pids, err := listeningPids(protocol, port)
+    if err != nil {
+        return false, err
+    }
+    for _, pid := range pids {
+        if o.pids[pid] {
+            return true, nil
+        }
+    }
+    return false, nil
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package foreman
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// groupOutlivesLeader is set as a process group keeps the pid of its leader
+// after it exits, until every process of the group exited: the pid can't be
+// reused meanwhile, so the group can still be signaled safely.
+const groupOutlivesLeader = true
+
+// Return the attributes starting a process as the leader of a new process
+// group, which foreman signals as a whole.
+func processGroupAttr() *syscall.SysProcAttr {
+    return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Send sig to a process, signal 0 only checks it is alive.
+func signalProcess(pid int, sig syscall.Signal) error {
+    return syscall.Kill(pid, sig)
+}
+
+// Send sig to every process of the group led by pid.
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+    return syscall.Kill(-pid, sig)
+}
+
+// Report whether a process exists, even one foreman can't signal.
+func processAlive(pid int) bool {
+    err := syscall.Kill(pid, 0)
+    return err == nil || err == syscall.EPERM
+}
+
+// Set the umask of the process cmd starts, which a shell sets before
+// executing the command in its place: the umask of foreman, shared by all its
+// threads, is left alone.
+func setChildUmask(cmd *exec.Cmd, umask string) error {
+    if umask == "" || cmd.Err != nil {
+        return nil
+    }
+    shell, err := exec.LookPath("sh")
+    if err != nil {
+        return err
+    }
+    cmd.Args = append([]string{"sh", "-c", "umask " + umask + ` && exec "$0" "$@"`, cmd.Path}, cmd.Args[1:]...)
+    cmd.Path = shell
+    return nil
+}
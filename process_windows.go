@@ -0,0 +1,78 @@
+package foreman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code of a process that didn't exit yet.
+const stillActive = 259
+
+// groupOutlivesLeader is unset as Windows reuses the pid of a process as soon
+// as it exited, so the tree of an exited process can't be killed by its pid.
+const groupOutlivesLeader = false
+
+// Return the attributes starting a process as the root of a new process
+// group, which console control events reach as a whole.
+func processGroupAttr() *syscall.SysProcAttr {
+    return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// Windows has no signals: 0 checks the process is alive, SIGINT sends a
+// CTRL_BREAK event to its process group and SIGTERM, SIGQUIT and SIGKILL
+// terminate it.
+func signalProcess(pid int, sig syscall.Signal) error {
+    switch sig {
+    case 0:
+        if !processAlive(pid) {
+            return syscall.ESRCH
+        }
+        return nil
+    case syscall.SIGINT:
+        return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+    case syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL:
+        process, err := os.FindProcess(pid)
+        if err != nil {
+            return err
+        }
+        return process.Kill()
+    }
+    return fmt.Errorf("%s can't be sent on Windows", signalName(sig))
+}
+
+// Send sig to the process tree rooted at pid: SIGINT as a CTRL_BREAK event to
+// its process group, and the stop signals by terminating the whole tree.
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+    switch sig {
+    case syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL:
+        return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+    }
+    return signalProcess(pid, sig)
+}
+
+// Report whether a process exists and didn't exit yet.
+func processAlive(pid int) bool {
+    handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+    if err != nil {
+        // A process foreman isn't allowed to open still exists.
+        return err == windows.ERROR_ACCESS_DENIED
+    }
+    defer windows.CloseHandle(handle)
+
+    var exitCode uint32
+    err = windows.GetExitCodeProcess(handle, &exitCode)
+    return err == nil && exitCode == stillActive
+}
+
+func setChildUmask(cmd *exec.Cmd, umask string) error {
+    if umask != "" {
+        return errors.New("umask isn't supported on Windows")
+    }
+    return nil
+}
@@ -1,59 +1,531 @@
-package main
+package foreman
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
 
-func parseService(serviceMap map[string]any) Service {
-    service := Service{}
+const (
+    includeKey = "include"
+    defaultsKey = "defaults"
+    envKey = "env"
+    readinessKey = "readiness"
+    livenessKey = "liveness"
+)
+
+// checkBlocks are the service fields holding checks.
+var checkBlocks = []string{"checks", readinessKey, livenessKey}
+
+// procfileSettings say how loadProcfile reads Procfiles.
+type procfileSettings struct {
+    // Render files with template first when it isn't nil.
+    template *procfileTemplate
+    // Format of the files, FormatAuto to detect it.
+    format string
+    // Decode files with decoder instead when it isn't nil.
+    decoder Decoder
+}
+
+// Return the settings the Procfile is read with, .env variables available to templates.
+func (f *Foreman) procfileSettings(dotEnv map[string]string) procfileSettings {
+    return procfileSettings{template: f.template(dotEnv), format: f.procfileFormat, decoder: f.decoder}
+}
+
+// Read a Procfile and merge in the services of the files it includes.
+// Included files are resolved relative to the including file's directory,
+// and the fields of services defined locally override the included ones.
+// Every file is rendered and decoded as the settings say. The positions of
+// the keys are returned too when the decoders know them.
+func loadProcfile(procfilePath string, including []string, settings procfileSettings) (map[string]map[string]any, keyPositions, error) {
+    absProcfilePath, err := filepath.Abs(procfilePath)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    for _, includingPath := range including {
+        if includingPath == absProcfilePath {
+            cycle := strings.Join(append(including, absProcfilePath), " -> ")
+            return nil, nil, fmt.Errorf("include cycle detected: %s", cycle)
+        }
+    }
+    including = append(including, absProcfilePath)
+
+    procfileData, err := os.ReadFile(procfilePath)
+    if err != nil {
+        return nil, nil, err
+    }
+    if settings.template != nil {
+        procfileData, err = settings.template.render(procfilePath, procfileData)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+
+    decoder, err := settings.decoderFor(procfilePath, procfileData)
+    if err != nil {
+        return nil, nil, err
+    }
+    procfileMap, err := decoder.Decode(procfilePath, procfileData)
+    if err != nil {
+        return nil, nil, err
+    }
+    filePositions := keyPositions{}
+    if decoder, ok := decoder.(positionDecoder); ok {
+        filePositions, err = decoder.positions(procfilePath, procfileData)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+
+    services := map[string]map[string]any{}
+    positions := keyPositions{}
+
+    if include, ok := procfileMap[includeKey]; ok {
+        includePaths, err := parseStringList(includeKey, include)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        for _, includePath := range includePaths {
+            if !filepath.IsAbs(includePath) {
+                includePath = filepath.Join(filepath.Dir(absProcfilePath), includePath)
+            }
+
+            included, includedPositions, err := loadProcfile(includePath, including, settings)
+            if err != nil {
+                return nil, nil, err
+            }
+
+            mergeServices(services, positions, included, includedPositions)
+        }
+        delete(procfileMap, includeKey)
+    }
+
+    local := map[string]map[string]any{}
+    for serviceName, value := range procfileMap {
+        serviceMap, ok := value.(map[string]any)
+        if !ok {
+            parseErr := &ParseError{Service: serviceName, Cause: errors.New("service definition must be a mapping")}
+            return nil, nil, filePositions.locateError(serviceName, parseErr)
+        }
+        local[serviceName] = serviceMap
+    }
+    for path := range filePositions {
+        if path == includeKey || strings.HasPrefix(path, includeKey+".") {
+            delete(filePositions, path)
+        }
+    }
+    mergeServices(services, positions, local, filePositions)
+
+    return services, positions, nil
+}
+
+// Read Procfiles and merge them, later ones overriding the services of the
+// earlier ones field by field.
+func loadProcfiles(procfilePaths []string, settings procfileSettings) (map[string]map[string]any, keyPositions, error) {
+    services := map[string]map[string]any{}
+    positions := keyPositions{}
+    for _, procfilePath := range procfilePaths {
+        loaded, loadedPositions, err := loadProcfile(procfilePath, nil, settings)
+        if err != nil {
+            return nil, nil, err
+        }
+        mergeServices(services, positions, loaded, loadedPositions)
+    }
+    return services, positions, nil
+}
+
+// Merge overriding service definitions and their positions into services, the
+// fields they set replacing the existing ones and maps like checks merged key by key.
+func mergeServices(services map[string]map[string]any, positions keyPositions, overrides map[string]map[string]any, overridePositions keyPositions) {
+    for serviceName, serviceMap := range overrides {
+        services[serviceName] = applyDefaults(serviceMap, services[serviceName])
+    }
+    for path, position := range overridePositions {
+        positions[path] = position
+    }
+}
+
+// Apply the defaults block to a service definition. Fields set on the service
+// take precedence, and maps like checks are merged key by key.
+func applyDefaults(serviceMap, defaults map[string]any) map[string]any {
+    merged := make(map[string]any, len(serviceMap))
+    for key, value := range defaults {
+        merged[key] = value
+    }
+
+    for key, value := range serviceMap {
+        valueMap, isMap := value.(map[string]any)
+        defaultMap, hasDefaultMap := merged[key].(map[string]any)
+        if isMap && hasDefaultMap {
+            value = applyDefaults(valueMap, defaultMap)
+        }
+        merged[key] = value
+    }
+
+    return merged
+}
+
+// Parse a service definition once its defaults, profiles and variables are
+// applied. Every format decodes to the same maps, so the fields are type
+// checked here rather than by the YAML decoder. The keys must match serviceKeys.
+func parseService(serviceMap map[string]any) (Service, error) {
+    var err error
+    var runOnce bool
+    service := Service{enabled: true}
     for key, value := range serviceMap {
         switch key {
         case "cmd":
-            service.cmd = value.(string)
+            service.cmd, service.argv, err = parseCmd(key, value)
+        case "cwd":
+            service.cwd, err = parseString(key, value)
+        case "shell":
+            service.shell, err = parseShell(key, value)
+        case "umask":
+            service.umask, err = parseUmask(key, value)
+        case "user":
+            service.user, err = parseAccount(key, value)
+        case "group":
+            service.group, err = parseAccount(key, value)
+        case "supplementary_groups":
+            service.supplementaryGroups, err = parseAccountList(key, value)
+        case "no_new_privs":
+            service.noNewPrivs, err = parseBool(key, value)
+        case "env":
+            service.env, err = parseEnv(key, value)
+        case envFileKey:
+            _, err = parsePaths(key, value)
+        case instancesKey:
+            _, err = parseCount(key, value)
+        case "stdout":
+            service.stdout, err = parseString(key, value)
+        case "stderr":
+            service.stderr, err = parseString(key, value)
+        case "binary_output":
+            service.binaryOutput, err = parseBinaryOutput(key, value)
+        case "log_file":
+            service.logFile, err = parseString(key, value)
+        case "max_log_size":
+            service.maxLogSize, err = parseSize(key, value)
+        case "max_log_files":
+            service.maxLogFiles, err = parseCount(key, value)
         case "run_once":
-            service.runOnce = value.(bool)
-        case "deps":
-            service.deps = parseDeps(value)
+            runOnce, err = parseBool(key, value)
+        case "restart":
+            service.restart, err = parseRestartPolicy(key, value)
+        case "restart_delay":
+            service.restartDelay, err = parseDuration(key, value)
+        case "backoff_factor":
+            service.backoffFactor, err = parseBackoffFactor(key, value)
+        case "max_restarts":
+            service.maxRestarts, err = parseCount(key, value)
+        case "restart_window":
+            service.restartWindow, err = parseDuration(key, value)
+        case "no_health_check":
+            service.noHealthCheck, err = parseBool(key, value)
+        case "enabled":
+            service.enabled, err = parseBool(key, value)
+        case "deps", "depends_on":
+            service.deps, service.depConditions, service.depTimeouts, err = parseDeps(key, value)
+        case "before":
+            service.before, err = parseStringList(key, value)
+        case "groups":
+            service.groups, err = parseStringList(key, value)
+        case "dep_timeout":
+            service.depTimeout, err = parseDuration(key, value)
+        case "forward_signals":
+            service.forwardSignals, err = parseForwardSignals(key, value)
+        case "stop_signal":
+            service.stopSignal, err = parseStopSignal(key, value)
+        case "start_timeout":
+            service.startTimeout, err = parseDuration(key, value)
+        case "start":
+            service.startMode, err = parseStartMode(key, value)
+        case "start_delay":
+            service.startDelay, err = parseDuration(key, value)
+        case "stop_timeout":
+            service.stopTimeout, err = parseDuration(key, value)
+        case "watch":
+            service.watch, err = parseWatch(key, value)
+        case "hooks":
+            service.hooks, err = parseCommandHooks(key, value)
+        case "schedule":
+            service.schedule, err = parseSchedule(key, value)
         case "checks":
             checks := Checks{}
-            parseCheck(value, &checks)
+            err = parseCheck(key, value, &checks)
             service.checks = checks
+        case readinessKey:
+            service.readiness = &Checks{}
+            err = parseCheck(key, value, service.readiness)
+        case livenessKey:
+            service.liveness = &Checks{}
+            err = parseCheck(key, value, service.liveness)
+        }
+        if err != nil {
+            return Service{}, err
         }
     }
-    return service
-}
 
-func parseDeps(deps any) []string {
-    var resultList []string
-    depsList := deps.([]any)
-
-    for _, dep := range depsList {
-        resultList = append(resultList, dep.(string))
+    // run_once is the older spelling of restart: no, restart wins when both are set.
+    // Scheduled services run again on their schedule and init tasks run once
+    // instead of being restarted.
+    if service.restart == "" {
+        service.restart = RestartAlways
+        if runOnce || service.scheduled() || len(service.before) > 0 {
+            service.restart = RestartNo
+        }
     }
-
-    return resultList
+    return service, nil
 }
 
-func parseCheck(check any, out *Checks)  {
-    checkMap := check.(map[string]any)
+func parseCheck(field string, check any, out *Checks) error {
+    var err error
+    checkMap, ok := check.(map[string]any)
+    if !ok {
+        return fieldError(field, "expected a mapping, got %v", check)
+    }
 
     for key, value := range checkMap {
         switch key {
+        case "interval":
+            out.interval, err = parseDuration(key, value)
+        case "timeout":
+            out.timeout, err = parseDuration(key, value)
+        case "retries":
+            out.retries, err = parseCount(key, value)
+        case "successes":
+            out.successes, err = parseCount(key, value)
+        case "initial_delay":
+            out.initialDelay, err = parseDuration(key, value)
         case "cmd":
-            out.cmd = value.(string)
+            out.cmd, out.argv, err = parseCmd(key, value)
         case "tcp_ports":
-            out.tcpPorts = parsePorts(value)
+            out.tcpPorts, err = parsePorts(key, value)
         case "udp_ports":
-            out.udpPorts = parsePorts(value)
+            out.udpPorts, err = parsePorts(key, value)
+        case "network_ready":
+            out.networkReady, err = parseStringList(key, value)
+        case "grpc":
+            out.grpc, err = parseGRPCCheck(key, value)
+        case "file_exists":
+            out.fileExists, err = parsePaths(key, value)
+        case "socket_exists":
+            out.socketExists, err = parsePaths(key, value)
+        case "pid_file":
+            out.pidFile, err = parseString(key, value)
+        case "dns":
+            out.dns, err = parseDNSChecks(key, value)
+        case "ping":
+            out.ping, err = parseStringList(key, value)
+        case "max_memory":
+            out.maxMemory, err = parseSize(key, value)
+        case "max_cpu_percent":
+            out.maxCPUPercent, err = parsePercent(key, value)
+        case "max_open_files":
+            out.maxOpenFiles, err = parseCount(key, value)
+        case "limit_duration":
+            out.limitDuration, err = parseDuration(key, value)
+        case "on_failure":
+            out.onFailure, err = parseFailureAction(key, value)
+        }
+        if err != nil {
+            return err
         }
     }
+    return nil
 }
 
-func parsePorts(ports any) []string {
-    var resultList []string
-    portsList := ports.([]any)
+// Parse a list of port numbers.
+func parsePorts(field string, ports any) ([]string, error) {
+    portsList, ok := ports.([]any)
+    if !ok {
+        return nil, fieldError(field, "expected a list of ports, got %v", ports)
+    }
 
+    var resultList []string
     for _, port := range portsList {
-        resultList = append(resultList, fmt.Sprint(port.(int)))
+        number, ok := port.(int)
+        if text, isText := port.(string); isText {
+            parsed, err := strconv.Atoi(text)
+            number, ok = parsed, err == nil
+        }
+        if !ok || number < 1 || number > 65535 {
+            return nil, fieldError(field, "expected a port number, got %v", port)
+        }
+        resultList = append(resultList, fmt.Sprint(number))
+    }
+
+    return resultList, nil
+}
+
+// Parse a duration field given either as a duration string like "2s"
+// or as a whole number of milliseconds.
+func parseDuration(field string, value any) (time.Duration, error) {
+    var duration time.Duration
+    switch v := value.(type) {
+    case string:
+        parsed, err := time.ParseDuration(v)
+        if err != nil {
+            return 0, fieldError(field, "invalid duration %q", v)
+        }
+        duration = parsed
+    case int:
+        duration = time.Duration(v) * time.Millisecond
+    default:
+        return 0, fieldError(field, "expected a duration like \"2s\" or milliseconds, got %v", value)
+    }
+
+    if duration < 0 {
+        return 0, fieldError(field, "duration must not be negative, got %v", value)
+    }
+    return duration, nil
+}
+
+// Parse a non-negative count like max_restarts.
+func parseCount(field string, value any) (int, error) {
+    switch v := value.(type) {
+    case int:
+        if v >= 0 {
+            return v, nil
+        }
+    case string:
+        parsed, err := strconv.Atoi(v)
+        if err == nil && parsed >= 0 {
+            return parsed, nil
+        }
+    }
+    return 0, fieldError(field, "expected a non-negative integer, got %v", value)
+}
+
+// Parse a size given either as a number of bytes or as a string like "10MB",
+// with the KB, MB and GB units counted in powers of 1024.
+func parseSize(field string, value any) (int64, error) {
+    switch v := value.(type) {
+    case int:
+        if v >= 0 {
+            return int64(v), nil
+        }
+    case string:
+        number, unit := v, int64(1)
+        for _, suffix := range []struct {
+            name string
+            size int64
+        }{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+            if strings.HasSuffix(strings.ToUpper(v), suffix.name) {
+                number, unit = strings.TrimSpace(v[:len(v)-len(suffix.name)]), suffix.size
+                break
+            }
+        }
+        parsed, err := strconv.ParseInt(number, 10, 64)
+        if err == nil && parsed >= 0 {
+            return parsed * unit, nil
+        }
+    }
+    return 0, fieldError(field, "expected a size like \"10MB\" or a number of bytes, got %v", value)
+}
+
+// Parse the factor restart delays are multiplied by, at least 1.
+func parseBackoffFactor(field string, value any) (float64, error) {
+    var factor float64
+    switch v := value.(type) {
+    case int:
+        factor = float64(v)
+    case float64:
+        factor = v
+    case string:
+        parsed, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return 0, fieldError(field, "expected a number, got %v", value)
+        }
+        factor = parsed
+    default:
+        return 0, fieldError(field, "expected a number, got %v", value)
+    }
+
+    if factor < 1 {
+        return 0, fieldError(field, "factor must be at least 1, got %v", value)
+    }
+    return factor, nil
+}
+
+// Parse a boolean field given either as a yaml boolean or as a string like "true".
+func parseBool(field string, value any) (bool, error) {
+    switch v := value.(type) {
+    case bool:
+        return v, nil
+    case string:
+        parsed, err := strconv.ParseBool(v)
+        if err == nil {
+            return parsed, nil
+        }
+    }
+    return false, fieldError(field, "expected a boolean, got %v", value)
+}
+
+// Parse a string field.
+func parseString(field string, value any) (string, error) {
+    str, ok := value.(string)
+    if !ok {
+        return "", fieldError(field, "expected a string, got %v", value)
+    }
+    return str, nil
+}
+
+// Parse a mapping of environment variables. Scalar values like numbers and
+// booleans are turned into their text.
+func parseEnv(field string, value any) (map[string]string, error) {
+    envMap, ok := value.(map[string]any)
+    if !ok {
+        return nil, fieldError(field, "expected a mapping of variables, got %v", value)
+    }
+
+    env := make(map[string]string, len(envMap))
+    for key, value := range envMap {
+        if key == "" || strings.Contains(key, "=") {
+            return nil, fieldError(field, "invalid variable name %q", key)
+        }
+        switch value.(type) {
+        case map[string]any, []any:
+            return nil, fieldError(field, "expected a string value for %s, got %v", key, value)
+        case nil:
+            env[key] = ""
+        default:
+            env[key] = fmt.Sprint(value)
+        }
+    }
+    return env, nil
+}
+
+// Parse a list of strings field.
+func parseStringList(field string, value any) ([]string, error) {
+    list, ok := value.([]any)
+    if !ok {
+        return nil, fieldError(field, "expected a list")
+    }
+
+    var resultList []string
+    for _, item := range list {
+        str, ok := item.(string)
+        if !ok {
+            return nil, fieldError(field, "expected a list of strings, got %v", item)
+        }
+        resultList = append(resultList, str)
+    }
+
+    return resultList, nil
+}
+
+// Parse how binary output is handled, either escape or suppress.
+func parseBinaryOutput(field string, value any) (string, error) {
+    mode, ok := value.(string)
+    if !ok || (mode != binaryEscape && mode != binarySuppress) {
+        return "", fieldError(field, "expected %q or %q, got %v", binaryEscape, binarySuppress, value)
     }
-    
-    return resultList
+    return mode, nil
 }
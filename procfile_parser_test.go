@@ -0,0 +1,725 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+    t.Run("accepted forms", func(t *testing.T) {
+        cases := map[any]time.Duration{
+            "2s":    2 * time.Second,
+            "150ms": 150 * time.Millisecond,
+            2000:    2 * time.Second,
+            0:       0,
+        }
+
+        for value, want := range cases {
+            got, err := parseDuration("check_interval", value)
+            if err != nil {
+                t.Errorf("unexpected error for %v: %v", value, err)
+            }
+            if got != want {
+                t.Errorf("got:\n%v\nwant:\n%v", got, want)
+            }
+        }
+    })
+
+    t.Run("rejected forms", func(t *testing.T) {
+        cases := map[any]string{
+            "soon": `check_interval: invalid duration "soon"`,
+            "-1s":  "check_interval: duration must not be negative, got -1s",
+            -5:     "check_interval: duration must not be negative, got -5",
+            true:   `check_interval: expected a duration like "2s" or milliseconds, got true`,
+        }
+
+        for value, want := range cases {
+            _, err := parseDuration("check_interval", value)
+            assertError(t, err, want)
+        }
+    })
+}
+
+func TestParseSize(t *testing.T) {
+    t.Run("accepted forms", func(t *testing.T) {
+        cases := map[any]int64{
+            "10MB":   10 << 20,
+            "512 kb": 512 << 10,
+            "1GB":    1 << 30,
+            "100B":   100,
+            "4096":   4096,
+            2048:     2048,
+        }
+
+        for value, want := range cases {
+            got, err := parseSize("max_log_size", value)
+            if err != nil {
+                t.Errorf("unexpected error for %v: %v", value, err)
+            }
+            if got != want {
+                t.Errorf("got:\n%v\nwant:\n%v", got, want)
+            }
+        }
+    })
+
+    t.Run("rejected forms", func(t *testing.T) {
+        for _, value := range []any{"big", "-1MB", -5, 1.5} {
+            _, err := parseSize("max_log_size", value)
+            assertError(t, err, fmt.Sprintf(`max_log_size: expected a size like "10MB" or a number of bytes, got %v`, value))
+        }
+    })
+}
+
+func TestParseBool(t *testing.T) {
+    t.Run("accepted forms", func(t *testing.T) {
+        cases := map[any]bool{
+            true:    true,
+            false:   false,
+            "true":  true,
+            "false": false,
+        }
+
+        for value, want := range cases {
+            got, err := parseBool("run_once", value)
+            if err != nil {
+                t.Errorf("unexpected error for %v: %v", value, err)
+            }
+            if got != want {
+                t.Errorf("got:\n%t\nwant:\n%t", got, want)
+            }
+        }
+    })
+
+    t.Run("rejected forms", func(t *testing.T) {
+        cases := map[any]string{
+            "maybe": "run_once: expected a boolean, got maybe",
+            1:       "run_once: expected a boolean, got 1",
+        }
+
+        for value, want := range cases {
+            _, err := parseBool("run_once", value)
+            assertError(t, err, want)
+        }
+    })
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+    t.Run("accepted forms", func(t *testing.T) {
+        cases := map[any]RestartPolicy{
+            "always":         RestartAlways,
+            "on-failure":     RestartOnFailure,
+            "unless-stopped": RestartUnlessStopped,
+            "no":             RestartNo,
+            false:            RestartNo,
+        }
+
+        for value, want := range cases {
+            got, err := parseRestartPolicy("restart", value)
+            if err != nil {
+                t.Errorf("unexpected error for %v: %v", value, err)
+            }
+            assertString(t, string(got), string(want))
+        }
+    })
+
+    t.Run("rejected forms", func(t *testing.T) {
+        _, err := parseRestartPolicy("restart", "sometimes")
+        assertError(t, err, "restart: expected always, on-failure, unless-stopped or no, got sometimes")
+    })
+
+    t.Run("run_once is read as restart no", func(t *testing.T) {
+        service, _ := parseService(map[string]any{"cmd": "true", "run_once": true})
+        assertString(t, string(service.restart), "no")
+
+        service, _ = parseService(map[string]any{"cmd": "true", "run_once": true, "restart": "on-failure"})
+        assertString(t, string(service.restart), "on-failure")
+    })
+}
+
+func TestParseBackoff(t *testing.T) {
+    t.Run("backoff factor", func(t *testing.T) {
+        factor, err := parseBackoffFactor("backoff_factor", 1.5)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(factor), "1.5")
+
+        _, err = parseBackoffFactor("backoff_factor", 0.5)
+        assertError(t, err, "backoff_factor: factor must be at least 1, got 0.5")
+    })
+
+    t.Run("max restarts", func(t *testing.T) {
+        count, err := parseCount("max_restarts", 5)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(count), "5")
+
+        _, err = parseCount("max_restarts", -1)
+        assertError(t, err, "max_restarts: expected a non-negative integer, got -1")
+    })
+}
+
+func TestParseDeps(t *testing.T) {
+    t.Run("list of names", func(t *testing.T) {
+        names, conditions, _, err := parseDeps("deps", []any{"db", "redis"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, names, []string{"db", "redis"})
+        if conditions != nil {
+            t.Errorf("expected no conditions, got %v", conditions)
+        }
+    })
+
+    t.Run("map of conditions", func(t *testing.T) {
+        names, conditions, timeouts, err := parseDeps("deps", map[string]any{
+            "redis": "service_started",
+            "db":    map[string]any{"condition": "service_healthy", "timeout": "30s"},
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, names, []string{"db", "redis"})
+        assertString(t, string(conditions["db"]), "service_healthy")
+        assertString(t, timeouts["db"].String(), "30s")
+    })
+
+    t.Run("unknown condition", func(t *testing.T) {
+        _, _, _, err := parseDeps("deps", map[string]any{"db": "service_ready"})
+        assertError(t, err, "deps: expected service_started, service_healthy or service_completed_successfully, got service_ready")
+    })
+}
+
+func TestParseEnv(t *testing.T) {
+    t.Run("global and service variables", func(t *testing.T) {
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        content := "env:\n    LEVEL: info\n    PORT: 5000\n" +
+            "web:\n    cmd: ./web\n    env:\n        LEVEL: debug\n        DEBUG: true\n" +
+            "worker:\n    cmd: ./worker\n"
+        err := os.WriteFile(procfile, []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(len(foreman.services)), "2")
+        assertString(t, fmt.Sprint(foreman.services["web"].env), "map[DEBUG:true LEVEL:debug PORT:5000]")
+        assertString(t, fmt.Sprint(foreman.services["worker"].env), "map[LEVEL:info PORT:5100]")
+    })
+
+    t.Run("rejected forms", func(t *testing.T) {
+        cases := map[string]any{
+            "env: expected a mapping of variables, got [A]":           []any{"A"},
+            `env: invalid variable name "A=B"`:                        map[string]any{"A=B": "c"},
+            "env: expected a string value for A, got map[nested:yes]": map[string]any{"A": map[string]any{"nested": "yes"}},
+        }
+
+        for want, value := range cases {
+            _, err := parseEnv("env", value)
+            assertError(t, err, want)
+        }
+    })
+}
+
+func TestEnvFile(t *testing.T) {
+    writeFile := func(t *testing.T, path, content string) {
+        t.Helper()
+        err := os.WriteFile(path, []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    t.Run("read the forms of variables", func(t *testing.T) {
+        path := filepath.Join(t.TempDir(), ".env")
+        writeFile(t, path, "# comment\n\nexport HOST=localhost\nPORT = 5000 # inline\n"+
+            "URL=\"http://${HOST}:${PORT}\\n\"\nRAW='${HOST} # kept'\nEMPTY=\n")
+
+        env := map[string]string{}
+        err := readEnvFile(path, env)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprintf("%q", env),
+            `map["EMPTY":"" "HOST":"localhost" "PORT":"5000" "RAW":"${HOST} # kept" "URL":"http://localhost:5000\n"]`)
+    })
+
+    t.Run("report malformed lines", func(t *testing.T) {
+        path := filepath.Join(t.TempDir(), ".env")
+        writeFile(t, path, "OK=1\nnot a variable\n")
+
+        err := readEnvFile(path, map[string]string{})
+        assertError(t, err, path+`:2: expected KEY=VALUE, got "not a variable"`)
+    })
+
+    t.Run("interpolate services", func(t *testing.T) {
+        dir := t.TempDir()
+        writeFile(t, filepath.Join(dir, ".env"), "PORT=5000\nLEVEL=info\nNAME=dotenv\n")
+        writeFile(t, filepath.Join(dir, "web.env"), "LEVEL=debug\nNAME=web.env\n")
+        writeFile(t, filepath.Join(dir, "Procfile"), "env:\n    NAME: global\n"+
+            "web:\n    cmd: ./web --port ${PORT} $HOME ${UNSET}\n    env_file: web.env\n    env:\n        NAME: web\n"+
+            "    checks:\n        cmd: curl localhost:${PORT}\n        tcp_ports: [\"${PORT}\"]\n")
+
+        foreman, err := New(filepath.Join(dir, "Procfile"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        web := foreman.services["web"]
+        assertString(t, web.cmd, "./web --port 5000 $HOME ${UNSET}")
+        assertString(t, web.checks.cmd, "curl localhost:5000")
+        assertList(t, web.checks.tcpPorts, []string{"5000"})
+        assertString(t, fmt.Sprint(web.env), "map[LEVEL:debug NAME:web PORT:5000]")
+    })
+
+    t.Run("missing env_file", func(t *testing.T) {
+        dir := t.TempDir()
+        writeFile(t, filepath.Join(dir, "Procfile"), "web:\n    cmd: ./web\n    env_file: missing.env\n")
+
+        _, err := New(filepath.Join(dir, "Procfile"))
+        assertError(t, err, fmt.Sprintf("%s:3:5: web: env_file: open %s: no such file or directory", filepath.Join(dir, "Procfile"), filepath.Join(dir, "missing.env")))
+    })
+
+    t.Run("default values", func(t *testing.T) {
+        dir := t.TempDir()
+        writeFile(t, filepath.Join(dir, ".env"), "EMPTY=\nLEVEL=${LEVEL_UNSET:-info}\n")
+        writeFile(t, filepath.Join(dir, "Procfile"), "web:\n    cmd: ./web ${PORT_UNSET:-5000} ${EMPTY:-x} ${LEVEL:-debug} ${UNSET}\n")
+
+        foreman, err := New(filepath.Join(dir, "Procfile"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["web"].cmd, "./web 5000 x info ${UNSET}")
+    })
+
+    t.Run("render templates", func(t *testing.T) {
+        dir := t.TempDir()
+        writeFile(t, filepath.Join(dir, ".env"), "WORKERS=2\n")
+        writeFile(t, filepath.Join(dir, "Procfile"), "web:\n    cmd: ./web --port {{ .Env.PORT_UNSET | default \"5000\" }}"+
+            "{{ if .Env.WORKERS }} --workers {{ .Env.WORKERS }}{{ end }}\n")
+
+        foreman, err := New(filepath.Join(dir, "Procfile"), WithProcfileTemplate())
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["web"].cmd, "./web --port 5000 --workers 2")
+    })
+}
+
+func TestPortAllocation(t *testing.T) {
+    procfile := filepath.Join(t.TempDir(), "Procfile")
+    content := "web:\n    cmd: ./web --port ${PORT}\n    checks:\n        tcp_ports: [\"${PORT}\"]\n" +
+        "api:\n    cmd: ./api\n" +
+        "worker:\n    cmd: ./worker\n    env:\n        PORT: 9000\n"
+    err := os.WriteFile(procfile, []byte(content), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("number services from 5000", func(t *testing.T) {
+        t.Setenv("PORT", "")
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["api"].env["PORT"], "5000")
+        assertString(t, foreman.services["web"].cmd, "./web --port 5100")
+        assertList(t, foreman.services["web"].checks.tcpPorts, []string{"5100"})
+        assertString(t, foreman.services["worker"].env["PORT"], "9000")
+    })
+
+    t.Run("take the base port from PORT", func(t *testing.T) {
+        t.Setenv("PORT", "3000")
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["web"].env["PORT"], "3100")
+    })
+
+    t.Run("take the base port from the option", func(t *testing.T) {
+        t.Setenv("PORT", "3000")
+        foreman, err := New(procfile, WithBasePort(8000))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["web"].env["PORT"], "8100")
+    })
+
+    t.Run("reject an invalid PORT", func(t *testing.T) {
+        t.Setenv("PORT", "http")
+        _, err := New(procfile)
+        assertError(t, err, `PORT: expected a port number, got "http"`)
+    })
+}
+
+func TestFormation(t *testing.T) {
+    t.Setenv("PORT", "")
+    procfile := filepath.Join(t.TempDir(), "Procfile")
+    content := "web:\n    cmd: ./web --port ${PORT}\n    instances: 2\n    checks:\n        tcp_ports: [\"${PORT}\"]\n" +
+        "worker:\n    cmd: ./worker\n    deps:\n        web:\n            condition: service_healthy\n"
+    err := os.WriteFile(procfile, []byte(content), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serviceNames := func(foreman *Foreman) []string {
+        var names []string
+        for name := range foreman.services {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        return names
+    }
+
+    t.Run("run instances with their own PORT", func(t *testing.T) {
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, serviceNames(foreman), []string{"web.1", "web.2", "worker"})
+        second := foreman.services["web.2"]
+        assertString(t, second.cmd, "./web --port 5001")
+        assertList(t, second.checks.tcpPorts, []string{"5001"})
+        assertString(t, second.env[instanceEnvKey], "2")
+        assertString(t, foreman.services["worker"].env["PORT"], "5100")
+        if _, ok := foreman.services["worker"].env[instanceEnvKey]; ok {
+            t.Error("services running once must not get FOREMAN_INSTANCE")
+        }
+    })
+
+    t.Run("depend on every instance", func(t *testing.T) {
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        worker := foreman.services["worker"]
+        assertList(t, worker.deps, []string{"web.1", "web.2"})
+        assertString(t, fmt.Sprint(worker.depConditions), "map[web.1:service_healthy web.2:service_healthy]")
+
+        foreman.targets = []string{"web"}
+        selected, err := foreman.selectServices(foreman.buildDependencyGraph())
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, selected, []string{"web.1", "web.2"})
+    })
+
+    t.Run("override the instances with a formation", func(t *testing.T) {
+        counts, err := ParseFormation("web=1, worker=3")
+        if err != nil {
+            t.Fatal(err)
+        }
+        foreman, err := New(procfile, WithFormation(counts))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertList(t, serviceNames(foreman), []string{"web", "worker.1", "worker.2", "worker.3"})
+        assertList(t, foreman.services["worker.3"].deps, []string{"web"})
+
+        foreman, err = New(procfile, WithFormation(map[string]int{"worker": 0}))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(foreman.services["worker"].enabled), "false")
+    })
+
+    t.Run("report invalid formations", func(t *testing.T) {
+        _, err := ParseFormation("web=many")
+        assertError(t, err, `invalid formation "web=many", expected service=count`)
+        _, err = New(procfile, WithFormation(map[string]int{"cache": 2}))
+        assertError(t, err, `unknown service "cache"`)
+    })
+}
+
+func TestClassicProcfile(t *testing.T) {
+    writeProcfile := func(t *testing.T, content string) string {
+        t.Helper()
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        err := os.WriteFile(procfile, []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return procfile
+    }
+
+    t.Run("detect the classic format", func(t *testing.T) {
+        procfile := writeProcfile(t, "# processes\nweb: bundle exec puma -p $PORT\n\nworker:  echo \"queue: default\"\n")
+
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, foreman.services["web"].cmd, "bundle exec puma -p $PORT")
+        assertString(t, foreman.services["worker"].cmd, `echo "queue: default"`)
+    })
+
+    t.Run("keep YAML Procfiles", func(t *testing.T) {
+        assertString(t, fmt.Sprint(isClassicProcfile([]byte("web:\n    cmd: ./web\n"))), "false")
+        assertString(t, fmt.Sprint(isClassicProcfile([]byte("web: {cmd: ./web}\n"))), "false")
+        assertString(t, fmt.Sprint(isClassicProcfile([]byte("# empty\n"))), "false")
+    })
+
+    t.Run("select the format", func(t *testing.T) {
+        procfile := writeProcfile(t, "web: ./web\n")
+
+        _, err := New(procfile, WithProcfileFormat(FormatYAML))
+        assertError(t, err, procfile+":1:1: web: service definition must be a mapping")
+
+        _, err = New(procfile, WithProcfileFormat("ini"))
+        assertError(t, err, `unknown Procfile format "ini", expected one of: auto, classic, json, toml, yaml`)
+    })
+
+    t.Run("report malformed lines", func(t *testing.T) {
+        procfile := writeProcfile(t, "web: ./web\n  indented: ./x\n")
+        _, err := New(procfile, WithProcfileFormat(FormatClassic))
+        assertError(t, err, procfile+`:2: expected name: command, got "  indented: ./x"`)
+
+        procfile = writeProcfile(t, "web: ./web\nweb: ./other\n")
+        _, err = New(procfile)
+        assertError(t, err, procfile+`:2: duplicate process "web"`)
+    })
+}
+
+func TestDecoders(t *testing.T) {
+    procfiles := map[string]string{
+        "Procfile.yml": "env:\n    LEVEL: info\n" +
+            "db:\n    cmd: ./db\n    checks:\n        tcp_ports: [5432]\n" +
+            "web:\n    cmd: ./web\n    max_restarts: 3\n    backoff_factor: 1.5\n    deps: [db]\n",
+        "Procfile.toml": "[env]\nLEVEL = \"info\"\n\n" +
+            "[db]\ncmd = \"./db\"\n[db.checks]\ntcp_ports = [5432]\n\n" +
+            "[web]\ncmd = \"./web\"\nmax_restarts = 3\nbackoff_factor = 1.5\ndeps = [\"db\"]\n",
+        "Procfile.json": `{"env": {"LEVEL": "info"},
+            "db": {"cmd": "./db", "checks": {"tcp_ports": [5432]}},
+            "web": {"cmd": "./web", "max_restarts": 3, "backoff_factor": 1.5, "deps": ["db"]}}`,
+    }
+
+    dir := t.TempDir()
+    for name, content := range procfiles {
+        err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for name := range procfiles {
+        t.Run(name, func(t *testing.T) {
+            foreman, err := New(filepath.Join(dir, name))
+            if err != nil {
+                t.Fatal(err)
+            }
+            db, web := foreman.services["db"], foreman.services["web"]
+            assertList(t, db.checks.tcpPorts, []string{"5432"})
+            assertString(t, web.cmd, "./web")
+            assertString(t, fmt.Sprint(web.maxRestarts, web.backoffFactor), "3 1.5")
+            assertList(t, web.deps, []string{"db"})
+            assertString(t, web.env["LEVEL"], "info")
+        })
+    }
+
+    t.Run("select the decoder", func(t *testing.T) {
+        procfile := filepath.Join(dir, "Procfile.json")
+        _, err := New(procfile, WithProcfileFormat(FormatTOML))
+        if err == nil {
+            t.Error("expected an error decoding JSON as TOML")
+        }
+
+        decoder := staticDecoder{"api": map[string]any{"cmd": "./api"}}
+        foreman, err := New(procfile, WithDecoder(decoder), WithProcfileFormat(FormatTOML))
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(len(foreman.services)), "1")
+        assertString(t, foreman.services["api"].cmd, "./api")
+    })
+}
+
+// staticDecoder decodes every Procfile to the same services.
+type staticDecoder map[string]any
+
+func (d staticDecoder) Decode(procfilePath string, data []byte) (map[string]any, error) {
+    procfileMap := make(map[string]any, len(d))
+    for key, value := range d {
+        procfileMap[key] = value
+    }
+    return procfileMap, nil
+}
+
+func TestOverrides(t *testing.T) {
+    dir := t.TempDir()
+    files := map[string]string{
+        "base.yml": "web:\n    cmd: ./web\n    stop_timeout: 5s\n    env:\n        LEVEL: info\n        NAME: web\n" +
+            "    checks:\n        cmd: curl localhost\n        interval: 1s\n" +
+            "db:\n    cmd: ./db\n",
+        "override.yml": "web:\n    cmd: ./web --debug\n    env:\n        LEVEL: debug\n    checks:\n        interval: 5s\n" +
+            "cache:\n    cmd: ./cache\n",
+        "local.yml": "web:\n    stop_timeout: 1s\n",
+    }
+    for name, content := range files {
+        err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    foreman, err := New(filepath.Join(dir, "base.yml"), WithOverrides(filepath.Join(dir, "override.yml"), filepath.Join(dir, "local.yml")))
+    if err != nil {
+        t.Fatal(err)
+    }
+    assertString(t, fmt.Sprint(len(foreman.services)), "3")
+    web := foreman.services["web"]
+    assertString(t, web.cmd, "./web --debug")
+    assertString(t, web.stopTimeout.String(), "1s")
+    assertString(t, fmt.Sprint(web.env["LEVEL"], " ", web.env["NAME"]), "debug web")
+    assertString(t, web.checks.cmd, "curl localhost")
+    assertString(t, web.checks.interval.String(), "5s")
+}
+
+func TestProfiles(t *testing.T) {
+    procfile := filepath.Join(t.TempDir(), "Procfile")
+    content := "web:\n    cmd: ./web\n    env:\n        LEVEL: info\n" +
+        "    profiles:\n        dev:\n            cmd: ./web --reload\n            env:\n                LEVEL: debug\n        prod:\n" +
+        "debugger:\n    cmd: ./debugger\n    profiles: [dev]\n" +
+        "db:\n    cmd: ./db\n"
+    err := os.WriteFile(procfile, []byte(content), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Run("run services without profiles by default", func(t *testing.T) {
+        foreman, err := New(procfile)
+        if err != nil {
+            t.Fatal(err)
+        }
+        assertString(t, fmt.Sprint(foreman.services["db"].enabled, foreman.services["web"].enabled, foreman.services["debugger"].enabled), "true false false")
+    })
+
+    t.Run("apply the selected profile", func(t *testing.T) {
+        foreman, err := New(procfile, WithProfiles("dev"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        web := foreman.services["web"]
+        assertString(t, fmt.Sprint(foreman.services["db"].enabled, web.enabled, foreman.services["debugger"].enabled), "true true true")
+        assertString(t, web.cmd, "./web --reload")
+        assertString(t, web.env["LEVEL"], "debug")
+
+        foreman, err = New(procfile, WithProfiles("prod"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        web = foreman.services["web"]
+        assertString(t, fmt.Sprint(web.enabled, foreman.services["debugger"].enabled), "true false")
+        assertString(t, web.cmd, "./web")
+    })
+
+    t.Run("report invalid profiles", func(t *testing.T) {
+        _, _, err := applyProfiles(map[string]any{"profiles": "dev"}, nil)
+        assertError(t, err, "profiles: expected a list of profiles or a mapping of profile overrides, got dev")
+
+        diagnostics := unknownKeys("web", map[string]any{"profiles": map[string]any{"dev": map[string]any{"comand": "x"}}})
+        assertString(t, fmt.Sprint(len(diagnostics)), "1")
+        assertString(t, diagnostics[0].String(), "web: profiles.dev.comand: unknown key")
+    })
+}
+
+func TestStrictParsing(t *testing.T) {
+    writeFile := func(t *testing.T, path, content string) {
+        t.Helper()
+        err := os.WriteFile(path, []byte(content), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    t.Run("reject unknown keys", func(t *testing.T) {
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        writeFile(t, procfile, "web:\n    cmd: ./web\n    checks:\n        comand: true\n")
+
+        _, err := New(procfile)
+        assertError(t, err, procfile+":4:9: web: checks.comand: unknown key")
+    })
+
+    t.Run("locate fields of the defaults and included files", func(t *testing.T) {
+        dir := t.TempDir()
+        writeFile(t, filepath.Join(dir, "common.yml"), "db:\n    cmd: ./db\n    enabled: sometimes\n")
+        writeFile(t, filepath.Join(dir, "Procfile"), "include:\n    - common.yml\ndefaults:\n    stop_timeout: later\nweb:\n    cmd: ./web\n")
+
+        var got []string
+        for _, diagnostic := range Validate(filepath.Join(dir, "Procfile")) {
+            got = append(got, diagnostic.String())
+        }
+        assertList(t, got, []string{
+            filepath.Join(dir, "common.yml") + ":3:5: db: enabled: expected a boolean, got sometimes",
+            filepath.Join(dir, "Procfile") + `:4:5: db: stop_timeout: invalid duration "later"`,
+            filepath.Join(dir, "Procfile") + `:4:5: web: stop_timeout: invalid duration "later"`,
+        })
+    })
+}
+
+func TestValidate(t *testing.T) {
+    t.Run("report every problem", func(t *testing.T) {
+        var got []string
+        for _, diagnostic := range Validate(testInvalidProcfile) {
+            got = append(got, diagnostic.String())
+        }
+
+        want := []string{
+            testInvalidProcfile + ":13:9: db: checks.tcp_ports: expected a port number, got x",
+            testInvalidProcfile + `:11:5: db: stop_timeout: invalid duration "soon"`,
+            testInvalidProcfile + ":4:5: web: restrt: unknown key",
+            testInvalidProcfile + ":6:9: web: checks.intervl: unknown key",
+            testInvalidProcfile + ":2:5: web: cmd: expected a command line or a list of arguments, got 3",
+            testInvalidProcfile + ":3:5: web: deps: unknown service cache",
+            "Cyclic dependency detected: db -> web -> db",
+        }
+        assertList(t, got, want)
+    })
+
+    t.Run("valid procfile", func(t *testing.T) {
+        diagnostics := Validate(testChainProcfile)
+        if len(diagnostics) != 0 {
+            t.Errorf("unexpected diagnostics: %v", diagnostics)
+        }
+    })
+
+    t.Run("duplicate service", func(t *testing.T) {
+        procfile := filepath.Join(t.TempDir(), "Procfile")
+        err := os.WriteFile(procfile, []byte("app:\n    cmd: a\napp:\n    cmd: b\n"), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        var got []string
+        for _, diagnostic := range Validate(procfile) {
+            got = append(got, diagnostic.String())
+        }
+        assertList(t, got, []string{`line 3: mapping key "app" already defined at line 1`})
+    })
+}
+
+func TestKnownKeysAreParsed(t *testing.T) {
+    // A key listed as known but missing from the parser would be silently ignored.
+    for _, key := range serviceKeys {
+        // Profiles are applied before the service is parsed.
+        if key == profilesKey {
+            continue
+        }
+        _, err := parseService(map[string]any{key: struct{}{}})
+        if err == nil {
+            t.Errorf("expected the parser to check the %s key", key)
+        }
+    }
+    for _, key := range checkKeys {
+        err := parseCheck("checks", map[string]any{key: struct{}{}}, &Checks{})
+        if err == nil {
+            t.Errorf("expected the parser to check the checks.%s key", key)
+        }
+    }
+}
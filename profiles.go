@@ -0,0 +1,74 @@
+package foreman
+
+import "fmt"
+
+const profilesKey = "profiles"
+
+// Apply the overrides of the selected profiles to a service definition, in the
+// order they were selected, and report whether the service belongs to one of
+// them. Services without profiles belong to every profile. The definition isn't
+// modified, a copy is returned.
+func applyProfiles(serviceMap map[string]any, selected []string) (map[string]any, bool, error) {
+    value, ok := serviceMap[profilesKey]
+    if !ok {
+        return serviceMap, true, nil
+    }
+    overrides, err := parseProfiles(profilesKey, value)
+    if err != nil {
+        return nil, false, err
+    }
+
+    applied := make(map[string]any, len(serviceMap))
+    for key, value := range serviceMap {
+        if key != profilesKey {
+            applied[key] = value
+        }
+    }
+
+    member := false
+    for _, profile := range selected {
+        override, ok := overrides[profile]
+        if !ok {
+            continue
+        }
+        member = true
+        applied = applyDefaults(override, applied)
+    }
+    return applied, member, nil
+}
+
+// Parse profiles, either a list of profile names or a mapping of profile names
+// to the fields the profile overrides.
+func parseProfiles(field string, value any) (map[string]map[string]any, error) {
+    profiles := make(map[string]map[string]any)
+    if _, ok := value.([]any); ok {
+        names, err := parseStringList(field, value)
+        if err != nil {
+            return nil, err
+        }
+        for _, name := range names {
+            profiles[name] = nil
+        }
+        return profiles, nil
+    }
+
+    profileMap, ok := value.(map[string]any)
+    if !ok {
+        return nil, fieldError(field, "expected a list of profiles or a mapping of profile overrides, got %v", value)
+    }
+    for name, override := range profileMap {
+        if override == nil {
+            profiles[name] = nil
+            continue
+        }
+        overrideMap, ok := override.(map[string]any)
+        if !ok {
+            return nil, fieldError(fmt.Sprintf("%s.%s", field, name), "expected a mapping of fields, got %v", override)
+        }
+        if _, ok := overrideMap[profilesKey]; ok {
+            return nil, fieldError(fmt.Sprintf("%s.%s", field, name), "profiles can't be overridden")
+        }
+        profiles[name] = overrideMap
+    }
+    return profiles, nil
+}
@@ -0,0 +1,140 @@
+package foreman
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+// Parse the Procfile again and apply the changes: the services it adds are
+// started, the ones it drops are stopped and unregistered, and the ones whose
+// definition changed are restarted with their active dependents, unless
+// disabled by WithRestartDependents(false). The other services keep running,
+// and so do the services added with AddService. A Procfile that fails to
+// parse or validate leaves the services untouched. Before Start, the
+// definitions are only replaced.
+func (f *Foreman) Reload() error {
+    if atomic.LoadInt32(&f.lifecycle) == lifecycleStopped {
+        return f.lifecycleError()
+    }
+    f.reloadMu.Lock()
+    defer f.reloadMu.Unlock()
+
+    reloaded, err := New(f.procfilePath, f.options...)
+    if err != nil {
+        return err
+    }
+    reloaded.notifier.close()
+
+    current := f.snapshot()
+    var added, removed, changed []string
+    for serviceName, service := range reloaded.services {
+        old, ok := current[serviceName]
+        if !ok {
+            added = append(added, serviceName)
+        } else if !reflect.DeepEqual(old.spec(), service.spec()) {
+            changed = append(changed, serviceName)
+        }
+    }
+    for serviceName, service := range current {
+        if _, ok := reloaded.services[serviceName]; !ok && service.fromProcfile {
+            removed = append(removed, serviceName)
+        }
+    }
+    if len(added)+len(removed)+len(changed) == 0 {
+        return nil
+    }
+
+    // The services added in code must still fit the reloaded ones.
+    candidate := make(map[string]Service, len(current))
+    for serviceName, service := range current {
+        candidate[serviceName] = service
+    }
+    for _, serviceName := range removed {
+        delete(candidate, serviceName)
+    }
+    for _, serviceName := range append(added, changed...) {
+        candidate[serviceName] = reloaded.services[serviceName]
+    }
+    f.mu.Lock()
+    f.services, candidate = candidate, f.services
+    err = f.validateGraph()
+    f.services = candidate
+    f.mu.Unlock()
+    if err != nil {
+        return err
+    }
+
+    // Stop the removed and changed services, with the active dependents of
+    // the changed ones.
+    stopping := append(append([]string(nil), removed...), changed...)
+    if f.restartDependents {
+        dependents := f.buildDependencyGraph().reverse()
+        seen := make(map[string]bool)
+        for _, serviceName := range stopping {
+            seen[serviceName] = true
+        }
+        for _, serviceName := range changed {
+            for _, dependent := range dependents.reachable(serviceName) {
+                if !seen[dependent] && current[dependent].active {
+                    seen[dependent] = true
+                    stopping = append(stopping, dependent)
+                }
+            }
+        }
+    }
+    for _, serviceName := range stopping {
+        f.stopScheduler(serviceName)
+    }
+    f.requestStop(stopping)
+    f.stopGroup(stopping)
+
+    f.mu.Lock()
+    for _, serviceName := range removed {
+        delete(f.services, serviceName)
+    }
+    for _, serviceName := range append(added, changed...) {
+        f.services[serviceName] = reloaded.services[serviceName]
+    }
+    f.mu.Unlock()
+
+    sort.Strings(added)
+    sort.Strings(removed)
+    sort.Strings(changed)
+    f.log(LogRecord{Level: LevelInfo, Event: "reload", Message: fmt.Sprintf("reloaded the Procfile: added %v, removed %v, changed %v", added, removed, changed)})
+    if atomic.LoadInt32(&f.lifecycle) != lifecycleStarted {
+        return nil
+    }
+
+    // Start the added services and restart the stopped ones, as long as Start
+    // would start them. Changed services stopped on purpose stay stopped, unless
+    // they are scheduled.
+    starting := make(map[string]bool)
+    for _, serviceName := range append(added, stopping...) {
+        starting[serviceName] = true
+    }
+    for _, serviceName := range changed {
+        old := current[serviceName]
+        starting[serviceName] = old.active || !old.enabled || old.stopScheduler != nil
+    }
+    selected, err := f.selectServices(f.buildDependencyGraph())
+    if err != nil {
+        return err
+    }
+    var startList []string
+    for _, serviceName := range selected {
+        if starting[serviceName] {
+            startList = append(startList, serviceName)
+        }
+    }
+    return f.launch(startList)
+}
+
+// Reload the Procfile on SIGHUP, logging why it failed.
+func (f *Foreman) reloadOnSignal() {
+    err := f.Reload()
+    if err != nil {
+        f.log(LogRecord{Level: LevelError, Event: "reload", Message: fmt.Sprintf("reloading the Procfile failed: %v", err)})
+    }
+}
@@ -0,0 +1,41 @@
+package foreman
+
+// RestartPolicy decides whether a service is restarted after its process exits.
+// Services stopped by foreman itself are never restarted, whatever their policy.
+type RestartPolicy string
+
+const (
+    RestartAlways RestartPolicy = "always"
+    RestartOnFailure RestartPolicy = "on-failure"
+    RestartUnlessStopped RestartPolicy = "unless-stopped"
+    RestartNo RestartPolicy = "no"
+)
+
+// Parse the restart field. A plain false is read as "no".
+func parseRestartPolicy(field string, value any) (RestartPolicy, error) {
+    switch v := value.(type) {
+    case bool:
+        if !v {
+            return RestartNo, nil
+        }
+        return RestartAlways, nil
+    case string:
+        policy := RestartPolicy(v)
+        switch policy {
+        case RestartAlways, RestartOnFailure, RestartUnlessStopped, RestartNo:
+            return policy, nil
+        }
+    }
+    return "", fieldError(field, "expected always, on-failure, unless-stopped or no, got %v", value)
+}
+
+// Report whether a process that exited on its own with exitCode should be started again.
+func (p RestartPolicy) shouldRestart(exitCode int) bool {
+    switch p {
+    case RestartNo:
+        return false
+    case RestartOnFailure:
+        return exitCode != 0
+    }
+    return true
+}
@@ -0,0 +1,64 @@
+package foreman
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Run a single service once, like a migration or a seed task, and return its exit code.
+// With withDeps its dependency chain is started first and stopped once it exits;
+// without it the dependencies are assumed to be available already. The service is
+// never restarted. It can only be called once, instead of Start.
+func (f *Foreman) Run(ctx context.Context, serviceName string, withDeps bool) (int, error) {
+    if !atomic.CompareAndSwapInt32(&f.lifecycle, lifecycleNew, lifecycleStarted) {
+        return 0, f.lifecycleError()
+    }
+
+    f.mu.Lock()
+    service, ok := f.services[serviceName]
+    if !ok {
+        f.mu.Unlock()
+        atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+        return 0, &UnknownServiceError{Service: serviceName}
+    }
+    depGraph := graphOf(f.services)
+    service.restart = RestartNo
+    if !withDeps {
+        service.deps = nil
+    }
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    cycle := depGraph.findCycle()
+    if cycle != nil {
+        atomic.StoreInt32(&f.lifecycle, lifecycleNew)
+        return 0, &CyclicDependencyError{Cycle: cycle}
+    }
+    // Shut down like Stop once the services are stopped.
+    defer atomic.StoreInt32(&f.lifecycle, lifecycleStopped)
+    defer f.notifier.close()
+    defer close(f.done)
+    defer f.startForwarding()()
+    defer f.stopAll()
+
+    if withDeps {
+        err := f.startGroup(depGraph.reachable(serviceName))
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    err := f.startService(serviceName)
+    if err != nil {
+        return 0, err
+    }
+
+    service, _ = f.service(serviceName)
+    select {
+    case <-service.exited:
+        service, _ = f.service(serviceName)
+        return service.exitCode, nil
+    case <-ctx.Done():
+        return 0, ctx.Err()
+    }
+}
@@ -0,0 +1,149 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// Runner launches and controls the processes of the services.
+// The default runner wraps os/exec, tests can inject a fake one.
+type Runner interface {
+    // Start launches the command and returns the pid of the new process.
+    Start(cmd *exec.Cmd) (int, error)
+    // Signal sends sig to the process, signal 0 only checks it is alive.
+    Signal(pid int, sig syscall.Signal) error
+    // Wait blocks until the process exits and returns its exit code.
+    Wait(pid int) (int, error)
+}
+
+// GroupSignaler is implemented by runners able to signal the process group a
+// service process leads, like the default one. Foreman stops a service through
+// its group, so the processes its shell started stop too, and kills what is
+// left of the group once the service process exits.
+type GroupSignaler interface {
+    // SignalGroup sends sig to every process of the group led by pid.
+    SignalGroup(pid int, sig syscall.Signal) error
+}
+
+// SignalWaiter is implemented by runners telling which signal killed a
+// process, like the default one. Foreman reports that signal along with the
+// exit code of the services.
+type SignalWaiter interface {
+    // WaitSignal blocks until the process exits and returns its exit code,
+    // 128 plus the signal number when a signal killed it, and that signal.
+    WaitSignal(pid int) (int, syscall.Signal, error)
+}
+
+type execRunner struct {
+    mu sync.Mutex
+    processes map[int]*os.Process
+}
+
+func newExecRunner() *execRunner {
+    return &execRunner{
+        processes: make(map[int]*os.Process),
+    }
+}
+
+func (r *execRunner) Start(cmd *exec.Cmd) (int, error) {
+    err := startChild(cmd)
+    if err != nil {
+        return 0, err
+    }
+
+    r.mu.Lock()
+    r.processes[cmd.Process.Pid] = cmd.Process
+    r.mu.Unlock()
+
+    return cmd.Process.Pid, nil
+}
+
+func (r *execRunner) Signal(pid int, sig syscall.Signal) error {
+    return signalProcess(pid, sig)
+}
+
+func (r *execRunner) SignalGroup(pid int, sig syscall.Signal) error {
+    return signalProcessGroup(pid, sig)
+}
+
+func (r *execRunner) Wait(pid int) (int, error) {
+    exitCode, _, err := r.WaitSignal(pid)
+    return exitCode, err
+}
+
+func (r *execRunner) WaitSignal(pid int) (int, syscall.Signal, error) {
+    r.mu.Lock()
+    process, ok := r.processes[pid]
+    r.mu.Unlock()
+    if !ok {
+        return 0, 0, fmt.Errorf("unknown process %d", pid)
+    }
+
+    var status syscall.WaitStatus
+    var err error
+    // While foreman reaps as a subreaper, the SIGCHLD handler waits for the
+    // process instead.
+    if c := awaitChild(pid); c != nil && c.reaped {
+        process.Release()
+        status = c.status
+    } else {
+        var state *os.ProcessState
+        state, err = process.Wait()
+        if err == nil {
+            status = state.Sys().(syscall.WaitStatus)
+        }
+    }
+    forgetChild(pid)
+
+    r.mu.Lock()
+    delete(r.processes, pid)
+    r.mu.Unlock()
+
+    if err != nil {
+        return 0, 0, err
+    }
+    exitCode, sig := exitStatus(status)
+    return exitCode, sig, nil
+}
+
+// Wait for a service process to exit and return its exit code, along with the
+// signal that killed it when the runner tells.
+func (f *Foreman) wait(pid int) (int, syscall.Signal, error) {
+    if runner, ok := f.runner.(SignalWaiter); ok {
+        return runner.WaitSignal(pid)
+    }
+    exitCode, err := f.runner.Wait(pid)
+    return exitCode, 0, err
+}
+
+// Send sig to the process group of a service process, or to the process alone
+// when the runner can't signal groups.
+func (f *Foreman) signalGroup(pid int, sig syscall.Signal) error {
+    if runner, ok := f.runner.(GroupSignaler); ok {
+        return runner.SignalGroup(pid, sig)
+    }
+    return f.runner.Signal(pid, sig)
+}
+
+// Kill the processes left in the group of a service process that exited, like
+// the children of its shell, so none of them is orphaned. It does nothing on
+// Windows, where the pid may already belong to another process.
+func (f *Foreman) killLeftovers(service Service) {
+    runner, ok := f.runner.(GroupSignaler)
+    if !ok || !groupOutlivesLeader {
+        return
+    }
+    // The group is gone when every process of it exited.
+    if runner.SignalGroup(service.pid, syscall.SIGKILL) == nil {
+        f.log(LogRecord{
+        	Level:   LevelWarning,
+        	Event:   "killed",
+        	Service: service.serviceName,
+        	PID:     service.pid,
+        	Message: fmt.Sprintf("%d %s: killed the processes left in its process group", service.pid, service.serviceName),
+        })
+    }
+}
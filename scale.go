@@ -0,0 +1,122 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+    instancesKey = "instances"
+    // instanceEnvKey holds the number of the instance of a scaled service, from 1.
+    instanceEnvKey = "FOREMAN_INSTANCE"
+)
+
+// Return how many instances of a service run: its WithFormation count, else
+// its instances field, else 1.
+func (f *Foreman) instanceCount(serviceName string, serviceMap map[string]any) (int, error) {
+    if count, ok := f.formation[serviceName]; ok {
+        return count, nil
+    }
+    value, ok := serviceMap[instancesKey]
+    if !ok {
+        return 1, nil
+    }
+    return parseCount(instancesKey, value)
+}
+
+// Return the name of an instance of a service: the service name when it runs
+// once, else the service name and the instance number, like web.2.
+func instanceName(serviceName string, instance, instances int) string {
+    if instances <= 1 {
+        return serviceName
+    }
+    return fmt.Sprintf("%s.%d", serviceName, instance)
+}
+
+// Return the environment an instance adds to the service environment: its
+// PORT, the service port plus the instance number, and FOREMAN_INSTANCE for
+// scaled services.
+func instanceEnv(port, instance, instances int) map[string]string {
+    env := map[string]string{"PORT": strconv.Itoa(port + instance - 1)}
+    if instances > 1 {
+        env[instanceEnvKey] = strconv.Itoa(instance)
+    }
+    return env
+}
+
+// Make the services depending on a scaled service depend on all of its
+// instances. scaled maps the scaled services to the names of their instances.
+func (f *Foreman) expandInstanceDeps(scaled map[string][]string) {
+    if len(scaled) == 0 {
+        return
+    }
+    for serviceName, service := range f.services {
+        var deps []string
+        for _, depName := range service.deps {
+            instances, ok := scaled[depName]
+            if !ok {
+                deps = append(deps, depName)
+                continue
+            }
+            deps = append(deps, instances...)
+            for _, instance := range instances {
+                if condition, ok := service.depConditions[depName]; ok {
+                    service.depConditions[instance] = condition
+                }
+                if timeout, ok := service.depTimeouts[depName]; ok {
+                    service.depTimeouts[instance] = timeout
+                }
+            }
+            delete(service.depConditions, depName)
+            delete(service.depTimeouts, depName)
+        }
+        service.deps = deps
+        f.services[serviceName] = service
+    }
+}
+
+// Parse a formation like web=3,worker=2 into instance counts by service.
+func ParseFormation(formation string) (map[string]int, error) {
+    counts := make(map[string]int)
+    for _, entry := range strings.Split(formation, ",") {
+        serviceName, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+        count, err := strconv.Atoi(value)
+        if !ok || serviceName == "" || err != nil || count < 0 {
+            return nil, fmt.Errorf("invalid formation %q, expected service=count", entry)
+        }
+        counts[serviceName] = count
+    }
+    return counts, nil
+}
+
+// Return the names of the services of the formation missing from serviceNames, sorted.
+func unknownFormation(formation map[string]int, serviceNames []string) []string {
+    known := make(map[string]bool, len(serviceNames))
+    for _, serviceName := range serviceNames {
+        known[serviceName] = true
+    }
+    var unknown []string
+    for serviceName := range formation {
+        if !known[serviceName] {
+            unknown = append(unknown, serviceName)
+        }
+    }
+    sort.Strings(unknown)
+    return unknown
+}
+
+// Report whether serviceName is a scaled service, whose instances are services.
+func isScaled(serviceName string, services map[string]Service) bool {
+    first, ok := services[instanceName(serviceName, 1, 2)]
+    if !ok {
+        return false
+    }
+    for _, group := range first.groups {
+        if group == serviceName {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,206 @@
+package foreman
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// OverlapPolicy decides what a scheduled run does when the previous run of
+// the service is still going.
+type OverlapPolicy string
+
+const (
+    OverlapSkip OverlapPolicy = "skip"
+    OverlapQueue OverlapPolicy = "queue"
+    OverlapKillPrevious OverlapPolicy = "kill-previous"
+)
+
+// Schedule turns a service into a job launched on a cron expression instead
+// of running all the time.
+type Schedule struct {
+    // Cron is a standard 5 fields cron expression, like "*/5 * * * *", or a
+    // descriptor like "@hourly" or "@every 90s".
+    Cron string `yaml:"cron"`
+    // Overlap is what happens when a run is due while the previous one is
+    // still going: skip (default) the run, queue it for when the previous one
+    // exits, or kill-previous to stop the previous one first.
+    Overlap OverlapPolicy `yaml:"overlap"`
+}
+
+// Parse schedule, a cron expression or a mapping of cron and overlap.
+func parseSchedule(field string, value any) (Schedule, error) {
+    if expression, ok := value.(string); ok {
+        value = map[string]any{"cron": expression}
+    }
+    scheduleMap, ok := value.(map[string]any)
+    if !ok {
+        return Schedule{}, fieldError(field, "expected a cron expression or a mapping of cron and overlap, got %v", value)
+    }
+
+    var schedule Schedule
+    var err error
+    for key, value := range scheduleMap {
+        switch key {
+        case "cron":
+            schedule.Cron, err = parseString(field+".cron", value)
+            if err == nil {
+                _, err = schedule.parse()
+                if err != nil {
+                    err = fieldError(field+".cron", "invalid cron expression %q: %v", schedule.Cron, err)
+                }
+            }
+        case "overlap":
+            schedule.Overlap, err = parseOverlapPolicy(field+".overlap", value)
+        default:
+            err = fieldError(field+"."+key, "unknown key")
+        }
+        if err != nil {
+            return Schedule{}, err
+        }
+    }
+    if schedule.Cron == "" {
+        return Schedule{}, fieldError(field+".cron", "a cron expression is required")
+    }
+    return schedule, nil
+}
+
+func parseOverlapPolicy(field string, value any) (OverlapPolicy, error) {
+    policy, _ := value.(string)
+    switch OverlapPolicy(policy) {
+    case OverlapSkip, OverlapQueue, OverlapKillPrevious:
+        return OverlapPolicy(policy), nil
+    }
+    return "", fieldError(field, "expected skip, queue or kill-previous, got %v", value)
+}
+
+func (s Schedule) parse() (cron.Schedule, error) {
+    return cron.ParseStandard(s.Cron)
+}
+
+// Report whether the service is launched on a schedule.
+func (s Service) scheduled() bool {
+    return s.schedule.Cron != ""
+}
+
+// Start launching a scheduled service on its schedule, unless it already is.
+func (f *Foreman) startScheduler(serviceName string) {
+    f.mu.Lock()
+    service := f.services[serviceName]
+    if service.stopScheduler != nil {
+        f.mu.Unlock()
+        return
+    }
+    service.stopScheduler = make(chan struct{})
+    f.services[serviceName] = service
+    f.mu.Unlock()
+
+    go f.scheduler(service)
+}
+
+// Stop launching a scheduled service, leaving its current run alone.
+func (f *Foreman) stopScheduler(serviceName string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    service, ok := f.services[serviceName]
+    if !ok || service.stopScheduler == nil {
+        return
+    }
+    close(service.stopScheduler)
+    service.stopScheduler = nil
+    service.nextRun = time.Time{}
+    f.services[serviceName] = service
+}
+
+// Launch a service every time its schedule is due, until its scheduler is
+// stopped or foreman stops.
+func (f *Foreman) scheduler(service Service) {
+    serviceName := service.serviceName
+    schedule, err := service.schedule.parse()
+    if err != nil {
+        f.log(LogRecord{Level: LevelError, Event: "schedule", Service: serviceName, Message: fmt.Sprintf("%s: invalid schedule: %v", serviceName, err)})
+        return
+    }
+
+    for {
+        now := f.clock.Now()
+        next := schedule.Next(now)
+        f.mu.Lock()
+        current := f.services[serviceName]
+        if current.stopScheduler == service.stopScheduler {
+            current.nextRun = next
+            f.services[serviceName] = current
+        }
+        f.mu.Unlock()
+
+        select {
+        case <-f.clock.After(next.Sub(now)):
+        case <-service.stopScheduler:
+            return
+        case <-f.done:
+            return
+        }
+        f.runScheduled(serviceName)
+    }
+}
+
+// Launch a scheduled run of a service, applying its overlap policy when the
+// previous run is still going.
+func (f *Foreman) runScheduled(serviceName string) {
+    f.mu.Lock()
+    if !f.active {
+        f.mu.Unlock()
+        return
+    }
+    service := f.services[serviceName]
+    running := service.active
+    if running && service.schedule.Overlap == OverlapQueue {
+        service.queued = true
+        f.services[serviceName] = service
+    }
+    f.mu.Unlock()
+
+    if running {
+        switch service.schedule.Overlap {
+        case OverlapQueue:
+            f.log(LogRecord{Event: "schedule", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: still running, queueing the next run", service.pid, serviceName)})
+            return
+        case OverlapKillPrevious:
+            f.log(LogRecord{Level: LevelWarning, Event: "schedule", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: still running, stopping it for the next run", service.pid, serviceName)})
+            f.requestStop([]string{serviceName})
+            f.stopService(serviceName)
+        default:
+            f.log(LogRecord{Level: LevelWarning, Event: "schedule", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: still running, skipping the run", service.pid, serviceName)})
+            return
+        }
+    }
+
+    err := f.startService(serviceName)
+    if err != nil {
+        f.log(LogRecord{Level: LevelError, Event: "schedule", Service: serviceName, Message: fmt.Sprintf("%s: scheduled run failed: %v", serviceName, err)})
+    }
+}
+
+// Start a group of services in dependency order, and the schedulers of the
+// scheduled ones instead of launching them, once the others started.
+func (f *Foreman) launch(serviceNames []string) error {
+    services := f.snapshot()
+    var started, scheduled []string
+    for _, serviceName := range serviceNames {
+        if services[serviceName].scheduled() {
+            scheduled = append(scheduled, serviceName)
+        } else {
+            started = append(started, serviceName)
+        }
+    }
+
+    err := f.startGroup(started)
+    if err != nil {
+        return err
+    }
+    for _, serviceName := range scheduled {
+        f.startScheduler(serviceName)
+    }
+    return nil
+}
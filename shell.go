@@ -0,0 +1,101 @@
+package foreman
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// shellNone runs commands directly, split on spaces, without a shell.
+const shellNone = "none"
+
+// Return bash -c, or sh -c on systems without bash like Alpine, or cmd /C on
+// Windows without either.
+func defaultShell() []string {
+    if _, err := exec.LookPath("bash"); err != nil {
+        if _, err := exec.LookPath("sh"); err != nil && runtime.GOOS == "windows" {
+            return []string{"cmd", "/C"}
+        }
+        return []string{"sh", "-c"}
+    }
+    return []string{"bash", "-c"}
+}
+
+// Parse the shell running the commands of a service, either a command line like
+// "sh -c" or a list like [sh, -c], or none to run them without a shell.
+func parseShell(field string, value any) ([]string, error) {
+    var shell []string
+    if str, ok := value.(string); ok {
+        shell = strings.Fields(str)
+    } else {
+        list, err := parseStringList(field, value)
+        if err != nil {
+            return nil, fieldError(field, "expected a shell like \"sh -c\" or a list of strings, got %v", value)
+        }
+        shell = list
+    }
+    if len(shell) == 0 {
+        return nil, fieldError(field, "expected a shell like \"sh -c\", got an empty one")
+    }
+    if shell[0] == shellNone && len(shell) > 1 {
+        return nil, fieldError(field, "%s takes no flags, got %v", shellNone, value)
+    }
+    return shell, nil
+}
+
+// Parse the command of a service, either a command line run through the shell
+// or a list of arguments run directly. The command line of a list is returned
+// too, quoted for the shell.
+func parseCmd(field string, value any) (string, []string, error) {
+    if cmd, ok := value.(string); ok {
+        return cmd, nil, nil
+    }
+    argv, err := parseStringList(field, value)
+    if err != nil {
+        return "", nil, fieldError(field, "expected a command line or a list of arguments, got %v", value)
+    }
+    if len(argv) == 0 {
+        return "", nil, fieldError(field, "expected a command line or a list of arguments, got an empty list")
+    }
+    return shellJoin(argv), argv, nil
+}
+
+// Join arguments into a command line, quoting the ones the shell would split
+// or expand.
+func shellJoin(argv []string) string {
+    quoted := make([]string, len(argv))
+    for i, arg := range argv {
+        quoted[i] = arg
+        if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`!*?[]{}()<>|&;#~") {
+            quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+        }
+    }
+    return strings.Join(quoted, " ")
+}
+
+// Parse a umask, an octal string like "022" or a number like YAML's 022.
+// It is returned as 3 octal digits.
+func parseUmask(field string, value any) (string, error) {
+    var mask uint64
+    switch v := value.(type) {
+    case int:
+        if v < 0 {
+            return "", fieldError(field, "expected an octal umask like \"022\", got %v", value)
+        }
+        mask = uint64(v)
+    case string:
+        var err error
+        mask, err = strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+        if err != nil {
+            return "", fieldError(field, "expected an octal umask like \"022\", got %v", value)
+        }
+    default:
+        return "", fieldError(field, "expected an octal umask like \"022\", got %v", value)
+    }
+    if mask > 0777 {
+        return "", fieldError(field, "expected an octal umask up to 0777, got %v", value)
+    }
+    return fmt.Sprintf("%03o", mask), nil
+}
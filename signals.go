@@ -0,0 +1,192 @@
+package foreman
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// defaultStopSignal is sent to stop services without a stop_signal.
+const defaultStopSignal = syscall.SIGINT
+
+var signalNames = map[string]syscall.Signal{
+    "HUP":  syscall.SIGHUP,
+    "INT":  syscall.SIGINT,
+    "QUIT": syscall.SIGQUIT,
+    "KILL": syscall.SIGKILL,
+    "USR1": sigUSR1,
+    "USR2": sigUSR2,
+    "TERM": syscall.SIGTERM,
+    "CONT": sigCONT,
+    "STOP": sigSTOP,
+    "TSTP": sigTSTP,
+    "WINCH": sigWINCH,
+    "CHLD": sigCHLD,
+    "ABRT": syscall.SIGABRT,
+    "ALRM": syscall.SIGALRM,
+    "BUS":  syscall.SIGBUS,
+    "FPE":  syscall.SIGFPE,
+    "ILL":  syscall.SIGILL,
+    "PIPE": syscall.SIGPIPE,
+    "SEGV": syscall.SIGSEGV,
+}
+
+// Parse a signal name like "HUP" or "SIGHUP".
+func parseSignal(name string) (syscall.Signal, error) {
+    sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+    if !ok {
+        return 0, fmt.Errorf("unknown signal %q", name)
+    }
+    return sig, nil
+}
+
+// Parse the signals a service wants forwarded from foreman.
+// SIGINT, SIGTERM, SIGQUIT and SIGCHLD drive foreman itself and can't be
+// forwarded.
+func parseForwardSignals(field string, value any) ([]syscall.Signal, error) {
+    names, err := parseStringList(field, value)
+    if err != nil {
+        return nil, err
+    }
+
+    var sigs []syscall.Signal
+    for _, name := range names {
+        sig, err := parseSignal(name)
+        if err != nil {
+            return nil, &ParseError{Field: field, Cause: err}
+        }
+        switch sig {
+        case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, sigCHLD, syscall.SIGKILL, sigSTOP:
+            return nil, fieldError(field, "%s can't be forwarded", name)
+        }
+        sigs = append(sigs, sig)
+    }
+
+    return sigs, nil
+}
+
+// Parse the signal used to stop a service.
+func parseStopSignal(field string, value any) (syscall.Signal, error) {
+    name, ok := value.(string)
+    if !ok {
+        return 0, fieldError(field, "expected a signal name, got %v", value)
+    }
+
+    sig, err := parseSignal(name)
+    if err != nil {
+        return 0, &ParseError{Field: field, Cause: err}
+    }
+    if sig == sigCHLD || sig == sigSTOP || sig == sigCONT {
+        return 0, fieldError(field, "%s can't stop a service", name)
+    }
+    return sig, nil
+}
+
+// Return the signals Start handles: the ones stopping foreman, SIGHUP, and the
+// ones forwarded to the services.
+func (f *Foreman) handledSignals() []os.Signal {
+    sigs := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP}
+    forwarded := f.forwardedSignals()
+    if f.init {
+        forwarded = initForwardedSignals
+    }
+    for _, sig := range forwarded {
+        if sig != syscall.SIGHUP {
+            sigs = append(sigs, sig)
+        }
+    }
+    return sigs
+}
+
+// Handle the signals received by foreman until it stops.
+func (f *Foreman) handleSignals(sigs <-chan os.Signal) {
+    for {
+        select {
+        case sig := <-sigs:
+            switch sig {
+            case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
+                // docker stop sends SIGTERM, and only a graceful stop keeps
+                // the services from being orphaned.
+                go f.sigIntHandler()
+            case syscall.SIGHUP:
+                // Services asking for SIGHUP still get it, on top of the reload.
+                f.forwardSignal(syscall.SIGHUP)
+                go f.reloadOnSignal()
+            default:
+                f.forwardSignal(sig.(syscall.Signal))
+            }
+        case <-f.done:
+            return
+        }
+    }
+}
+
+// Send a signal to the process of a running service, like HUP to make it
+// reload its configuration.
+func (f *Foreman) SignalService(serviceName string, sig syscall.Signal) error {
+    service, ok := f.service(serviceName)
+    if !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+    if !service.active {
+        return fmt.Errorf("%s isn't running", serviceName)
+    }
+
+    f.log(LogRecord{Event: "signal", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: sending %s", service.pid, serviceName, signalName(sig))})
+    return f.runner.Signal(service.pid, sig)
+}
+
+// Collect the signals any service asked to be forwarded.
+func (f *Foreman) forwardedSignals() []os.Signal {
+    seen := make(map[syscall.Signal]bool)
+    var sigs []os.Signal
+    for _, service := range f.snapshot() {
+        for _, sig := range service.forwardSignals {
+            if !seen[sig] {
+                seen[sig] = true
+                sigs = append(sigs, sig)
+            }
+        }
+    }
+    return sigs
+}
+
+// Forward a signal received by foreman to every running service that asked
+// for it, or to all of them in init mode.
+func (f *Foreman) forwardSignal(sig syscall.Signal) {
+    for _, service := range f.snapshot() {
+        if !service.active {
+            continue
+        }
+        if f.init {
+            f.runner.Signal(service.pid, sig)
+            continue
+        }
+
+        for _, forwarded := range service.forwardSignals {
+            if forwarded == sig {
+                f.runner.Signal(service.pid, sig)
+                break
+            }
+        }
+    }
+}
+
+// Describe how a process exited, like "exit code 3" or "killed by SIGKILL".
+func describeExit(exitCode int, sig syscall.Signal) string {
+    if sig != 0 {
+        return "killed by " + signalName(sig)
+    }
+    return fmt.Sprintf("exit code %d", exitCode)
+}
+
+// Return the name of a signal like "SIGHUP".
+func signalName(sig syscall.Signal) string {
+    for name, known := range signalNames {
+        if known == sig {
+            return "SIG" + name
+        }
+    }
+    return fmt.Sprintf("%d", int(sig))
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package foreman
+
+import "syscall"
+
+// The signals missing from Windows, named for every platform.
+const (
+    sigUSR1 = syscall.SIGUSR1
+    sigUSR2 = syscall.SIGUSR2
+    sigCHLD = syscall.SIGCHLD
+    sigCONT = syscall.SIGCONT
+    sigSTOP = syscall.SIGSTOP
+    sigTSTP = syscall.SIGTSTP
+    sigWINCH = syscall.SIGWINCH
+)
@@ -0,0 +1,15 @@
+package foreman
+
+import "syscall"
+
+// Windows lacks these signals. They keep their Linux numbers so Procfiles
+// naming them still parse, but foreman never receives nor sends them.
+const (
+    sigUSR1 = syscall.Signal(0xa)
+    sigUSR2 = syscall.Signal(0xc)
+    sigCHLD = syscall.Signal(0x11)
+    sigCONT = syscall.Signal(0x12)
+    sigSTOP = syscall.Signal(0x13)
+    sigTSTP = syscall.Signal(0x14)
+    sigWINCH = syscall.Signal(0x1c)
+)
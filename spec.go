@@ -0,0 +1,384 @@
+package foreman
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ServiceSpec is the exported configuration of a service, as read from the Procfile.
+// Relative paths are already resolved. A zero ServiceSpec describes a disabled service.
+type ServiceSpec struct {
+    Cmd string `yaml:"cmd"`
+    Argv []string `yaml:"argv"`
+    Cwd string `yaml:"cwd"`
+    Shell []string `yaml:"shell"`
+    Umask string `yaml:"umask"`
+    // User and Group are names or numeric ids, Group defaulting to the primary
+    // group of User and SupplementaryGroups to the groups User is a member of.
+    User string `yaml:"user"`
+    Group string `yaml:"group"`
+    SupplementaryGroups []string `yaml:"supplementary_groups"`
+    NoNewPrivs bool `yaml:"no_new_privs"`
+    Env map[string]string `yaml:"env"`
+    Stdout string `yaml:"stdout"`
+    Stderr string `yaml:"stderr"`
+    BinaryOutput string `yaml:"binary_output"`
+    LogFile string `yaml:"log_file"`
+    // MaxLogSize is the size in bytes LogFile is rotated at, never when zero.
+    MaxLogSize int64 `yaml:"max_log_size"`
+    MaxLogFiles int `yaml:"max_log_files"`
+    Restart RestartPolicy `yaml:"restart"`
+    RestartDelay time.Duration `yaml:"restart_delay"`
+    BackoffFactor float64 `yaml:"backoff_factor"`
+    MaxRestarts int `yaml:"max_restarts"`
+    RestartWindow time.Duration `yaml:"restart_window"`
+    NoHealthCheck bool `yaml:"no_health_check"`
+    Enabled bool `yaml:"enabled"`
+    Deps []string `yaml:"deps"`
+    // Before lists the services an init task runs before. They get it as a
+    // dependency with DepCompleted when the Procfile is read.
+    Before []string `yaml:"before"`
+    Groups []string `yaml:"groups"`
+    // DepConditions maps a dependency to the condition waited for before starting,
+    // DepStarted when missing. In the Procfile it is written as a deps map.
+    DepConditions map[string]DepCondition `yaml:"-"`
+    // DepTimeouts overrides DepTimeout for single dependencies.
+    DepTimeouts map[string]time.Duration `yaml:"-"`
+    DepTimeout time.Duration `yaml:"dep_timeout"`
+    ForwardSignals []syscall.Signal `yaml:"forward_signals"`
+    StopSignal syscall.Signal `yaml:"stop_signal"`
+    StopTimeout time.Duration `yaml:"stop_timeout"`
+    StartTimeout time.Duration `yaml:"start_timeout"`
+    // Start is StartManual for services Start doesn't launch, StartAuto when empty.
+    Start StartMode `yaml:"start"`
+    StartDelay time.Duration `yaml:"start_delay"`
+    Watch Watch `yaml:"watch"`
+    Hooks CommandHooks `yaml:"hooks"`
+    Schedule Schedule `yaml:"schedule"`
+    Checks CheckSpec `yaml:"checks"`
+    Readiness *CheckSpec `yaml:"readiness"`
+    Liveness *CheckSpec `yaml:"liveness"`
+}
+
+// CheckSpec is the exported configuration of a service's health checks.
+type CheckSpec struct {
+    Interval time.Duration `yaml:"interval"`
+    Timeout time.Duration `yaml:"timeout"`
+    Retries int `yaml:"retries"`
+    Successes int `yaml:"successes"`
+    InitialDelay time.Duration `yaml:"initial_delay"`
+    Cmd string `yaml:"cmd"`
+    // Argv is set when the check command is a list of arguments run without
+    // the shell, Cmd then holds them quoted.
+    Argv []string `yaml:"argv"`
+    TCPPorts []string `yaml:"tcp_ports"`
+    UDPPorts []string `yaml:"udp_ports"`
+    NetworkReady []string `yaml:"network_ready"`
+    GRPC GRPCCheck `yaml:"grpc"`
+    FileExists []string `yaml:"file_exists"`
+    SocketExists []string `yaml:"socket_exists"`
+    PIDFile string `yaml:"pid_file"`
+    DNS []DNSCheck `yaml:"dns"`
+    Ping []string `yaml:"ping"`
+    MaxMemory int64 `yaml:"max_memory"`
+    MaxCPUPercent float64 `yaml:"max_cpu_percent"`
+    MaxOpenFiles int `yaml:"max_open_files"`
+    LimitDuration time.Duration `yaml:"limit_duration"`
+    OnFailure FailureAction `yaml:"on_failure"`
+}
+
+// Return the configuration of every service.
+func (f *Foreman) Specs() map[string]ServiceSpec {
+    specs := make(map[string]ServiceSpec)
+    for serviceName, service := range f.snapshot() {
+        specs[serviceName] = service.spec()
+    }
+    return specs
+}
+
+// Return the configuration of a single service.
+func (f *Foreman) Spec(serviceName string) (ServiceSpec, error) {
+    service, ok := f.service(serviceName)
+    if !ok {
+        return ServiceSpec{}, &UnknownServiceError{Service: serviceName}
+    }
+    return service.spec(), nil
+}
+
+// Replace the configuration of a service. It can only be called before Start,
+// and the change is rejected if it would make the dependency graph invalid.
+func (f *Foreman) SetSpec(serviceName string, spec ServiceSpec) error {
+    if atomic.LoadInt32(&f.lifecycle) != lifecycleNew {
+        return f.lifecycleError()
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    old, ok := f.services[serviceName]
+    if !ok {
+        return &UnknownServiceError{Service: serviceName}
+    }
+    f.services[serviceName] = f.prepareService(spec.service(serviceName))
+
+    err := f.validateGraph()
+    if err != nil {
+        f.services[serviceName] = old
+        return err
+    }
+    return nil
+}
+
+func (s Service) spec() ServiceSpec {
+    var env map[string]string
+    if s.env != nil {
+        env = make(map[string]string, len(s.env))
+        for key, value := range s.env {
+            env[key] = value
+        }
+    }
+
+    return ServiceSpec{
+    	Cmd:                 s.cmd,
+    	Argv:                append([]string(nil), s.argv...),
+    	Cwd:                 s.cwd,
+    	Shell:               append([]string(nil), s.shell...),
+    	Umask:               s.umask,
+    	User:                s.user,
+    	Group:               s.group,
+    	SupplementaryGroups: append([]string(nil), s.supplementaryGroups...),
+    	NoNewPrivs:          s.noNewPrivs,
+    	Env:                 env,
+    	Stdout:              s.stdout,
+    	Stderr:              s.stderr,
+    	BinaryOutput:        s.binaryOutput,
+    	LogFile:             s.logFile,
+    	MaxLogSize:          s.maxLogSize,
+    	MaxLogFiles:         s.maxLogFiles,
+    	Restart:             s.restart,
+    	RestartDelay:        s.restartDelay,
+    	BackoffFactor:       s.backoffFactor,
+    	MaxRestarts:         s.maxRestarts,
+    	RestartWindow:       s.restartWindow,
+    	NoHealthCheck:       s.noHealthCheck,
+    	Enabled:             s.enabled,
+    	Deps:                append([]string(nil), s.deps...),
+    	Before:              append([]string(nil), s.before...),
+    	Groups:              append([]string(nil), s.groups...),
+    	DepConditions:       copyConditions(s.depConditions),
+    	DepTimeouts:         copyTimeouts(s.depTimeouts),
+    	DepTimeout:          s.depTimeout,
+    	ForwardSignals:      append([]syscall.Signal(nil), s.forwardSignals...),
+    	StopSignal:          s.stopSignal,
+    	StopTimeout:         s.stopTimeout,
+    	StartTimeout:        s.startTimeout,
+    	Start:               s.startMode,
+    	StartDelay:          s.startDelay,
+    	Watch:               copyWatch(s.watch),
+    	Hooks:               copyCommandHooks(s.hooks),
+    	Schedule:            s.schedule,
+    	Checks:              s.checks.spec(),
+    	Readiness:           s.readiness.specPointer(),
+    	Liveness:            s.liveness.specPointer(),
+    }
+}
+
+func (spec ServiceSpec) service(serviceName string) Service {
+    service := Service{
+    	serviceName:         serviceName,
+    	cmd:                 spec.Cmd,
+    	argv:                append([]string(nil), spec.Argv...),
+    	cwd:                 spec.Cwd,
+    	shell:               append([]string(nil), spec.Shell...),
+    	umask:               spec.Umask,
+    	user:                spec.User,
+    	group:               spec.Group,
+    	supplementaryGroups: append([]string(nil), spec.SupplementaryGroups...),
+    	noNewPrivs:          spec.NoNewPrivs,
+    	stdout:              spec.Stdout,
+    	stderr:              spec.Stderr,
+    	binaryOutput:        spec.BinaryOutput,
+    	logFile:             spec.LogFile,
+    	maxLogSize:          spec.MaxLogSize,
+    	maxLogFiles:         spec.MaxLogFiles,
+    	restart:             spec.Restart,
+    	restartDelay:        spec.RestartDelay,
+    	backoffFactor:       spec.BackoffFactor,
+    	maxRestarts:         spec.MaxRestarts,
+    	restartWindow:       spec.RestartWindow,
+    	noHealthCheck:       spec.NoHealthCheck,
+    	enabled:             spec.Enabled,
+    	deps:                append([]string(nil), spec.Deps...),
+    	before:              append([]string(nil), spec.Before...),
+    	groups:              append([]string(nil), spec.Groups...),
+    	depConditions:       copyConditions(spec.DepConditions),
+    	depTimeouts:         copyTimeouts(spec.DepTimeouts),
+    	depTimeout:          spec.DepTimeout,
+    	forwardSignals:      append([]syscall.Signal(nil), spec.ForwardSignals...),
+    	stopSignal:          spec.StopSignal,
+    	stopTimeout:         spec.StopTimeout,
+    	startTimeout:        spec.StartTimeout,
+    	startMode:           spec.Start,
+    	startDelay:          spec.StartDelay,
+    	watch:               copyWatch(spec.Watch),
+    	hooks:               copyCommandHooks(spec.Hooks),
+    	schedule:            spec.Schedule,
+    	checks:              spec.Checks.checks(),
+    	readiness:           spec.Readiness.checksPointer(),
+    	liveness:            spec.Liveness.checksPointer(),
+    }
+
+    if service.restart == "" {
+        service.restart = RestartAlways
+        if service.scheduled() {
+            service.restart = RestartNo
+        }
+    }
+    if spec.Env != nil {
+        service.env = make(map[string]string, len(spec.Env))
+        for key, value := range spec.Env {
+            service.env[key] = value
+        }
+    }
+    return service
+}
+
+func copyConditions(conditions map[string]DepCondition) map[string]DepCondition {
+    if conditions == nil {
+        return nil
+    }
+
+    copied := make(map[string]DepCondition, len(conditions))
+    for depName, condition := range conditions {
+        copied[depName] = condition
+    }
+    return copied
+}
+
+func copyTimeouts(timeouts map[string]time.Duration) map[string]time.Duration {
+    if timeouts == nil {
+        return nil
+    }
+
+    copied := make(map[string]time.Duration, len(timeouts))
+    for depName, timeout := range timeouts {
+        copied[depName] = timeout
+    }
+    return copied
+}
+
+// Add a service's own variables on top of the environment of cmd.
+func setServiceEnv(cmd *exec.Cmd, env map[string]string) {
+    if len(env) == 0 {
+        return
+    }
+
+    if cmd.Env == nil {
+        cmd.Env = os.Environ()
+    }
+    keys := make([]string, 0, len(env))
+    for key := range env {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        cmd.Env = append(cmd.Env, key+"="+env[key])
+    }
+}
+
+func (c Checks) spec() CheckSpec {
+    return CheckSpec{
+    	Interval:      c.interval,
+    	Timeout:       c.timeout,
+    	Retries:       c.retries,
+    	Successes:     c.successes,
+    	InitialDelay:  c.initialDelay,
+    	Cmd:           c.cmd,
+    	Argv:          append([]string(nil), c.argv...),
+    	TCPPorts:      append([]string(nil), c.tcpPorts...),
+    	UDPPorts:      append([]string(nil), c.udpPorts...),
+    	NetworkReady:  append([]string(nil), c.networkReady...),
+    	GRPC:          c.grpc,
+    	FileExists:    append([]string(nil), c.fileExists...),
+    	SocketExists:  append([]string(nil), c.socketExists...),
+    	PIDFile:       c.pidFile,
+    	DNS:           copyDNSChecks(c.dns),
+    	Ping:          append([]string(nil), c.ping...),
+    	MaxMemory:     c.maxMemory,
+    	MaxCPUPercent: c.maxCPUPercent,
+    	MaxOpenFiles:  c.maxOpenFiles,
+    	LimitDuration: c.limitDuration,
+    	OnFailure:     c.onFailure,
+    }
+}
+
+func (c *Checks) specPointer() *CheckSpec {
+    if c == nil {
+        return nil
+    }
+    spec := c.spec()
+    return &spec
+}
+
+func (spec CheckSpec) checks() Checks {
+    return Checks{
+    	interval:      spec.Interval,
+    	timeout:       spec.Timeout,
+    	retries:       spec.Retries,
+    	successes:     spec.Successes,
+    	initialDelay:  spec.InitialDelay,
+    	cmd:           spec.Cmd,
+    	argv:          append([]string(nil), spec.Argv...),
+    	tcpPorts:      append([]string(nil), spec.TCPPorts...),
+    	udpPorts:      append([]string(nil), spec.UDPPorts...),
+    	networkReady:  append([]string(nil), spec.NetworkReady...),
+    	grpc:          spec.GRPC,
+    	fileExists:    append([]string(nil), spec.FileExists...),
+    	socketExists:  append([]string(nil), spec.SocketExists...),
+    	pidFile:       spec.PIDFile,
+    	dns:           copyDNSChecks(spec.DNS),
+    	ping:          append([]string(nil), spec.Ping...),
+    	maxMemory:     spec.MaxMemory,
+    	maxCPUPercent: spec.MaxCPUPercent,
+    	maxOpenFiles:  spec.MaxOpenFiles,
+    	limitDuration: spec.LimitDuration,
+    	onFailure:     spec.OnFailure,
+    }
+}
+
+func (spec *CheckSpec) checksPointer() *Checks {
+    if spec == nil {
+        return nil
+    }
+    checks := spec.checks()
+    return &checks
+}
+
+func copyDNSChecks(checks []DNSCheck) []DNSCheck {
+    var copied []DNSCheck
+    for _, check := range checks {
+        copied = append(copied, DNSCheck{Name: check.Name, Expect: append([]string(nil), check.Expect...)})
+    }
+    return copied
+}
+
+func copyWatch(watch Watch) Watch {
+    return Watch{
+    	Paths:    append([]string(nil), watch.Paths...),
+    	Ignore:   append([]string(nil), watch.Ignore...),
+    	Debounce: watch.Debounce,
+    }
+}
+
+func copyCommandHooks(hooks CommandHooks) CommandHooks {
+    return CommandHooks{
+    	PreStart:  append([]string(nil), hooks.PreStart...),
+    	PostStart: append([]string(nil), hooks.PostStart...),
+    	PreStop:   append([]string(nil), hooks.PreStop...),
+    	PostStop:  append([]string(nil), hooks.PostStop...),
+    }
+}
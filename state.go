@@ -0,0 +1,158 @@
+package foreman
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+    StatePending State = iota
+    StateStarting
+    StateHealthy
+    StateStopped
+    StateFailed
+    StateDisabled
+    StateCrashed
+    StateRestarting
+    StatePaused
+)
+
+// State is the lifecycle state of a single service.
+type State int
+
+// ServiceStatus is a point in time report of a single service.
+// PID and Uptime are zero while the service isn't running.
+type ServiceStatus struct {
+    State State
+    PID int
+    Uptime time.Duration
+    Restarts int
+    // ExitCode is the exit code of the last process of the service, 128 plus
+    // the signal number when ExitSignal killed it.
+    ExitCode int
+    ExitSignal syscall.Signal
+    // CheckHistory holds the results of the latest rounds of checks, oldest first.
+    CheckHistory []CheckResult
+    // LastRun and NextRun are when the last run of a scheduled service
+    // started and when the next one is due, zero when unknown.
+    LastRun time.Time
+    NextRun time.Time
+}
+
+// StateChangeFunc is called whenever a service moves from one state to another.
+type StateChangeFunc func(name string, old, new State)
+
+type stateTransition struct {
+    serviceName string
+    old State
+    new State
+}
+
+// Delivers state transitions to the registered callbacks in order,
+// on its own goroutine so a slow callback can't stall the supervisor.
+type stateNotifier struct {
+    mu sync.Mutex
+    callbacks []StateChangeFunc
+    queue []stateTransition
+    wake chan struct{}
+    // closed is set once the notifier is closed, the transitions notified
+    // after that being dropped.
+    closed bool
+}
+
+func (s State) String() string {
+    switch s {
+    case StatePending:
+        return "pending"
+    case StateStarting:
+        return "starting"
+    case StateHealthy:
+        return "healthy"
+    case StateStopped:
+        return "stopped"
+    case StateFailed:
+        return "failed"
+    case StateDisabled:
+        return "disabled"
+    case StateCrashed:
+        return "crashed"
+    case StateRestarting:
+        return "restarting"
+    case StatePaused:
+        return "paused"
+    }
+    return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+    for state := StatePending; state <= StatePaused; state++ {
+        if state.String() == string(text) {
+            *s = state
+            return nil
+        }
+    }
+    return fmt.Errorf("unknown state %q", text)
+}
+
+func newStateNotifier() *stateNotifier {
+    notifier := &stateNotifier{
+        wake: make(chan struct{}, 1),
+    }
+    go notifier.run()
+    return notifier
+}
+
+// Register a callback to be invoked on every transition.
+func (n *stateNotifier) subscribe(callback StateChangeFunc) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.callbacks = append(n.callbacks, callback)
+}
+
+// Stop delivering transitions once the queued ones are delivered, ending the
+// goroutine of the notifier. Closing it again does nothing.
+func (n *stateNotifier) close() {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if !n.closed {
+        n.closed = true
+        close(n.wake)
+    }
+}
+
+// Queue a transition without blocking the caller.
+func (n *stateNotifier) notify(transition stateTransition) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if n.closed {
+        return
+    }
+    n.queue = append(n.queue, transition)
+
+    select {
+    case n.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (n *stateNotifier) run() {
+    for range n.wake {
+        n.mu.Lock()
+        queue := n.queue
+        callbacks := n.callbacks
+        n.queue = nil
+        n.mu.Unlock()
+
+        for _, transition := range queue {
+            for _, callback := range callbacks {
+                callback(transition.serviceName, transition.old, transition.new)
+            }
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package foreman
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Make foreman the subreaper of the processes started by the services, which
+// are re-parented to it instead of init when their parent exits.
+func setSubreaper() error {
+    return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+// Handles incoming SIGCHLD: reap every child that exited, as SIGCHLDs
+// received together are delivered once. The children foreman started get
+// their wait status handed over to their wait, the adopted orphans are logged.
+func (f *Foreman) sigChildHandler() {
+    children.Lock()
+    defer children.Unlock()
+
+    for {
+        var status syscall.WaitStatus
+        var rusage syscall.Rusage
+        pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, &rusage)
+        if err == syscall.EINTR {
+            continue
+        }
+        if err != nil || pid <= 0 {
+            return
+        }
+
+        if c, ok := children.pids[pid]; ok {
+            c.reap(status)
+            continue
+        }
+        exitCode, sig := exitStatus(status)
+        cpu := time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+        f.log(LogRecord{Event: "reaped", PID: pid, ExitCode: exitCodeOf(exitCode), Signal: signalNameOf(sig), Message: fmt.Sprintf("%d: reaped orphaned process, %s, cpu time %s", pid, describeExit(exitCode, sig), cpu)})
+    }
+}
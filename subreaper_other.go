@@ -0,0 +1,11 @@
+//go:build !linux
+
+package foreman
+
+import "errors"
+
+func setSubreaper() error {
+    return errors.New("subreaper mode is only supported on Linux")
+}
+
+func (f *Foreman) sigChildHandler() {}
@@ -0,0 +1,99 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StartMode decides whether Start launches a service.
+type StartMode string
+
+const (
+    StartAuto StartMode = "auto"
+    StartManual StartMode = "manual"
+)
+
+// Parse the start field.
+func parseStartMode(field string, value any) (StartMode, error) {
+    mode, _ := value.(string)
+    switch StartMode(mode) {
+    case StartAuto, StartManual:
+        return StartMode(mode), nil
+    }
+    return "", fieldError(field, "expected auto or manual, got %v", value)
+}
+
+// Return the services Start starts: every enabled service but the manual ones,
+// or only the targets and the services of the target groups, with the services
+// they depend on. The name of a scaled service targets all its instances.
+func (f *Foreman) selectServices(depGraph dependencyGraph) ([]string, error) {
+    services := f.snapshot()
+    if len(f.targets) == 0 && len(f.targetGroups) == 0 {
+        selected := make(map[string]bool)
+        for serviceName, service := range services {
+            if service.enabled && service.startMode != StartManual {
+                selected[serviceName] = true
+            }
+        }
+        // A manual service still starts when a started service depends on it.
+        for serviceName := range selected {
+            for _, dep := range depGraph.reachable(serviceName) {
+                if services[dep].enabled {
+                    selected[dep] = true
+                }
+            }
+        }
+
+        serviceNames := make([]string, 0, len(selected))
+        for serviceName := range selected {
+            serviceNames = append(serviceNames, serviceName)
+        }
+        sort.Strings(serviceNames)
+        return serviceNames, nil
+    }
+
+    selected := make(map[string]bool)
+    groups := append([]string(nil), f.targetGroups...)
+    for _, serviceName := range f.targets {
+        service, ok := services[serviceName]
+        if !ok && isScaled(serviceName, services) {
+            groups = append(groups, serviceName)
+            continue
+        }
+        if !ok {
+            return nil, &UnknownServiceError{Service: serviceName}
+        }
+        if !service.enabled {
+            return nil, fmt.Errorf("%s is disabled", serviceName)
+        }
+        selected[serviceName] = true
+    }
+
+    for _, group := range groups {
+        found := false
+        for serviceName, service := range services {
+            for _, serviceGroup := range service.groups {
+                if service.enabled && serviceGroup == group {
+                    selected[serviceName] = true
+                    found = true
+                }
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("no enabled service in group %q", group)
+        }
+    }
+
+    for serviceName := range selected {
+        for _, dep := range depGraph.reachable(serviceName) {
+            selected[dep] = true
+        }
+    }
+
+    serviceNames := make([]string, 0, len(selected))
+    for serviceName := range selected {
+        serviceNames = append(serviceNames, serviceName)
+    }
+    sort.Strings(serviceNames)
+    return serviceNames, nil
+}
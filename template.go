@@ -0,0 +1,58 @@
+package foreman
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// procfileTemplate holds the data a Procfile is rendered with when templating
+// is enabled, the environment under .Env.
+type procfileTemplate struct {
+    Env map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+    // Use fallback when value is empty, as in {{ .Env.PORT | default "5000" }}.
+    "default": func(fallback, value string) string {
+        if value == "" {
+            return fallback
+        }
+        return value
+    },
+}
+
+// Render the Procfile data as a Go template. Missing variables render empty.
+func (t *procfileTemplate) render(path string, data []byte) ([]byte, error) {
+    tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(data))
+    if err != nil {
+        return nil, err
+    }
+
+    var rendered bytes.Buffer
+    err = tmpl.Execute(&rendered, t)
+    if err != nil {
+        return nil, err
+    }
+    return rendered.Bytes(), nil
+}
+
+// Return the template data the Procfile is rendered with, or nil when templating
+// is disabled. The environment holds foreman's own environment, the WithEnv
+// entries and the .env variables, later ones taking precedence.
+func (f *Foreman) template(dotEnv map[string]string) *procfileTemplate {
+    if !f.procfileTemplate {
+        return nil
+    }
+
+    env := make(map[string]string)
+    for _, entries := range [][]string{os.Environ(), f.env} {
+        for _, entry := range entries {
+            if key, value, ok := strings.Cut(entry, "="); ok {
+                env[key] = value
+            }
+        }
+    }
+    return &procfileTemplate{Env: mergeEnv(env, dotEnv)}
+}
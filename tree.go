@@ -0,0 +1,84 @@
+package foreman
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Render the services as an indented dependency tree annotated with their live state.
+// Top level nodes are the services nothing depends on, each followed by its dependencies.
+// Services only reached through a dependency cycle get top level nodes of their own,
+// marked as part of a cycle.
+func (f *Foreman) Tree() string {
+    graph := f.DependencyGraph()
+    dependents := dependencyGraph(graph).reverse()
+    status := f.Status()
+    services := f.snapshot()
+
+    var roots []string
+    for serviceName := range graph {
+        if len(dependents[serviceName]) == 0 {
+            roots = append(roots, serviceName)
+        }
+    }
+    sort.Strings(roots)
+
+    var tree strings.Builder
+    rendered := make(map[string]bool)
+    var render func(serviceName string, depth int, path map[string]bool)
+    render = func(serviceName string, depth int, path map[string]bool) {
+        tree.WriteString(strings.Repeat("  ", depth))
+        tree.WriteString(treeNode(serviceName, status[serviceName].State, services[serviceName]))
+        if depth == 0 && len(dependents[serviceName]) > 0 {
+            tree.WriteString(" [cycle]")
+        }
+        tree.WriteString("\n")
+        rendered[serviceName] = true
+
+        if path[serviceName] {
+            return
+        }
+        path[serviceName] = true
+        defer delete(path, serviceName)
+
+        deps := append([]string{}, graph[serviceName]...)
+        sort.Strings(deps)
+        for _, depName := range deps {
+            render(depName, depth+1, path)
+        }
+    }
+
+    for _, root := range roots {
+        render(root, 0, map[string]bool{})
+    }
+
+    var serviceNames []string
+    for serviceName := range graph {
+        serviceNames = append(serviceNames, serviceName)
+    }
+    sort.Strings(serviceNames)
+    for _, serviceName := range serviceNames {
+        if !rendered[serviceName] {
+            render(serviceName, 0, map[string]bool{})
+        }
+    }
+
+    return tree.String()
+}
+
+// Format a single tree line like "● app (healthy, pid 42)".
+func treeNode(serviceName string, state State, service Service) string {
+    symbol := "○"
+    switch state {
+    case StateStarting, StateHealthy:
+        symbol = "●"
+    case StateFailed, StateCrashed:
+        symbol = "✖"
+    }
+
+    if service.active {
+        return fmt.Sprintf("%s %s (%s, pid %d)", symbol, serviceName, state, service.pid)
+    }
+    return fmt.Sprintf("%s %s (%s)", symbol, serviceName, state)
+}
@@ -0,0 +1,68 @@
+package foreman
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// ProcessUsage is the resource usage of the main process of a running service.
+type ProcessUsage struct {
+    PID int
+    CPUPercent float64
+    RSS uint64
+    OpenFDs int32
+}
+
+// Measure the resource usage of every running service, with the CPU usage
+// sampled over interval. Services whose process can't be inspected are left out.
+func (f *Foreman) Usage(interval time.Duration) map[string]ProcessUsage {
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    usage := make(map[string]ProcessUsage)
+
+    for serviceName, service := range f.snapshot() {
+        if !service.active {
+            continue
+        }
+
+        wg.Add(1)
+        go func(serviceName string, pid int) {
+            defer wg.Done()
+
+            serviceUsage, err := processUsage(pid, interval)
+            if err != nil {
+                return
+            }
+            mu.Lock()
+            usage[serviceName] = serviceUsage
+            mu.Unlock()
+        }(serviceName, service.pid)
+    }
+
+    wg.Wait()
+    return usage
+}
+
+func processUsage(pid int, interval time.Duration) (ProcessUsage, error) {
+    proc, err := process.NewProcess(int32(pid))
+    if err != nil {
+        return ProcessUsage{}, err
+    }
+
+    cpu, err := proc.Percent(interval)
+    if err != nil {
+        return ProcessUsage{}, err
+    }
+    memory, err := proc.MemoryInfo()
+    if err != nil {
+        return ProcessUsage{}, err
+    }
+    fds, err := proc.NumFDs()
+    if err != nil {
+        return ProcessUsage{}, err
+    }
+
+    return ProcessUsage{PID: pid, CPUPercent: cpu, RSS: memory.RSS, OpenFDs: fds}, nil
+}
@@ -0,0 +1,292 @@
+package foreman
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys understood in a service definition, its checks and its deps map.
+var (
+    serviceKeys = []string{
+        "cmd", "cwd", "shell", "umask", "user", "group", "supplementary_groups", "no_new_privs", "env", "env_file", "stdout", "stderr", "binary_output", "log_file", "max_log_size", "max_log_files",
+        "run_once", "restart", "restart_delay", "backoff_factor", "max_restarts", "restart_window", "no_health_check",
+        "enabled", "instances", "deps", "depends_on", "dep_timeout", "before", "groups", "forward_signals", "stop_signal",
+        "start", "start_delay", "start_timeout", "stop_timeout", "watch", "hooks", "schedule", "checks", "readiness", "liveness", "profiles",
+    }
+    checkKeys = []string{"interval", "timeout", "retries", "successes", "initial_delay", "cmd", "tcp_ports", "udp_ports", "network_ready", "grpc", "file_exists", "socket_exists", "pid_file", "dns", "ping", "max_memory", "max_cpu_percent", "max_open_files", "limit_duration", "on_failure"}
+    depKeys = []string{"condition", "timeout"}
+)
+
+// Diagnostic is a single problem found in a Procfile by Validate.
+type Diagnostic struct {
+    Service string
+    Field string
+    Position Position
+    Message string
+}
+
+func (d Diagnostic) String() string {
+    return d.err().Error()
+}
+
+func (d Diagnostic) err() *ParseError {
+    return &ParseError{Service: d.Service, Field: d.Field, Position: d.Position, Cause: errors.New(d.Message)}
+}
+
+// Check a Procfile without starting anything and report every problem found:
+// unknown keys, values of the wrong type, duplicate services, missing or disabled
+// dependencies, port conflicts and dependency cycles, located in the file when
+// its format allows. The options select the .env files, overrides, templating
+// and format as for New.
+func Validate(procfilePath string, opts ...Option) []Diagnostic {
+    settings := newForeman(opts...)
+    procfileDir := filepath.Dir(procfilePath)
+    dotEnv, err := loadDotEnv(procfileDir, settings.envFiles)
+    if err != nil {
+        return []Diagnostic{{Message: err.Error()}}
+    }
+
+    procfilePaths := append([]string{procfilePath}, settings.overrides...)
+    procfileMap, positions, err := loadProcfiles(procfilePaths, settings.procfileSettings(dotEnv))
+    if err != nil {
+        return loadDiagnostics(err)
+    }
+
+    var diagnostics []Diagnostic
+    defaults := procfileMap[defaultsKey]
+    delete(procfileMap, defaultsKey)
+    diagnostics = append(diagnostics, unknownKeys(defaultsKey, defaults)...)
+
+    if env, ok := procfileMap[envKey]; ok {
+        globalEnv, err := parseEnv(envKey, env)
+        if err != nil {
+            diagnostics = append(diagnostics, fieldDiagnostic("", envKey, err))
+        }
+        dotEnv = mergeEnv(dotEnv, globalEnv)
+        delete(procfileMap, envKey)
+    }
+    resolve := func(path string) string {
+        if filepath.IsAbs(path) {
+            return path
+        }
+        return filepath.Join(procfileDir, path)
+    }
+
+    var serviceNames []string
+    for serviceName := range procfileMap {
+        serviceNames = append(serviceNames, serviceName)
+    }
+    sort.Strings(serviceNames)
+
+    services := make(map[string]Service)
+    for _, serviceName := range serviceNames {
+        serviceMap := procfileMap[serviceName]
+        diagnostics = append(diagnostics, unknownKeys(serviceName, serviceMap)...)
+
+        merged := applyDefaults(serviceMap, defaults)
+        applied, member, err := applyProfiles(merged, settings.profiles)
+        if err != nil {
+            diagnostics = append(diagnostics, fieldDiagnostic(serviceName, profilesKey, err))
+            delete(merged, profilesKey)
+        } else {
+            merged = applied
+            if !member {
+                merged["enabled"] = false
+            }
+        }
+        interpolated, err := interpolateService(merged, dotEnv, nil, resolve)
+        var parseErr *ParseError
+        if errors.As(err, &parseErr) && parseErr.Field == envFileKey {
+            diagnostics = append(diagnostics, fieldDiagnostic(serviceName, envFileKey, err))
+            delete(merged, envFileKey)
+            interpolated, err = interpolateService(merged, dotEnv, nil, resolve)
+        }
+        // An invalid env is reported with the other fields below.
+        if err == nil {
+            merged = interpolated
+        }
+        var keys []string
+        for key := range merged {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        // Parse the fields one at a time to report all of them, not only the first,
+        // and check the dependency graph with the fields that are valid.
+        for _, key := range keys {
+            _, err := parseService(map[string]any{key: merged[key]})
+            if err != nil {
+                diagnostics = append(diagnostics, fieldDiagnostic(serviceName, key, err))
+                delete(merged, key)
+            }
+        }
+        service, _ := parseService(merged)
+        service.serviceName = serviceName
+        services[serviceName] = service
+    }
+
+    err = expandBefore(services, nil)
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) {
+        diagnostics = append(diagnostics, fieldDiagnostic(parseErr.Service, parseErr.Field, err))
+    } else {
+        diagnostics = append(diagnostics, graphDiagnostics(services)...)
+    }
+    for i, diagnostic := range diagnostics {
+        if diagnostic.Service != "" || diagnostic.Field != "" {
+            diagnostics[i].Position = positions.locate(diagnostic.Service, diagnostic.Field)
+        }
+    }
+    return diagnostics
+}
+
+// Report the keys of a service definition foreman doesn't know about, likely typos.
+func unknownKeys(serviceName string, serviceMap map[string]any) []Diagnostic {
+    var diagnostics []Diagnostic
+    for _, key := range unknown(serviceMap, serviceKeys) {
+        diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: key, Message: "unknown key"})
+    }
+
+    for _, checksKey := range checkBlocks {
+        checks, ok := serviceMap[checksKey].(map[string]any)
+        if !ok {
+            continue
+        }
+        for _, key := range unknown(checks, checkKeys) {
+            diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: checksKey + "." + key, Message: "unknown key"})
+        }
+    }
+
+    if profiles, ok := serviceMap[profilesKey].(map[string]any); ok {
+        var names []string
+        for name := range profiles {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            override, ok := profiles[name].(map[string]any)
+            if !ok {
+                continue
+            }
+            for _, diagnostic := range unknownKeys(serviceName, override) {
+                diagnostic.Field = fmt.Sprintf("%s.%s.%s", profilesKey, name, diagnostic.Field)
+                diagnostics = append(diagnostics, diagnostic)
+            }
+        }
+    }
+
+    for _, depsKey := range []string{"deps", "depends_on"} {
+        deps, ok := serviceMap[depsKey].(map[string]any)
+        if !ok {
+            continue
+        }
+        for depName, options := range deps {
+            options, ok := options.(map[string]any)
+            if !ok {
+                continue
+            }
+            for _, key := range unknown(options, depKeys) {
+                field := fmt.Sprintf("%s.%s.%s", depsKey, depName, key)
+                diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: field, Message: "unknown key"})
+            }
+        }
+    }
+
+    return diagnostics
+}
+
+// Return the sorted keys of values missing from known.
+func unknown(values map[string]any, known []string) []string {
+    isKnown := make(map[string]bool, len(known))
+    for _, key := range known {
+        isKnown[key] = true
+    }
+
+    var keys []string
+    for key := range values {
+        if !isKnown[key] {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Report the dependencies that can't be satisfied and the conflicts between
+// services that all parsed correctly.
+func graphDiagnostics(services map[string]Service) []Diagnostic {
+    var serviceNames []string
+    for serviceName := range services {
+        serviceNames = append(serviceNames, serviceName)
+    }
+    sort.Strings(serviceNames)
+
+    var diagnostics []Diagnostic
+    for _, serviceName := range serviceNames {
+        service := services[serviceName]
+        if !service.enabled {
+            continue
+        }
+
+        for _, depName := range service.deps {
+            dep, ok := services[depName]
+            switch {
+            case !ok:
+                diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: "deps", Message: "unknown service " + depName})
+            case !dep.enabled:
+                diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: "deps", Message: "depends on disabled service " + depName})
+            case dep.scheduled():
+                diagnostics = append(diagnostics, Diagnostic{Service: serviceName, Field: "deps", Message: "depends on scheduled service " + depName})
+            }
+        }
+    }
+
+    cycle := graphOf(services).findCycle()
+    if cycle != nil {
+        diagnostics = append(diagnostics, Diagnostic{Message: (&CyclicDependencyError{Cycle: cycle}).Error()})
+    }
+
+    foreman := &Foreman{services: services}
+    err := foreman.checkPortConflicts()
+    if err != nil {
+        diagnostics = append(diagnostics, Diagnostic{Message: err.Error()})
+    }
+
+    return diagnostics
+}
+
+// Turn the error of a field parser into a diagnostic.
+func fieldDiagnostic(serviceName, key string, err error) Diagnostic {
+    diagnostic := Diagnostic{Service: serviceName, Field: key, Message: err.Error()}
+
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) {
+        if parseErr.Field != key && parseErr.Field != "" {
+            diagnostic.Field = key + "." + parseErr.Field
+        }
+        diagnostic.Message = parseErr.Cause.Error()
+    }
+    return diagnostic
+}
+
+// Turn an error reading the Procfile, like a duplicate service, into diagnostics.
+func loadDiagnostics(err error) []Diagnostic {
+    var typeErr *yaml.TypeError
+    if errors.As(err, &typeErr) {
+        diagnostics := make([]Diagnostic, len(typeErr.Errors))
+        for i, message := range typeErr.Errors {
+            diagnostics[i] = Diagnostic{Message: message}
+        }
+        return diagnostics
+    }
+
+    var parseErr *ParseError
+    if errors.As(err, &parseErr) {
+        return []Diagnostic{{Service: parseErr.Service, Field: parseErr.Field, Position: parseErr.Position, Message: parseErr.Cause.Error()}}
+    }
+    return []Diagnostic{{Message: err.Error()}}
+}
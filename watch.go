@@ -0,0 +1,193 @@
+package foreman
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounce is how long a service waits after a change to its
+// watched files for more changes before it is restarted.
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// Watch is the files whose changes restart a service.
+type Watch struct {
+    // Paths are the watched files and directories, directories recursively.
+    Paths []string `yaml:"paths"`
+    // Ignore holds patterns of changes that don't restart the service, matched
+    // against the name of the changed file or directory and its path under the
+    // watched directory, like "*.log" or "node_modules".
+    Ignore []string `yaml:"ignore"`
+    // Debounce is how long to wait after a change for more changes before
+    // restarting the service, 500ms by default.
+    Debounce time.Duration `yaml:"debounce"`
+}
+
+// Parse watch, a path, a list of paths, or a mapping of paths, ignore and debounce.
+func parseWatch(field string, value any) (Watch, error) {
+    watchMap, ok := value.(map[string]any)
+    if !ok {
+        paths, err := parsePaths(field, value)
+        return Watch{Paths: paths}, err
+    }
+
+    var watch Watch
+    var err error
+    for key, value := range watchMap {
+        switch key {
+        case "paths":
+            watch.Paths, err = parsePaths(field+".paths", value)
+        case "ignore":
+            watch.Ignore, err = parseStringList(field+".ignore", value)
+        case "debounce":
+            watch.Debounce, err = parseDuration(field+".debounce", value)
+        default:
+            err = fieldError(field+"."+key, "unknown key")
+        }
+        if err != nil {
+            return Watch{}, err
+        }
+    }
+    if len(watch.Paths) == 0 {
+        return Watch{}, fieldError(field+".paths", "a path is required")
+    }
+    for _, pattern := range watch.Ignore {
+        if _, err := filepath.Match(pattern, ""); err != nil {
+            return Watch{}, fieldError(field+".ignore", "invalid pattern %q", pattern)
+        }
+    }
+    return watch, nil
+}
+
+// Return the watch with its paths resolved.
+func (w Watch) resolvePaths(resolve func(string) string) Watch {
+    watch := copyWatch(w)
+    for i, path := range watch.Paths {
+        watch.Paths[i] = resolve(path)
+    }
+    return watch
+}
+
+// Report whether a change of path matters: it is a watched file or under a
+// watched directory, and no ignore pattern matches it.
+func (w Watch) matches(path string) bool {
+    for _, root := range w.Paths {
+        rel, err := filepath.Rel(root, path)
+        if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            continue
+        }
+        return !w.ignored(rel)
+    }
+    return false
+}
+
+// Report whether an ignore pattern matches a path relative to a watched
+// directory, or one of its elements.
+func (w Watch) ignored(rel string) bool {
+    for _, pattern := range w.Ignore {
+        if matched, _ := filepath.Match(pattern, rel); matched {
+            return true
+        }
+        for _, element := range strings.Split(rel, string(filepath.Separator)) {
+            if matched, _ := filepath.Match(pattern, element); matched {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Watch a file through its directory, so files replaced by editors stay
+// watched, or a directory and the directories under it that aren't ignored.
+func (w Watch) add(watcher *fsnotify.Watcher, path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        return watcher.Add(filepath.Dir(path))
+    }
+    return filepath.WalkDir(path, func(dir string, entry fs.DirEntry, err error) error {
+        if err != nil || !entry.IsDir() {
+            return err
+        }
+        if dir != path && !w.matches(dir) {
+            return filepath.SkipDir
+        }
+        return watcher.Add(dir)
+    })
+}
+
+// Restart a service once its watched files changed and stayed unchanged for
+// the debounce duration. It returns when the service process exits.
+func (f *Foreman) watchFiles(service Service) {
+    serviceName := service.serviceName
+    watch := service.watch
+    warn := func(err error) {
+        f.log(LogRecord{Level: LevelWarning, Event: "watch", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%s: watching files failed: %v", serviceName, err)})
+    }
+
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        warn(err)
+        return
+    }
+    defer watcher.Close()
+    for _, path := range watch.Paths {
+        err := watch.add(watcher, path)
+        if err != nil {
+            warn(err)
+        }
+    }
+
+    debounce := watch.Debounce
+    if debounce == 0 {
+        debounce = defaultWatchDebounce
+    }
+    var settled <-chan time.Time
+    var changed string
+    for {
+        select {
+        case event, ok := <-watcher.Events:
+            if !ok {
+                return
+            }
+            if !watch.matches(event.Name) || event.Op == fsnotify.Chmod {
+                continue
+            }
+            if event.Op&fsnotify.Create != 0 {
+                // Watch the new directories too, errors mean it's a file or already gone.
+                if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+                    watch.add(watcher, event.Name)
+                }
+            }
+            changed = event.Name
+            settled = f.clock.After(debounce)
+        case err, ok := <-watcher.Errors:
+            if !ok {
+                return
+            }
+            warn(err)
+        case <-settled:
+            if atomic.LoadInt32(&f.lifecycle) == lifecycleStopped {
+                return
+            }
+            f.log(LogRecord{Event: "watch", Service: serviceName, PID: service.pid, Message: fmt.Sprintf("%d %s: %s changed, restarting", service.pid, serviceName, changed)})
+            go func() {
+                err := f.RestartService(serviceName)
+                if err != nil {
+                    f.log(LogRecord{Level: LevelError, Event: "watch", Service: serviceName, Message: fmt.Sprintf("%s: restarting failed: %v", serviceName, err)})
+                }
+            }()
+            return
+        case <-service.exited:
+            return
+        }
+    }
+}